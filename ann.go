@@ -0,0 +1,312 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Once the memory store reaches "ann": {"threshold": N} memories (5000 by
+// default), retrieval searches an HNSW graph instead of scoring every
+// memory. The graph is kept in ~/.go-chat-memory-hnsw.json next to the
+// store. Nodes refer to store positions, so memories appended by
+// saveVectorStore are inserted incrementally; anything else (deletes,
+// re-embedding) rebuilds it. The graph's candidates are filtered by scope
+// and namespace; if fewer than the memories wanted survive, retrieval falls
+// back to scoring every memory. min_score is applied in rankMemories.
+const annFilePath = ".go-chat-memory-hnsw.json"
+
+type ANNConfig struct {
+	Threshold int `json:"threshold,omitempty"`
+	EfSearch  int `json:"ef_search,omitempty"`
+}
+
+const (
+	defaultANNThreshold = 5000
+	defaultANNEfSearch  = 128
+
+	annM              = 16
+	annEfConstruction = 200
+	annCandidates     = 200 // handed to rankMemories for filtering
+)
+
+type annGraph struct {
+	Model    string      `json:"model"`
+	Entry    int         `json:"entry"`
+	MaxLevel int         `json:"max_level"`
+	Keys     []string    `json:"keys"`  // memoryKey of each store position
+	Links    [][][]int32 `json:"links"` // node -> level -> neighbours
+
+	vecs [][]float32
+}
+
+func annSettings(cfg Config) ANNConfig {
+	a := cfg.ANN
+	if a.Threshold == 0 {
+		a.Threshold = defaultANNThreshold
+	}
+	if a.EfSearch == 0 {
+		a.EfSearch = defaultANNEfSearch
+	}
+	return a
+}
+
+// memoryKey identifies a stored memory for the graph.
+func memoryKey(m VectorMemory) string {
+	return memoryID(normScope(m.Scope) + "\x00" + storedNamespace(m.Namespace) + "\x00" + m.Text)
+}
+
+var (
+	annMu     sync.Mutex
+	annCached *annGraph
+	annMod    time.Time
+)
+
+// loadANN returns the persisted graph, cached for as long as the file is
+// unchanged so a server doesn't re-read it per request.
+func loadANN() *annGraph {
+	annMu.Lock()
+	defer annMu.Unlock()
+
+	info, err := os.Stat(filepath.Join(homeDir, annFilePath))
+	if err != nil {
+		return nil
+	}
+	if annCached == nil || !info.ModTime().Equal(annMod) {
+		annCached, annMod = readANN(), info.ModTime()
+	}
+	return annCached
+}
+
+func readANN() *annGraph {
+	data, err := os.ReadFile(filepath.Join(homeDir, annFilePath))
+	if err != nil {
+		return nil
+	}
+	g := &annGraph{}
+	if err := json.Unmarshal(data, g); err != nil || len(g.Keys) != len(g.Links) {
+		return nil
+	}
+	return g
+}
+
+func saveANN(g *annGraph) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(homeDir, annFilePath), data, 0o644)
+}
+
+// syncANN brings the graph up to date with a store that was just saved.
+// Below the threshold it does nothing and retrieval stays brute force.
+func syncANN(store []VectorMemory, rebuild bool) {
+	cfg := getConfig()
+	if len(store) < annSettings(cfg).Threshold {
+		return
+	}
+	keys := make([]string, len(store))
+	for i, m := range store {
+		keys[i] = memoryKey(m)
+	}
+
+	g := readANN() // not the cached copy, which searches may be using
+	if rebuild || g == nil || g.Model != embeddingModel() ||
+		len(g.Keys) > len(keys) || !slices.Equal(g.Keys, keys[:len(g.Keys)]) {
+		g = &annGraph{Model: embeddingModel(), Entry: -1}
+	}
+	g.vecs = make([][]float32, len(store))
+	for i, m := range store {
+		g.vecs[i] = m.Embedding
+	}
+	for i := len(g.Keys); i < len(store); i++ {
+		g.Keys = append(g.Keys, keys[i])
+		g.insert(i)
+	}
+	if err := saveANN(g); err != nil {
+		log.Printf("memory graph: %v", err)
+	}
+}
+
+// annCandidatesFor returns the store positions nearest to vec that keep
+// accepts, or false if brute force should be used: the store is under the
+// threshold, the graph doesn't match it, or fewer than want candidates
+// pass keep.
+func annCandidatesFor(store []VectorMemory, vec []float32, keep func(VectorMemory) bool, want int) ([]int, bool) {
+	cfg := getConfig()
+	a := annSettings(cfg)
+	if len(store) < a.Threshold {
+		return nil, false
+	}
+	cached := loadANN()
+	if cached == nil || len(cached.Keys) != len(store) || cached.Model != embeddingModel() {
+		return nil, false
+	}
+	g := *cached
+	g.vecs = make([][]float32, len(store))
+	for i, m := range store {
+		g.vecs[i] = m.Embedding
+	}
+	var kept []int
+	for _, id := range g.search(vec, annCandidates, max(a.EfSearch, annCandidates)) {
+		if memoryKey(store[id]) != g.Keys[id] {
+			return nil, false // store was edited outside go-chat
+		}
+		if keep(store[id]) {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) < want {
+		return nil, false
+	}
+	return kept, true
+}
+
+func annDist(a, b []float32) float64 { return 1 - cosineSim(a, b) }
+
+func (g *annGraph) randomLevel() int {
+	return int(-math.Log(1-rand.Float64()) / math.Log(annM))
+}
+
+func (g *annGraph) insert(id int) {
+	level := g.randomLevel()
+	g.Links = append(g.Links, make([][]int32, level+1))
+	if g.Entry < 0 {
+		g.Entry, g.MaxLevel = id, level
+		return
+	}
+
+	q := g.vecs[id]
+	ep := g.Entry
+	for l := g.MaxLevel; l > level; l-- {
+		ep = g.searchLayer(q, ep, 1, l)[0].id
+	}
+	for l := min(level, g.MaxLevel); l >= 0; l-- {
+		found := g.searchLayer(q, ep, annEfConstruction, l)
+		limit := annM
+		if l == 0 {
+			limit = 2 * annM
+		}
+		for _, n := range found[:min(annM, len(found))] {
+			g.Links[id][l] = append(g.Links[id][l], int32(n.id))
+			g.Links[n.id][l] = append(g.Links[n.id][l], int32(id))
+			if len(g.Links[n.id][l]) > limit {
+				g.prune(n.id, l, limit)
+			}
+		}
+		ep = found[0].id
+	}
+	if level > g.MaxLevel {
+		g.Entry, g.MaxLevel = id, level
+	}
+}
+
+// prune keeps a node's closest neighbours at one level.
+func (g *annGraph) prune(id, level, limit int) {
+	links := g.Links[id][level]
+	slices.SortFunc(links, func(a, b int32) int {
+		da, db := annDist(g.vecs[id], g.vecs[a]), annDist(g.vecs[id], g.vecs[b])
+		switch {
+		case da < db:
+			return -1
+		case da > db:
+			return 1
+		}
+		return 0
+	})
+	g.Links[id][level] = links[:limit]
+}
+
+func (g *annGraph) search(q []float32, k, ef int) []int {
+	if g.Entry < 0 {
+		return nil
+	}
+	ep := g.Entry
+	for l := g.MaxLevel; l > 0; l-- {
+		ep = g.searchLayer(q, ep, 1, l)[0].id
+	}
+	found := g.searchLayer(q, ep, max(ef, k), 0)
+	ids := make([]int, 0, k)
+	for _, n := range found[:min(k, len(found))] {
+		ids = append(ids, n.id)
+	}
+	return ids
+}
+
+// searchLayer is the standard HNSW beam search, returning up to ef nodes
+// nearest first.
+func (g *annGraph) searchLayer(q []float32, ep, ef, level int) []annItem {
+	seen := map[int]bool{ep: true}
+	start := annItem{ep, annDist(q, g.vecs[ep])}
+	cands := &annHeap{items: []annItem{start}}
+	found := &annHeap{items: []annItem{start}, far: true}
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(annItem)
+		if found.Len() >= ef && c.dist > found.items[0].dist {
+			break
+		}
+		if level >= len(g.Links[c.id]) {
+			continue
+		}
+		for _, n := range g.Links[c.id][level] {
+			if seen[int(n)] {
+				continue
+			}
+			seen[int(n)] = true
+			d := annDist(q, g.vecs[n])
+			if found.Len() < ef || d < found.items[0].dist {
+				heap.Push(cands, annItem{int(n), d})
+				heap.Push(found, annItem{int(n), d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	out := found.items
+	slices.SortFunc(out, func(a, b annItem) int {
+		switch {
+		case a.dist < b.dist:
+			return -1
+		case a.dist > b.dist:
+			return 1
+		}
+		return 0
+	})
+	return out
+}
+
+type annItem struct {
+	id   int
+	dist float64
+}
+
+// annHeap is a min-heap on distance, or a max-heap with far set.
+type annHeap struct {
+	items []annItem
+	far   bool
+}
+
+func (h *annHeap) Len() int { return len(h.items) }
+func (h *annHeap) Less(i, j int) bool {
+	if h.far {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+func (h *annHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *annHeap) Push(x any)    { h.items = append(h.items, x.(annItem)) }
+func (h *annHeap) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// anthropicProvider speaks Anthropic's Messages API, using
+// $ANTHROPIC_API_KEY and $ANTHROPIC_API_BASE. Pick it with "provider":
+// "anthropic" in the config or -provider anthropic. go-chat asks for its
+// OpenAI model names, so those are mapped to Claude models: the mini and
+// nano ones to "small_model", the rest to "model". Claude model names are
+// sent as they are.
+//
+//	"anthropic": {"model": "claude-opus-4-1", "small_model": "claude-haiku-4-5"}
+//
+// Anthropic has no embeddings API, so embeddings go to the default
+// provider.
+type AnthropicConfig struct {
+	Model      string `json:"model,omitempty"`       // default claude-sonnet-4-5
+	SmallModel string `json:"small_model,omitempty"` // default claude-haiku-4-5
+}
+
+const (
+	anthropicName       = "anthropic"
+	defaultAnthropicURL = "https://api.anthropic.com"
+	anthropicVersion    = "2023-06-01"
+
+	defaultClaudeModel      = "claude-sonnet-4-5"
+	defaultClaudeSmallModel = "claude-haiku-4-5"
+
+	// Claude requires max_tokens; this is used when a caller leaves it 0.
+	defaultClaudeMaxTokens = 4096
+)
+
+type anthropicProvider struct{}
+
+func init() {
+	registerProvider(anthropicName, anthropicProvider{})
+}
+
+func (anthropicProvider) base() string {
+	return strings.TrimSuffix(cmp.Or(os.Getenv("ANTHROPIC_API_BASE"), defaultAnthropicURL), "/")
+}
+
+// ResolveModel maps an OpenAI model name to the configured Claude model.
+func (anthropicProvider) ResolveModel(model string) string {
+	if strings.HasPrefix(model, "claude") {
+		return model
+	}
+	cfg := getConfig().Anthropic
+	if strings.Contains(model, "-mini") || strings.Contains(model, "-nano") {
+		return cmp.Or(cfg.SmallModel, defaultClaudeSmallModel)
+	}
+	return cmp.Or(cfg.Model, defaultClaudeModel)
+}
+
+func (a anthropicProvider) Complete(req ChatRequest) (Message, error) { return a.chat(req, nil) }
+
+func (a anthropicProvider) Stream(req ChatRequest, onToken func(string)) (Message, error) {
+	return a.chat(req, onToken)
+}
+
+func (anthropicProvider) Embed(model string, texts []string) ([][]float32, error) {
+	return providers[defaultProvider].Embed(model, texts)
+}
+
+// EstimateTokens counts locally, for when the API isn't asked.
+func (anthropicProvider) EstimateTokens(s string) int { return claudeTokens(s) }
+
+// CountTokens counts a request's input with Claude's own tokenizer.
+func (a anthropicProvider) CountTokens(r ChatRequest) (int, error) {
+	payload := anthropicPayload(r)
+	for _, k := range []string{"max_tokens", "temperature", "stop_sequences"} {
+		delete(payload, k)
+	}
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	resp, err := a.post(context.Background(), "/v1/messages/count_tokens", payload)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("%s: decode: %w", anthropicName, err)
+	}
+	return out.InputTokens, nil
+}
+
+// anthropicBlock is a content block, in requests and responses.
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`          // tool_use
+	Name      string          `json:"name,omitempty"`        // tool_use
+	Input     json.RawMessage `json:"input,omitempty"`       // tool_use
+	ToolUseID string          `json:"tool_use_id,omitempty"` // tool_result
+	Content   string          `json:"content,omitempty"`     // tool_result
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicPayload translates a request: system messages become the
+// system field, tool calls and results become tool_use and tool_result
+// blocks, and consecutive messages from one side are merged, since turns
+// must alternate.
+func anthropicPayload(r ChatRequest) map[string]any {
+	var system []string
+	var msgs []anthropicMessage
+	add := func(role string, b anthropicBlock) {
+		if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+			msgs[n-1].Content = append(msgs[n-1].Content, b)
+			return
+		}
+		msgs = append(msgs, anthropicMessage{Role: role, Content: []anthropicBlock{b}})
+	}
+	for _, m := range r.Messages {
+		switch m.Role {
+		case "system":
+			if m.Content != "" {
+				system = append(system, m.Content)
+			}
+		case "tool":
+			add("user", anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		default:
+			role := "user"
+			if m.Role == "assistant" {
+				role = "assistant"
+			}
+			if strings.TrimSpace(m.Content) != "" {
+				add(role, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				input := json.RawMessage(cmp.Or(strings.TrimSpace(tc.Function.Arguments), "{}"))
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				add("assistant", anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: input})
+			}
+		}
+	}
+	if len(msgs) > 0 && msgs[0].Role != "user" {
+		msgs = append([]anthropicMessage{{Role: "user", Content: []anthropicBlock{{Type: "text", Text: "(continued)"}}}}, msgs...)
+	}
+
+	payload := map[string]any{
+		"model":       r.Model,
+		"messages":    msgs,
+		"max_tokens":  cmp.Or(r.MaxTokens, defaultClaudeMaxTokens),
+		"temperature": min(r.Temperature, 1), // Claude's range is 0 to 1
+	}
+	if len(system) > 0 {
+		payload["system"] = strings.Join(system, "\n\n")
+	}
+	if len(r.Stop) > 0 {
+		payload["stop_sequences"] = r.Stop
+	}
+	if len(r.Tools) > 0 {
+		payload["tools"] = anthropicTools(r.Tools)
+	}
+	return payload
+}
+
+// anthropicTools converts tool definitions from the chat completions
+// format.
+func anthropicTools(defs []map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(defs))
+	for _, d := range defs {
+		fn, _ := d["function"].(map[string]any)
+		if fn == nil {
+			continue
+		}
+		schema := fn["parameters"]
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		out = append(out, map[string]any{"name": fn["name"], "description": fn["description"], "input_schema": schema})
+	}
+	return out
+}
+
+// post sends a request to the API and returns the response if it
+// succeeded.
+func (a anthropicProvider) post(ctx context.Context, path string, payload map[string]any) (*http.Response, error) {
+	key := os.Getenv("ANTHROPIC_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("%s: ANTHROPIC_API_KEY env missing", anthropicName)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.base()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", key)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s – %s", anthropicName, resp.Status, bytes.TrimSpace(b))
+	}
+	return resp, nil
+}
+
+func (a anthropicProvider) chat(r ChatRequest, onToken func(string)) (Message, error) {
+	stream := onToken != nil
+	payload := anthropicPayload(r)
+	payload["stream"] = stream
+
+	ctx, gotToken, done := watchFirstToken(stream)
+	defer done()
+	resp, err := a.post(ctx, "/v1/messages", payload)
+	if err != nil {
+		return Message{}, requestErr(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if !stream {
+		var out struct {
+			Content []anthropicBlock `json:"content"`
+			Usage   anthropicUsage   `json:"usage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return Message{}, requestErr(ctx, fmt.Errorf("decode: %w", err))
+		}
+		return anthropicReply(out.Content, out.Usage), nil
+	}
+
+	var st anthropicStream
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return Message{}, requestErr(ctx, fmt.Errorf("stream read: %w", err))
+			}
+			break
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		if err := st.event([]byte(strings.TrimSpace(line[len("data:"):])), onToken, gotToken); err != nil {
+			return Message{}, fmt.Errorf("%s: %w", anthropicName, err)
+		}
+	}
+	return st.reply(), nil
+}
+
+// anthropicStream collects a streamed reply. The stream is a series of
+// events, each a JSON object with a type: content blocks start, grow by
+// deltas and stop, and the usage comes at the start and end. Bedrock
+// streams the same events for Claude (see bedrock.go).
+type anthropicStream struct {
+	blocks []anthropicBlock
+	usage  anthropicUsage
+}
+
+// event adds one event to the reply, passing text to onToken and calling
+// got when content arrives.
+func (s *anthropicStream) event(data []byte, onToken func(string), got func()) error {
+	var ev struct {
+		Type    string `json:"type"`
+		Index   int    `json:"index"`
+		Message struct {
+			Usage anthropicUsage `json:"usage"`
+		} `json:"message"`
+		ContentBlock anthropicBlock `json:"content_block"`
+		Delta        struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+		} `json:"delta"`
+		Usage anthropicUsage `json:"usage"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil
+	}
+	switch ev.Type {
+	case "message_start":
+		s.usage = ev.Message.Usage
+	case "content_block_start":
+		for len(s.blocks) <= ev.Index {
+			s.blocks = append(s.blocks, anthropicBlock{})
+		}
+		b := ev.ContentBlock
+		b.Input = nil // arrives as deltas
+		s.blocks[ev.Index] = b
+	case "content_block_delta":
+		if ev.Index >= len(s.blocks) {
+			return nil
+		}
+		got()
+		switch ev.Delta.Type {
+		case "text_delta":
+			onToken(ev.Delta.Text)
+			s.blocks[ev.Index].Text += ev.Delta.Text
+		case "input_json_delta":
+			s.blocks[ev.Index].Input = append(s.blocks[ev.Index].Input, ev.Delta.PartialJSON...)
+		}
+	case "message_delta":
+		s.usage.OutputTokens = ev.Usage.OutputTokens
+	case "error":
+		return fmt.Errorf("%s: %s", ev.Error.Type, ev.Error.Message)
+	}
+	return nil
+}
+
+func (s *anthropicStream) reply() Message { return anthropicReply(s.blocks, s.usage) }
+
+// anthropicReply turns response content blocks into a message.
+func anthropicReply(blocks []anthropicBlock, usage anthropicUsage) Message {
+	m := Message{Role: "assistant", Usage: &TokenUsage{Input: usage.InputTokens, Output: usage.OutputTokens}}
+	var text strings.Builder
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "tool_use":
+			args := cmp.Or(string(b.Input), "{}")
+			m.ToolCalls = append(m.ToolCalls, ToolCall{ID: b.ID, Type: "function", Function: ToolFunction{Name: b.Name, Arguments: args}})
+		}
+	}
+	m.Content = text.String()
+	return m
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Everything the binary needs at runtime besides the user's own files is
+// embedded, so go-chat can be copied to a server as a single file.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+var assetsDirPath string
+
+// asset returns the named asset (e.g. "prompts/checkin.txt"), preferring a
+// copy exported to ~/.go-chat-assets so users can customise it.
+func asset(name string) string {
+	if data, err := os.ReadFile(filepath.Join(assetsDirPath, name)); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	data, err := embeddedAssets.ReadFile("assets/" + name)
+	if err != nil {
+		log.Fatalf("asset %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func prompt(name string) string { return asset("prompts/" + name + ".txt") }
+
+func runAssets(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat assets list | export [-force] [dir]")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		_ = fs.WalkDir(embeddedAssets, "assets", func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				fmt.Println(strings.TrimPrefix(p, "assets/"))
+			}
+			return err
+		})
+	case "export":
+		fset := flag.NewFlagSet("assets export", flag.ExitOnError)
+		force := fset.Bool("force", false, "Overwrite existing files")
+		fset.Parse(args[1:])
+
+		dir := assetsDirPath
+		if fset.NArg() > 0 {
+			dir = fset.Arg(0)
+		}
+		if err := exportAssets(dir, *force); err != nil {
+			log.Fatalf("export assets: %v", err)
+		}
+	default:
+		log.Fatalf("unknown assets command %q", args[0])
+	}
+}
+
+func exportAssets(dir string, force bool) error {
+	return fs.WalkDir(embeddedAssets, "assets", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		dst := filepath.Join(dir, strings.TrimPrefix(p, "assets/"))
+		if _, err := os.Stat(dst); err == nil && !force {
+			fmt.Printf("skip %s (exists)\n", dst)
+			return nil
+		}
+		data, err := embeddedAssets.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		fmt.Println(dst)
+		return os.WriteFile(dst, data, 0o644)
+	})
+}
@@ -0,0 +1,183 @@
+# bash completion for go-chat
+# source this file or drop it in /etc/bash_completion.d/
+
+_go_chat() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    case "$prev" in
+        -f|-diff|-o)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return
+            ;;
+        -lint)
+            COMPREPLY=($(compgen -W "similarity model off" -- "$cur"))
+            return
+            ;;
+        -length)
+            COMPREPLY=($(compgen -W "short normal detailed" -- "$cur"))
+            return
+            ;;
+        -input-format)
+            COMPREPLY=($(compgen -W "text json" -- "$cur"))
+            return
+            ;;
+        -provider)
+            COMPREPLY=($(compgen -W "openai anthropic ollama bedrock openrouter" -- "$cur"))
+            return
+            ;;
+    esac
+
+    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+        COMPREPLY=($(compgen -W "assets audit batch bookmarks changelog cron daemon data dnd edit flow gen gh index journal jq log logs memory models notebook persona plugins regex repo retry-last serve shell-hook snip sql task token track wipe" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == assets && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "export list" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == bookmarks && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "export list remove show" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == data && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == changelog ]]; then
+        COMPREPLY=($(compgen -W "-version -raw -model" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == gh ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "describe" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -W "-base -template -push -y -model" -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == regex ]]; then
+        COMPREPLY=($(compgen -W "-match -no-match -f -rounds" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == jq ]]; then
+        COMPREPLY=($(compgen -W "-f -rounds" -f -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == batch ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "run submit status fetch cancel" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -W "-model -system -temp -max-tokens -o -wait -every -y" -f -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == shell-hook ]]; then
+        COMPREPLY=($(compgen -W "bash zsh" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == models ]]; then
+        COMPREPLY=($(compgen -W "-provider" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == daemon ]]; then
+        COMPREPLY=($(compgen -W "status" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == dnd ]]; then
+        COMPREPLY=($(compgen -W "off 30m 1h 2h" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == cron ]]; then
+        COMPREPLY=($(compgen -W "-systemd -tz -rounds" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == edit ]]; then
+        COMPREPLY=($(compgen -W "-model -y" -f -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == sql ]]; then
+        COMPREPLY=($(compgen -W "-dsn -write -y -raw" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == flow && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "list standup weekly -y -no-post" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == track ]]; then
+        COMPREPLY=($(compgen -W "report -weeks -raw" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == journal && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "list show" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == retry-last ]]; then
+        COMPREPLY=($(compgen -W "-model -list -force -timeout" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == wipe ]]; then
+        COMPREPLY=($(compgen -W "-all -memories -logs -sessions -local -y" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == gen && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "tests" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == log ]]; then
+        COMPREPLY=($(compgen -W "browse -date -since -all -session -grep -reverse -n" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == logs ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "analyze" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == memory ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "export import quantize reembed" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == notebook ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "run" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == persona ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "install list remove" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == snip && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "list remove search show" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == repo && $COMP_CWORD -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "ask" -- "$cur"))
+        return
+    fi
+    if [[ ${COMP_WORDS[1]} == index ]]; then
+        if [[ $COMP_CWORD -eq 2 ]]; then
+            COMPREPLY=($(compgen -W "add list remove status update" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -f -- "$cur"))
+        fi
+        return
+    fi
+
+    COMPREPLY=($(compgen -W "-fusion -c -p -a -n -i -d -t -f -u -ai -b -force -y -persona -profile -provider -pager -o -overwrite -q -input-format -grounded -fix-loop -fix-rounds -ns -all-ns -auto-session -resume -samples -seed -deterministic -ephemeral -timeout -first-token-timeout -length -env -show-context -lint -diff -v -h" -- "$cur"))
+}
+complete -F _go_chat go-chat
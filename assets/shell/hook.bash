@@ -0,0 +1,15 @@
+# go-chat shell hook for bash: records the last command that failed, for
+# "env_context". Add to ~/.bashrc:
+#   eval "$(go-chat shell-hook bash)"
+__gochat_hook() {
+    local ret=$? entry
+    entry=$(HISTTIMEFORMAT= history 1)
+    if [[ $ret -ne 0 && $entry != "$__gochat_last" ]]; then
+        printf '%s\t%s\t%s\t%s\n' "$(date +%s)" "$ret" "$PWD" "$(sed '1s/^ *[0-9]*\*\{0,1\} *//' <<<"$entry")" >"$HOME/.go-chat-lastfail"
+    fi
+    __gochat_last=$entry
+    return $ret
+}
+if [[ $PROMPT_COMMAND != *__gochat_hook* ]]; then
+    PROMPT_COMMAND="__gochat_hook${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+fi
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit log records every request go-chat sends over the network
+// (providers, embeddings, tool fetches) as one JSON line in
+// ~/.go-chat-audit.jsonl. It is opened append-only and each entry carries
+// the hash of the previous line, so `go-chat audit verify` can tell if it
+// was edited or truncated in the middle.
+//
+// Enable with "audit": {"mode": "hash"} (body digest only) or "full"
+// (body included) in the config.
+const (
+	auditOff  = "off"
+	auditHash = "hash"
+	auditFull = "full"
+)
+
+var auditFilePath string
+
+type AuditConfig struct {
+	Mode string `json:"mode"`
+}
+
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	Destination string    `json:"destination"`
+	Bytes       int       `json:"bytes"`
+	SHA256      string    `json:"sha256"`
+	Body        string    `json:"body,omitempty"`
+	Prev        string    `json:"prev"`
+}
+
+// auditMode is read once per process rather than on every request.
+var auditMode = sync.OnceValue(func() string { return getConfig().Audit.Mode })
+
+// auditTransport records outbound requests before handing them on.
+type auditTransport struct {
+	base http.RoundTripper
+}
+
+func (t auditTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	mode := auditMode()
+	if mode == "" || mode == auditOff {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	sum := sha256.Sum256(body)
+	e := AuditEntry{
+		Time:        time.Now().UTC(),
+		Method:      req.Method,
+		Destination: req.URL.Redacted(),
+		Bytes:       len(body),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	if mode == auditFull {
+		e.Body = string(body)
+	}
+	if err := appendAudit(e); err != nil {
+		// Refuse to send what we couldn't record.
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// appendAudit chains e to the last line under the log's lock, so other
+// go-chat processes appending at the same time can't fork the chain.
+func appendAudit(e AuditEntry) error {
+	defer lockFile(auditFilePath)()
+
+	prev, err := lastAuditHash()
+	if err != nil {
+		return err
+	}
+	e.Prev = prev
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(auditFilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// lastAuditHash is the hash of the last line in the log, or "" if empty.
+// It reads backwards from the end, so only the last line is read.
+func lastAuditHash() (string, error) {
+	f, err := os.Open(auditFilePath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Skip the trailing newline, then read chunks back to the one before.
+	end := info.Size()
+	nl := make([]byte, 1)
+	if end > 0 {
+		if _, err := f.ReadAt(nl, end-1); err != nil {
+			return "", err
+		}
+		if nl[0] == '\n' {
+			end--
+		}
+	}
+	start := int64(0)
+	buf := make([]byte, 4096)
+	for pos := end; pos > 0; {
+		n := min(pos, int64(len(buf)))
+		pos -= n
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return "", err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			start = pos + int64(i) + 1
+			break
+		}
+	}
+	last := make([]byte, end-start)
+	if _, err := f.ReadAt(last, start); err != nil {
+		return "", err
+	}
+	if len(last) == 0 {
+		return "", nil
+	}
+	sum := sha256.Sum256(last)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readAudit(fn func(lineNo int, raw []byte, e AuditEntry) error) error {
+	f, err := os.Open(auditFilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := fn(n, sc.Bytes(), e); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func runAudit(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat audit show [-n N] [-since YYYY-MM-DD] [-body] | verify")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "show":
+		fset := flag.NewFlagSet("audit show", flag.ExitOnError)
+		n := fset.Int("n", 0, "Show only the last N entries")
+		since := fset.String("since", "", "Only entries on or after this date")
+		withBody := fset.Bool("body", false, "Print recorded bodies (full mode)")
+		fset.Parse(args[1:])
+
+		var from time.Time
+		if *since != "" {
+			var err error
+			if from, err = time.ParseInLocation(time.DateOnly, *since, time.Local); err != nil {
+				log.Fatalf("audit show: -since: %v", err)
+			}
+		}
+
+		var entries []AuditEntry
+		err := readAudit(func(_ int, _ []byte, e AuditEntry) error {
+			if !e.Time.Before(from) {
+				entries = append(entries, e)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("audit show: %v", err)
+		}
+		if *n > 0 && len(entries) > *n {
+			entries = entries[len(entries)-*n:]
+		}
+		for _, e := range entries {
+			fmt.Printf("%s  %-6s %-50s %8d B  sha256:%s\n",
+				e.Time.Local().Format(time.RFC3339), e.Method, e.Destination, e.Bytes, e.SHA256[:16])
+			if *withBody && e.Body != "" {
+				fmt.Println(indent(e.Body, "    "))
+			}
+		}
+
+	case "verify":
+		prev := ""
+		count := 0
+		err := readAudit(func(n int, raw []byte, e AuditEntry) error {
+			if e.Prev != prev {
+				return fmt.Errorf("line %d: chain broken (log edited or lines removed)", n)
+			}
+			sum := sha256.Sum256(raw)
+			prev = hex.EncodeToString(sum[:])
+			count++
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("audit verify: %v", err)
+		}
+		fmt.Printf("ok: %d entries\n", count)
+
+	default:
+		log.Fatalf("unknown audit command %q", args[0])
+	}
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Scopes granted to API tokens and OIDC identities. admin implies the
+// others and is needed for anything that writes memory or config.
+const (
+	scopeChat       = "chat"
+	scopeMemoryRead = "memory:read"
+	scopeAdmin      = "admin"
+)
+
+var allScopes = []string{scopeChat, scopeMemoryRead, scopeAdmin}
+
+const apiTokenPrefix = "gct_"
+
+var tokensFilePath string
+
+// APIToken is a stored server credential. Only the SHA-256 of the secret is
+// kept; the secret itself is shown once when issued.
+type APIToken struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	Scopes      []string  `json:"scopes"`
+	MemoryScope string    `json:"memory_scope,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// ServerConfig is the "server" section of the config file.
+type ServerConfig struct {
+	OIDC  *OIDCConfig `json:"oidc,omitempty"`
+	Queue QueueConfig `json:"queue,omitempty"`
+}
+
+// OIDCConfig enables bearer JWTs from an OpenID Connect issuer. Scopes are
+// read from ScopesClaim (default "scope"), as a space-separated string or a
+// list, and only the go-chat scope names in it count. OIDC users share the
+// MemoryScope memory scope (default team).
+type OIDCConfig struct {
+	Issuer      string `json:"issuer"`
+	Audience    string `json:"audience"`
+	ScopesClaim string `json:"scopes_claim,omitempty"`
+	MemoryScope string `json:"memory_scope,omitempty"`
+}
+
+type Identity struct {
+	Name   string
+	Scopes []string
+	// MemoryScope is the memory scope the identity's conversations run in.
+	MemoryScope string
+}
+
+func (id *Identity) has(scope string) bool {
+	return id != nil && (slices.Contains(id.Scopes, scopeAdmin) || slices.Contains(id.Scopes, scope))
+}
+
+func loadTokens() []APIToken {
+	var toks []APIToken
+	if data, err := os.ReadFile(tokensFilePath); err == nil {
+		_ = json.Unmarshal(data, &toks)
+	}
+	return toks
+}
+
+func saveTokens(toks []APIToken) error {
+	data, _ := json.MarshalIndent(toks, "", "  ")
+	return writeFileAtomic(tokensFilePath, data, 0o600)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func runToken(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat token issue -name N [-scopes chat,memory:read,admin] [-memory private|team|global] | list | revoke <id|name>")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "issue":
+		fset := flag.NewFlagSet("token issue", flag.ExitOnError)
+		name := fset.String("name", "", "Label for the token (e.g. phone)")
+		scopes := fset.String("scopes", scopeChat, "Comma-separated scopes: "+strings.Join(allScopes, ", "))
+		memScope := fset.String("memory", memScopePrivate, "Memory scope the token's conversations use: private, team or global")
+		fset.Parse(args[1:])
+		if *name == "" {
+			log.Fatal("token issue: -name is required")
+		}
+		if err := validMemScope(*memScope); err != nil {
+			log.Fatalf("token issue: %v", err)
+		}
+
+		var granted []string
+		for _, s := range strings.Split(*scopes, ",") {
+			s = strings.TrimSpace(s)
+			if !slices.Contains(allScopes, s) {
+				log.Fatalf("token issue: unknown scope %q", s)
+			}
+			granted = append(granted, s)
+		}
+
+		raw := make([]byte, 24)
+		if _, err := rand.Read(raw); err != nil {
+			log.Fatalf("token issue: %v", err)
+		}
+		secret := apiTokenPrefix + hex.EncodeToString(raw)
+		tok := APIToken{
+			ID:          hashToken(secret)[:8],
+			Name:        *name,
+			Hash:        hashToken(secret),
+			Scopes:      granted,
+			MemoryScope: *memScope,
+			Created:     time.Now(),
+		}
+		defer lockFile(tokensFilePath)()
+		if err := saveTokens(append(loadTokens(), tok)); err != nil {
+			log.Fatalf("token issue: %v", err)
+		}
+		fmt.Printf("issued %s (%s) scopes=%s memory=%s\n", tok.ID, tok.Name, strings.Join(granted, ","), tok.MemoryScope)
+		fmt.Println(secret)
+		fmt.Fprintln(os.Stderr, "store it now, it will not be shown again")
+
+	case "list":
+		for _, t := range loadTokens() {
+			fmt.Printf("%s  %-16s %-28s %-8s %s\n", t.ID, t.Name, strings.Join(t.Scopes, ","), normScope(t.MemoryScope), t.Created.Format(time.DateOnly))
+		}
+
+	case "revoke":
+		if len(args) != 2 {
+			log.Fatal("usage: go-chat token revoke <id|name>")
+		}
+		defer lockFile(tokensFilePath)()
+		toks := loadTokens()
+		kept := toks[:0]
+		for _, t := range toks {
+			if t.ID != args[1] && t.Name != args[1] {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == len(toks) {
+			log.Fatalf("token revoke: no token %q", args[1])
+		}
+		if err := saveTokens(kept); err != nil {
+			log.Fatalf("token revoke: %v", err)
+		}
+		fmt.Printf("revoked %d token(s)\n", len(toks)-len(kept))
+
+	default:
+		log.Fatalf("unknown token command %q", args[0])
+	}
+}
+
+// authenticator checks bearer credentials for the server. Tokens are re-read
+// on every request so revocation takes effect without a restart. When auth
+// is off every request runs as the anonymous identity.
+type authenticator struct {
+	enabled bool
+	anon    Identity
+	oidc    *oidcVerifier
+}
+
+// newAuthenticator returns an authenticator that lets everyone in as an
+// admin in anonScope when enabled is false; runServe only allows that on
+// loopback addresses. OIDC needs an audience so tokens minted for other
+// clients of the issuer aren't accepted.
+func newAuthenticator(cfg ServerConfig, enabled bool, anonScope string) (*authenticator, error) {
+	a := &authenticator{
+		enabled: enabled,
+		anon:    Identity{Name: "anonymous", Scopes: []string{scopeAdmin}, MemoryScope: anonScope},
+	}
+	if cfg.OIDC != nil && cfg.OIDC.Issuer != "" {
+		if cfg.OIDC.Audience == "" {
+			return nil, errors.New("server.oidc.audience is required")
+		}
+		a.oidc = &oidcVerifier{cfg: *cfg.OIDC}
+	}
+	return a, nil
+}
+
+func (a *authenticator) identify(bearer string) (*Identity, error) {
+	if !a.enabled {
+		return &a.anon, nil
+	}
+	if bearer == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	if strings.HasPrefix(bearer, apiTokenPrefix) {
+		h := hashToken(bearer)
+		for _, t := range loadTokens() {
+			if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(h)) == 1 {
+				return &Identity{Name: "token:" + t.Name, Scopes: t.Scopes, MemoryScope: normScope(t.MemoryScope)}, nil
+			}
+		}
+		return nil, errors.New("unknown token")
+	}
+	if a.oidc != nil && strings.Count(bearer, ".") == 2 {
+		return a.oidc.verify(bearer)
+	}
+	return nil, errors.New("unrecognised token")
+}
+
+type identityKey struct{}
+
+func withIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityFrom returns the caller set by the auth middleware. Without one
+// (which shouldn't happen) it falls back to the most restricted view.
+func identityFrom(ctx context.Context) *Identity {
+	if id, ok := ctx.Value(identityKey{}).(*Identity); ok {
+		return id
+	}
+	return &Identity{Name: "unknown", MemoryScope: memScopeGlobal}
+}
+
+// bearerFromRequest reads the Authorization header.
+func bearerFromRequest(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// bearerFromWebSocket also accepts a ?token= query parameter, for browsers
+// opening a WebSocket, which can't set headers. Other routes don't, so
+// tokens stay out of URLs and logs.
+func bearerFromWebSocket(r *http.Request) string {
+	if b := bearerFromRequest(r); b != "" {
+		return b
+	}
+	return r.URL.Query().Get("token")
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Hijack lets WebSocket upgrades through the recorder.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// require wraps h so it only runs for identities holding scope, and puts
+// the identity in the request context. Every request is logged with who
+// made it.
+func (a *authenticator) require(scope string, h http.Handler) http.Handler {
+	return a.requireWith(bearerFromRequest, scope, h)
+}
+
+// requireWebSocket is require for the WebSocket route, which may also
+// take the token from the query.
+func (a *authenticator) requireWebSocket(scope string, h http.Handler) http.Handler {
+	return a.requireWith(bearerFromWebSocket, scope, h)
+}
+
+func (a *authenticator) requireWith(bearer func(*http.Request) string, scope string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		who := "anonymous"
+
+		id, err := a.identify(bearer(r))
+		switch {
+		case err != nil:
+			writeOpenAIError(rec, http.StatusUnauthorized, err.Error())
+		case !id.has(scope):
+			who = id.Name
+			writeOpenAIError(rec, http.StatusForbidden, "token lacks scope "+scope)
+		default:
+			who = id.Name
+			h.ServeHTTP(rec, r.WithContext(withIdentity(r.Context(), id)))
+		}
+		log.Printf("%s %s %s %d %s", who, r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// grpcScopes maps RPC names to the scope they need.
+var grpcScopes = map[string]string{
+	"Chat":         scopeChat,
+	"ListSessions": scopeMemoryRead,
+	"GetSession":   scopeMemoryRead,
+	"ListMemories": scopeMemoryRead,
+	"GetConfig":    scopeMemoryRead,
+	"AddMemory":    scopeAdmin,
+	"DeleteMemory": scopeAdmin,
+	"UpdateConfig": scopeAdmin,
+}
+
+func (a *authenticator) grpcCheck(ctx context.Context, fullMethod string) (*Identity, error) {
+	var bearer string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			bearer = strings.TrimPrefix(v[0], "Bearer ")
+		}
+	}
+	id, err := a.identify(bearer)
+	if err != nil {
+		return &Identity{Name: "anonymous"}, status.Error(codes.Unauthenticated, err.Error())
+	}
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	scope, ok := grpcScopes[method]
+	if !ok {
+		scope = scopeAdmin
+	}
+	if !id.has(scope) {
+		return id, status.Error(codes.PermissionDenied, "token lacks scope "+scope)
+	}
+	return id, nil
+}
+
+func (a *authenticator) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	id, err := a.grpcCheck(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = h(withIdentity(ctx, id), req)
+	}
+	log.Printf("%s grpc %s %s %s", id.Name, info.FullMethod, status.Code(err), time.Since(start).Round(time.Millisecond))
+	return resp, err
+}
+
+// identityStream overrides Context so stream handlers see the caller.
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s identityStream) Context() context.Context { return s.ctx }
+
+func (a *authenticator) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+	start := time.Now()
+	id, err := a.grpcCheck(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = h(srv, identityStream{ServerStream: ss, ctx: withIdentity(ss.Context(), id)})
+	}
+	log.Printf("%s grpc %s %s %s", id.Name, info.FullMethod, status.Code(err), time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+// oidcVerifier validates RS256 ID/access tokens against the issuer's JWKS,
+// which is fetched on first use and again when an unknown key id shows up.
+type oidcVerifier struct {
+	cfg OIDCConfig
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func (v *oidcVerifier) verify(raw string) (*Identity, error) {
+	parts := strings.Split(raw, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("jwt header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("jwt: unsupported alg %q", header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("jwt signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("jwt: bad signature")
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("jwt claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+		return nil, fmt.Errorf("jwt: issuer %q not trusted", iss)
+	}
+	if v.cfg.Audience == "" || !claimContains(claims["aud"], v.cfg.Audience) {
+		return nil, errors.New("jwt: wrong audience")
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); !ok || now > exp {
+		return nil, errors.New("jwt: expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, errors.New("jwt: not yet valid")
+	}
+
+	claim := v.cfg.ScopesClaim
+	if claim == "" {
+		claim = "scope"
+	}
+	var scopes []string
+	for _, s := range allScopes {
+		if claimContains(claims[claim], s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	name, _ := claims["email"].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	memScope := v.cfg.MemoryScope
+	if memScope == "" {
+		memScope = memScopeTeam
+	}
+	return &Identity{Name: "oidc:" + name, Scopes: scopes, MemoryScope: memScope}, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimContains handles claims that may be a single (space-separated)
+// string or a list of strings, as aud and scope both can be.
+func claimContains(claim any, want string) bool {
+	switch c := claim.(type) {
+	case string:
+		return slices.Contains(strings.Fields(c), want)
+	case []any:
+		for _, x := range c {
+			if s, _ := x.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *oidcVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(v.fetched) < time.Minute {
+		return nil, fmt.Errorf("jwt: unknown key %q", kid)
+	}
+	keys, err := fetchJWKS(v.cfg.Issuer)
+	v.fetched = time.Now()
+	if err != nil {
+		return nil, fmt.Errorf("oidc: %w", err)
+	}
+	v.keys = keys
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("jwt: unknown key %q", kid)
+}
+
+func fetchJWKS(issuer string) (map[string]*rsa.PublicKey, error) {
+	var disc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &disc); err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := getJSON(disc.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func getJSON(url string, v any) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
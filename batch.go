@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// `go-chat batch` answers a file of prompts, one per line, and writes the
+// answers to results.jsonl. A line is either plain text or an object
+// {"id", "prompt", "system"}; lines without an id are numbered. Each
+// result is {"id", "prompt", "answer"}, or "error" in place of the answer,
+// in the order of the input.
+//
+// `batch run` asks the model one prompt at a time, straight away. For
+// jobs that can wait, `batch submit` sends them all through OpenAI's Batch
+// API instead, at half the price, and `batch fetch` writes the same
+// results once the batch is done (within 24 hours). Submitted batches are
+// remembered in ~/.go-chat-batches.json until fetched, so `batch status`
+// can list them. Batched requests don't run tools.
+const (
+	batchEndpoint   = "/v1/chat/completions"
+	batchWindow     = "24h"
+	batchDiscount   = 0.5
+	defaultBatchOut = "results.jsonl"
+)
+
+var batchesFilePath string
+
+// batchPrompt is one line of the input.
+type batchPrompt struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+	System string `json:"system,omitempty"`
+}
+
+type batchResult struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+	Answer string `json:"answer,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchJob is a submitted batch, kept until its results are fetched.
+type batchJob struct {
+	ID      string            `json:"id"`
+	Input   string            `json:"input"`
+	Model   string            `json:"model"`
+	Created time.Time         `json:"created"`
+	Prompts map[string]string `json:"prompts"`
+	Order   []string          `json:"order"`
+}
+
+// openAIBatch is the API's batch object, as far as go-chat reads it.
+type openAIBatch struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	OutputFileID  string `json:"output_file_id"`
+	ErrorFileID   string `json:"error_file_id"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+	Errors *struct {
+		Data []struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	} `json:"errors"`
+}
+
+// done says whether the batch has stopped; expired and cancelled batches
+// may still have results for some of their requests.
+func (b openAIBatch) done() bool {
+	switch b.Status {
+	case "completed", "failed", "expired", "cancelled":
+		return true
+	}
+	return false
+}
+
+func runBatch(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat batch run [-model M] [-system S] [-o results.jsonl] <prompts> | submit [-model M] [-system S] [-y] <prompts> | status [id] | fetch [-wait] [-o results.jsonl] <id> | cancel <id>")
+		os.Exit(2)
+	}
+
+	fset := flag.NewFlagSet("batch "+args[0], flag.ExitOnError)
+	model := fset.String("model", modelLogic, "Model to answer with")
+	system := fset.String("system", "", "System prompt for lines that don't set one")
+	temp := fset.Float64("temp", 0.7, "Temperature")
+	maxTok := fset.Int("max-tokens", 1024, "Longest answer, in tokens")
+	out := fset.String("o", defaultBatchOut, "File to write the results to")
+	wait := fset.Bool("wait", false, "Wait for the batch to finish instead of failing")
+	every := fset.Duration("every", time.Minute, "How often -wait checks")
+	fset.BoolVar(&assumeYes, "y", false, "Submit without showing the cost first")
+	fset.Parse(args[1:])
+
+	switch args[0] {
+	case "run":
+		if fset.NArg() != 1 {
+			log.Fatal("batch run: need one prompts file (- for stdin)")
+		}
+		prompts, err := readBatchPrompts(fset.Arg(0))
+		if err != nil {
+			log.Fatalf("batch run: %v", err)
+		}
+		results := make([]batchResult, len(prompts))
+		failed := 0
+		for i, p := range prompts {
+			fmt.Fprintf(os.Stderr, "\r%d/%d", i+1, len(prompts))
+			answer, err := queryGPTStream(*model, cmp.Or(p.System, *system), *temp, *maxTok, []Message{{Role: "user", Content: p.Prompt}}, nil)
+			results[i] = batchResult{ID: p.ID, Prompt: p.Prompt, Answer: answer}
+			if err != nil {
+				results[i].Error = err.Error()
+				failed++
+			}
+		}
+		fmt.Fprintln(os.Stderr)
+		if err := writeBatchResults(*out, results); err != nil {
+			log.Fatalf("batch run: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "%d answers written to %s (%d failed)\n", len(results)-failed, *out, failed)
+
+	case "submit":
+		if fset.NArg() != 1 {
+			log.Fatal("batch submit: need one prompts file (- for stdin)")
+		}
+		prompts, err := readBatchPrompts(fset.Arg(0))
+		if err != nil {
+			log.Fatalf("batch submit: %v", err)
+		}
+		job, err := submitBatch(fset.Arg(0), expandModel(*model), *system, *temp, *maxTok, prompts)
+		if err != nil {
+			log.Fatalf("batch submit: %v", err)
+		}
+		fmt.Printf("submitted batch %s with %d prompts; `go-chat batch fetch %s` when it's done\n", job.ID, len(prompts), job.ID)
+
+	case "status":
+		jobs := loadBatchJobs()
+		if fset.NArg() > 0 {
+			jobs = []batchJob{{ID: fset.Arg(0)}}
+		}
+		if len(jobs) == 0 {
+			fmt.Println("no batches waiting to be fetched")
+		}
+		for _, j := range jobs {
+			b, err := getBatch(j.ID)
+			if err != nil {
+				log.Fatalf("batch status: %v", err)
+			}
+			c := b.RequestCounts
+			fmt.Printf("%s  %-11s %d/%d done, %d failed", b.ID, b.Status, c.Completed, c.Total, c.Failed)
+			if j.Input != "" {
+				fmt.Printf("  %s, %s", j.Input, j.Created.Local().Format("2006-01-02 15:04"))
+			}
+			fmt.Println()
+		}
+
+	case "fetch":
+		if fset.NArg() != 1 {
+			log.Fatal("batch fetch: need a batch id")
+		}
+		b, err := getBatch(fset.Arg(0))
+		for err == nil && !b.done() && *wait {
+			fmt.Fprintf(os.Stderr, "%s: %s, %d/%d done\n", b.ID, b.Status, b.RequestCounts.Completed, b.RequestCounts.Total)
+			time.Sleep(*every)
+			b, err = getBatch(b.ID)
+		}
+		if err != nil {
+			log.Fatalf("batch fetch: %v", err)
+		}
+		if !b.done() {
+			log.Fatalf("batch fetch: %s is still %s (%d/%d done); try again later or use -wait", b.ID, b.Status, b.RequestCounts.Completed, b.RequestCounts.Total)
+		}
+		results, err := fetchBatch(b)
+		if err != nil {
+			log.Fatalf("batch fetch: %v", err)
+		}
+		if err := writeBatchResults(*out, results); err != nil {
+			log.Fatalf("batch fetch: %v", err)
+		}
+		forgetBatchJob(b.ID)
+		fmt.Fprintf(os.Stderr, "batch %s %s: %d results written to %s\n", b.ID, b.Status, len(results), *out)
+
+	case "cancel":
+		if fset.NArg() != 1 {
+			log.Fatal("batch cancel: need a batch id")
+		}
+		var b openAIBatch
+		if err := batchAPI(http.MethodPost, "/v1/batches/"+fset.Arg(0)+"/cancel", nil, "", &b); err != nil {
+			log.Fatalf("batch cancel: %v", err)
+		}
+		fmt.Printf("batch %s is %s; fetch it for any answers it already has\n", b.ID, b.Status)
+
+	default:
+		log.Fatalf("batch: unknown command %q", args[0])
+	}
+}
+
+// readBatchPrompts reads the prompts file, checking that ids are unique.
+func readBatchPrompts(name string) ([]batchPrompt, error) {
+	r := io.Reader(os.Stdin)
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var prompts []batchPrompt
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		p := batchPrompt{Prompt: line}
+		if strings.HasPrefix(line, "{") {
+			p = batchPrompt{}
+			if err := json.Unmarshal([]byte(line), &p); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if p.Prompt == "" {
+				return nil, fmt.Errorf("line %d: no prompt", n)
+			}
+		}
+		if p.ID == "" {
+			p.ID = strconv.Itoa(n)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("line %d: id %q used twice", n, p.ID)
+		}
+		seen[p.ID] = true
+		prompts = append(prompts, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, errors.New("no prompts")
+	}
+	return prompts, nil
+}
+
+func writeBatchResults(path string, results []batchResult) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if path == "-" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// submitBatch uploads the prompts as a file of chat completion requests
+// and starts a batch on it.
+func submitBatch(input, model, system string, temp float64, maxTok int, prompts []batchPrompt) (batchJob, error) {
+	job := batchJob{Input: input, Model: model, Created: time.Now(), Prompts: map[string]string{}}
+	if name := providerName(getConfig()); name != defaultProvider {
+		return job, fmt.Errorf("the Batch API is OpenAI's; the configured provider is %s", name)
+	}
+
+	var lines bytes.Buffer
+	enc := json.NewEncoder(&lines)
+	total := 0
+	for _, p := range prompts {
+		var msgs []Message
+		if s := cmp.Or(p.System, system); s != "" {
+			msgs = append(msgs, Message{Role: "system", Content: s})
+		}
+		msgs = append(msgs, Message{Role: "user", Content: p.Prompt})
+		total += messagesTokens(providers[defaultProvider], "", msgs)
+		err := enc.Encode(map[string]any{
+			"custom_id": p.ID,
+			"method":    http.MethodPost,
+			"url":       batchEndpoint,
+			"body": map[string]any{
+				"model":       model,
+				"messages":    msgs,
+				"temperature": temp,
+				"max_tokens":  maxTok,
+			},
+		})
+		if err != nil {
+			return job, err
+		}
+		job.Prompts[p.ID] = p.Prompt
+		job.Order = append(job.Order, p.ID)
+	}
+
+	if price, ok := modelPrice(getConfig(), model); ok && !assumeYes {
+		cost := batchDiscount * estimateCost(price, total, len(prompts)*maxTok)
+		if cost >= 1 && !confirm(fmt.Sprintf("%d prompts, about %d tokens: up to %s at the batch price. Submit?", len(prompts), total, usd(cost))) {
+			return job, errNotSent
+		}
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("purpose", "batch")
+	fw, err := mw.CreateFormFile("file", "go-chat-batch.jsonl")
+	if err != nil {
+		return job, err
+	}
+	fw.Write(lines.Bytes())
+	mw.Close()
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := batchAPI(http.MethodPost, "/v1/files", &form, mw.FormDataContentType(), &file); err != nil {
+		return job, fmt.Errorf("upload: %w", err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"input_file_id":     file.ID,
+		"endpoint":          batchEndpoint,
+		"completion_window": batchWindow,
+		"metadata":          map[string]string{"source": "go-chat"},
+	})
+	var b openAIBatch
+	if err := batchAPI(http.MethodPost, "/v1/batches", bytes.NewReader(body), "application/json", &b); err != nil {
+		return job, err
+	}
+	job.ID = b.ID
+	saveBatchJob(job)
+	return job, nil
+}
+
+func getBatch(id string) (openAIBatch, error) {
+	var b openAIBatch
+	err := batchAPI(http.MethodGet, "/v1/batches/"+id, nil, "", &b)
+	return b, err
+}
+
+// fetchBatch downloads a finished batch's output and error files and turns
+// them into results, recording what they cost. Prompts the batch never
+// got to are listed with an error.
+func fetchBatch(b openAIBatch) ([]batchResult, error) {
+	if b.Status == "failed" && b.Errors != nil && len(b.Errors.Data) > 0 {
+		return nil, fmt.Errorf("batch %s failed: %s", b.ID, b.Errors.Data[0].Message)
+	}
+	var job batchJob
+	for _, j := range loadBatchJobs() {
+		if j.ID == b.ID {
+			job = j
+		}
+	}
+
+	byID := map[string]batchResult{}
+	var order []string
+	for _, fileID := range []string{b.OutputFileID, b.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		data, err := batchFile(fileID)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+		for sc.Scan() {
+			var line struct {
+				CustomID string `json:"custom_id"`
+				Response *struct {
+					StatusCode int             `json:"status_code"`
+					Body       json.RawMessage `json:"body"`
+				} `json:"response"`
+				Error *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(sc.Bytes(), &line); err != nil || line.CustomID == "" {
+				continue
+			}
+			r := batchResult{ID: line.CustomID, Prompt: job.Prompts[line.CustomID]}
+			switch {
+			case line.Error != nil:
+				r.Error = line.Error.Message
+			case line.Response == nil:
+				r.Error = "no response"
+			case line.Response.StatusCode != http.StatusOK:
+				r.Error = fmt.Sprintf("HTTP %d: %s", line.Response.StatusCode, line.Response.Body)
+			default:
+				var c struct {
+					Model   string `json:"model"`
+					Choices []struct {
+						Message Message `json:"message"`
+					} `json:"choices"`
+					Usage struct {
+						PromptTokens     int `json:"prompt_tokens"`
+						CompletionTokens int `json:"completion_tokens"`
+					} `json:"usage"`
+				}
+				if err := json.Unmarshal(line.Response.Body, &c); err != nil || len(c.Choices) == 0 {
+					r.Error = "no answer in the response"
+					break
+				}
+				r.Answer = c.Choices[0].Message.Content
+				if price, ok := modelPrice(getConfig(), cmp.Or(job.Model, c.Model)); ok {
+					addSpend(batchDiscount * estimateCost(price, c.Usage.PromptTokens, c.Usage.CompletionTokens))
+				}
+			}
+			if _, ok := byID[r.ID]; !ok {
+				order = append(order, r.ID)
+			}
+			byID[r.ID] = r
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Keep the order of the input when it's known.
+	if len(job.Order) > 0 {
+		order = job.Order
+	}
+	results := make([]batchResult, 0, len(order))
+	for _, id := range order {
+		r, ok := byID[id]
+		if !ok {
+			r = batchResult{ID: id, Prompt: job.Prompts[id], Error: "not answered: batch " + b.Status}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func batchFile(id string) ([]byte, error) {
+	var data []byte
+	err := batchAPI(http.MethodGet, "/v1/files/"+id+"/content", nil, "", &data)
+	return data, err
+}
+
+// batchAPI calls the OpenAI API and decodes the JSON answer into v, or
+// copies the raw body if v is a *[]byte.
+func batchAPI(method, path string, body io.Reader, contentType string, v any) error {
+	if apiKey == "" {
+		return errors.New("OPENAI_API_KEY env missing")
+	}
+	req, err := http.NewRequest(method, apiURL+path, body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("openai: %s – %s", resp.Status, bytes.TrimSpace(data))
+	}
+	if raw, ok := v.(*[]byte); ok {
+		*raw = data
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	return nil
+}
+
+func loadBatchJobs() []batchJob {
+	var jobs []batchJob
+	if data, err := os.ReadFile(batchesFilePath); err == nil {
+		_ = json.Unmarshal(data, &jobs)
+	}
+	return jobs
+}
+
+func saveBatchJob(job batchJob) {
+	defer lockFile(batchesFilePath)()
+	writeBatchJobs(append(loadBatchJobs(), job))
+}
+
+func forgetBatchJob(id string) {
+	defer lockFile(batchesFilePath)()
+	jobs := loadBatchJobs()
+	kept := jobs[:0]
+	for _, j := range jobs {
+		if j.ID != id {
+			kept = append(kept, j)
+		}
+	}
+	writeBatchJobs(kept)
+}
+
+func writeBatchJobs(jobs []batchJob) {
+	data, _ := json.MarshalIndent(jobs, "", "  ")
+	if err := writeFileAtomic(batchesFilePath, data, 0o600); err != nil {
+		log.Printf("save batches: %v", err)
+	}
+}
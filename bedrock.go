@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The bedrock provider runs Claude and Llama models hosted in your AWS
+// account through Amazon Bedrock's InvokeModel and
+// InvokeModelWithResponseStream APIs, signing requests with the AWS
+// credentials found as sigv4.go describes. Memories are embedded with
+// Titan, so nothing leaves AWS.
+//
+//	"provider": "bedrock",
+//	"bedrock": {"region": "eu-west-1", "model": "eu.anthropic.claude-sonnet-4-5-20250929-v1:0"}
+//
+// go-chat's OpenAI model names map to "model", or "small_model" for the
+// mini and nano ones; Bedrock model ids are sent as they are. The default
+// models are Claude's cross-region inference profiles for the region.
+// Tool calls work with Claude; Llama answers without tools.
+type BedrockConfig struct {
+	Region         string `json:"region,omitempty"`          // default $AWS_REGION, then us-east-1
+	Model          string `json:"model,omitempty"`           // default Claude Sonnet 4.5
+	SmallModel     string `json:"small_model,omitempty"`     // default Claude Haiku 4.5
+	EmbeddingModel string `json:"embedding_model,omitempty"` // default amazon.titan-embed-text-v2:0
+	Endpoint       string `json:"endpoint,omitempty"`        // default https://bedrock-runtime.<region>.amazonaws.com
+}
+
+const (
+	bedrockName              = "bedrock"
+	bedrockAnthropicVersion  = "bedrock-2023-05-31"
+	defaultBedrockRegion     = "us-east-1"
+	defaultBedrockModel      = "anthropic.claude-sonnet-4-5-20250929-v1:0"
+	defaultBedrockSmallModel = "anthropic.claude-haiku-4-5-20251001-v1:0"
+	defaultBedrockEmbedding  = "amazon.titan-embed-text-v2:0"
+)
+
+type bedrockProvider struct{}
+
+func init() {
+	registerProvider(bedrockName, bedrockProvider{})
+}
+
+func bedrockRegion() string {
+	return cmp.Or(getConfig().Bedrock.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), defaultBedrockRegion)
+}
+
+// ResolveModel maps an OpenAI model name to a Bedrock model id. The
+// default Claude models are only offered through inference profiles,
+// named with the region's geography.
+func (bedrockProvider) ResolveModel(model string) string {
+	if strings.Contains(model, ".") {
+		return model
+	}
+	cfg := getConfig().Bedrock
+	if strings.Contains(model, "-mini") || strings.Contains(model, "-nano") {
+		return cmp.Or(cfg.SmallModel, bedrockProfile(defaultBedrockSmallModel))
+	}
+	return cmp.Or(cfg.Model, bedrockProfile(defaultBedrockModel))
+}
+
+// EstimateTokens counts locally as for Claude, the default models; for
+// Llama it errs on the high side.
+func (bedrockProvider) EstimateTokens(s string) int { return claudeTokens(s) }
+
+func bedrockProfile(model string) string {
+	geo, _, _ := strings.Cut(bedrockRegion(), "-")
+	if geo == "ap" {
+		geo = "apac"
+	}
+	return geo + "." + model
+}
+
+func (bedrockProvider) EmbeddingModel() string {
+	return cmp.Or(getConfig().Bedrock.EmbeddingModel, defaultBedrockEmbedding)
+}
+
+// bedrockFamily is the maker of a model, which decides its request format:
+// "anthropic" or "meta".
+func bedrockFamily(model string) string {
+	for _, f := range []string{"anthropic", "meta"} {
+		if strings.HasPrefix(model, f+".") || strings.Contains(model, "."+f+".") {
+			return f
+		}
+	}
+	return ""
+}
+
+// invoke sends a request body to a model and returns the response if it
+// succeeded. With stream, the response is an event stream.
+func (bedrockProvider) invoke(ctx context.Context, model string, payload any, stream bool) (*http.Response, error) {
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", bedrockName, err)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encode payload: %w", err)
+	}
+	region := bedrockRegion()
+	endpoint := strings.TrimSuffix(cmp.Or(getConfig().Bedrock.Endpoint, "https://bedrock-runtime."+region+".amazonaws.com"), "/")
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/model/"+awsEscape(model)+"/"+action, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	signAWS(req, body, creds, region, "bedrock", time.Now())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s – %s", bedrockName, resp.Status, bytes.TrimSpace(b))
+	}
+	return resp, nil
+}
+
+func (b bedrockProvider) Complete(req ChatRequest) (Message, error) { return b.chat(req, nil) }
+
+func (b bedrockProvider) Stream(req ChatRequest, onToken func(string)) (Message, error) {
+	return b.chat(req, onToken)
+}
+
+func (b bedrockProvider) chat(r ChatRequest, onToken func(string)) (Message, error) {
+	family := bedrockFamily(r.Model)
+	var payload map[string]any
+	switch family {
+	case "anthropic":
+		payload = anthropicPayload(r)
+		delete(payload, "model")
+		payload["anthropic_version"] = bedrockAnthropicVersion
+	case "meta":
+		payload = map[string]any{
+			"prompt":      llamaPrompt(r.Messages),
+			"max_gen_len": cmp.Or(r.MaxTokens, 2048),
+			"temperature": r.Temperature,
+			"top_p":       0.96,
+		}
+	default:
+		return Message{}, fmt.Errorf("%s: model %q: only Anthropic Claude and Meta Llama models are supported", bedrockName, r.Model)
+	}
+
+	stream := onToken != nil
+	ctx, gotToken, done := watchFirstToken(stream)
+	defer done()
+	resp, err := b.invoke(ctx, r.Model, payload, stream)
+	if err != nil {
+		return Message{}, requestErr(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if !stream {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return Message{}, requestErr(ctx, fmt.Errorf("read: %w", err))
+		}
+		var reply Message
+		if family == "anthropic" {
+			var out struct {
+				Content []anthropicBlock `json:"content"`
+				Usage   anthropicUsage   `json:"usage"`
+			}
+			if err := json.Unmarshal(data, &out); err != nil {
+				return Message{}, fmt.Errorf("%s: decode: %w", bedrockName, err)
+			}
+			reply = anthropicReply(out.Content, out.Usage)
+		} else {
+			var out struct {
+				Generation string `json:"generation"`
+			}
+			if err := json.Unmarshal(data, &out); err != nil {
+				return Message{}, fmt.Errorf("%s: decode: %w", bedrockName, err)
+			}
+			reply = Message{Role: "assistant", Content: out.Generation}
+		}
+		in, _ := strconv.Atoi(resp.Header.Get("X-Amzn-Bedrock-Input-Token-Count"))
+		out, _ := strconv.Atoi(resp.Header.Get("X-Amzn-Bedrock-Output-Token-Count"))
+		if in > 0 || out > 0 {
+			reply.Usage = &TokenUsage{Input: in, Output: out}
+		}
+		return reply, nil
+	}
+
+	// Each chunk of the event stream carries one of the model's own stream
+	// events; the last also has the token counts.
+	var claude anthropicStream
+	var text strings.Builder
+	var usage *TokenUsage
+	r2 := bufio.NewReader(resp.Body)
+	for {
+		headers, payload, err := readEventStream(r2)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return Message{}, requestErr(ctx, fmt.Errorf("%s: stream read: %w", bedrockName, err))
+		}
+		if headers[":message-type"] == "exception" {
+			return Message{}, fmt.Errorf("%s: %s: %s", bedrockName, headers[":exception-type"], payload)
+		}
+		var chunk struct {
+			Bytes []byte `json:"bytes"`
+		}
+		if headers[":event-type"] != "chunk" || json.Unmarshal(payload, &chunk) != nil {
+			continue
+		}
+		var ev struct {
+			Generation string `json:"generation"`
+			Metrics    *struct {
+				Input  int `json:"inputTokenCount"`
+				Output int `json:"outputTokenCount"`
+			} `json:"amazon-bedrock-invocationMetrics"`
+		}
+		_ = json.Unmarshal(chunk.Bytes, &ev)
+		if m := ev.Metrics; m != nil {
+			usage = &TokenUsage{Input: m.Input, Output: m.Output}
+		}
+		if family == "anthropic" {
+			if err := claude.event(chunk.Bytes, onToken, gotToken); err != nil {
+				return Message{}, fmt.Errorf("%s: %w", bedrockName, err)
+			}
+		} else if ev.Generation != "" {
+			gotToken()
+			onToken(ev.Generation)
+			text.WriteString(ev.Generation)
+		}
+	}
+	reply := Message{Role: "assistant", Content: text.String()}
+	if family == "anthropic" {
+		reply = claude.reply()
+	}
+	if usage != nil {
+		reply.Usage = usage
+	}
+	return reply, nil
+}
+
+// llamaPrompt writes a conversation in Llama 3's chat format. Tool results
+// are passed on as user messages.
+func llamaPrompt(msgs []Message) string {
+	var b strings.Builder
+	b.WriteString("<|begin_of_text|>")
+	for _, m := range msgs {
+		role, content := m.Role, m.Content
+		if role == "tool" {
+			role, content = "user", "Tool result: "+content
+		}
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "<|start_header_id|>%s<|end_header_id|>\n\n%s<|eot_id|>", role, content)
+	}
+	b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return b.String()
+}
+
+// Embed embeds texts one at a time, as Titan takes a single input.
+func (b bedrockProvider) Embed(model string, texts []string) ([][]float32, error) {
+	vecs := make([][]float32, len(texts))
+	for i, t := range texts {
+		resp, err := b.invoke(context.Background(), model, map[string]any{"inputText": t}, false)
+		if err != nil {
+			return nil, err
+		}
+		var out struct {
+			Embedding []float32 `json:"embedding"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("embeddings: %w", err)
+		}
+		if len(out.Embedding) == 0 {
+			return nil, fmt.Errorf("embeddings: %s returned none", model)
+		}
+		vecs[i] = out.Embedding
+	}
+	return vecs, nil
+}
+
+// readEventStream reads one message of the AWS event stream encoding:
+// a prelude with the lengths, headers, the payload and CRCs, and returns
+// its string headers and payload.
+func readEventStream(r io.Reader) (map[string]string, []byte, error) {
+	var prelude [12]byte
+	if _, err := io.ReadFull(r, prelude[:]); err != nil {
+		return nil, nil, err
+	}
+	total, hlen := binary.BigEndian.Uint32(prelude[0:4]), binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("event stream: bad prelude checksum")
+	}
+	if total < 16+hlen || total > 16<<20 {
+		return nil, nil, fmt.Errorf("event stream: bad message length %d", total)
+	}
+	msg := make([]byte, total-12)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, nil, err
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(prelude[:]), crc32.IEEETable, msg[:len(msg)-4])
+	if crc != binary.BigEndian.Uint32(msg[len(msg)-4:]) {
+		return nil, nil, errors.New("event stream: bad message checksum")
+	}
+
+	headers := map[string]string{}
+	h := msg[:hlen]
+	for len(h) > 0 {
+		n := int(h[0])
+		if len(h) < 2+n {
+			return nil, nil, errors.New("event stream: bad header")
+		}
+		name, typ := string(h[1:1+n]), h[1+n]
+		h = h[2+n:]
+		var size int
+		switch typ {
+		case 0, 1: // true, false
+		case 2:
+			size = 1
+		case 3:
+			size = 2
+		case 4:
+			size = 4
+		case 5, 8: // long, timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes, string
+			if len(h) < 2 {
+				return nil, nil, errors.New("event stream: bad header")
+			}
+			size = 2 + int(binary.BigEndian.Uint16(h))
+		default:
+			return nil, nil, fmt.Errorf("event stream: unknown header type %d", typ)
+		}
+		if len(h) < size {
+			return nil, nil, errors.New("event stream: bad header")
+		}
+		if typ == 7 {
+			headers[name] = string(h[2:size])
+		}
+		h = h[size:]
+	}
+	return headers, msg[hlen : len(msg)-4], nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bookmarks flag answers worth finding again. `/bookmark note #tag` in
+// interactive mode saves the last exchange to ~/.go-chat-bookmarks.json;
+// `go-chat bookmarks` lists, shows, exports and removes them.
+const bookmarksFilePath = ".go-chat-bookmarks.json"
+
+type Bookmark struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	Prompt    string    `json:"prompt"`
+	Answer    string    `json:"answer"`
+	Note      string    `json:"note,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+}
+
+func bookmarksFile() string { return filepath.Join(homeDir, bookmarksFilePath) }
+
+func loadBookmarks() []Bookmark {
+	var bms []Bookmark
+	if data, err := os.ReadFile(bookmarksFile()); err == nil {
+		_ = json.Unmarshal(data, &bms)
+	}
+	return bms
+}
+
+func saveBookmarks(bms []Bookmark) error {
+	data, err := json.MarshalIndent(bms, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bookmarksFile(), data, 0o644)
+}
+
+// addBookmark saves an exchange; words of note starting with # are tags.
+func addBookmark(prompt, answer, note string) (Bookmark, error) {
+	var words, tags []string
+	for _, w := range strings.Fields(note) {
+		if t, ok := strings.CutPrefix(w, "#"); ok && t != "" {
+			tags = append(tags, strings.ToLower(t))
+		} else {
+			words = append(words, w)
+		}
+	}
+	bm := Bookmark{
+		Created:   time.Now().UTC(),
+		Prompt:    prompt,
+		Answer:    answer,
+		Note:      strings.Join(words, " "),
+		Tags:      tags,
+		Namespace: storedNamespace(activeNamespace),
+	}
+	bm.ID = memoryID(bm.Created.String() + answer)
+
+	defer lockFile(bookmarksFile())()
+	if err := saveBookmarks(append(loadBookmarks(), bm)); err != nil {
+		return Bookmark{}, err
+	}
+	return bm, nil
+}
+
+func (b Bookmark) title() string {
+	if b.Note != "" {
+		return b.Note
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(b.Answer), "\n")
+	return first
+}
+
+func runBookmarks(args []string) {
+	cmd := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "list", "export":
+		fset := flag.NewFlagSet("bookmarks "+cmd, flag.ExitOnError)
+		tag := fset.String("tag", "", "Only bookmarks with this tag")
+		grep := fset.String("grep", "", "Only bookmarks whose note, prompt or answer match this regexp")
+		format := fset.String("format", "md", "Export format: md or json")
+		fset.Parse(args)
+
+		var re *regexp.Regexp
+		if *grep != "" {
+			var err error
+			if re, err = regexp.Compile(*grep); err != nil {
+				log.Fatalf("bookmarks: -grep: %v", err)
+			}
+		}
+		var bms []Bookmark
+		for _, b := range loadBookmarks() {
+			if *tag != "" && !slices.Contains(b.Tags, strings.ToLower(strings.TrimPrefix(*tag, "#"))) {
+				continue
+			}
+			if re != nil && !re.MatchString(b.Note) && !re.MatchString(b.Prompt) && !re.MatchString(b.Answer) {
+				continue
+			}
+			bms = append(bms, b)
+		}
+
+		if cmd == "list" {
+			if len(bms) == 0 {
+				fmt.Println("no bookmarks (use /bookmark in interactive mode)")
+			}
+			for _, b := range bms {
+				tags := ""
+				if len(b.Tags) > 0 {
+					tags = " #" + strings.Join(b.Tags, " #")
+				}
+				fmt.Printf("%s  %s  %s%s\n", b.ID, b.Created.In(userLocation(getConfig())).Format(time.DateOnly), b.title(), tags)
+			}
+			return
+		}
+
+		if fset.NArg() != 1 {
+			log.Fatal("usage: go-chat bookmarks export [-tag t] [-grep re] [-format md|json] <file|->")
+		}
+		var out io.Writer = os.Stdout
+		if name := fset.Arg(0); name != "-" {
+			f, err := os.Create(name)
+			if err != nil {
+				log.Fatalf("bookmarks export: %v", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if err := exportBookmarks(out, bms, *format); err != nil {
+			log.Fatalf("bookmarks export: %v", err)
+		}
+
+	case "show":
+		if len(args) != 1 {
+			log.Fatal("usage: go-chat bookmarks show <id>")
+		}
+		i := slices.IndexFunc(loadBookmarks(), func(b Bookmark) bool { return b.ID == args[0] })
+		if i < 0 {
+			log.Fatalf("bookmarks: no bookmark %q", args[0])
+		}
+		exportBookmarks(os.Stdout, loadBookmarks()[i:i+1], "md")
+
+	case "remove":
+		if len(args) != 1 {
+			log.Fatal("usage: go-chat bookmarks remove <id>")
+		}
+		defer lockFile(bookmarksFile())()
+		bms := loadBookmarks()
+		kept := slices.DeleteFunc(slices.Clone(bms), func(b Bookmark) bool { return b.ID == args[0] })
+		if len(kept) == len(bms) {
+			log.Fatalf("bookmarks: no bookmark %q", args[0])
+		}
+		if err := saveBookmarks(kept); err != nil {
+			log.Fatalf("bookmarks remove: %v", err)
+		}
+		fmt.Println("removed", args[0])
+
+	default:
+		fmt.Fprintln(os.Stderr, "usage: go-chat bookmarks [list] [-tag t] [-grep re] | show <id> | export [-format md|json] <file|-> | remove <id>")
+		os.Exit(2)
+	}
+}
+
+func exportBookmarks(w io.Writer, bms []Bookmark, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bms)
+	case "md":
+		loc := userLocation(getConfig())
+		for _, b := range bms {
+			fmt.Fprintf(w, "## %s\n\n", b.title())
+			fmt.Fprintf(w, "_%s · %s", b.ID, b.Created.In(loc).Format("2006-01-02 15:04"))
+			if len(b.Tags) > 0 {
+				fmt.Fprintf(w, " · #%s", strings.Join(b.Tags, " #"))
+			}
+			fmt.Fprintf(w, "_\n\n**Prompt:** %s\n\n%s\n\n", b.Prompt, strings.TrimSpace(b.Answer))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q (want md or json)", format)
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// `go-chat log browse` is a full-screen browser over the daily logs. The
+// left pane lists sessions, one per day and namespace, newest first; the
+// right pane shows the selected conversation. It draws with plain ANSI
+// escapes on a raw terminal rather than pulling in a TUI framework.
+const browseHelp = "↑↓ select  PgUp/PgDn scroll  / search  e export  d delete  c continue  q quit"
+
+type logSession struct {
+	Day       string
+	Namespace string // as stored: "" is the default namespace
+	Entries   []ChatLog
+}
+
+func (s logSession) label() string {
+	return fmt.Sprintf("%s %s (%d)", s.Day, cmp.Or(s.Namespace, defaultNamespace), len(s.Entries))
+}
+
+func (s logSession) matches(q string) bool {
+	q = strings.ToLower(q)
+	for _, l := range s.Entries {
+		if strings.Contains(strings.ToLower(l.Request), q) || strings.Contains(strings.ToLower(l.Response), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSessions groups every log entry by day and namespace.
+func loadSessions() []logSession {
+	var out []logSession
+	days := logDays()
+	for i := len(days) - 1; i >= 0; i-- {
+		logs, err := readDayLog(days[i])
+		if err != nil {
+			continue
+		}
+		at := map[string]int{}
+		first := len(out)
+		for _, l := range logs {
+			ns := storedNamespace(l.Namespace)
+			j, ok := at[ns]
+			if !ok {
+				j = len(out) - first
+				at[ns] = j
+				out = append(out, logSession{Day: days[i], Namespace: ns})
+			}
+			out[first+j].Entries = append(out[first+j].Entries, l)
+		}
+	}
+	return out
+}
+
+type logBrowser struct {
+	all, items []logSession
+	sel, top   int
+	scroll     int
+	query      string
+	status     string
+}
+
+func (b *logBrowser) filter(q string) {
+	b.query = q
+	b.items = b.items[:0]
+	for _, s := range b.all {
+		if q == "" || s.matches(q) {
+			b.items = append(b.items, s)
+		}
+	}
+	b.sel, b.top = 0, 0
+	b.jumpToMatch()
+}
+
+// jumpToMatch scrolls the conversation to the first line with the search
+// term, or to the top.
+func (b *logBrowser) jumpToMatch() {
+	b.scroll = 0
+	if b.query == "" || len(b.items) == 0 {
+		return
+	}
+	w, _ := browseSize()
+	for i, l := range b.render(w) {
+		if strings.Contains(strings.ToLower(l), strings.ToLower(b.query)) {
+			b.scroll = max(0, i-1)
+			return
+		}
+	}
+}
+
+func browseSize() (right, rows int) {
+	w, h, _ := term.GetSize(int(os.Stdout.Fd()))
+	return w - min(32, w/3) - 3, h - 2
+}
+
+// render lays out the selected conversation for a pane width wide.
+func (b *logBrowser) render(width int) []string {
+	if len(b.items) == 0 || width < 10 {
+		return nil
+	}
+	loc := userLocation(getConfig())
+	ai := getConfig().AIName
+	var lines []string
+	for _, l := range b.items[b.sel].Entries {
+		lines = append(lines, "\033[1m"+l.Timestamp.In(loc).Format("15:04")+"\033[0m")
+		lines = append(lines, wrapLines("> "+l.Request, width)...)
+		lines = append(lines, wrapLines("["+cmp.Or(l.Speaker, ai)+"] "+l.Response, width)...)
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func (b *logBrowser) draw() {
+	w, _, _ := term.GetSize(int(os.Stdout.Fd()))
+	right, rows := browseSize()
+	left := w - right - 3
+	if b.sel < b.top {
+		b.top = b.sel
+	} else if b.sel >= b.top+rows {
+		b.top = b.sel - rows + 1
+	}
+	lines := b.render(right)
+
+	var out strings.Builder
+	out.WriteString("\033[H\033[2J")
+	title := fmt.Sprintf("go-chat log — %d sessions", len(b.items))
+	if b.query != "" {
+		title += fmt.Sprintf(" matching %q", b.query)
+	}
+	out.WriteString("\033[1m" + fitWidth(title, w) + "\033[0m\r\n")
+	for row := 0; row < rows; row++ {
+		cell := fitWidth("", left)
+		if i := b.top + row; i < len(b.items) {
+			cell = fitWidth(b.items[i].label(), left)
+			if i == b.sel {
+				cell = "\033[7m" + cell + "\033[0m"
+			}
+		}
+		text := ""
+		if j := b.scroll + row; j < len(lines) {
+			text = lines[j]
+		}
+		out.WriteString(cell + " │ " + text + "\033[0m\r\n")
+	}
+	out.WriteString("\033[2m" + fitWidth(cmp.Or(b.status, browseHelp), w) + "\033[0m")
+	fmt.Print(out.String())
+	b.status = ""
+}
+
+func runLogBrowse() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || termWidth() == 0 {
+		log.Fatal("log browse: needs a terminal")
+	}
+	b := &logBrowser{all: loadSessions()}
+	b.filter("")
+
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Fatalf("log browse: %v", err)
+	}
+	fmt.Print("\033[?1049h\033[?25l")
+	restore := func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		term.Restore(fd, old)
+	}
+	defer restore()
+
+	for {
+		b.draw()
+		_, rows := browseSize()
+		switch key := readKey(); key {
+		case "q", "ctrl-c":
+			return
+		case "up", "k":
+			b.sel = max(0, b.sel-1)
+			b.jumpToMatch()
+		case "down", "j":
+			b.sel = max(0, min(len(b.items)-1, b.sel+1))
+			b.jumpToMatch()
+		case "pgup", "K":
+			b.scroll = max(0, b.scroll-rows+1)
+		case "pgdn", "J", " ":
+			right, _ := browseSize()
+			b.scroll = max(0, min(len(b.render(right))-1, b.scroll+rows-1))
+		case "/":
+			if q, ok := b.ask("search: "); ok {
+				b.filter(q)
+			}
+		case "esc":
+			b.filter("")
+		case "e":
+			if len(b.items) == 0 {
+				continue
+			}
+			s := b.items[b.sel]
+			name, ok := b.ask(fmt.Sprintf("export to [%s-%s.md]: ", s.Day, cmp.Or(s.Namespace, defaultNamespace)))
+			if !ok {
+				continue
+			}
+			name = cmp.Or(name, fmt.Sprintf("%s-%s.md", s.Day, cmp.Or(s.Namespace, defaultNamespace)))
+			if err := exportSession(s, name); err != nil {
+				b.status = "export: " + err.Error()
+			} else {
+				b.status = "exported to " + name
+			}
+		case "d":
+			if len(b.items) == 0 {
+				continue
+			}
+			s := b.items[b.sel]
+			w, h, _ := term.GetSize(int(os.Stdout.Fd()))
+			fmt.Printf("\033[%d;1H\033[2K%s", h, fitWidth(fmt.Sprintf("delete %d entries of %s? [y/N] ", len(s.Entries), s.label()), w))
+			if k := readKey(); k != "y" && k != "Y" {
+				continue
+			}
+			if err := deleteSession(s); err != nil {
+				b.status = "delete: " + err.Error()
+				continue
+			}
+			sel := b.sel
+			b.all = loadSessions()
+			b.filter(b.query)
+			b.sel = min(sel, max(0, len(b.items)-1))
+			b.status = "deleted " + s.label()
+		case "c":
+			if len(b.items) == 0 {
+				continue
+			}
+			s := b.items[b.sel]
+			restore()
+			activeNamespace = s.Namespace
+			if s.Day != logDay(time.Now()) {
+				resumeFrom = s.Day
+			}
+			fmt.Printf("continuing %s\n", s.label())
+			enterInteractiveMode()
+			os.Exit(0)
+		}
+	}
+}
+
+// readKey reads one key press from the raw terminal.
+func readKey() string {
+	c, err := stdin.ReadByte()
+	if err != nil {
+		return "q"
+	}
+	switch c {
+	case 3:
+		return "ctrl-c"
+	case '\r', '\n':
+		return "enter"
+	case 127, 8:
+		return "backspace"
+	case 0x1b:
+		if stdin.Buffered() == 0 {
+			return "esc"
+		}
+		if c, _ := stdin.ReadByte(); c != '[' {
+			return "esc"
+		}
+		seq := ""
+		for {
+			c, err := stdin.ReadByte()
+			if err != nil {
+				return "esc"
+			}
+			seq += string(c)
+			if c >= 0x40 && c <= 0x7e {
+				break
+			}
+		}
+		switch seq {
+		case "A":
+			return "up"
+		case "B":
+			return "down"
+		case "5~":
+			return "pgup"
+		case "6~":
+			return "pgdn"
+		}
+		return ""
+	}
+	if c < 0x80 {
+		return string(c)
+	}
+	// The rest of a UTF-8 sequence.
+	buf := []byte{c}
+	for !utf8.FullRune(buf) {
+		c, err := stdin.ReadByte()
+		if err != nil {
+			break
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
+
+// ask reads a line on the status row; false if cancelled with Esc.
+func (b *logBrowser) ask(label string) (string, bool) {
+	w, h, _ := term.GetSize(int(os.Stdout.Fd()))
+	var in []rune
+	for {
+		fmt.Printf("\033[%d;1H\033[2K%s", h, fitWidth(label+string(in), w))
+		switch k := readKey(); k {
+		case "enter":
+			return strings.TrimSpace(string(in)), true
+		case "esc", "ctrl-c":
+			return "", false
+		case "backspace":
+			if len(in) > 0 {
+				in = in[:len(in)-1]
+			}
+		default:
+			if utf8.RuneCountInString(k) == 1 && k >= " " {
+				in = append(in, []rune(k)...)
+			}
+		}
+	}
+}
+
+func exportSession(s logSession, path string) error {
+	loc := userLocation(getConfig())
+	ai := getConfig().AIName
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s (%s)\n\n", s.Day, cmp.Or(s.Namespace, defaultNamespace))
+	for _, l := range s.Entries {
+		fmt.Fprintf(&out, "### %s\n\n**You:** %s\n\n**%s:** %s\n\n",
+			l.Timestamp.In(loc).Format("15:04"), l.Request, cmp.Or(l.Speaker, ai), l.Response)
+	}
+	return os.WriteFile(path, []byte(out.String()), 0o644)
+}
+
+// deleteSession removes a session's entries from its day's log.
+func deleteSession(s logSession) error {
+	p := filepath.Join(logDirPath, s.Day+".json")
+	defer lockFile(p)()
+	logs, err := readDayLog(s.Day)
+	if err != nil {
+		return err
+	}
+	kept := logs[:0]
+	for _, l := range logs {
+		if storedNamespace(l.Namespace) != s.Namespace {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == 0 {
+		return os.Remove(p)
+	}
+	data, _ := json.MarshalIndent(kept, "", "  ")
+	return writeFileAtomic(p, data, 0o644)
+}
+
+// wrapLines word-wraps text to width columns.
+func wrapLines(text string, width int) []string {
+	var out []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		r := []rune(line)
+		for len(r) > width {
+			cut := width
+			if i := lastSpace(r[:width]); i > 0 {
+				cut = i
+			}
+			out = append(out, string(r[:cut]))
+			r = []rune(strings.TrimLeft(string(r[cut:]), " "))
+		}
+		out = append(out, string(r))
+	}
+	return out
+}
+
+func lastSpace(r []rune) int {
+	for i := len(r) - 1; i >= 0; i-- {
+		if r[i] == ' ' {
+			return i
+		}
+	}
+	return -1
+}
+
+// fitWidth truncates or pads s to exactly n columns.
+func fitWidth(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:max(0, n-1)]) + "…"
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Budget guardrails cap what go-chat spends on model calls, in US dollars:
+//
+//	"budget": {"per_request": 0.05, "per_day": 1, "per_month": 20}
+//
+// Each call is checked before it is sent, using its prompt size and
+// max_tokens as the worst case; spending is estimated from token counts and
+// kept per day in ~/.go-chat-spend.json. A warning is printed once a limit
+// is 80% used, and calls are refused at 100% unless -force is given.
+// Prices are per million tokens; the default models are built in and
+// others can be added under "prices".
+type BudgetConfig struct {
+	PerRequest float64               `json:"per_request,omitempty"`
+	PerDay     float64               `json:"per_day,omitempty"`
+	PerMonth   float64               `json:"per_month,omitempty"`
+	Prices     map[string]ModelPrice `json:"prices,omitempty"`
+
+	// ConfirmAboveTokens: terminal prompts bigger than this (20000 by
+	// default) show their estimated cost and wait for a yes, unless -y.
+	ConfirmAboveTokens int `json:"confirm_above_tokens,omitempty"`
+}
+
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+var defaultPrices = map[string]ModelPrice{
+	"gpt-4o":       {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":  {Input: 0.15, Output: 0.60},
+	"gpt-4.1":      {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini": {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano": {Input: 0.10, Output: 0.40},
+
+	"claude-opus-4-1":   {Input: 15.00, Output: 75.00},
+	"claude-sonnet-4-5": {Input: 3.00, Output: 15.00},
+	"claude-haiku-4-5":  {Input: 1.00, Output: 5.00},
+}
+
+const (
+	budgetWarnAt = 0.8
+
+	defaultConfirmTokens = 20000
+)
+
+// errNotSent is returned when the user declines to send a large prompt.
+var errNotSent = errors.New("not sent")
+
+var (
+	spendFilePath string
+	forceBudget   bool // -force
+	assumeYes     bool // -y
+
+	spendMu    sync.Mutex
+	budgetWarn = map[string]bool{}
+)
+
+func (b BudgetConfig) enabled() bool {
+	return b.PerRequest > 0 || b.PerDay > 0 || b.PerMonth > 0
+}
+
+func modelPrice(cfg Config, model string) (ModelPrice, bool) {
+	if p, ok := cfg.Budget.Prices[model]; ok {
+		return p, true
+	}
+	p, ok := defaultPrices[model]
+	return p, ok
+}
+
+func estimateCost(p ModelPrice, in, out int) float64 {
+	return (float64(in)*p.Input + float64(out)*p.Output) / 1e6
+}
+
+// messagesTokens estimates a prompt's size as provider p counts it.
+func messagesTokens(p Provider, system string, msgs []Message) int {
+	n := tokensFor(p, system)
+	for _, m := range msgs {
+		n += tokensMsgFor(p, m)
+	}
+	return n
+}
+
+// promptTokens counts a prompt exactly if provider p can, and estimates
+// it otherwise. p may be nil.
+func promptTokens(p Provider, model, system string, msgs []Message) int {
+	if c, ok := p.(tokenCounter); ok {
+		all := msgs
+		if system != "" {
+			all = append([]Message{{Role: "system", Content: system}}, msgs...)
+		}
+		if n, err := c.CountTokens(ChatRequest{Model: model, Messages: all, Tools: toolDefinitions()}); err == nil {
+			return n
+		}
+	}
+	return messagesTokens(p, system, msgs)
+}
+
+// checkBudget refuses a call to p that would break a limit.
+func checkBudget(p Provider, model, system string, msgs []Message, maxTok int) error {
+	cfg := getConfig()
+	b := cfg.Budget
+	if !b.enabled() || forceBudget {
+		return nil
+	}
+	price, ok := modelPrice(cfg, model)
+	if !ok {
+		warnOnce("price:"+model, "budget: no price for model %q; add it under budget.prices to count it", model)
+		return nil
+	}
+	cost := estimateCost(price, promptTokens(p, model, system, msgs), maxTok)
+
+	if b.PerRequest > 0 && cost > b.PerRequest {
+		return fmt.Errorf("budget: this request could cost %s, over the per-request limit of %s (use -force to send it anyway)", usd(cost), usd(b.PerRequest))
+	}
+	day, month := spent(time.Now())
+	for _, l := range []struct {
+		name         string
+		limit, spent float64
+	}{{"daily", b.PerDay, day}, {"monthly", b.PerMonth, month}} {
+		if l.limit <= 0 {
+			continue
+		}
+		if l.spent+cost > l.limit {
+			return fmt.Errorf("budget: this request could take spending past the %s limit of %s (%s so far; use -force to override)", l.name, usd(l.limit), usd(l.spent))
+		}
+		if l.spent+cost >= budgetWarnAt*l.limit {
+			warnOnce(l.name, "budget: %.0f%% of the %s limit of %s used", 100*(l.spent+cost)/l.limit, l.name, usd(l.limit))
+		}
+	}
+	return nil
+}
+
+// confirmLargePrompt shows the size and estimated cost of a prompt over the
+// confirmation threshold and asks whether to send it.
+func confirmLargePrompt(cfg Config, model, system string, msgs []Message, maxTok int) error {
+	limit := cmp.Or(cfg.Budget.ConfirmAboveTokens, defaultConfirmTokens)
+	p, _ := activeProvider()
+	if assumeYes || messagesTokens(p, system, msgs) <= limit {
+		return nil
+	}
+	// Providers that count tokens exactly (see promptTokens) get the
+	// final say, since the local count is only an estimate for them.
+	model = resolveModel(p, model)
+	n := promptTokens(p, model, system, msgs)
+	if n <= limit {
+		return nil
+	}
+	q := fmt.Sprintf("This prompt is about %d tokens", n)
+	if price, ok := modelPrice(cfg, model); ok {
+		q += fmt.Sprintf(" (%s–%s with %s)", usd(estimateCost(price, n, 0)), usd(estimateCost(price, n, maxTok)), model)
+	}
+	if !confirm(q + ". Send it?") {
+		return errNotSent
+	}
+	return nil
+}
+
+// recordSpend adds the estimated cost of a finished call to p to today's
+// total.
+func recordSpend(p Provider, model, system string, msgs []Message, answer string) {
+	recordSpendTokens(model, messagesTokens(p, system, msgs), tokensFor(p, answer))
+}
+
+// recordSpendTokens is recordSpend for a request whose token counts are
+// known, such as one reported by the API.
+func recordSpendTokens(model string, in, out int) {
+	cfg := getConfig()
+	price, ok := modelPrice(cfg, model)
+	if !ok {
+		return
+	}
+	addSpend(estimateCost(price, in, out))
+}
+
+// addSpend adds cost dollars to today's total.
+func addSpend(cost float64) {
+	spendMu.Lock()
+	defer spendMu.Unlock()
+	defer lockFile(spendFilePath)()
+	days := loadSpend()
+	days[time.Now().Format("2006-01-02")] += cost
+	data, _ := json.MarshalIndent(days, "", "  ")
+	_ = writeFileAtomic(spendFilePath, data, 0o644)
+}
+
+func loadSpend() map[string]float64 {
+	days := map[string]float64{}
+	if data, err := os.ReadFile(spendFilePath); err == nil {
+		_ = json.Unmarshal(data, &days)
+	}
+	return days
+}
+
+// spent returns the estimated spending on t's day and in its month.
+func spent(t time.Time) (day, month float64) {
+	spendMu.Lock()
+	defer spendMu.Unlock()
+	today, thisMonth := t.Format("2006-01-02"), t.Format("2006-01")
+	for d, usd := range loadSpend() {
+		if strings.HasPrefix(d, thisMonth) {
+			month += usd
+		}
+		if d == today {
+			day += usd
+		}
+	}
+	return day, month
+}
+
+// usd formats dollars, with more places for small amounts.
+func usd(v float64) string {
+	if v < 1 {
+		return fmt.Sprintf("$%.4f", v)
+	}
+	return fmt.Sprintf("$%.2f", v)
+}
+
+func warnOnce(key, format string, args ...any) {
+	spendMu.Lock()
+	defer spendMu.Unlock()
+	if budgetWarn[key] {
+		return
+	}
+	budgetWarn[key] = true
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
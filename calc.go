@@ -0,0 +1,951 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// The calculate tool lets the model work out arithmetic and unit
+// conversions here instead of guessing at them. Numbers are exact
+// fractions, so 0.1 + 0.2 is 0.3 and 2^100 has every digit; only
+// functions such as sqrt(2) or sin fall back to floating point, and their
+// results are marked ≈. Exact results whose decimals never end are cut
+// off at 15 digits with …. Quantities carry units ("3.5 km + 200 m",
+// "72 mph to km/h", "20 degC to degF", "15% of 240") and mixing
+// incompatible ones is an error. It is on unless "calculator" is false in
+// the config; -v prints each call and its result.
+const calcMaxDigits = 5000 // exact results bigger than this are refused
+
+var calculatorOnce sync.Once
+
+func registerCalculator() {
+	calculatorOnce.Do(func() {
+		if c := getConfig().Calculator; c != nil && !*c {
+			return
+		}
+		registerTool(&Tool{
+			Name: "calculate",
+			Description: "Evaluate arithmetic exactly, with units and conversions, e.g. \"(3.5 km + 200 m) to mi\", " +
+				"\"15% of 240\", \"72 mph to km/h\", \"2^64\", \"sqrt(2)\", \"20 degC to degF\", \"1.5 GiB / 20 Mbit/s to min\". " +
+				"Use it for every calculation or unit conversion in an answer instead of working it out yourself.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"expression":{"type":"string","description":"The expression; may end with \"to <unit>\""},` +
+				`"to":{"type":"string","description":"Unit to give the result in"}},` +
+				`"required":["expression"]}`),
+			Run: func(args json.RawMessage) (string, error) {
+				var a struct {
+					Expression string `json:"expression"`
+					To         string `json:"to"`
+				}
+				if err := json.Unmarshal(args, &a); err != nil {
+					return "", err
+				}
+				if a.To != "" {
+					a.Expression += " to " + a.To
+				}
+				return calculate(a.Expression)
+			},
+		})
+	})
+}
+
+// Dimensions, as powers of the SI base units plus bytes for data.
+const (
+	dimLength = iota
+	dimMass
+	dimTime
+	dimTemp
+	dimCurrent
+	dimAmount
+	dimLight
+	dimData
+	dimCount
+)
+
+type dims [dimCount]int
+
+var dimUnits = [dimCount]string{"m", "kg", "s", "K", "A", "mol", "cd", "B"}
+
+// quantity is a value in base units.
+type quantity struct {
+	val   *big.Rat
+	dims  dims
+	exact bool
+	scale string // degC or the like, for a temperature reading
+}
+
+func number(r *big.Rat) quantity { return quantity{val: r, exact: true} }
+
+// unitDefs defines units in terms of base units and each other.
+var unitDefs = map[string]string{
+	// length
+	"km": "1000 m", "cm": "m/100", "mm": "m/1000", "um": "m/1e6", "µm": "um", "nm": "m/1e9",
+	"in": "2.54 cm", "inch": "in", "ft": "12 in", "foot": "ft", "feet": "ft", "yd": "3 ft", "yard": "yd",
+	"mi": "1760 yd", "mile": "mi", "nmi": "1852 m", "au": "149597870700 m", "ly": "9460730472580800 m",
+	"meter": "m", "metre": "m", "kilometer": "km", "kilometre": "km", "centimeter": "cm", "millimeter": "mm",
+	// mass
+	"g": "kg/1000", "mg": "g/1000", "ug": "mg/1000", "µg": "ug", "t": "1000 kg", "tonne": "t",
+	"lb": "0.45359237 kg", "lbs": "lb", "pound": "lb", "oz": "lb/16", "ounce": "oz", "st": "14 lb", "stone": "st",
+	"gram": "g", "kilogram": "kg",
+	// time
+	"sec": "s", "second": "s", "ms": "s/1000", "us": "ms/1000", "µs": "us", "ns": "us/1000",
+	"min": "60 s", "minute": "min", "h": "60 min", "hr": "h", "hour": "h", "day": "24 h", "d": "day",
+	"week": "7 day", "wk": "week", "yr": "365.25 day", "year": "yr", "month": "yr/12",
+	// area and volume
+	"ha": "10000 m^2", "hectare": "ha", "acre": "4046.8564224 m^2",
+	"l": "m^3/1000", "L": "l", "liter": "l", "litre": "l", "ml": "l/1000", "mL": "ml", "cl": "l/100", "dl": "l/10",
+	"gal": "3.785411784 l", "gallon": "gal", "qt": "gal/4", "quart": "qt", "pt": "qt/2", "pint": "pt",
+	"cup": "pt/2", "floz": "cup/8", "tbsp": "floz/2", "tsp": "tbsp/3",
+	// speed
+	"mph": "mi/h", "kph": "km/h", "kmh": "km/h", "knot": "nmi/h", "kn": "knot",
+	// force, energy, power, pressure
+	"N": "kg*m/s^2", "newton": "N", "lbf": "lb*9.80665 m/s^2", "J": "N*m", "joule": "J", "kJ": "1000 J", "MJ": "1000 kJ",
+	"cal": "4.184 J", "kcal": "1000 cal", "Wh": "W*h", "kWh": "1000 Wh", "MWh": "1000 kWh", "eV": "1.602176634e-19 J",
+	"W": "J/s", "watt": "W", "kW": "1000 W", "MW": "1000 kW", "GW": "1000 MW", "hp": "550 ft*lbf/s",
+	"Pa": "N/m^2", "kPa": "1000 Pa", "MPa": "1000 kPa", "bar": "100000 Pa", "mbar": "bar/1000",
+	"atm": "101325 Pa", "psi": "lbf/in^2",
+	// electricity and frequency
+	"mA": "A/1000", "V": "W/A", "volt": "V", "mV": "V/1000", "kV": "1000 V", "ohm": "V/A", "Ω": "ohm",
+	"C": "A*s", "mAh": "mA*h", "Ah": "A*h", "Hz": "1/s", "kHz": "1000 Hz", "MHz": "1000 kHz", "GHz": "1000 MHz",
+	// data
+	"byte": "B", "bit": "B/8", "kB": "1000 B", "MB": "1000 kB", "GB": "1000 MB", "TB": "1000 GB", "PB": "1000 TB",
+	"KiB": "1024 B", "MiB": "1024 KiB", "GiB": "1024 MiB", "TiB": "1024 GiB",
+	"kbit": "1000 bit", "Mbit": "1000 kbit", "Gbit": "1000 Mbit", "kbps": "kbit/s", "Mbps": "Mbit/s", "Gbps": "Gbit/s",
+	// angles, in radians
+	"rad": "1", "deg": "pi/180", "degree": "deg",
+}
+
+var baseUnits = map[string]int{
+	"m": dimLength, "kg": dimMass, "s": dimTime, "K": dimTemp, "kelvin": dimTemp,
+	"A": dimCurrent, "amp": dimCurrent, "mol": dimAmount, "cd": dimLight, "B": dimData,
+}
+
+// tempUnits are temperature scales with an offset: kelvin = (v + offset) * scale.
+// They can only stand alone, as in "20 degC" or "to degF".
+var tempUnits = map[string][2]*big.Rat{
+	"degC": {calcRat("273.15"), big.NewRat(1, 1)}, "°C": {calcRat("273.15"), big.NewRat(1, 1)}, "celsius": {calcRat("273.15"), big.NewRat(1, 1)},
+	"degF": {calcRat("459.67"), big.NewRat(5, 9)}, "°F": {calcRat("459.67"), big.NewRat(5, 9)}, "fahrenheit": {calcRat("459.67"), big.NewRat(5, 9)},
+}
+
+var calcConstants = map[string]float64{"pi": math.Pi, "π": math.Pi, "tau": 2 * math.Pi, "e": math.E}
+
+func calcRat(s string) *big.Rat {
+	r, _ := new(big.Rat).SetString(s)
+	return r
+}
+
+var (
+	unitCache   = map[string]quantity{}
+	unitCacheMu sync.Mutex
+)
+
+// lookupUnit returns a unit's size, trying "miles" as "mile" and
+// "inches" as "inch".
+func lookupUnit(name string) (quantity, bool) {
+	for _, n := range []string{name, strings.TrimSuffix(name, "s"), strings.TrimSuffix(name, "es")} {
+		if q, ok := unitValue(n); ok {
+			return q, true
+		}
+	}
+	return quantity{}, false
+}
+
+func unitValue(name string) (quantity, bool) {
+	if d, ok := baseUnits[name]; ok {
+		q := number(big.NewRat(1, 1))
+		q.dims[d] = 1
+		return q, true
+	}
+	def, ok := unitDefs[name]
+	if !ok {
+		return quantity{}, false
+	}
+	unitCacheMu.Lock()
+	q, ok := unitCache[name]
+	unitCacheMu.Unlock()
+	if ok {
+		return q, true
+	}
+	p := &calcParser{toks: calcTokens(def)}
+	q, err := p.expr()
+	if err != nil || p.pos < len(p.toks) {
+		panic(fmt.Sprintf("calculate: bad unit definition %s = %s", name, def))
+	}
+	unitCacheMu.Lock()
+	unitCache[name] = q
+	unitCacheMu.Unlock()
+	return q, true
+}
+
+func isUnit(name string) bool {
+	if _, ok := tempUnits[name]; ok {
+		return true
+	}
+	_, ok := lookupUnit(name)
+	return ok
+}
+
+// calculate evaluates an expression, optionally ending in "to <unit>"
+// (or "in" or "as"), and formats the result.
+func calculate(expr string) (string, error) {
+	p := &calcParser{toks: calcTokens(expr)}
+	q, err := p.expr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos == len(p.toks) {
+		return p.format(q), nil
+	}
+	if t := p.toks[p.pos]; t != "to" && t != "in" && t != "as" {
+		return "", fmt.Errorf("unexpected %q", t)
+	}
+	target := strings.Join(p.toks[p.pos+1:], "")
+	if target == "" {
+		return "", errors.New("nothing to convert to")
+	}
+	return convert(q, target)
+}
+
+func convert(q quantity, target string) (string, error) {
+	if t, ok := tempUnits[target]; ok {
+		if q.dims != (dims{dimTemp: 1}) {
+			return "", fmt.Errorf("can't convert %s to %s", dimString(q.dims), target)
+		}
+		v := new(big.Rat).Quo(q.val, t[1])
+		return formatRat(v.Sub(v, t[0]), q.exact) + " " + target, nil
+	}
+	tp := &calcParser{toks: calcTokens(target)}
+	u, err := tp.expr()
+	if err != nil {
+		return "", err
+	}
+	if tp.pos < len(tp.toks) {
+		return "", fmt.Errorf("unexpected %q in %q", tp.toks[tp.pos], target)
+	}
+	if u.dims != q.dims {
+		if d := dimString(u.dims); d != target {
+			target += " (" + d + ")"
+		}
+		return "", fmt.Errorf("can't convert %s to %s", dimString(q.dims), target)
+	}
+	if u.val.Sign() == 0 {
+		return "", errors.New("can't convert to a zero unit")
+	}
+	return formatRat(new(big.Rat).Quo(q.val, u.val), q.exact && u.exact) + " " + target, nil
+}
+
+type calcParser struct {
+	toks  []string
+	pos   int
+	units []string // unit expressions after numbers, to show the result in
+}
+
+func (p *calcParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *calcParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// calcTokens splits an expression into numbers, names and operators.
+func calcTokens(s string) []string {
+	var toks []string
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.':
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			// An exponent, as in 1.5e-3, but not the unit e in "2 e".
+			if j < len(rs) && (rs[j] == 'e' || rs[j] == 'E') {
+				k := j + 1
+				if k < len(rs) && (rs[k] == '+' || rs[k] == '-') {
+					k++
+				}
+				if k < len(rs) && unicode.IsDigit(rs[k]) {
+					for k < len(rs) && unicode.IsDigit(rs[k]) {
+						k++
+					}
+					j = k
+				}
+			}
+			toks = append(toks, string(rs[i:j]))
+			i = j
+		case unicode.IsLetter(r) || r == '°' || r == 'µ' || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '°' || rs[j] == '_') {
+				j++
+			}
+			toks = append(toks, string(rs[i:j]))
+			i = j
+		case r == '*' && i+1 < len(rs) && rs[i+1] == '*':
+			toks = append(toks, "^")
+			i += 2
+		case r == '×' || r == '·':
+			toks = append(toks, "*")
+			i++
+		case r == '÷':
+			toks = append(toks, "/")
+			i++
+		case r == '−':
+			toks = append(toks, "-")
+			i++
+		default:
+			toks = append(toks, string(r))
+			i++
+		}
+	}
+	return toks
+}
+
+// expr parses sums.
+func (p *calcParser) expr() (quantity, error) {
+	q, err := p.term()
+	if err != nil {
+		return q, err
+	}
+	for {
+		op := p.peek()
+		if op != "+" && op != "-" {
+			return q, nil
+		}
+		p.next()
+		r, err := p.term()
+		if err != nil {
+			return q, err
+		}
+		if err := scaleOperand(op, q, r); err != nil {
+			return q, err
+		}
+		if q.dims != r.dims {
+			return q, fmt.Errorf("can't %s %s and %s", map[string]string{"+": "add", "-": "subtract"}[op], dimString(q.dims), dimString(r.dims))
+		}
+		v := new(big.Rat)
+		if op == "+" {
+			v.Add(q.val, r.val)
+		} else {
+			v.Sub(q.val, r.val)
+		}
+		q = quantity{val: v, dims: q.dims, exact: q.exact && r.exact}
+	}
+}
+
+// term parses products, quotients, "mod" and "of" (as in 15% of 240).
+func (p *calcParser) term() (quantity, error) {
+	q, err := p.unary()
+	if err != nil {
+		return q, err
+	}
+	for {
+		op := p.peek()
+		if op != "*" && op != "/" && op != "mod" && op != "of" {
+			return q, nil
+		}
+		p.next()
+		r, err := p.unary()
+		if err != nil {
+			return q, err
+		}
+		if err := scaleOperand(op, q, r); err != nil {
+			return q, err
+		}
+		if q, err = arith(op, q, r); err != nil {
+			return q, err
+		}
+	}
+}
+
+// scaleOperand refuses arithmetic on temperature readings such as 20 degC,
+// whose zero isn't zero: 0 degC + 1 degC isn't 547.3 K. They can stand
+// alone or be converted.
+func scaleOperand(op string, q, r quantity) error {
+	for _, x := range []quantity{q, r} {
+		if x.scale != "" {
+			return fmt.Errorf("can't use a %s reading with %s; use K for temperature differences", x.scale, op)
+		}
+	}
+	return nil
+}
+
+func arith(op string, q, r quantity) (quantity, error) {
+	out := quantity{val: new(big.Rat), exact: q.exact && r.exact}
+	switch op {
+	case "*", "of":
+		out.val.Mul(q.val, r.val)
+		for i := range out.dims {
+			out.dims[i] = q.dims[i] + r.dims[i]
+		}
+	case "/":
+		if r.val.Sign() == 0 {
+			return out, errors.New("division by zero")
+		}
+		out.val.Quo(q.val, r.val)
+		for i := range out.dims {
+			out.dims[i] = q.dims[i] - r.dims[i]
+		}
+	case "mod":
+		if q.dims != r.dims {
+			return out, fmt.Errorf("can't take %s mod %s", dimString(q.dims), dimString(r.dims))
+		}
+		if r.val.Sign() == 0 {
+			return out, errors.New("mod by zero")
+		}
+		n := new(big.Rat).SetInt(floorRat(new(big.Rat).Quo(q.val, r.val)))
+		out.val.Sub(q.val, n.Mul(n, r.val))
+		out.dims = q.dims
+	}
+	return out, checkSize(out)
+}
+
+func (p *calcParser) unary() (quantity, error) {
+	switch p.peek() {
+	case "-":
+		p.next()
+		if p.pos+1 < len(p.toks) && isNumber(p.peek()) {
+			if _, ok := tempUnits[p.toks[p.pos+1]]; ok {
+				// -40 degC is a temperature below zero, not minus 233.15 K.
+				p.toks[p.pos] = "-" + p.toks[p.pos]
+				return p.power()
+			}
+		}
+		q, err := p.unary()
+		if err == nil {
+			q.val = new(big.Rat).Neg(q.val)
+		}
+		return q, err
+	case "+":
+		p.next()
+		return p.unary()
+	}
+	return p.power()
+}
+
+// power parses x^y, which binds tighter than a leading minus and to the
+// right: -2^2 is -4 and 2^3^2 is 2^9.
+func (p *calcParser) power() (quantity, error) {
+	q, err := p.postfix()
+	if err != nil || p.peek() != "^" {
+		return q, err
+	}
+	p.next()
+	e, err := p.unary()
+	if err != nil {
+		return q, err
+	}
+	return raise(q, e)
+}
+
+func (p *calcParser) postfix() (quantity, error) {
+	q, err := p.primary()
+	for err == nil {
+		switch p.peek() {
+		case "%":
+			p.next()
+			q.val = new(big.Rat).Quo(q.val, big.NewRat(100, 1))
+		case "!":
+			p.next()
+			q, err = factorial(q)
+		default:
+			return q, nil
+		}
+	}
+	return q, err
+}
+
+func isNumber(t string) bool {
+	return t != "" && (unicode.IsDigit(rune(t[0])) || t[0] == '.')
+}
+
+func (p *calcParser) primary() (quantity, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return quantity{}, errors.New("unexpected end of expression")
+	case t == "(":
+		q, err := p.expr()
+		if err != nil {
+			return q, err
+		}
+		if p.next() != ")" {
+			return q, errors.New("missing )")
+		}
+		return q, nil
+	case isNumber(strings.TrimPrefix(t, "-")):
+		v, ok := new(big.Rat).SetString(t)
+		if !ok {
+			return quantity{}, fmt.Errorf("bad number %q", t)
+		}
+		q := number(v)
+		if isUnit(p.peek()) {
+			return p.withUnit(q)
+		}
+		return q, nil
+	}
+	if p.peek() == "(" {
+		if _, ok := calcFuncs[t]; ok {
+			return p.call(t)
+		}
+		return quantity{}, fmt.Errorf("unknown function %q", t)
+	}
+	if c, ok := calcConstants[t]; ok {
+		return quantity{val: new(big.Rat).SetFloat64(c)}, nil
+	}
+	if _, ok := tempUnits[t]; ok {
+		return quantity{}, fmt.Errorf("%s needs a number before it; use K for temperature differences", t)
+	}
+	if u, ok := lookupUnit(t); ok {
+		return u, nil
+	}
+	return quantity{}, fmt.Errorf("unknown name %q", t)
+}
+
+// withUnit applies the units after a number, as in "60 km/h" or "9.8 m/s^2",
+// which bind tighter than operators between numbers: "60 km / 2 h" is
+// 30 km/h.
+func (p *calcParser) withUnit(q quantity) (quantity, error) {
+	if t, ok := tempUnits[p.peek()]; ok {
+		q.scale = p.next()
+		q.val.Add(q.val, t[0]).Mul(q.val, t[1])
+		q.dims[dimTemp] = 1
+		return q, nil
+	}
+	start := p.pos
+	op := "*"
+	for {
+		name := p.next()
+		if _, ok := tempUnits[name]; ok {
+			return q, fmt.Errorf("%s can't be part of a compound unit; use K", name)
+		}
+		u, _ := lookupUnit(name)
+		var err error
+		if p.peek() == "^" {
+			i, sign := p.pos+1, int64(1)
+			if i < len(p.toks) && p.toks[i] == "-" {
+				i, sign = i+1, -1
+			}
+			if i < len(p.toks) {
+				if n, perr := strconv.ParseInt(p.toks[i], 10, 64); perr == nil {
+					p.pos = i + 1
+					if u, err = raise(u, number(big.NewRat(sign*n, 1))); err != nil {
+						return q, err
+					}
+				}
+			}
+		}
+		if q, err = arith(op, q, u); err != nil {
+			return q, err
+		}
+		op = p.peek()
+		if (op != "/" && op != "*") || p.pos+1 >= len(p.toks) || !isUnit(p.toks[p.pos+1]) {
+			break
+		}
+		if _, ok := tempUnits[p.toks[p.pos+1]]; ok {
+			break
+		}
+		p.next()
+	}
+	p.units = append(p.units, strings.Join(p.toks[start:p.pos], ""))
+	return q, nil
+}
+
+func (p *calcParser) call(name string) (quantity, error) {
+	p.next() // (
+	var args []quantity
+	for p.peek() != ")" {
+		q, err := p.expr()
+		if err != nil {
+			return q, err
+		}
+		args = append(args, q)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if p.next() != ")" {
+		return quantity{}, fmt.Errorf("missing ) after %s(", name)
+	}
+	f := calcFuncs[name]
+	if len(args) < f.min || len(args) > f.max {
+		return quantity{}, fmt.Errorf("%s takes %d to %d arguments", name, f.min, f.max)
+	}
+	if f.dimensionless {
+		for _, a := range args {
+			if a.dims != (dims{}) {
+				return quantity{}, fmt.Errorf("%s needs a plain number, not %s", name, dimString(a.dims))
+			}
+		}
+	}
+	q, err := f.fn(args)
+	if err != nil {
+		return q, fmt.Errorf("%s: %w", name, err)
+	}
+	return q, checkSize(q)
+}
+
+type calcFunc struct {
+	min, max      int
+	dimensionless bool
+	fn            func(args []quantity) (quantity, error)
+}
+
+var calcFuncs map[string]calcFunc
+
+func init() {
+	float1 := func(f func(float64) float64) calcFunc {
+		return calcFunc{1, 1, true, func(a []quantity) (quantity, error) {
+			x, _ := a[0].val.Float64()
+			return fromFloat(f(x))
+		}}
+	}
+	root := func(n int64) calcFunc {
+		return calcFunc{1, 1, false, func(a []quantity) (quantity, error) {
+			return raise(a[0], number(big.NewRat(1, n)))
+		}}
+	}
+	rounding := func(f func(*big.Rat) *big.Int) calcFunc {
+		return calcFunc{1, 2, true, func(a []quantity) (quantity, error) {
+			scale := big.NewRat(1, 1)
+			if len(a) == 2 {
+				if !a[1].val.IsInt() || a[1].val.Num().BitLen() > 10 {
+					return quantity{}, errors.New("digits must be a small whole number")
+				}
+				d := a[1].val.Num().Int64()
+				scale.SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(abs64(d)), nil))
+				if d < 0 {
+					scale.Inv(scale)
+				}
+			}
+			v := new(big.Rat).SetInt(f(new(big.Rat).Mul(a[0].val, scale)))
+			return quantity{val: v.Quo(v, scale), exact: a[0].exact}, nil
+		}}
+	}
+	extreme := func(want int) calcFunc {
+		return calcFunc{1, 100, false, func(a []quantity) (quantity, error) {
+			best := a[0]
+			for _, q := range a[1:] {
+				if q.dims != best.dims {
+					return q, fmt.Errorf("can't compare %s and %s", dimString(best.dims), dimString(q.dims))
+				}
+				if q.val.Cmp(best.val) == want {
+					best = q
+				}
+			}
+			return best, nil
+		}}
+	}
+	calcFuncs = map[string]calcFunc{
+		"sqrt": root(2), "cbrt": root(3),
+		"abs": {1, 1, false, func(a []quantity) (quantity, error) {
+			return quantity{val: new(big.Rat).Abs(a[0].val), dims: a[0].dims, exact: a[0].exact}, nil
+		}},
+		"floor": rounding(floorRat),
+		"ceil": rounding(func(r *big.Rat) *big.Int {
+			return new(big.Int).Neg(floorRat(new(big.Rat).Neg(r)))
+		}),
+		"round": rounding(func(r *big.Rat) *big.Int {
+			return floorRat(new(big.Rat).Add(r, big.NewRat(1, 2)))
+		}),
+		"exp": float1(math.Exp), "ln": float1(math.Log), "log2": float1(math.Log2), "log10": float1(math.Log10),
+		"log": {1, 2, true, func(a []quantity) (quantity, error) {
+			x, _ := a[0].val.Float64()
+			if len(a) == 1 {
+				return fromFloat(math.Log10(x))
+			}
+			b, _ := a[1].val.Float64()
+			return fromFloat(math.Log(x) / math.Log(b))
+		}},
+		"sin": float1(math.Sin), "cos": float1(math.Cos), "tan": float1(math.Tan),
+		"asin": float1(math.Asin), "acos": float1(math.Acos), "atan": float1(math.Atan),
+		"min": extreme(-1), "max": extreme(1),
+	}
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func fromFloat(f float64) (quantity, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return quantity{}, errors.New("result is too large or not a real number")
+	}
+	return quantity{val: new(big.Rat).SetFloat64(f)}, nil
+}
+
+// floorRat rounds r down to an integer.
+func floorRat(r *big.Rat) *big.Int {
+	// Euclidean division by a positive denominator rounds down.
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+// raise computes q^e exactly where it can: whole powers of exact numbers,
+// and roots of perfect powers.
+func raise(q, e quantity) (quantity, error) {
+	if e.dims != (dims{}) {
+		return q, fmt.Errorf("an exponent can't have units (%s)", dimString(e.dims))
+	}
+	num, den := e.val.Num(), e.val.Denom()
+	if den.BitLen() > 8 || num.BitLen() > 16 {
+		if q.dims != (dims{}) {
+			return q, errors.New("that power of a unit isn't whole")
+		}
+		x, _ := q.val.Float64()
+		y, _ := e.val.Float64()
+		return fromFloat(math.Pow(x, y))
+	}
+	n, d := num.Int64(), den.Int64()
+	out := quantity{exact: q.exact && e.exact}
+	for i, k := range q.dims {
+		if int64(k)*n%d != 0 {
+			return q, fmt.Errorf("%s to the power %s isn't a whole unit", dimString(q.dims), e.val.RatString())
+		}
+		out.dims[i] = int(int64(k) * n / d)
+	}
+	if q.val.Sign() == 0 && n < 0 {
+		return q, errors.New("division by zero")
+	}
+	if q.val.Sign() < 0 && d%2 == 0 {
+		return q, errors.New("even root of a negative number")
+	}
+
+	// The d-th root, exactly if both parts are perfect powers.
+	base := new(big.Rat).Set(q.val)
+	if d > 1 {
+		rn, okN := intRoot(new(big.Int).Abs(base.Num()), d)
+		rd, okD := intRoot(base.Denom(), d)
+		if !out.exact || !okN || !okD {
+			x, _ := base.Float64()
+			r := math.Pow(math.Abs(x), 1/float64(d))
+			if x < 0 {
+				r = -r
+			}
+			f, err := fromFloat(r)
+			if err != nil {
+				return f, err
+			}
+			base, out.exact = f.val, false
+		} else {
+			if base.Sign() < 0 {
+				rn.Neg(rn)
+			}
+			base.SetFrac(rn, rd)
+		}
+	}
+
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	if bits := int64(base.Num().BitLen() + base.Denom().BitLen()); bits*abs > calcMaxDigits*4 {
+		return q, errors.New("result too large to compute exactly")
+	}
+	p := big.NewInt(abs)
+	out.val = new(big.Rat).SetFrac(new(big.Int).Exp(base.Num(), p, nil), new(big.Int).Exp(base.Denom(), p, nil))
+	if n < 0 {
+		out.val.Inv(out.val)
+	}
+	return out, checkSize(out)
+}
+
+// intRoot returns the d-th root of x if x is a perfect d-th power.
+func intRoot(x *big.Int, d int64) (*big.Int, bool) {
+	if d == 2 {
+		r := new(big.Int).Sqrt(x)
+		return r, new(big.Int).Mul(r, r).Cmp(x) == 0
+	}
+	f, _ := new(big.Float).SetInt(x).Float64()
+	guess := int64(math.Round(math.Pow(f, 1/float64(d))))
+	for g := guess - 1; g <= guess+1; g++ {
+		if g < 0 {
+			continue
+		}
+		r := big.NewInt(g)
+		if new(big.Int).Exp(r, big.NewInt(d), nil).Cmp(x) == 0 {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func factorial(q quantity) (quantity, error) {
+	if q.dims != (dims{}) || !q.val.IsInt() || q.val.Sign() < 0 {
+		return q, errors.New("factorial needs a whole number of 0 or more")
+	}
+	if !q.val.Num().IsInt64() || q.val.Num().Int64() > 1000 {
+		return q, errors.New("factorial too large to compute exactly")
+	}
+	v := new(big.Int).MulRange(1, q.val.Num().Int64())
+	return quantity{val: new(big.Rat).SetInt(v), exact: q.exact}, nil
+}
+
+// checkSize refuses results too big to be useful, before they get bigger.
+func checkSize(q quantity) error {
+	if q.val.Num().BitLen() > calcMaxDigits*4 || q.val.Denom().BitLen() > calcMaxDigits*4 {
+		return errors.New("result too large to compute exactly")
+	}
+	return nil
+}
+
+// format shows q in the units the expression was written in: the first
+// written unit that fits it, else one built from their parts ("km" for
+// "100 km/h * 2 h", "km/h" for "60 km / 2 h", "W*h", "m^2"), else a named
+// SI unit, else base units.
+func (p *calcParser) format(q quantity) string {
+	if q.dims == (dims{}) {
+		return formatRat(q.val, q.exact)
+	}
+	for _, u := range displayUnits(p.units) {
+		if s, err := convert(q, u); err == nil {
+			return s
+		}
+	}
+	return formatRat(q.val, q.exact) + " " + dimString(q.dims)
+}
+
+// siUnits are tried for results that fit none of the written units.
+var siUnits = []string{"N", "J", "W", "Pa", "V", "ohm", "C", "Hz"}
+
+// displayUnits lists the units format tries, in order: those written,
+// their parts (and the parts of compounds such as mph), squares and cubes
+// of the parts, pairs of parts multiplied or divided, then siUnits.
+func displayUnits(written []string) []string {
+	var parts []string
+	addParts := func(u string) {
+		for _, f := range strings.FieldsFunc(u, func(r rune) bool { return r == '*' || r == '/' }) {
+			if f, _, _ = strings.Cut(f, "^"); isUnit(f) && !slices.Contains(parts, f) {
+				parts = append(parts, f)
+			}
+		}
+	}
+	for _, u := range written {
+		addParts(u)
+	}
+	for _, f := range slices.Clone(parts) {
+		if def := unitDefs[f]; strings.ContainsAny(def, "*/") && !strings.ContainsAny(def, "0123456789 ") && simpleParts(def) {
+			addParts(def)
+		}
+	}
+	out := append(slices.Clone(written), parts...)
+	for _, a := range parts {
+		out = append(out, a+"^2", a+"^3")
+	}
+	for _, a := range parts {
+		for _, b := range parts {
+			if a != b {
+				out = append(out, a+"*"+b, a+"/"+b)
+			}
+		}
+	}
+	return append(out, siUnits...)
+}
+
+// simpleParts reports whether each unit in def measures a single
+// dimension, as mi and h do in mph, so a result in W is not shown in J.
+func simpleParts(def string) bool {
+	for _, f := range strings.FieldsFunc(def, func(r rune) bool { return r == '*' || r == '/' }) {
+		f, _, _ = strings.Cut(f, "^")
+		q, ok := lookupUnit(f)
+		if !ok {
+			return false
+		}
+		n := 0
+		for _, k := range q.dims {
+			if k != 0 {
+				n++
+			}
+		}
+		if n != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatRat writes r as an exact decimal where it has one, else as a
+// short fraction and its value to 15 significant digits, cut off with …
+// since the digits go on. Inexact results are marked ≈.
+func formatRat(r *big.Rat, exact bool) string {
+	if !exact {
+		f, _ := r.Float64()
+		return "≈ " + strconv.FormatFloat(f, 'g', 15, 64)
+	}
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// Denominators of the form 2^a 5^b give terminating decimals.
+	d := new(big.Int).Set(r.Denom())
+	places, q, rem := 0, new(big.Int), new(big.Int)
+	for _, f := range []*big.Int{big.NewInt(2), big.NewInt(5)} {
+		n := 0
+		for ; ; n++ {
+			if q.QuoRem(d, f, rem); rem.Sign() != 0 {
+				break
+			}
+			d.Set(q)
+		}
+		places = max(places, n)
+	}
+	if d.Cmp(big.NewInt(1)) == 0 && places <= 50 {
+		return r.FloatString(places)
+	}
+	digits := new(big.Float).SetPrec(256).SetRat(r).Text('g', 15) + "…"
+	if frac := r.RatString(); len(frac) <= 7 {
+		return frac + " = " + digits
+	}
+	return digits
+}
+
+// dimString writes dimensions as base units, such as kg*m^2/s^2.
+func dimString(d dims) string {
+	var num, den []string
+	for i, k := range d {
+		u := dimUnits[i]
+		switch {
+		case k == 1:
+			num = append(num, u)
+		case k > 1:
+			num = append(num, fmt.Sprintf("%s^%d", u, k))
+		case k == -1:
+			den = append(den, u)
+		case k < -1:
+			den = append(den, fmt.Sprintf("%s^%d", u, -k))
+		}
+	}
+	s := strings.Join(num, "*")
+	if s == "" {
+		s = "1"
+	}
+	switch len(den) {
+	case 0:
+		if len(num) == 0 {
+			return "a plain number"
+		}
+	case 1:
+		s += "/" + den[0]
+	default:
+		s += "/(" + strings.Join(den, "*") + ")"
+	}
+	return s
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		// arithmetic
+		{"0.1 + 0.2", "0.3"},
+		{"10 / 4", "2.5"},
+		{"-(2 - 5) * 4", "12"},
+		{"2^100", "1267650600228229401496703205376"},
+		{"5!", "120"},
+		{"15% of 240", "36"},
+		{"1/3", "1/3 = 0.333333333333333…"},
+		{"sqrt(2)", "≈ 1.4142135623731"},
+		{"2 ^ 0.5", "≈ 1.4142135623731"},
+		// conversions
+		{"1 ft to m", "0.3048 m"},
+		{"1e3 m to km", "1 km"},
+		{"3 miles to km", "4.828032 km"},
+		{"72 mph to km/h", "115.872768 km/h"},
+		{"2 kg to lbs", "4.40924524369755… lbs"},
+		{"(3.5 km + 200 m) to mi", "2.29907341127814… mi"},
+		{"20 degC to degF", "68 degF"},
+		{"100 degF to degC", "340/9 = 37.7777777777778… degC"},
+		// results in the units written
+		{"5 km + 300 m", "5.3 km"},
+		{"100 km/h * 2 h", "200 km"},
+		{"2 h * 60 km/h", "120 km"},
+		{"60 km / 2 h", "30 km/h"},
+		{"72 mph * 2 h", "144 mi"},
+		{"3 m * 4 m", "12 m^2"},
+		{"100 W * 3 h", "300 W*h"},
+		{"2 N * 3 m", "6 N*m"},
+		{"1 GB / 10 s", "0.1 GB/s"},
+		{"1.5 GiB / 20 Mbit/s", "644.2450944 s"},
+		{"10 kg * 9.8 m/s^2", "98 N"},
+		{"1 / 2 s", "0.5 Hz"},
+	} {
+		got, err := calculate(tc.expr)
+		if err != nil || got != tc.want {
+			t.Errorf("calculate(%q) = %q, %v, want %q", tc.expr, got, err, tc.want)
+		}
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"1 km + 1 kg", "can't add m and kg"},
+		{"3 m to s", "can't convert m to s"},
+		{"20 degC + 1", "can't use a degC reading with +; use K for temperature differences"},
+		{"1/0", "division by zero"},
+		{"(2", "missing )"},
+		{"2 to", "nothing to convert to"},
+		{"2 x", `unexpected "x"`},
+	} {
+		if _, err := calculate(tc.expr); err == nil || err.Error() != tc.want {
+			t.Errorf("calculate(%q) error = %v, want %q", tc.expr, err, tc.want)
+		}
+	}
+}
+
+func TestFormatRat(t *testing.T) {
+	for _, tc := range []struct {
+		r     string
+		exact bool
+		want  string
+	}{
+		{"42", true, "42"},
+		{"-7/4", true, "-1.75"},
+		{"1/1024", true, "0.0009765625"},
+		{"2/3", true, "2/3 = 0.666666666666667…"},
+		{"200000000/45359237", true, "4.40924524369755…"},
+		{"1/2", false, "≈ 0.5"},
+	} {
+		r, _ := new(big.Rat).SetString(tc.r)
+		if got := formatRat(r, tc.exact); got != tc.want {
+			t.Errorf("formatRat(%s, %v) = %q, want %q", tc.r, tc.exact, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// `go-chat changelog v1.2.0..HEAD` writes a CHANGELOG section for a range
+// of commits. Each commit is put in the first group whose pattern matches
+// its message (by default the conventional-commit types, with anything
+// else under "Other"), and the model rewrites them as entries from their
+// messages and diffs in the configured tone:
+//
+//	"changelog": {
+//	  "groups": [
+//	    {"title": "New", "match": "^feat"},
+//	    {"title": "Fixed", "match": "^fix"},
+//	    {"match": "^(chore|ci|test)", "hide": true},
+//	    {"title": "Other"}
+//	  ],
+//	  "tone": "plain and user-facing, no internal jargon"
+//	}
+//
+// A group without a pattern takes whatever reaches it; commits matching no
+// group are left out. -raw lists the commit subjects by group without
+// asking the model.
+type ChangelogConfig struct {
+	Groups []ChangelogGroup `json:"groups,omitempty"`
+	Tone   string           `json:"tone,omitempty"`
+}
+
+type ChangelogGroup struct {
+	Title string `json:"title,omitempty"`
+	Match string `json:"match,omitempty"` // regexp on the whole message
+	Hide  bool   `json:"hide,omitempty"`
+}
+
+const (
+	changelogDiffChars   = 2000 // of each commit's diff sent to the model
+	defaultChangelogTone = "concise and user-facing: what changed for someone using the project, not how"
+)
+
+var defaultChangelogGroups = []ChangelogGroup{
+	{Title: "Breaking Changes", Match: `^\w+(\([^)]*\))?!:|(?m)^BREAKING[ -]CHANGE:`},
+	{Title: "Features", Match: `^feat\b`},
+	{Title: "Bug Fixes", Match: `^fix\b`},
+	{Title: "Performance", Match: `^perf\b`},
+	{Title: "Documentation", Match: `^docs\b`},
+	{Match: `^(chore|ci|build|test|style|refactor)\b`, Hide: true},
+	{Title: "Other"},
+}
+
+// conventionalPrefix is a conventional-commit type and scope.
+var conventionalPrefix = regexp.MustCompile(`^\w+(\([^)]*\))?!?:\s*`)
+
+type changelogCommit struct {
+	Hash    string `json:"hash"`
+	Subject string `json:"subject"`
+	Body    string `json:"body,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+	group   int
+}
+
+func runChangelog(args []string) {
+	fset := flag.NewFlagSet("changelog", flag.ExitOnError)
+	version := fset.String("version", "", "Heading for the section (default Unreleased)")
+	raw := fset.Bool("raw", false, "List the commit subjects by group without the model")
+	model := fset.String("model", modelLogic, "Model to write the entries with")
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		log.Fatal("usage: go-chat changelog [-version V] [-raw] [-model M] <from>..<to>")
+	}
+
+	cfg := getConfig().Changelog
+	groups := cfg.Groups
+	if len(groups) == 0 {
+		groups = defaultChangelogGroups
+	}
+	patterns := make([]*regexp.Regexp, len(groups))
+	for i, g := range groups {
+		if g.Match == "" {
+			continue
+		}
+		re, err := regexp.Compile(g.Match)
+		if err != nil {
+			log.Fatalf("changelog: group %q: %v", g.Title, err)
+		}
+		patterns[i] = re
+	}
+
+	commits, err := gitCommits(fset.Arg(0), !*raw)
+	if err != nil {
+		log.Fatalf("changelog: %v", err)
+	}
+	var kept []changelogCommit
+	for _, c := range commits {
+		c.group = -1
+		for i, re := range patterns {
+			if re == nil || re.MatchString(c.Subject+"\n"+c.Body) {
+				c.group = i
+				break
+			}
+		}
+		if c.group >= 0 && !groups[c.group].Hide {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		log.Fatalf("changelog: no commits to list in %s", fset.Arg(0))
+	}
+
+	entries := map[int][]string{}
+	if *raw {
+		for _, c := range kept {
+			entries[c.group] = append(entries[c.group], fmt.Sprintf("%s (%s)", conventionalPrefix.ReplaceAllString(c.Subject, ""), c.Hash))
+		}
+	} else if entries, err = writeChangelog(*model, groups, kept, cmp.Or(cfg.Tone, defaultChangelogTone)); err != nil {
+		log.Fatalf("changelog: %v", err)
+	}
+
+	heading := "Unreleased"
+	if *version != "" {
+		heading = *version + " - " + time.Now().Format("2006-01-02")
+	}
+	fmt.Printf("## %s\n", heading)
+	for i, g := range groups {
+		if len(entries[i]) == 0 {
+			continue
+		}
+		fmt.Printf("\n### %s\n\n", g.Title)
+		for _, e := range entries[i] {
+			fmt.Printf("- %s\n", e)
+		}
+	}
+}
+
+// gitCommits lists the commits in a range, oldest first, leaving out
+// merges, with the start of each diff if withDiffs is set.
+func gitCommits(rng string, withDiffs bool) ([]changelogCommit, error) {
+	out, err := gitOutput("log", "--reverse", "--no-merges", "--format=%h%x1f%s%x1f%b%x1e", rng, "--")
+	if err != nil {
+		return nil, err
+	}
+	var commits []changelogCommit
+	for _, rec := range strings.Split(out, "\x1e") {
+		f := strings.Split(strings.TrimSpace(rec), "\x1f")
+		if len(f) < 3 {
+			continue
+		}
+		c := changelogCommit{Hash: f[0], Subject: f[1], Body: strings.TrimSpace(f[2])}
+		if withDiffs {
+			diff, err := gitOutput("show", "--format=", "--stat", "--patch", c.Hash)
+			if err != nil {
+				return nil, err
+			}
+			c.Diff = truncateRunes(diff, changelogDiffChars)
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// writeChangelog has the model write the entries for each group.
+func writeChangelog(model string, groups []ChangelogGroup, commits []changelogCommit, tone string) (map[int][]string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tone: %s\n", tone)
+	for i, g := range groups {
+		if g.Hide {
+			continue
+		}
+		var in []changelogCommit
+		for _, c := range commits {
+			if c.group == i {
+				in = append(in, c)
+			}
+		}
+		if len(in) == 0 {
+			continue
+		}
+		data, err := json.MarshalIndent(in, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\nGroup %d: %s\n%s\n", i, g.Title, data)
+	}
+
+	out, err := queryGPTStream(model, prompt("changelog"), 0.3, 4096, []Message{{Role: "user", Content: b.String()}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, errors.New("no JSON in reply")
+	}
+	var parsed struct {
+		Groups []struct {
+			Group   int `json:"group"`
+			Entries []struct {
+				Text    string   `json:"text"`
+				Commits []string `json:"commits"`
+			} `json:"entries"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal([]byte(out[i:j+1]), &parsed); err != nil {
+		return nil, err
+	}
+	entries := map[int][]string{}
+	for _, g := range parsed.Groups {
+		if g.Group < 0 || g.Group >= len(groups) || groups[g.Group].Hide {
+			continue
+		}
+		for _, e := range g.Entries {
+			text := strings.TrimSpace(e.Text)
+			if len(e.Commits) > 0 {
+				text += " (" + strings.Join(e.Commits, ", ") + ")"
+			}
+			entries[g.Group] = append(entries[g.Group], text)
+		}
+	}
+	return entries, nil
+}
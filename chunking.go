@@ -0,0 +1,231 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// How documents are split for the index. Per-source rules go in the config
+// and the first one whose match fits the source wins:
+//
+//	"index": {"chunking": [
+//	  {"match": "*.go", "strategy": "code", "size": 80},
+//	  {"match": "/home/me/notes", "strategy": "markdown", "size": 60, "overlap": 0}
+//	]}
+//
+// match is a glob tried against the full path or URL and the base name, or
+// a directory or URL prefix. Sizes are in lines. Strategies:
+//
+//	auto      code for .go and .py, markdown for .md, lines otherwise
+//	lines     fixed windows of size lines, overlapping by overlap
+//	code      one chunk per top-level declaration (go/parser for Go, def and
+//	          class for Python); small ones are merged, long ones windowed
+//	markdown  one chunk per header section, merged and windowed likewise
+const (
+	chunkAuto     = "auto"
+	chunkLines    = "lines"
+	chunkCode     = "code"
+	chunkMarkdown = "markdown"
+)
+
+type IndexConfig struct {
+	Chunking []ChunkRule `json:"chunking,omitempty"`
+	Roots    []string    `json:"roots,omitempty"` // what `index add` indexes when given nothing; see project.go
+}
+
+type ChunkRule struct {
+	Match    string `json:"match"`
+	Strategy string `json:"strategy,omitempty"`
+	Size     int    `json:"size,omitempty"`
+	Overlap  *int   `json:"overlap,omitempty"`
+}
+
+// chunkRule returns the settings for a source, with defaults filled in.
+func chunkRule(cfg IndexConfig, source string) ChunkRule {
+	r := ChunkRule{Strategy: chunkAuto}
+	for _, rule := range cfg.Chunking {
+		if ruleMatches(rule.Match, source) {
+			r = rule
+			break
+		}
+	}
+	if r.Strategy == "" {
+		r.Strategy = chunkAuto
+	}
+	if r.Size <= 0 {
+		r.Size = defaultChunkLines
+	}
+	if r.Overlap == nil {
+		ov := min(defaultChunkOverlap, r.Size/4)
+		r.Overlap = &ov
+	}
+	if *r.Overlap >= r.Size {
+		ov := r.Size - 1
+		r.Overlap = &ov
+	}
+	return r
+}
+
+func ruleMatches(match, source string) bool {
+	if match == "" {
+		return false
+	}
+	if ok, _ := path.Match(match, source); ok {
+		return true
+	}
+	if ok, _ := path.Match(match, path.Base(source)); ok {
+		return true
+	}
+	prefix := strings.TrimSuffix(match, "/")
+	return strings.HasPrefix(source, prefix+"/")
+}
+
+type textChunk struct {
+	start, end int // 1-based, inclusive
+	text       string
+}
+
+// chunkDocument splits a document according to its rule.
+func chunkDocument(d document, r ChunkRule) []textChunk {
+	lines := strings.Split(strings.TrimRight(d.text, "\n"), "\n")
+
+	strategy := r.Strategy
+	if strategy == chunkAuto {
+		switch strings.ToLower(filepath.Ext(d.source)) {
+		case ".go", ".py":
+			strategy = chunkCode
+		case ".md", ".markdown":
+			strategy = chunkMarkdown
+		default:
+			strategy = chunkLines
+		}
+	}
+
+	var starts []int
+	switch strategy {
+	case chunkCode:
+		if strings.HasSuffix(d.source, ".go") {
+			starts = goDeclStarts(d.source, d.text)
+		} else {
+			starts = pythonDefStarts(lines)
+		}
+	case chunkMarkdown:
+		starts = markdownHeaderStarts(lines)
+	}
+	if len(starts) == 0 {
+		return windowLines(lines, 0, len(lines), r.Size, *r.Overlap)
+	}
+	return chunkSections(lines, starts, r.Size, *r.Overlap)
+}
+
+// chunkSections cuts lines at the given 0-based section starts, merges
+// neighbouring sections while they fit in size and windows the ones that
+// don't.
+func chunkSections(lines []string, starts []int, size, overlap int) []textChunk {
+	if starts[0] != 0 {
+		starts = append([]int{0}, starts...)
+	}
+	var out []textChunk
+	from := starts[0]
+	for i := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		switch {
+		case end-starts[i] > size:
+			// Flush what was merged so far, then window the long section.
+			out = append(out, windowLines(lines, from, starts[i], size, overlap)...)
+			out = append(out, windowLines(lines, starts[i], end, size, overlap)...)
+			from = end
+		case end-from > size:
+			out = append(out, windowLines(lines, from, starts[i], size, overlap)...)
+			from = starts[i]
+		}
+	}
+	return append(out, windowLines(lines, from, len(lines), size, overlap)...)
+}
+
+// windowLines splits lines[from:to] into windows of size lines that
+// overlap by overlap, skipping blank ones.
+func windowLines(lines []string, from, to, size, overlap int) []textChunk {
+	var out []textChunk
+	for start := from; start < to; start += size - overlap {
+		end := min(start+size, to)
+		body := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(body) != "" {
+			out = append(out, textChunk{start: start + 1, end: end, text: body})
+		}
+		if end == to {
+			break
+		}
+	}
+	return out
+}
+
+// goDeclStarts returns the 0-based first line of each top-level
+// declaration, doc comment included. Files that don't parse yield nil.
+func goDeclStarts(filename, src string) []int {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var starts []int
+	for _, decl := range f.Decls {
+		pos := decl.Pos()
+		if doc := declDoc(decl); doc != nil {
+			pos = doc.Pos()
+		}
+		starts = append(starts, fset.Position(pos).Line-1)
+	}
+	return starts
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
+
+// pythonDefStarts returns the 0-based lines that begin a top-level def or
+// class, including decorators and comments directly above.
+func pythonDefStarts(lines []string) []int {
+	var starts []int
+	for i, l := range lines {
+		if !(strings.HasPrefix(l, "def ") || strings.HasPrefix(l, "async def ") || strings.HasPrefix(l, "class ")) {
+			continue
+		}
+		s := i
+		for s > 0 && (strings.HasPrefix(lines[s-1], "@") || strings.HasPrefix(lines[s-1], "#")) {
+			s--
+		}
+		starts = append(starts, s)
+	}
+	return starts
+}
+
+// markdownHeaderStarts returns the 0-based lines holding a header, ignoring
+// # lines inside code fences.
+func markdownHeaderStarts(lines []string) []int {
+	var starts []int
+	inFence := false
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence && strings.HasPrefix(l, "#") {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
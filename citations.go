@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Answers drawing on the document index cite their sources inline as [n];
+// the cited ones are listed beneath the answer and kept in the log entry.
+
+type Citation struct {
+	N         int    `json:"n"`
+	Source    string `json:"source"`
+	StartLine int    `json:"start_line,omitempty"`
+	EndLine   int    `json:"end_line,omitempty"`
+}
+
+func (c Citation) String() string {
+	if c.StartLine == 0 {
+		return c.Source
+	}
+	return fmt.Sprintf("%s:%d-%d", c.Source, c.StartLine, c.EndLine)
+}
+
+const (
+	sourcesTopK     = 4
+	sourcesMinScore = 0.3
+)
+
+// retrieveSources returns the indexed chunks most relevant to the prompt
+// that a conversation in scope may see.
+func retrieveSources(userPrompt, scope string) []IndexChunk {
+	index := loadIndex().Chunks
+	if len(index) == 0 {
+		return nil
+	}
+	vec, err := embedText(userPrompt)
+	if err != nil {
+		return nil
+	}
+	return rankChunks(index, vec, scope, sourcesTopK)
+}
+
+// rankChunks returns up to k chunks readable from scope that are similar
+// enough to vec, best first.
+func rankChunks(chunks []IndexChunk, vec []float32, scope string, k int) []IndexChunk {
+	type scored struct {
+		IndexChunk
+		score float64
+	}
+	var hits []scored
+	for _, c := range chunks {
+		if !canRead(scope, c.Scope) {
+			continue
+		}
+		if s := cosineSim(c.Embedding, vec); s >= sourcesMinScore {
+			hits = append(hits, scored{c, s})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	out := make([]IndexChunk, len(hits))
+	for i, h := range hits {
+		out[i] = h.IndexChunk
+	}
+	return out
+}
+
+// sourcesPrompt numbers the chunks for the system prompt.
+func sourcesPrompt(chunks []IndexChunk) string {
+	var b strings.Builder
+	b.WriteString(prompt("sources"))
+	for i, c := range chunks {
+		fmt.Fprintf(&b, "\n\n[%d] %s\n%s", i+1, chunkCitation(i+1, c), c.Text)
+	}
+	return b.String()
+}
+
+func chunkCitation(n int, c IndexChunk) Citation {
+	return Citation{N: n, Source: c.Source, StartLine: c.StartLine, EndLine: c.EndLine}
+}
+
+var citationRe = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// citedSources returns the chunks the answer actually cites, in number
+// order. Numbers that don't match a source are ignored.
+func citedSources(answer string, chunks []IndexChunk) []Citation {
+	seen := map[int]bool{}
+	for _, m := range citationRe.FindAllStringSubmatch(answer, -1) {
+		for _, f := range strings.Split(m[1], ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(f)); err == nil && n >= 1 && n <= len(chunks) {
+				seen[n] = true
+			}
+		}
+	}
+	var out []Citation
+	for i, c := range chunks {
+		if seen[i+1] {
+			out = append(out, chunkCitation(i+1, c))
+		}
+	}
+	return out
+}
+
+// renderCitations formats the source list shown beneath an answer.
+func renderCitations(cites []Citation) string {
+	if len(cites) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Sources:")
+	for _, c := range cites {
+		fmt.Fprintf(&b, "\n[%d] %s", c.N, c)
+	}
+	return b.String()
+}
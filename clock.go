@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// timeContext returns the system prompt line giving the model the current
+// local time, time zone and locale, so "tomorrow", "in two hours" and
+// number or currency formats come out right. Set "time_context": false in
+// the config to leave it out (e.g. for reproducible runs); "timezone" and
+// "locale" override what the environment says.
+func timeContext(cfg Config) string {
+	if cfg.TimeContext != nil && !*cfg.TimeContext {
+		return ""
+	}
+
+	now := time.Now()
+	zone := localZoneName()
+	if loc := userLocation(cfg); loc != time.Local {
+		now, zone = now.In(loc), cfg.Timezone
+	}
+	abbr, _ := now.Zone()
+	if zone == "" {
+		zone = abbr
+	}
+
+	line := fmt.Sprintf("Current local time: %s (%s, UTC%s).",
+		now.Format("Monday 2006-01-02 15:04"), zone, now.Format("-07:00"))
+
+	locale := cfg.Locale
+	if locale == "" {
+		locale = envLocale()
+	}
+	if locale != "" {
+		line += " User locale: " + locale + "; use its date, number and currency conventions."
+	}
+	return line
+}
+
+// userLocation is the configured "timezone", or the local zone.
+func userLocation(cfg Config) *time.Location {
+	if cfg.Timezone != "" {
+		if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// logDay is the daily log t belongs to: its date in the user's time zone,
+// with hours before "day_rollover_hour" (default 0, midnight) counted as
+// the previous day so a late-night session stays in one file. Timestamps
+// themselves are stored in UTC and shown in the user's zone.
+func logDay(t time.Time) string {
+	cfg := getConfig()
+	h := cfg.DayRolloverHour
+	if h < 0 || h > 23 {
+		h = 0
+	}
+	return t.In(userLocation(cfg)).Add(-time.Duration(h) * time.Hour).Format(time.DateOnly)
+}
+
+// localZoneName returns the IANA name of the local zone when it can be
+// found, from $TZ or the /etc/localtime link.
+func localZoneName() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		return tz
+	}
+	if name := time.Local.String(); name != "Local" {
+		return name
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if _, after, ok := strings.Cut(target, "zoneinfo/"); ok {
+			return after
+		}
+	}
+	return ""
+}
+
+// envLocale reads the POSIX locale variables, ignoring the C/POSIX default.
+func envLocale() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		l := os.Getenv(v)
+		l, _, _ = strings.Cut(l, ".")
+		if l != "" && l != "C" && l != "POSIX" {
+			return l
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Contacts are shorthand for people, kept in the config:
+//
+//	"contacts": {
+//	  "J": {"name": "Jordan Lee", "about": "my manager; likes short emails",
+//	        "email": "jordan@example.com", "timezone": "America/New_York"}
+//	}
+//
+// When a prompt mentions a contact by key, name or one of its aliases,
+// what is known about them goes into the system prompt, so "draft a reply
+// to J" needs no more context. Names written in capitals match only in
+// capitals, so "J" doesn't match "j"; others match in any case. Contacts
+// with a timezone can also be named as zones in the time_zones tool.
+type Contact struct {
+	Name     string   `json:"name,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+	About    string   `json:"about,omitempty"` // relationship and anything worth knowing
+	Email    string   `json:"email,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+}
+
+// contactMentioned reports whether text mentions name as a whole word.
+func contactMentioned(text, name string) bool {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false
+	}
+	flags := "(?i)"
+	if name == strings.ToUpper(name) {
+		flags = ""
+	}
+	re, err := regexp.Compile(flags + `(?:^|[^\pL\pN])` + regexp.QuoteMeta(name) + `(?:$|[^\pL\pN])`)
+	return err == nil && re.MatchString(text)
+}
+
+// mentionedContacts returns the keys of the contacts text mentions, sorted.
+func mentionedContacts(contacts map[string]Contact, text string) []string {
+	var keys []string
+	for key, c := range contacts {
+		for _, n := range append([]string{key, c.Name}, c.Aliases...) {
+			if contactMentioned(text, n) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contactsContext describes the contacts a prompt mentions for the system
+// prompt, or is "" if it mentions none.
+func contactsContext(cfg Config, userPrompt string) string {
+	keys := mentionedContacts(cfg.Contacts, userPrompt)
+	if len(keys) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("People the user mentions:")
+	for _, key := range keys {
+		c := cfg.Contacts[key]
+		var parts []string
+		if c.Name != "" && c.Name != key {
+			parts = append(parts, c.Name)
+		}
+		if c.About != "" {
+			parts = append(parts, c.About)
+		}
+		if c.Email != "" {
+			parts = append(parts, "email "+c.Email)
+		}
+		if c.Timezone != "" {
+			if loc, err := time.LoadLocation(c.Timezone); err == nil {
+				parts = append(parts, fmt.Sprintf("time zone %s (now %s there)", c.Timezone, time.Now().In(loc).Format("Mon 15:04 MST")))
+			} else {
+				warnOnce("contact "+key, "contacts: %s: %v", key, err)
+			}
+		}
+		fmt.Fprintf(&b, "\n- %s: %s", key, strings.Join(parts, "; "))
+	}
+	return b.String()
+}
+
+// contactZone is the time zone of the contact called name, if it has one.
+func contactZone(name string) (string, bool) {
+	for key, c := range getConfig().Contacts {
+		if c.Timezone == "" {
+			continue
+		}
+		for _, n := range append([]string{key, c.Name}, c.Aliases...) {
+			if n != "" && strings.EqualFold(n, name) {
+				return c.Timezone, true
+			}
+		}
+	}
+	return "", false
+}
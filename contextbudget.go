@@ -0,0 +1,127 @@
+package main
+
+import "slices"
+
+// ContextConfig decides what of a turn's context survives when it has to
+// be trimmed. Sections are given tokens in Order, highest priority first:
+// each takes what it needs, up to its Tokens cap, from what the sections
+// before it left, so a section late in the order is the first to lose
+// content. By default nothing is capped and the history, last in the
+// order, is what gets cut.
+//
+//	"context": {
+//	  "order": ["persona", "history", "memories", "sources"],
+//	  "tokens": {"memories": 2000, "sources": 6000},
+//	  "total": 60000
+//	}
+//
+// Sections are "persona" (bio and personality), "memories", "sources"
+// (cited index chunks) and "history" (the day's turns and -resume context).
+// Ones left out of Order follow in that order. Memories and sources are
+// dropped whole, least relevant first; the history loses turns as
+// "history_trim" says, the oldest by default; the persona is cut short.
+// Where sections appear in the system prompt is up to the template (see
+// systemprompt.go).
+type ContextConfig struct {
+	Order  []string       `json:"order,omitempty"`
+	Tokens map[string]int `json:"tokens,omitempty"` // per-section cap; 0 is none
+	Total  int            `json:"total,omitempty"`  // default: the context window less 2048 for the answer
+
+	HistoryTrim   HistoryTrim `json:"history_trim,omitempty"`   // see historytrim.go
+	MessageTokens int         `json:"message_tokens,omitempty"` // per-turn cap; see turncap.go
+}
+
+const (
+	sectionPersona  = "persona"
+	sectionMemories = "memories"
+	sectionSources  = "sources"
+	sectionHistory  = "history"
+)
+
+var contextSections = []string{sectionPersona, sectionMemories, sectionSources, sectionHistory}
+
+// contextParts is the trimmable context of one turn.
+type contextParts struct {
+	Bio, Personality string
+	Memories         []VectorMemory // best first
+	Sources          []IndexChunk   // best first
+	History          []Message      // oldest first
+	Latest           string         // the prompt being answered
+}
+
+func (c ContextConfig) order() []string {
+	var order []string
+	for _, s := range c.Order {
+		switch {
+		case !slices.Contains(contextSections, s):
+			warnOnce("context:"+s, "context: unknown section %q (use %v)", s, contextSections)
+		case !slices.Contains(order, s):
+			order = append(order, s)
+		}
+	}
+	for _, s := range contextSections {
+		if !slices.Contains(order, s) {
+			order = append(order, s)
+		}
+	}
+	return order
+}
+
+// fitContext trims p to the budget in c.
+func fitContext(c ContextConfig, p contextParts) contextParts {
+	counter := configuredProvider()
+	left := c.Total
+	if left <= 0 {
+		left = contextWindowTokens - 2048
+	}
+	for _, section := range c.order() {
+		allow := left
+		if limit := c.Tokens[section]; limit > 0 {
+			allow = min(allow, limit)
+		}
+		used := 0
+		switch section {
+		case sectionPersona:
+			p.Bio = fitTokens(p.Bio, allow)
+			used = tokensFor(counter, p.Bio)
+			p.Personality = fitTokens(p.Personality, allow-used)
+			used += tokensFor(counter, p.Personality)
+		case sectionMemories:
+			for i, m := range p.Memories {
+				if used+tokensFor(counter, m.Text) > allow {
+					p.Memories = p.Memories[:i]
+					break
+				}
+				used += tokensFor(counter, m.Text)
+			}
+		case sectionSources:
+			for i, s := range p.Sources {
+				if used+tokensFor(counter, s.Text) > allow {
+					p.Sources = p.Sources[:i]
+					break
+				}
+				used += tokensFor(counter, s.Text)
+			}
+		case sectionHistory:
+			p.History = c.HistoryTrim.trim(p.History, allow, p.Latest)
+			for _, m := range p.History {
+				used += tokensMsgFor(counter, m)
+			}
+		}
+		left -= used
+	}
+	return p
+}
+
+// fitTokens cuts s to at most n tokens.
+func fitTokens(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	counter := configuredProvider()
+	for t := tokensFor(counter, s); t > n; t = tokensFor(counter, s) {
+		r := []rune(s)
+		s = string(r[:min(len(r)*n/t, len(r)-1)])
+	}
+	return s
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron schedules: five fields (minute, hour, day of month, month, day of
+// week) of *, numbers, names (jan, mon), ranges, lists and /steps, or one
+// of @yearly, @monthly, @weekly, @daily and @hourly. As in Vixie cron, when
+// both day fields are restricted a day matching either one fires. The
+// daemon uses them for "checkin_schedule", and `go-chat cron` writes them
+// from a description, checks them and shows when they fire, along with a
+// systemd timer with -systemd.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit n set: n matches
+	anyDay                        bool   // dom or dow is *
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDays   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+const cronFires = 5
+
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	f := strings.Fields(expr)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("%q: want 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(f))
+	}
+	s := &cronSchedule{anyDay: strings.HasPrefix(f[2], "*") || strings.HasPrefix(f[4], "*")}
+	var err error
+	if s.minute, err = cronField(f[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = cronField(f[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = cronField(f[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = cronField(f[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = cronField(f[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	return s, nil
+}
+
+// cronField parses one field into a bit set of the values in lo..hi.
+// names, if given, name the values from lo (or from 0 for days).
+func cronField(field string, lo, hi int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, n := range names {
+			if strings.EqualFold(s, n) {
+				if len(names) == 12 {
+					return i + 1, nil
+				}
+				return i, nil
+			}
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < lo || v > hi {
+			return 0, fmt.Errorf("%q is not a value from %d to %d", s, lo, hi)
+		}
+		return v, nil
+	}
+
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", stepText)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		switch a, b, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+		case isRange:
+			var err error
+			if from, err = value(a); err != nil {
+				return 0, err
+			}
+			if to, err = value(b); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("range %q runs backwards", rng)
+			}
+		default:
+			v, err := value(rng)
+			if err != nil {
+				return 0, err
+			}
+			from = v
+			if !hasStep {
+				to = v
+			}
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom&(1<<t.Day()) != 0, s.dow&(1<<int(t.Weekday())) != 0
+	if s.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that s fires, or the zero time if
+// it never does (such as February 30th).
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// onCalendar is s in systemd's OnCalendar syntax: one line, or two when
+// both day fields are restricted, since systemd requires both to match.
+func (s *cronSchedule) onCalendar() []string {
+	list := func(set uint64, lo, hi int, pad bool) string {
+		if bits.OnesCount64(set>>lo) >= hi-lo+1 {
+			return "*"
+		}
+		var vs []string
+		for v := lo; v <= hi; v++ {
+			if set&(1<<v) != 0 {
+				if pad {
+					vs = append(vs, fmt.Sprintf("%02d", v))
+				} else {
+					vs = append(vs, strconv.Itoa(v))
+				}
+			}
+		}
+		return strings.Join(vs, ",")
+	}
+	days := func(set uint64) string {
+		var ds []string
+		for d := 0; d < 7; d++ {
+			if set&(1<<d) != 0 {
+				ds = append(ds, strings.ToUpper(cronDays[d][:1])+cronDays[d][1:])
+			}
+		}
+		return strings.Join(ds, ",")
+	}
+	dow := s.dow & 0x7f
+	clock := fmt.Sprintf("%s:%s:00", list(s.hour, 0, 23, true), list(s.minute, 0, 59, true))
+	month := list(s.month, 1, 12, true)
+	dom := list(s.dom, 1, 31, true)
+	switch {
+	case !s.anyDay && (dow == 0x7f || dom == "*"):
+		return []string{fmt.Sprintf("*-%s-* %s", month, clock)}
+	case dow == 0x7f:
+		return []string{fmt.Sprintf("*-%s-%s %s", month, dom, clock)}
+	case dom == "*":
+		return []string{fmt.Sprintf("%s *-%s-* %s", days(dow), month, clock)}
+	case !s.anyDay:
+		return []string{
+			fmt.Sprintf("*-%s-%s %s", month, dom, clock),
+			fmt.Sprintf("%s *-%s-* %s", days(dow), month, clock),
+		}
+	}
+	return []string{fmt.Sprintf("%s *-%s-%s %s", days(dow), month, dom, clock)}
+}
+
+func runCron(args []string) {
+	fset := flag.NewFlagSet("cron", flag.ExitOnError)
+	systemd := fset.Bool("systemd", false, "Also print a systemd timer unit")
+	tz := fset.String("tz", "", "Show fire times in this time zone (default local)")
+	rounds := fset.Int("rounds", defaultExprRounds, "Attempts before giving up")
+	desc := strings.TrimSpace(strings.Join(parseInterspersed(fset, args), " "))
+	if desc == "" {
+		log.Fatal(`usage: go-chat cron [-systemd] [-tz Zone] "every weekday at 7am" | "<expression>"`)
+	}
+	loc := time.Local
+	if *tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			log.Fatalf("cron: %v", err)
+		}
+	}
+
+	// An expression is checked as it is; anything else is described.
+	expr, explanation := desc, ""
+	sched, err := parseCron(desc)
+	if err != nil {
+		var res struct {
+			Cron        string `json:"cron"`
+			Explanation string `json:"explanation"`
+		}
+		reply, _ := buildExpression(prompt("cron-builder"), desc, *rounds, func(reply string) string {
+			res.Cron, sched = "", nil
+			if err := replyJSON(reply, &res); err != nil {
+				return err.Error()
+			}
+			var err error
+			if sched, err = parseCron(res.Cron); err != nil {
+				return err.Error()
+			}
+			if sched.next(time.Now().In(loc)).IsZero() {
+				sched = nil
+				return "it never fires"
+			}
+			return ""
+		})
+		if sched == nil {
+			log.Fatalf("cron: no valid expression in the reply:\n%s", reply)
+		}
+		expr, explanation = res.Cron, res.Explanation
+		logExpression("cron: "+desc, expr, true)
+	}
+
+	fmt.Println(expr)
+	if explanation != "" {
+		fmt.Printf("\n%s\n", explanation)
+	}
+	fmt.Printf("\nNext %d times (%s):\n", cronFires, loc)
+	t := time.Now().In(loc)
+	for i := 0; i < cronFires; i++ {
+		if t = sched.next(t); t.IsZero() {
+			fmt.Println("  (never)")
+			break
+		}
+		fmt.Println("  " + t.Format("Mon 2006-01-02 15:04 MST"))
+	}
+
+	if *systemd {
+		fmt.Printf("\n[Unit]\nDescription=%s\n\n[Timer]\n", desc)
+		for _, c := range sched.onCalendar() {
+			fmt.Printf("OnCalendar=%s\n", c)
+		}
+		fmt.Print("Persistent=true\n\n[Install]\nWantedBy=timers.target\n")
+	}
+}
+
+// checkInSchedule is the configured check-in schedule, or nil for the
+// default of at most one check-in every two hours.
+func checkInSchedule() *cronSchedule {
+	expr := getConfig().CheckInSchedule
+	if expr == "" {
+		return nil
+	}
+	s, err := parseCron(expr)
+	if err != nil {
+		warnOnce("checkin_schedule", "checkin_schedule: %v; checking in every two hours", err)
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2026-01-14 is a Wednesday.
+	from := time.Date(2026, 1, 14, 10, 7, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"* * * * *", "2026-01-14 10:08"},
+		{"*/15 * * * *", "2026-01-14 10:15"},
+		{"5/20 * * * *", "2026-01-14 10:25"},
+		{"0 9-17 * * *", "2026-01-14 11:00"},
+		{"30 8,12 * * *", "2026-01-14 12:30"},
+		{"0 9 * * mon-fri", "2026-01-15 09:00"},
+		{"0 0 * * 0", "2026-01-18 00:00"},
+		{"0 0 * * 7", "2026-01-18 00:00"},
+		{"0 0 * * SUN", "2026-01-18 00:00"},
+		{"0 0 1 * *", "2026-02-01 00:00"},
+		{"0 0 29 feb *", "2028-02-29 00:00"},
+		{"0 12 31 * *", "2026-01-31 12:00"},
+		{"0 0 31 apr *", ""},
+		{"@hourly", "2026-01-14 11:00"},
+		{"@daily", "2026-01-15 00:00"},
+		{"@weekly", "2026-01-18 00:00"},
+		{"@monthly", "2026-02-01 00:00"},
+		{"@YEARLY", "2027-01-01 00:00"},
+		// Both day fields restricted: either one matches.
+		{"0 0 13 * fri", "2026-01-16 00:00"},
+		{"0 0 15 * fri", "2026-01-15 00:00"},
+		// A day field starting with * means both must match.
+		{"0 0 15 * *", "2026-01-15 00:00"},
+		{"0 0 */2 * fri", "2026-01-23 00:00"},
+	} {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		got := s.next(from)
+		if tc.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q.next = %v, want never", tc.expr, got)
+			}
+			continue
+		}
+		if g := got.Format("2006-01-02 15:04"); g != tc.want {
+			t.Errorf("%q.next = %s, want %s", tc.expr, g, tc.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"1-x * * * *",
+		"@reboot",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestOnCalendar(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want []string
+	}{
+		{"*/15 * * * *", []string{"*-*-* *:00,15,30,45:00"}},
+		{"0 9 * * mon-fri", []string{"Mon,Tue,Wed,Thu,Fri *-*-* 09:00:00"}},
+		{"30 6 1 jan *", []string{"*-01-01 06:30:00"}},
+		{"0 0 13 * fri", []string{"*-*-13 00:00:00", "Fri *-*-* 00:00:00"}},
+		{"0 0 * * 7", []string{"Sun *-*-* 00:00:00"}},
+	} {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := s.onCalendar(); !slices.Equal(got, tc.want) {
+			t.Errorf("%q.onCalendar() = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// While daemon mode (-d) runs it keeps ~/.go-chat-daemon.json up to date:
+// its pid, when it started and will next wake, and the last few errors.
+// A check-in that fails is recorded there and the daemon carries on
+// instead of exiting. `go-chat daemon status` reads it along with the
+// check-in state and the failed requests kept for retry-last. Whether the
+// pid is still alive can only be told on Unix.
+const maxDaemonErrors = 10
+
+type daemonStatus struct {
+	PID      int           `json:"pid"`
+	Started  time.Time     `json:"started"`
+	Stopped  time.Time     `json:"stopped"` // zero while running, or if it died
+	NextWake time.Time     `json:"next_wake"`
+	Errors   []daemonError `json:"errors,omitempty"`
+}
+
+type daemonError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+var (
+	daemonFilePath string
+	inDaemon       bool // set by runAsDaemon
+)
+
+func loadDaemonStatus() (daemonStatus, bool) {
+	var ds daemonStatus
+	data, err := os.ReadFile(daemonFilePath)
+	if err != nil {
+		return ds, false
+	}
+	return ds, json.Unmarshal(data, &ds) == nil
+}
+
+// updateDaemonStatus changes the status file under its lock.
+func updateDaemonStatus(change func(*daemonStatus)) {
+	defer lockFile(daemonFilePath)()
+	ds, _ := loadDaemonStatus()
+	change(&ds)
+	data, _ := json.MarshalIndent(ds, "", "  ")
+	if err := writeFileAtomic(daemonFilePath, data, 0o600); err != nil {
+		log.Printf("daemon status: %v", err)
+	}
+}
+
+func (ds daemonStatus) running() bool {
+	return ds.PID != 0 && ds.Stopped.IsZero() && processAlive(ds.PID)
+}
+
+// startDaemon claims the status file for this process, refusing if
+// another daemon is running; it returns the function that marks it
+// stopped.
+func startDaemon() func() {
+	if ds, _ := loadDaemonStatus(); ds.running() && ds.PID != os.Getpid() {
+		log.Fatalf("the daemon is already running (pid %d)", ds.PID)
+	}
+	inDaemon = true
+	updateDaemonStatus(func(ds *daemonStatus) {
+		ds.PID, ds.Started, ds.Stopped, ds.NextWake = os.Getpid(), time.Now(), time.Time{}, time.Time{}
+	})
+	return func() {
+		updateDaemonStatus(func(ds *daemonStatus) { ds.Stopped, ds.NextWake = time.Now(), time.Time{} })
+	}
+}
+
+// chatFailed ends the program on a failed chat, except in the daemon,
+// which logs and records the error and goes on.
+func chatFailed(err error) error {
+	if !inDaemon {
+		log.Fatal(err)
+	}
+	log.Print(err)
+	updateDaemonStatus(func(ds *daemonStatus) {
+		ds.Errors = append(ds.Errors, daemonError{time.Now(), err.Error()})
+		if len(ds.Errors) > maxDaemonErrors {
+			ds.Errors = ds.Errors[len(ds.Errors)-maxDaemonErrors:]
+		}
+	})
+	return err
+}
+
+func runDaemon(args []string) {
+	if len(args) != 1 || args[0] != "status" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat daemon status   (start it with go-chat -d)")
+		os.Exit(2)
+	}
+	cfg := getConfig()
+	loc := userLocation(cfg)
+	at := func(t time.Time) string {
+		if logDay(t) == logDay(time.Now()) {
+			return t.In(loc).Format("15:04")
+		}
+		return t.In(loc).Format("Mon Jan 2 15:04")
+	}
+	now := time.Now()
+
+	ds, ok := loadDaemonStatus()
+	switch {
+	case !ok:
+		fmt.Println("daemon: never run (start it with go-chat -d)")
+	case ds.running():
+		fmt.Printf("daemon: running, pid %d, since %s\n", ds.PID, at(ds.Started))
+		if !ds.NextWake.IsZero() {
+			fmt.Printf("  wakes next at %s\n", at(ds.NextWake))
+		}
+	case !ds.Stopped.IsZero():
+		fmt.Printf("daemon: not running, stopped at %s\n", at(ds.Stopped))
+	default:
+		fmt.Printf("daemon: not running; pid %d, started %s, exited without stopping\n", ds.PID, at(ds.Started))
+	}
+
+	st := getState()
+	switch {
+	case !st.CheckInEnabled:
+		fmt.Println("check-ins: off (go-chat -t turns them on)")
+	default:
+		last := "never"
+		if !st.LastChecked.IsZero() {
+			last = at(st.LastChecked)
+		}
+		fmt.Printf("check-ins: last %s", last)
+		due, from := checkInDue(st), now
+		if due.After(now) {
+			from = due
+		}
+		switch until := quietUntil(from, cfg, st); {
+		case due.IsZero():
+			fmt.Print(", none scheduled")
+		case until.IsZero():
+			fmt.Print(", none while there are no active days")
+		case until.After(from):
+			fmt.Printf(", next held until %s (%s)", at(until), quietReason(from, cfg, st))
+		case due.After(now):
+			fmt.Printf(", next due %s", at(due))
+		default:
+			fmt.Print(", next due now")
+		}
+		fmt.Println()
+	}
+
+	if fs := loadFailures(); len(fs) > 0 {
+		f := fs[len(fs)-1]
+		fmt.Printf("failed requests for retry-last: %d, the last at %s: %s\n", len(fs), at(f.Time), truncateRunes(oneLine(f.Error), 80))
+	}
+	if len(ds.Errors) > 0 {
+		fmt.Println("recent errors:")
+		for _, e := range ds.Errors {
+			fmt.Printf("  %s  %s\n", at(e.Time), truncateRunes(oneLine(e.Error), 100))
+		}
+	}
+}
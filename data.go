@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// `go-chat data latency.csv "average latency per region?"` answers questions
+// about a table without sending it. The model sees the columns and a few
+// sample rows, and gets a query_data tool whose filters, group-bys and
+// aggregates run here; only their results go back to it.
+const (
+	dataSampleRows   = 5
+	dataDefaultLimit = 50
+	dataMaxLimit     = 200
+)
+
+type dataTable struct {
+	cols []string
+	rows [][]string
+}
+
+// loadTable reads CSV (or TSV) or JSON: an array of objects, an object
+// holding one, or JSON lines. Nested values are kept as JSON text.
+func loadTable(name string, data []byte) (*dataTable, error) {
+	trimmed := bytes.TrimSpace(data)
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".json" || ext == ".jsonl" || bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		return loadJSONTable(trimmed)
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	if ext == ".tsv" {
+		r.Comma = '\t'
+	}
+	r.FieldsPerRecord = -1
+	recs, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, errors.New("no rows")
+	}
+	t := &dataTable{cols: recs[0]}
+	for _, rec := range recs[1:] {
+		row := make([]string, len(t.cols))
+		copy(row, rec)
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
+
+func loadJSONTable(data []byte) (*dataTable, error) {
+	var objs []map[string]any
+	if err := json.Unmarshal(data, &objs); err != nil {
+		var wrapper map[string]json.RawMessage
+		if json.Unmarshal(data, &wrapper) == nil && len(wrapper) == 1 {
+			for _, v := range wrapper {
+				if json.Unmarshal(v, &objs) == nil {
+					return objectTable(objs), nil
+				}
+			}
+		}
+		// JSON lines.
+		objs = nil
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var o map[string]any
+			if err := dec.Decode(&o); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("want an array of objects or JSON lines: %w", err)
+			}
+			objs = append(objs, o)
+		}
+	}
+	return objectTable(objs), nil
+}
+
+func objectTable(objs []map[string]any) *dataTable {
+	t := &dataTable{}
+	index := map[string]int{}
+	for _, o := range objs {
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if _, ok := index[k]; !ok {
+				index[k] = len(t.cols)
+				t.cols = append(t.cols, k)
+			}
+		}
+	}
+	for _, o := range objs {
+		row := make([]string, len(t.cols))
+		for k, v := range o {
+			switch v := v.(type) {
+			case nil:
+			case string:
+				row[index[k]] = v
+			case float64:
+				row[index[k]] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				b, _ := json.Marshal(v)
+				row[index[k]] = string(b)
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+func (t *dataTable) col(name string) (int, error) {
+	for i, c := range t.cols {
+		if strings.EqualFold(c, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column %q (columns: %s)", name, strings.Join(t.cols, ", "))
+}
+
+// describe is what the model sees of the table: each column with its type
+// and number of empty cells, then a few rows.
+func (t *dataTable) describe(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Table %s: %d rows.\nColumns:\n", name, len(t.rows))
+	for i, c := range t.cols {
+		numeric, empty := true, 0
+		for _, r := range t.rows {
+			if r[i] == "" {
+				empty++
+			} else if _, err := strconv.ParseFloat(r[i], 64); err != nil {
+				numeric = false
+			}
+		}
+		kind := "text"
+		if numeric && empty < len(t.rows) {
+			kind = "number"
+		}
+		fmt.Fprintf(&b, "- %s (%s", c, kind)
+		if empty > 0 {
+			fmt.Fprintf(&b, ", %d empty", empty)
+		}
+		b.WriteString(")\n")
+	}
+	n := min(dataSampleRows, len(t.rows))
+	fmt.Fprintf(&b, "\nFirst %d rows:\n", n)
+	b.WriteString(csvText(t.cols, t.rows[:n]))
+	return b.String()
+}
+
+type dataQuery struct {
+	Where []struct {
+		Column string `json:"column"`
+		Op     string `json:"op"`
+		Value  string `json:"value"`
+	} `json:"where"`
+	GroupBy    []string `json:"group_by"`
+	Aggregates []struct {
+		Fn     string `json:"fn"`
+		Column string `json:"column"`
+	} `json:"aggregates"`
+	Columns []string `json:"columns"`
+	OrderBy string   `json:"order_by"`
+	Desc    bool     `json:"desc"`
+	Limit   int      `json:"limit"`
+}
+
+var dataQuerySchema = json.RawMessage(`{"type":"object","properties":{
+"where":{"type":"array","description":"Conditions rows must all meet","items":{"type":"object","properties":{"column":{"type":"string"},"op":{"type":"string","enum":["=","!=","<","<=",">",">=","contains","empty","not_empty"]},"value":{"type":"string"}},"required":["column","op"]}},
+"group_by":{"type":"array","items":{"type":"string"},"description":"Columns to group by; use with aggregates"},
+"aggregates":{"type":"array","description":"Computed per group, or over all matching rows without group_by. Result columns are named like avg(latency) or count","items":{"type":"object","properties":{"fn":{"type":"string","enum":["count","sum","avg","min","max","median","distinct"]},"column":{"type":"string"}},"required":["fn"]}},
+"columns":{"type":"array","items":{"type":"string"},"description":"Columns to return when not aggregating (default all)"},
+"order_by":{"type":"string","description":"Result column to sort by"},
+"desc":{"type":"boolean"},
+"limit":{"type":"integer","description":"Rows to return (default 50, at most 200)"}}}`)
+
+// run executes a query and returns the result as CSV.
+func (t *dataTable) run(q dataQuery) (string, error) {
+	rows := t.rows
+	for _, w := range q.Where {
+		i, err := t.col(w.Column)
+		if err != nil {
+			return "", err
+		}
+		var kept [][]string
+		for _, r := range rows {
+			ok, err := dataCompare(r[i], w.Op, w.Value)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				kept = append(kept, r)
+			}
+		}
+		rows = kept
+	}
+
+	var cols []string
+	var out [][]string
+	if len(q.GroupBy) > 0 || len(q.Aggregates) > 0 {
+		var err error
+		if cols, out, err = t.aggregate(rows, q); err != nil {
+			return "", err
+		}
+	} else {
+		cols = t.cols
+		idx := make([]int, len(t.cols))
+		for i := range idx {
+			idx[i] = i
+		}
+		if len(q.Columns) > 0 {
+			cols, idx = nil, nil
+			for _, c := range q.Columns {
+				i, err := t.col(c)
+				if err != nil {
+					return "", err
+				}
+				cols, idx = append(cols, t.cols[i]), append(idx, i)
+			}
+		}
+		for _, r := range rows {
+			row := make([]string, len(idx))
+			for j, i := range idx {
+				row[j] = r[i]
+			}
+			out = append(out, row)
+		}
+	}
+
+	if q.OrderBy != "" {
+		i := slices.IndexFunc(cols, func(c string) bool { return strings.EqualFold(c, q.OrderBy) })
+		if i < 0 {
+			return "", fmt.Errorf("can't order by %q (result columns: %s)", q.OrderBy, strings.Join(cols, ", "))
+		}
+		slices.SortStableFunc(out, func(a, b []string) int {
+			c := dataCmp(a[i], b[i])
+			if q.Desc {
+				return -c
+			}
+			return c
+		})
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = dataDefaultLimit
+	}
+	limit = min(limit, dataMaxLimit)
+	res := csvText(cols, out[:min(limit, len(out))])
+	if len(out) > limit {
+		res += fmt.Sprintf("(%d rows, showing the first %d)\n", len(out), limit)
+	} else {
+		res += fmt.Sprintf("(%d rows)\n", len(out))
+	}
+	return res, nil
+}
+
+func (t *dataTable) aggregate(rows [][]string, q dataQuery) ([]string, [][]string, error) {
+	var keyIdx []int
+	cols := []string{}
+	for _, g := range q.GroupBy {
+		i, err := t.col(g)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyIdx = append(keyIdx, i)
+		cols = append(cols, t.cols[i])
+	}
+	aggIdx := make([]int, len(q.Aggregates))
+	for j, a := range q.Aggregates {
+		aggIdx[j] = -1
+		name := a.Fn
+		if a.Column != "" {
+			i, err := t.col(a.Column)
+			if err != nil {
+				return nil, nil, err
+			}
+			aggIdx[j] = i
+			name += "(" + t.cols[i] + ")"
+		} else if a.Fn != "count" {
+			return nil, nil, fmt.Errorf("%s needs a column", a.Fn)
+		}
+		cols = append(cols, name)
+	}
+
+	var order []string
+	groups := map[string][][]string{}
+	for _, r := range rows {
+		parts := make([]string, len(keyIdx))
+		for j, i := range keyIdx {
+			parts[j] = r[i]
+		}
+		k := strings.Join(parts, "\x00")
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], r)
+	}
+	if len(keyIdx) == 0 && len(order) == 0 {
+		order = []string{""}
+	}
+
+	var out [][]string
+	for _, k := range order {
+		g := groups[k]
+		row := []string{}
+		if len(keyIdx) > 0 {
+			row = strings.Split(k, "\x00")
+		}
+		for j, a := range q.Aggregates {
+			v, err := dataAgg(a.Fn, g, aggIdx[j])
+			if err != nil {
+				return nil, nil, err
+			}
+			row = append(row, v)
+		}
+		out = append(out, row)
+	}
+	return cols, out, nil
+}
+
+// dataAgg computes fn over column i of rows; numeric functions skip cells
+// that aren't numbers.
+func dataAgg(fn string, rows [][]string, i int) (string, error) {
+	switch fn {
+	case "count":
+		if i < 0 {
+			return strconv.Itoa(len(rows)), nil
+		}
+		n := 0
+		for _, r := range rows {
+			if r[i] != "" {
+				n++
+			}
+		}
+		return strconv.Itoa(n), nil
+	case "distinct":
+		seen := map[string]bool{}
+		for _, r := range rows {
+			seen[r[i]] = true
+		}
+		return strconv.Itoa(len(seen)), nil
+	}
+
+	var nums []float64
+	for _, r := range rows {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(r[i]), 64); err == nil {
+			nums = append(nums, f)
+		}
+	}
+	if len(nums) == 0 {
+		return "", nil
+	}
+	var v float64
+	switch fn {
+	case "sum", "avg":
+		for _, f := range nums {
+			v += f
+		}
+		if fn == "avg" {
+			v /= float64(len(nums))
+		}
+	case "min":
+		v = slices.Min(nums)
+	case "max":
+		v = slices.Max(nums)
+	case "median":
+		slices.Sort(nums)
+		v = nums[len(nums)/2]
+		if len(nums)%2 == 0 {
+			v = (v + nums[len(nums)/2-1]) / 2
+		}
+	default:
+		return "", fmt.Errorf("unknown aggregate %q", fn)
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', 0, 64), nil
+	}
+	return strconv.FormatFloat(v, 'f', 4, 64), nil
+}
+
+// dataCmp orders two cells, numerically when both are numbers.
+func dataCmp(a, b string) int {
+	fa, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	fb, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if errA == nil && errB == nil {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+func dataCompare(cell, op, value string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return dataCmp(cell, value) == 0 || strings.EqualFold(cell, value), nil
+	case "!=":
+		return dataCmp(cell, value) != 0 && !strings.EqualFold(cell, value), nil
+	case "<":
+		return cell != "" && dataCmp(cell, value) < 0, nil
+	case "<=":
+		return cell != "" && dataCmp(cell, value) <= 0, nil
+	case ">":
+		return dataCmp(cell, value) > 0, nil
+	case ">=":
+		return dataCmp(cell, value) >= 0, nil
+	case "contains":
+		return strings.Contains(strings.ToLower(cell), strings.ToLower(value)), nil
+	case "empty":
+		return strings.TrimSpace(cell) == "", nil
+	case "not_empty":
+		return strings.TrimSpace(cell) != "", nil
+	}
+	return false, fmt.Errorf("unknown op %q", op)
+}
+
+func csvText(cols []string, rows [][]string) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(cols)
+	w.WriteAll(rows)
+	return b.String()
+}
+
+func runData(args []string) {
+	fset := flag.NewFlagSet("data", flag.ExitOnError)
+	showQueries := fset.Bool("v", false, "Print each query the model runs and its result")
+	fset.Parse(args)
+	if fset.NArg() < 2 {
+		log.Fatal("usage: go-chat data [-v] <file.csv|file.json|-> <question>...")
+	}
+
+	name := fset.Arg(0)
+	var raw []byte
+	var err error
+	if name == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(name)
+	}
+	if err != nil {
+		log.Fatalf("data: %v", err)
+	}
+	t, err := loadTable(name, raw)
+	if err != nil {
+		log.Fatalf("data: %s: %v", name, err)
+	}
+
+	registerTool(&Tool{
+		Name:        "query_data",
+		Description: "Filter, group and aggregate the rows of " + filepath.Base(name) + ". Computed locally over every row; returns CSV.",
+		Parameters:  dataQuerySchema,
+		Run: func(args json.RawMessage) (string, error) {
+			var q dataQuery
+			if err := json.Unmarshal(args, &q); err != nil {
+				return "", err
+			}
+			res, err := t.run(q)
+			if *showQueries {
+				fmt.Fprintf(os.Stderr, "query_data %s\n", args)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n\n", err)
+				} else {
+					fmt.Fprintln(os.Stderr, res)
+				}
+			}
+			return res, err
+		},
+	})
+
+	question := strings.Join(fset.Args()[1:], " ")
+	sendChat(prompt("data-analysis") + "\n\n" + t.describe(filepath.Base(name)) + "\nQuestion: " + question)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// -seed N passes a seed with every request, so providers that honour it
+// sample the same way each time. -deterministic goes further for scripted
+// pipelines: every request is sent at temperature 0 with a fixed seed (N,
+// or 1), the day's chat history is left out, and the system prompt a
+// prompt was first answered with is kept in ~/.go-chat-prompt-cache.json
+// and reused, so new memories, the clock or template variables don't
+// change it. Outputs are then reproducible as far as the provider allows.
+const (
+	promptCacheFilePath = ".go-chat-prompt-cache.json"
+	promptCacheMax      = 500
+	defaultSeed         = 1
+)
+
+var (
+	seedFlag      = -1 // unset
+	deterministic bool
+)
+
+// requestSeed returns the seed to send, if any.
+func requestSeed() *int {
+	switch {
+	case seedFlag >= 0:
+		s := seedFlag
+		return &s
+	case deterministic:
+		s := defaultSeed
+		return &s
+	}
+	return nil
+}
+
+type cachedPrompt struct {
+	System string    `json:"system"`
+	Used   time.Time `json:"used"`
+}
+
+func promptCacheFile() string { return filepath.Join(homeDir, promptCacheFilePath) }
+
+// stableSystemPrompt returns the system prompt cached under key, storing
+// system there the first time. The least recently used entries are
+// dropped beyond promptCacheMax.
+func stableSystemPrompt(key, system string) string {
+	if ephemeral {
+		return system
+	}
+	path := promptCacheFile()
+	defer lockFile(path)()
+	cache := map[string]cachedPrompt{}
+	if data, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(data, &cache)
+	}
+	if c, ok := cache[key]; ok {
+		system = c.System
+	}
+	cache[key] = cachedPrompt{System: system, Used: time.Now().UTC()}
+
+	if len(cache) > promptCacheMax {
+		keys := make([]string, 0, len(cache))
+		for k := range cache {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b string) int { return cache[b].Used.Compare(cache[a].Used) })
+		for _, k := range keys[promptCacheMax:] {
+			delete(cache, k)
+		}
+	}
+	if data, err := json.MarshalIndent(cache, "", "  "); err == nil {
+		_ = writeFileAtomic(path, data, 0o600)
+	}
+	return system
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Unified diffs are made by unifiedDiff, with three lines of context, and
+// read back by parsePatch. Files that don't end in a newline are marked
+// the way diff and git do. Beyond diffMaxCells the changed middle of two
+// files is shown replaced whole rather than compared line by line.
+const (
+	diffContext  = 3
+	diffMaxCells = 4 << 20
+)
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns a unified diff from a to b, or "" if they are the
+// same.
+func unifiedDiff(oldName, newName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// oldAt and newAt are the lines of a and b before each op.
+	oldAt, newAt := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		oldAt[k+1], newAt[k+1] = oldAt[k], newAt[k]
+		if op.kind != '+' {
+			oldAt[k+1]++
+		}
+		if op.kind != '-' {
+			newAt[k+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start, end := max(i-diffContext, 0), i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end+1 > 2*diffContext {
+				break
+			}
+		}
+		stop := min(end+diffContext, len(ops))
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldAt[start], oldAt[stop]-oldAt[start]), hunkRange(newAt[start], newAt[stop]-newAt[start]))
+		for _, op := range ops[start:stop] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+func hunkRange(before, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if n == 1 {
+		return fmt.Sprint(before + 1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, n)
+}
+
+// splitLines splits s after each newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines turns a into b with the fewest deletions and insertions, by
+// longest common subsequence.
+func diffLines(a, b []string) []diffOp {
+	var head, tail []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append([]diffOp{{' ', a[len(a)-1]}}, tail...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	ops := head
+	if len(a)*len(b) > diffMaxCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return append(ops, tail...)
+	}
+
+	// lcs[i][j] is the common subsequence length of a[i:] and b[j:].
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i, j = i+1, j+1
+		case lcs[(i+1)*w+j] >= lcs[i*w+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return append(ops, tail...)
+}
+
+// diffStat counts the added and removed lines of a unified diff.
+func diffStat(diff string) (added, removed int) {
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "+++"), strings.HasPrefix(l, "---"):
+		case strings.HasPrefix(l, "+"):
+			added++
+		case strings.HasPrefix(l, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// filePatch is one file's part of a unified diff. An empty name is
+// /dev/null: a created or deleted file.
+type filePatch struct {
+	oldName, newName string
+	hunks            []diffHunk
+}
+
+type diffHunk struct {
+	header   string
+	oldStart int
+	ops      []diffOp
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// parsePatch reads a unified diff. It is lenient about what models get
+// wrong: line counts in hunk headers are ignored, the body running to the
+// next hunk or file, and blank lines are taken as blank context lines.
+func parsePatch(text string) ([]filePatch, error) {
+	lines := splitLines(text)
+	var out []filePatch
+	var fp *filePatch
+	var h *diffHunk
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			out = append(out, filePatch{oldName: patchName(l[4:]), newName: patchName(lines[i+1][4:])})
+			fp, h = &out[len(out)-1], nil
+			i++
+		case strings.HasPrefix(l, "@@"):
+			m := hunkHeader.FindStringSubmatch(l)
+			if fp == nil || m == nil {
+				return nil, fmt.Errorf("line %d: bad hunk header %q", i+1, strings.TrimSpace(l))
+			}
+			start, _ := strconv.Atoi(m[1])
+			fp.hunks = append(fp.hunks, diffHunk{header: strings.TrimSpace(l), oldStart: start})
+			h = &fp.hunks[len(fp.hunks)-1]
+		case h == nil:
+			// Text between files, such as "diff --git" lines.
+		case strings.HasPrefix(l, `\`):
+			if n := len(h.ops); n > 0 {
+				h.ops[n-1].line = strings.TrimSuffix(h.ops[n-1].line, "\n")
+			}
+		case strings.TrimRight(l, "\r\n") == "":
+			h.ops = append(h.ops, diffOp{' ', "\n"})
+		case l[0] == ' ' || l[0] == '-' || l[0] == '+':
+			h.ops = append(h.ops, diffOp{l[0], l[1:]})
+		default:
+			h = nil
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no unified diff found (expected --- and +++ lines)")
+	}
+	return out, nil
+}
+
+// patchName strips a/ or b/ and any timestamp from a file header name.
+func patchName(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	if s == "/dev/null" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(s, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(s, "b/"); ok {
+		return rest
+	}
+	return s
+}
+
+// apply applies the hunks to old. A hunk whose context isn't at the line
+// its header gives is looked for nearby, as patch and git apply do, but
+// must match exactly.
+func (fp filePatch) apply(old string) (string, error) {
+	lines := splitLines(old)
+	var out []string
+	at := 0
+	for n, h := range fp.hunks {
+		var want, repl []string
+		for _, op := range h.ops {
+			if op.kind != '+' {
+				want = append(want, op.line)
+			}
+			if op.kind != '-' {
+				repl = append(repl, op.line)
+			}
+		}
+		pos := -1
+		expect := max(h.oldStart-1, 0)
+		if len(want) == 0 {
+			expect = h.oldStart
+		}
+		for off := 0; pos < 0 && (expect-off >= at || expect+off <= len(lines)); off++ {
+			for _, p := range []int{expect - off, expect + off} {
+				if p >= at && p+len(want) <= len(lines) && slices.Equal(lines[p:p+len(want)], want) {
+					pos = p
+					break
+				}
+			}
+		}
+		if pos < 0 {
+			return "", fmt.Errorf("hunk %d (%s) does not match the file; its context and removed lines must be copied exactly:\n%s",
+				n+1, h.header, strings.Join(want, ""))
+		}
+		out = append(append(out, lines[at:pos]...), repl...)
+		at = pos + len(want)
+	}
+	return strings.Join(append(out, lines[at:]...), ""), nil
+}
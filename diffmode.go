@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// -diff a.go,b.go sends the named files with the prompt and has the model
+// answer only with a unified diff against them. The diff is applied to
+// the files in memory; if it doesn't apply, the errors go back to the model
+// for another attempt, up to -fix-rounds. The applied result is printed as
+// a clean diff with a/ and b/ names, after `git apply --check` has accepted
+// it, so it can be piped straight to `git apply`. Nothing is written.
+var diffFiles string
+
+// diffTargets reads the files given to -diff.
+func diffTargets() (map[string]string, error) {
+	files := map[string]string{}
+	ig := newIgnorer(getConfig())
+	for _, name := range strings.Split(diffFiles, ",") {
+		name = filepath.ToSlash(filepath.Clean(strings.TrimSpace(name)))
+		if name == "." {
+			continue
+		}
+		if abs, err := filepath.Abs(name); err == nil && ig.excluded(abs) {
+			return nil, fmt.Errorf("%s is excluded by ignore rules; not sending it", name)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if !isText(data) {
+			return nil, fmt.Errorf("%s is not a text file", name)
+		}
+		files[name] = string(data)
+	}
+	return files, nil
+}
+
+// diffPrompt is the prompt with the files it is about.
+func diffPrompt(userPrompt string, files map[string]string) string {
+	var b strings.Builder
+	b.WriteString(prompt("diff-format") + "\n\nRequest: " + userPrompt + "\n")
+	for _, name := range sortedKeys(files) {
+		fmt.Fprintf(&b, "\nFile %s:\n%s", name, fenced("", files[name]))
+	}
+	return b.String()
+}
+
+// reviseDiff returns the model's diff, corrected until it applies and
+// rewritten cleanly, or the last attempt with a warning if it never does.
+func reviseDiff(userPrompt, answer string, files map[string]string, rounds int) string {
+	for round := 1; ; round++ {
+		diff, problems := checkDiff(answer, files)
+		if problems == "" {
+			return diff
+		}
+		if round >= rounds {
+			fmt.Fprintf(os.Stderr, "diff: still not applying after %d attempts:\n%s\n", rounds, problems)
+			return patchText(answer)
+		}
+		fmt.Fprintf(os.Stderr, "diff: attempt %d doesn't apply, revising...\n", round)
+
+		msgs := []Message{
+			{Role: "user", Content: userPrompt},
+			{Role: "assistant", Content: answer},
+			{Role: "user", Content: "That diff doesn't apply:\n" + problems + "\nReply with the complete corrected diff in one ```diff block."},
+		}
+		reply, err := queryGPTStream(modelExec, prompt("diff-format"), 0.2, 4096, msgs, nil)
+		if err != nil {
+			log.Printf("diff: %v", err)
+			return patchText(answer)
+		}
+		answer = reply
+	}
+}
+
+// checkDiff applies the diff in answer to files and returns it rewritten
+// from the result, or what is wrong with it.
+func checkDiff(answer string, files map[string]string) (string, string) {
+	patches, err := parsePatch(patchText(answer))
+	if err != nil {
+		return "", err.Error()
+	}
+	var out strings.Builder
+	var problems []string
+	for _, fp := range patches {
+		name := cmp.Or(fp.oldName, fp.newName)
+		old, given := files[name]
+		if fp.oldName != "" && !given {
+			problems = append(problems, fmt.Sprintf("%s: not one of the files given (%s)", name, strings.Join(sortedKeys(files), ", ")))
+			continue
+		}
+		if fp.oldName == "" && given {
+			problems = append(problems, name+": already exists; diff against it instead of creating it")
+			continue
+		}
+		now, err := fp.apply(old)
+		if err != nil {
+			problems = append(problems, name+": "+err.Error())
+			continue
+		}
+		oldName, newName := "a/"+name, "b/"+name
+		if fp.oldName == "" {
+			oldName = "/dev/null"
+		}
+		if fp.newName == "" {
+			newName, now = "/dev/null", ""
+		}
+		out.WriteString(unifiedDiff(oldName, newName, old, now))
+	}
+	if len(problems) > 0 {
+		return "", strings.Join(problems, "\n")
+	}
+	if out.Len() == 0 {
+		return "", "the diff changes nothing"
+	}
+	if err := gitApplyCheck(out.String()); err != nil {
+		return "", err.Error()
+	}
+	return out.String(), ""
+}
+
+// gitApplyCheck runs the diff through `git apply --check` in the working
+// directory. Without git it only warns.
+func gitApplyCheck(diff string) error {
+	cmd := exec.Command("git", "apply", "--check", "-")
+	cmd.Stdin = strings.NewReader(diff)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		warnOnce("git apply", "diff: git not found; skipping git apply --check")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("git apply --check: %s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// patchText is the diff in an answer: its ```diff or ```patch block, or
+// the answer itself.
+func patchText(answer string) string {
+	for _, b := range codeBlocks(answer) {
+		if b.lang == "diff" || b.lang == "patch" {
+			return b.code
+		}
+	}
+	return answer
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// The daemon only speaks up inside the active hours and days in the
+// config, in the user's time zone,
+//
+//	"active_hours": "09:00-18:00", "active_days": "mon-fri"
+//
+// and not at all while do-not-disturb is on: `go-chat dnd 2h` (or `dnd
+// 17:30`) holds it for a while, `dnd off` ends that early and `dnd` alone
+// says when it will next speak. Hours may wrap past midnight
+// ("22:00-07:00"), counting on the day they start; days take cron's syntax
+// (see cron.go). A check-in that falls due while the daemon is quiet is
+// made once it may speak again, not once for every one missed.
+const dndSearch = 8 * 24 * time.Hour // how far ahead quietUntil looks
+
+// activeWindow is the parsed "active_hours" and "active_days"; minutes are
+// from midnight, and from == to means all day.
+type activeWindow struct {
+	from, to int
+	days     uint64 // bit n set: weekday n is active
+}
+
+// activeWindowOf parses the config's active hours and days, warning once
+// and ignoring them if they are malformed.
+func activeWindowOf(cfg Config) activeWindow {
+	w := activeWindow{days: 0x7f}
+	if cfg.ActiveHours != "" {
+		from, to, ok := strings.Cut(cfg.ActiveHours, "-")
+		f, err1 := parseClock(from)
+		t, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil {
+			warnOnce("active_hours", `active_hours: want "09:00-18:00", got %q; ignoring it`, cfg.ActiveHours)
+		} else {
+			w.from, w.to = f, t
+		}
+	}
+	if cfg.ActiveDays != "" {
+		days, err := cronField(strings.ToLower(cfg.ActiveDays), 0, 7, cronDays)
+		if err != nil {
+			warnOnce("active_days", `active_days: %v; ignoring it`, err)
+		} else {
+			if days&(1<<7) != 0 {
+				days |= 1 // 7 is Sunday too
+			}
+			w.days = days & 0x7f
+		}
+	}
+	return w
+}
+
+// parseClock reads a time of day as minutes from midnight.
+func parseClock(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, layout := range clockLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour()*60 + t.Minute(), nil
+		}
+	}
+	return 0, fmt.Errorf("can't read the time %q", s)
+}
+
+// active says whether t is inside the window.
+func (w activeWindow) active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t
+	switch {
+	case w.from == w.to:
+	case w.from < w.to:
+		if m < w.from || m >= w.to {
+			return false
+		}
+	case m < w.to:
+		day = t.AddDate(0, 0, -1) // the small hours of a window that began yesterday
+	case m < w.from:
+		return false
+	}
+	return w.days&(1<<int(day.Weekday())) != 0
+}
+
+// quietReason says why the daemon must keep quiet at t, or "" if it may
+// speak.
+func quietReason(t time.Time, cfg Config, st AppState) string {
+	if t.Before(st.DoNotDisturb) {
+		return "do not disturb"
+	}
+	if !activeWindowOf(cfg).active(t.In(userLocation(cfg))) {
+		return "outside active hours"
+	}
+	return ""
+}
+
+// quietUntil returns when the daemon may next speak: t itself if it may
+// now, or the zero time if it won't within dndSearch (no active days).
+func quietUntil(t time.Time, cfg Config, st AppState) time.Time {
+	if quietReason(t, cfg, st) == "" {
+		return t
+	}
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	if next.Before(st.DoNotDisturb) {
+		next = st.DoNotDisturb
+	}
+	for limit := t.Add(dndSearch); next.Before(limit); next = next.Add(time.Minute) {
+		if quietReason(next, cfg, st) == "" {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// runDND sets, ends or shows do-not-disturb.
+func runDND(args []string) {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat dnd [2h | 17:30 | off]")
+		os.Exit(2)
+	}
+	cfg := getConfig()
+	loc := userLocation(cfg)
+	if len(args) == 1 {
+		unlock := lockFile(stateFilePath)
+		st := getState()
+		switch arg := strings.ToLower(args[0]); arg {
+		case "off":
+			st.DoNotDisturb = time.Time{}
+		default:
+			until, err := dndUntil(arg, loc)
+			if err != nil {
+				unlock()
+				log.Fatalf("dnd: %v", err)
+			}
+			st.DoNotDisturb = until
+		}
+		saveState(st)
+		unlock()
+	}
+
+	st := getState()
+	now := time.Now()
+	if now.Before(st.DoNotDisturb) {
+		fmt.Printf("do not disturb until %s\n", st.DoNotDisturb.In(loc).Format("Mon 15:04"))
+	} else {
+		fmt.Println("do not disturb is off")
+	}
+	switch next := quietUntil(now, cfg, st); {
+	case next.IsZero():
+		fmt.Println("the daemon won't speak: no active days")
+	case next.After(now):
+		fmt.Printf("the daemon is quiet (%s) until %s\n", quietReason(now, cfg, st), next.In(loc).Format("Mon 15:04"))
+	}
+}
+
+// dndUntil reads a dnd argument: a duration, or a time of day (tomorrow's
+// if today's has passed).
+func dndUntil(arg string, loc *time.Location) (time.Time, error) {
+	if d, err := time.ParseDuration(arg); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("%s is not in the future", arg)
+		}
+		return time.Now().Add(d), nil
+	}
+	m, err := parseClock(arg)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want a duration (2h, 45m), a time (17:30, 5pm) or off, got %q", arg)
+	}
+	now := time.Now().In(loc)
+	t := time.Date(now.Year(), now.Month(), now.Day(), m/60, m%60, 0, 0, loc)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// `go-chat edit ./src` is a conversation about changing a file or a tree.
+// The text files under the target (skipping hidden, ignored, binary and
+// oversized ones, as the index does) are copied to a temporary workspace,
+// and the model reads and edits that copy through tools over as many
+// turns as it takes. /diff shows everything changed so far as one unified
+// diff, and only /apply writes it to the real files; a file that changed
+// on disk since it was copied is left alone. The workspace is removed on
+// exit.
+type editSession struct {
+	root  string            // the directory the workspace mirrors
+	box   string            // the workspace
+	files []string          // with a file target, the only file copied
+	base  map[string]string // the copied files as they are in root, by path
+	model string
+	msgs  []Message
+}
+
+// editChange is one file that differs between the workspace and base.
+type editChange struct {
+	path          string
+	old, now      string
+	inBase, inBox bool
+}
+
+func runEdit(args []string) {
+	fset := flag.NewFlagSet("edit", flag.ExitOnError)
+	model := fset.String("model", modelExec, "Model to edit with")
+	yes := fset.Bool("y", false, "Apply without asking")
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		log.Fatal("usage: go-chat edit [-model M] [-y] <dir|file>")
+	}
+
+	target, err := filepath.Abs(fset.Arg(0))
+	if err != nil {
+		log.Fatalf("edit: %v", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		log.Fatalf("edit: %v", err)
+	}
+	s := &editSession{root: target, model: *model}
+	if !info.IsDir() {
+		s.root, s.files = filepath.Dir(target), []string{filepath.Base(target)}
+	}
+	if s.box, err = os.MkdirTemp("", "go-chat-edit-"); err != nil {
+		log.Fatalf("edit: %v", err)
+	}
+	defer os.RemoveAll(s.box)
+	if err := s.copyIn(); err != nil {
+		log.Fatalf("edit: %v", err)
+	}
+	if len(s.base) == 0 {
+		log.Fatalf("edit: no text files to edit in %s", fset.Arg(0))
+	}
+	s.registerTools()
+
+	fmt.Printf("Editing a copy of %s (%d files). Describe a change; /diff shows the changes, /apply writes them, /reset drops them, /quit leaves.\n",
+		tildePath(target), len(s.base))
+	for {
+		fmt.Print("edit> ")
+		line, readErr := stdin.ReadString('\n')
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+		case "/diff":
+			if d := s.diff(); d != "" {
+				fmt.Print(d)
+			} else {
+				fmt.Println("no changes")
+			}
+		case "/apply":
+			s.apply(*yes)
+		case "/reset":
+			if err := s.copyIn(); err != nil {
+				log.Fatalf("edit: %v", err)
+			}
+			fmt.Println("workspace reset")
+		case "/quit", "exit":
+			if s.leave() {
+				return
+			}
+		default:
+			if err := s.ask(line); err != nil {
+				fmt.Printf("error: %v\n", err)
+			}
+		}
+		if readErr != nil {
+			if n := len(s.changes()); n > 0 {
+				fmt.Printf("\n(leaving %d changed files unapplied)\n", n)
+			}
+			return
+		}
+	}
+}
+
+// copyIn fills the workspace from root, dropping any changes.
+func (s *editSession) copyIn() error {
+	if err := os.RemoveAll(s.box); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.box, 0o700); err != nil {
+		return err
+	}
+	s.base = map[string]string{}
+	for path, text := range s.read(s.root, s.files, newIgnorer(getConfig())) {
+		if err := os.MkdirAll(filepath.Join(s.box, filepath.Dir(path)), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(s.box, path), []byte(text), 0o644); err != nil {
+			return err
+		}
+		s.base[path] = text
+	}
+	return nil
+}
+
+// read returns the text files under dir by relative path: files if it is
+// set, else all of them that the ignore rules allow.
+func (s *editSession) read(dir string, files []string, ig *ignorer) map[string]string {
+	out := map[string]string{}
+	add := func(path string) {
+		if info, err := os.Stat(path); err != nil || info.Size() > maxIndexFileSize {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || !isText(data) {
+			return
+		}
+		rel, _ := filepath.Rel(dir, path)
+		out[filepath.ToSlash(rel)] = string(data)
+	}
+	if files != nil {
+		for _, f := range files {
+			add(filepath.Join(dir, f))
+		}
+		return out
+	}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || (ig != nil && ig.ignored(path, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			add(path)
+		}
+		return nil
+	})
+	return out
+}
+
+// changes lists the files that differ between the workspace and base.
+func (s *editSession) changes() []editChange {
+	now := s.read(s.box, nil, nil)
+	var out []editChange
+	for path, old := range s.base {
+		if text, ok := now[path]; !ok || text != old {
+			out = append(out, editChange{path: path, old: old, now: text, inBase: true, inBox: ok})
+		}
+	}
+	for path, text := range now {
+		if _, ok := s.base[path]; !ok {
+			out = append(out, editChange{path: path, now: text, inBox: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].path < out[j].path })
+	return out
+}
+
+func (c editChange) diff() string {
+	oldName, newName := "a/"+c.path, "b/"+c.path
+	if !c.inBase {
+		oldName = "/dev/null"
+	}
+	if !c.inBox {
+		newName = "/dev/null"
+	}
+	return unifiedDiff(oldName, newName, c.old, c.now)
+}
+
+// diff is every change in the workspace as one unified diff.
+func (s *editSession) diff() string {
+	var b strings.Builder
+	for _, c := range s.changes() {
+		b.WriteString(c.diff())
+	}
+	return b.String()
+}
+
+// summary lists the changed files with their line counts.
+func (s *editSession) summary(cs []editChange) string {
+	var b strings.Builder
+	for _, c := range cs {
+		added, removed := diffStat(c.diff())
+		state := ""
+		switch {
+		case !c.inBase:
+			state = " (new)"
+		case !c.inBox:
+			state = " (deleted)"
+		}
+		fmt.Fprintf(&b, "  %s%s +%d -%d\n", c.path, state, added, removed)
+	}
+	return b.String()
+}
+
+// ask sends one request and reports what it changed.
+func (s *editSession) ask(request string) error {
+	before := s.diff()
+	var files []string
+	for path := range s.read(s.box, nil, nil) {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	system := prompt("edit-session") + "\n\nFiles in the workspace:\n" + strings.Join(files, "\n")
+
+	s.msgs = append(s.msgs, Message{Role: "user", Content: request})
+	tw := termWrapper()
+	answer, err := queryGPTWith(s.model, system, 0.2, 4096, s.msgs, tw.wrap(printToken), queryParams{})
+	if err != nil {
+		s.msgs = s.msgs[:len(s.msgs)-1]
+		return err
+	}
+	tw.finish(answer)
+	if !strings.HasSuffix(answer, "\n") {
+		fmt.Println()
+	}
+	s.msgs = append(s.msgs, Message{Role: "assistant", Content: answer})
+	if err := appendLog(ChatLog{Request: "edit: " + request, Response: answer, Namespace: storedNamespace(activeNamespace)}); err != nil {
+		log.Printf("log: %v", err)
+	}
+
+	if s.diff() != before {
+		fmt.Printf("\nChanged so far (/diff to review, /apply to write):\n%s", s.summary(s.changes()))
+	}
+	return nil
+}
+
+// apply writes the workspace's changes to root, skipping files that
+// changed there since they were copied.
+func (s *editSession) apply(yes bool) {
+	cs := s.changes()
+	if len(cs) == 0 {
+		fmt.Println("no changes")
+		return
+	}
+	fmt.Print(s.summary(cs))
+	if !yes && !confirm(fmt.Sprintf("Write %d files to %s?", len(cs), tildePath(s.root))) {
+		return
+	}
+	applied := 0
+	for _, c := range cs {
+		real := filepath.Join(s.root, filepath.FromSlash(c.path))
+		data, err := os.ReadFile(real)
+		switch {
+		case err == nil && (!c.inBase || string(data) != c.old),
+			err != nil && c.inBase:
+			fmt.Printf("  skipped %s: it changed on disk since the session started\n", c.path)
+			continue
+		case err != nil && !errors.Is(err, fs.ErrNotExist):
+			fmt.Printf("  skipped %s: %v\n", c.path, err)
+			continue
+		}
+
+		if !c.inBox {
+			err = os.Remove(real)
+		} else {
+			perm := os.FileMode(0o644)
+			if info, statErr := os.Stat(real); statErr == nil {
+				perm = info.Mode().Perm()
+			}
+			if err = os.MkdirAll(filepath.Dir(real), 0o755); err == nil {
+				err = writeFileAtomic(real, []byte(c.now), perm)
+			}
+		}
+		if err != nil {
+			fmt.Printf("  failed %s: %v\n", c.path, err)
+			continue
+		}
+		if c.inBox {
+			s.base[c.path] = c.now
+		} else {
+			delete(s.base, c.path)
+		}
+		applied++
+	}
+	fmt.Printf("applied %d of %d files\n", applied, len(cs))
+}
+
+// leave says whether the session may end, asking if changes would be lost.
+func (s *editSession) leave() bool {
+	n := len(s.changes())
+	return n == 0 || confirm(fmt.Sprintf("Leave without applying %d changed files?", n))
+}
+
+// registerTools gives the model the task tools for reading and writing
+// files, working in the workspace, and ways to edit and delete files.
+func (s *editSession) registerTools() {
+	t := &taskRun{root: s.box}
+	for name, tool := range t.builtinTools() {
+		if name == "run_command" {
+			continue
+		}
+		tool.Description = strings.ReplaceAll(tool.Description, "working directory", "workspace")
+		registerTool(s.announce(tool))
+	}
+
+	type editArgs struct {
+		Path string `json:"path"`
+		Old  string `json:"old"`
+		New  string `json:"new"`
+	}
+	registerTool(s.announce(&Tool{
+		Name:        "edit_file",
+		Description: "Replace the one occurrence of old in a workspace file with new. old must match exactly, including whitespace; include enough lines to make it unique.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string"},"old":{"type":"string"},"new":{"type":"string"}},"required":["path","old","new"]}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a editArgs
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			p, err := t.path(a.Path)
+			if err != nil {
+				return "", err
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return "", err
+			}
+			switch n := strings.Count(string(data), a.Old); {
+			case a.Old == "" || n == 0:
+				return "", fmt.Errorf("old text not found in %s", a.Path)
+			case n > 1:
+				return "", fmt.Errorf("old text occurs %d times in %s; include more lines", n, a.Path)
+			}
+			if err := os.WriteFile(p, []byte(strings.Replace(string(data), a.Old, a.New, 1)), 0o644); err != nil {
+				return "", err
+			}
+			return "edited " + a.Path, nil
+		},
+	}))
+	registerTool(s.announce(&Tool{
+		Name:        "delete_file",
+		Description: "Delete a file from the workspace.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string"}},"required":["path"]}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a editArgs
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			p, err := t.path(a.Path)
+			if err != nil {
+				return "", err
+			}
+			if err := os.Remove(p); err != nil {
+				return "", err
+			}
+			return "deleted " + a.Path, nil
+		},
+	}))
+}
+
+// announce makes tool print what it touches as it runs.
+func (s *editSession) announce(tool *Tool) *Tool {
+	run := tool.Run
+	tool.Run = func(raw json.RawMessage) (string, error) {
+		var a struct {
+			Path string `json:"path"`
+		}
+		_ = json.Unmarshal(raw, &a)
+		fmt.Fprintf(os.Stderr, "  %s %s\n", tool.Name, a.Path)
+		return run(raw)
+	}
+	return tool
+}
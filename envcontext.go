@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With "env_context": true in the config, or -env for one prompt, the
+// system prompt says where go-chat is running: the working directory, OS,
+// shell, git branch and status, and the last command that failed in the
+// shell, so "why did that fail?" can be answered without pasting anything.
+// The failed command comes from the shell hook,
+//
+//	eval "$(go-chat shell-hook bash)"   # in ~/.bashrc; zsh in ~/.zshrc
+//
+// which writes the exit status, directory and time of each failing
+// command to ~/.go-chat-lastfail. It's left out once it's an hour old.
+const (
+	lastFailMaxAge    = time.Hour
+	envStatusLines    = 20 // git status lines shown
+	envCommandTimeout = 2 * time.Second
+)
+
+var (
+	envContextFlag   bool // -env
+	lastFailFilePath string
+)
+
+// envContext returns the environment section of the system prompt, or ""
+// when it's off.
+func envContext(cfg Config) string {
+	if !cfg.EnvContext && !envContextFlag {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("The user's environment:")
+	if wd, err := os.Getwd(); err == nil {
+		fmt.Fprintf(&b, "\n- working directory: %s", wd)
+	}
+	fmt.Fprintf(&b, "\n- OS: %s", osDescription())
+	if sh := os.Getenv("SHELL"); sh != "" {
+		fmt.Fprintf(&b, "\n- shell: %s", filepath.Base(sh))
+	}
+	if status, ok := gitStatusShort(); ok {
+		branch, rest, _ := strings.Cut(status, "\n")
+		fmt.Fprintf(&b, "\n- git: %s", strings.TrimPrefix(branch, "## "))
+		if rest == "" {
+			b.WriteString(", clean")
+		} else {
+			lines := strings.Split(rest, "\n")
+			if len(lines) > envStatusLines {
+				lines = append(lines[:envStatusLines], fmt.Sprintf("… and %d more", len(lines)-envStatusLines))
+			}
+			b.WriteString(", changes:\n    " + strings.Join(lines, "\n    "))
+		}
+	}
+	if f, ok := lastFailedCommand(); ok {
+		fmt.Fprintf(&b, "\n- last failed command (exit status %d, %s ago, in %s): %s",
+			f.status, time.Since(f.at).Round(time.Second), f.dir, f.command)
+	}
+	return b.String()
+}
+
+// osDescription is GOOS/GOARCH, with the distribution's name on Linux.
+func osDescription() string {
+	desc := runtime.GOOS + "/" + runtime.GOARCH
+	if runtime.GOOS != "linux" {
+		return desc
+	}
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return desc
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+			return desc + " (" + strings.Trim(v, `"'`) + ")"
+		}
+	}
+	return desc
+}
+
+// gitStatusShort runs git status --short --branch in the working
+// directory; ok is false outside a repository.
+func gitStatusShort() (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), envCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", "status", "--short", "--branch").Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimRight(string(out), "\n"), true
+}
+
+type failedCommand struct {
+	at      time.Time
+	status  int
+	dir     string
+	command string
+}
+
+// lastFailedCommand reads what the shell hook recorded, if it's recent.
+func lastFailedCommand() (failedCommand, bool) {
+	data, err := os.ReadFile(lastFailFilePath)
+	if err != nil {
+		return failedCommand{}, false
+	}
+	fields := strings.SplitN(strings.TrimRight(string(data), "\n"), "\t", 4)
+	if len(fields) != 4 {
+		return failedCommand{}, false
+	}
+	secs, err1 := strconv.ParseInt(fields[0], 10, 64)
+	status, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil {
+		return failedCommand{}, false
+	}
+	f := failedCommand{time.Unix(secs, 0), status, fields[2], strings.TrimSpace(fields[3])}
+	if time.Since(f.at) > lastFailMaxAge || f.command == "" {
+		return failedCommand{}, false
+	}
+	return f, true
+}
+
+// runShellHook prints the hook for a shell, to be eval'd by its rc file.
+func runShellHook(args []string) {
+	if len(args) != 1 || (args[0] != "bash" && args[0] != "zsh") {
+		fmt.Fprintln(os.Stderr, `usage: go-chat shell-hook bash|zsh   (add eval "$(go-chat shell-hook bash)" to ~/.bashrc)`)
+		os.Exit(2)
+	}
+	fmt.Println(asset("shell/hook." + args[0]))
+}
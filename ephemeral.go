@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// -ephemeral keeps a session off the disk, for sensitive one-off
+// questions: its turns are held in memory as the history for the rest of
+// the session and then forgotten. Nothing is written to the chat log, no
+// memories or day summaries are saved, and bookmarks and snippets are
+// refused. Memories already stored are still read.
+var (
+	ephemeral    bool
+	ephemeralLog []ChatLog
+)
+
+const ephemeralTag = "[ephemeral] "
+
+func ephemeralNotice() {
+	fmt.Fprintln(os.Stderr, "ephemeral session: nothing is logged or remembered")
+}
+
+// keepEphemeral holds a log entry in memory instead of writing it.
+func keepEphemeral(entry ChatLog) {
+	entry.Timestamp = time.Now().UTC()
+	ephemeralLog = append(ephemeralLog, entry)
+}
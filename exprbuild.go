@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// `go-chat regex "ISO dates but not times"` and `go-chat jq "all user ids"
+// -f sample.json` build an expression from a description and test it here
+// before showing it. A regex (Go/RE2 syntax) is checked against the test
+// strings the model proposes, the lines of -f labelled by it, and any
+// -match and -no-match strings given, which win over the model's labels.
+// A jq filter is run with the local jq on the -f sample and its output
+// compared with what the model expected. Failures go back to the model
+// until the tests pass or -rounds attempts are used up.
+const (
+	defaultExprRounds = 4
+	exprSampleChars   = 6000 // of a sample sent to the model
+)
+
+// stringsFlag collects a repeated string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string     { return strings.Join(*s, ", ") }
+func (s *stringsFlag) Set(v string) error { *s = append(*s, v); return nil }
+
+// parseInterspersed parses flags given before, after or between the
+// arguments, returning the arguments.
+func parseInterspersed(fset *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fset.Parse(args)
+		args = fset.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest, args = append(rest, args[0]), args[1:]
+	}
+}
+
+// buildExpression asks for an expression until check passes it, and
+// returns the last reply and whether it passed.
+func buildExpression(system, request string, rounds int, check func(reply string) (problems string)) (string, bool) {
+	msgs := []Message{{Role: "user", Content: request}}
+	for round := 1; ; round++ {
+		reply, err := queryGPTStream(modelExec, system, 0.2, 2048, msgs, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		problems := check(reply)
+		if problems == "" {
+			return reply, true
+		}
+		if round >= rounds {
+			fmt.Fprintf(os.Stderr, "still failing after %d attempts:\n%s\n", rounds, problems)
+			return reply, false
+		}
+		fmt.Fprintf(os.Stderr, "attempt %d failed its tests, revising...\n", round)
+		msgs = append(msgs,
+			Message{Role: "assistant", Content: reply},
+			Message{Role: "user", Content: "That fails:\n" + problems + "\nReply with the corrected JSON."})
+	}
+}
+
+// replyJSON decodes the JSON object in a reply into v.
+func replyJSON(reply string, v any) error {
+	i, j := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if i < 0 || j < i {
+		return errors.New("no JSON in reply")
+	}
+	return json.Unmarshal([]byte(reply[i:j+1]), v)
+}
+
+type regexTest struct {
+	Input string `json:"input"`
+	Match bool   `json:"match"`
+}
+
+func runRegex(args []string) {
+	fset := flag.NewFlagSet("regex", flag.ExitOnError)
+	var match, noMatch stringsFlag
+	fset.Var(&match, "match", "A string the expression must match (repeatable)")
+	fset.Var(&noMatch, "no-match", "A string it must not match (repeatable)")
+	samples := fset.String("f", "", "File of sample lines to test against")
+	rounds := fset.Int("rounds", defaultExprRounds, "Attempts before giving up")
+	desc := strings.Join(parseInterspersed(fset, args), " ")
+	if strings.TrimSpace(desc) == "" {
+		log.Fatal(`usage: go-chat regex [-match S]... [-no-match S]... [-f samples] [-rounds N] "what to match"`)
+	}
+
+	given := map[string]bool{}
+	for _, s := range match {
+		given[s] = true
+	}
+	for _, s := range noMatch {
+		given[s] = false
+	}
+	var lines []string
+	if *samples != "" {
+		data, err := os.ReadFile(*samples)
+		if err != nil {
+			log.Fatalf("regex: %v", err)
+		}
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+
+	var b strings.Builder
+	b.WriteString("Match: " + desc + "\n")
+	for _, s := range match {
+		b.WriteString("\nMust match: " + s)
+	}
+	for _, s := range noMatch {
+		b.WriteString("\nMust not match: " + s)
+	}
+	if len(lines) > 0 {
+		b.WriteString("\n\nSample lines; add each one to tests with whether it should match:\n" + fenced("", truncateRunes(strings.Join(lines, "\n"), exprSampleChars)))
+	}
+
+	var res struct {
+		Pattern     string      `json:"pattern"`
+		Explanation string      `json:"explanation"`
+		Tests       []regexTest `json:"tests"`
+	}
+	var re *regexp.Regexp
+	reply, ok := buildExpression(prompt("regex-builder"), b.String(), *rounds, func(reply string) string {
+		res.Tests, re = nil, nil
+		if err := replyJSON(reply, &res); err != nil {
+			return err.Error()
+		}
+		var err error
+		if re, err = regexp.Compile(res.Pattern); err != nil {
+			return "the pattern doesn't compile as a Go (RE2) regexp: " + err.Error()
+		}
+		res.Tests = regexTests(res.Tests, given)
+		var failed []string
+		for _, t := range res.Tests {
+			if re.MatchString(t.Input) != t.Match {
+				want := "should match but doesn't"
+				if !t.Match {
+					want = "matches but shouldn't"
+				}
+				failed = append(failed, fmt.Sprintf("%q %s", t.Input, want))
+			}
+		}
+		return strings.Join(failed, "\n")
+	})
+	if re == nil {
+		log.Fatalf("regex: no usable pattern in the reply:\n%s", reply)
+	}
+
+	fmt.Println(res.Pattern)
+	if res.Explanation != "" {
+		fmt.Printf("\n%s\n", res.Explanation)
+	}
+	fmt.Println()
+	for _, t := range res.Tests {
+		status := "ok  "
+		if re.MatchString(t.Input) != t.Match {
+			status = "FAIL"
+		}
+		want := "match   "
+		if !t.Match {
+			want = "no match"
+		}
+		fmt.Printf("  %s %s  %s\n", status, want, t.Input)
+	}
+	logExpression("regex: "+desc, res.Pattern, ok)
+}
+
+// regexTests puts the given strings' labels over the model's tests and
+// adds the ones it left out.
+func regexTests(tests []regexTest, given map[string]bool) []regexTest {
+	seen := map[string]bool{}
+	var out []regexTest
+	for _, t := range tests {
+		if m, ok := given[t.Input]; ok {
+			t.Match = m
+		}
+		if !seen[t.Input] {
+			seen[t.Input] = true
+			out = append(out, t)
+		}
+	}
+	for s, m := range given {
+		if !seen[s] {
+			out = append(out, regexTest{Input: s, Match: m})
+		}
+	}
+	return out
+}
+
+func runJQ(args []string) {
+	fset := flag.NewFlagSet("jq", flag.ExitOnError)
+	sample := fset.String("f", "", "Sample JSON file to test the filter on")
+	rounds := fset.Int("rounds", defaultExprRounds, "Attempts before giving up")
+	desc := strings.Join(parseInterspersed(fset, args), " ")
+	if strings.TrimSpace(desc) == "" || *sample == "" {
+		log.Fatal(`usage: go-chat jq -f sample.json [-rounds N] "what to extract"`)
+	}
+	if _, err := exec.LookPath("jq"); err != nil {
+		log.Fatal("jq: jq is not installed; it is needed to test the filter")
+	}
+	data, err := os.ReadFile(*sample)
+	if err != nil {
+		log.Fatalf("jq: %v", err)
+	}
+	shown := truncateRunes(string(data), exprSampleChars)
+	whole := shown == string(data)
+	note := ""
+	if !whole {
+		note = " (cut short; set expected to null)"
+	}
+	request := fmt.Sprintf("Filter: %s\n\nSample input%s:\n%s", desc, note, fenced("json", shown))
+
+	var res struct {
+		Filter      string            `json:"filter"`
+		Explanation string            `json:"explanation"`
+		Expected    []json.RawMessage `json:"expected"`
+	}
+	var output string
+	reply, ok := buildExpression(prompt("jq-builder"), request, *rounds, func(reply string) string {
+		res.Expected, output = nil, ""
+		if err := replyJSON(reply, &res); err != nil {
+			return err.Error()
+		}
+		if output, err = runJQFilter(res.Filter, *sample); err != nil {
+			return err.Error()
+		}
+		if onlyNulls(output) {
+			return "the filter runs but produces nothing on the sample"
+		}
+		if whole && res.Expected != nil && !sameJSON(output, res.Expected) {
+			return "on the sample it outputs:\n" + truncateRunes(output, 2000) + "\nwhich is not what you expected; fix the filter if it is wrong, or the expected values if they are"
+		}
+		return ""
+	})
+	if res.Filter == "" {
+		log.Fatalf("jq: no filter in the reply:\n%s", reply)
+	}
+
+	fmt.Println(res.Filter)
+	if res.Explanation != "" {
+		fmt.Printf("\n%s\n", res.Explanation)
+	}
+	if output != "" {
+		fmt.Printf("\nOn %s:\n%s", *sample, truncateRunes(output, 2000))
+	}
+	logExpression("jq: "+desc, res.Filter, ok)
+}
+
+func runJQFilter(filter, file string) (string, error) {
+	cmd := exec.Command("jq", "-c", filter, file)
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("jq fails: %s", strings.TrimSpace(cmp.Or(stderr.String(), err.Error())))
+	}
+	return out.String(), nil
+}
+
+func onlyNulls(output string) bool {
+	for _, l := range strings.Split(output, "\n") {
+		if l != "" && l != "null" {
+			return false
+		}
+	}
+	return true
+}
+
+// sameJSON reports whether jq's output, one value per line, is the list
+// of expected values.
+func sameJSON(output string, expected []json.RawMessage) bool {
+	var got, want []any
+	dec := json.NewDecoder(strings.NewReader(output))
+	for dec.More() {
+		var v any
+		if dec.Decode(&v) != nil {
+			return false
+		}
+		got = append(got, v)
+	}
+	for _, raw := range expected {
+		var v any
+		if json.Unmarshal(raw, &v) != nil {
+			return false
+		}
+		want = append(want, v)
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func logExpression(request, expr string, passed bool) {
+	if !passed {
+		expr += "\n\n(failed its tests)"
+	}
+	if err := appendLog(ChatLog{Request: request, Response: expr, Namespace: storedNamespace(activeNamespace)}); err != nil {
+		log.Printf("log: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// When a prompt typed at the terminal fails for good (timeout, provider
+// error, budget), the request as it was composed, with its memories,
+// history and system prompt, is kept in ~/.go-chat-failures.json.
+// `go-chat retry-last` sends it again, with -model to try another model,
+// and logs the answer as the original turn. Only the last few failures are
+// kept; a successful retry removes its entry. Ephemeral sessions and
+// unlogged private prompts are not kept.
+const (
+	failuresFilePath = ".go-chat-failures.json"
+	maxFailures      = 20
+)
+
+type FailedRequest struct {
+	Time        time.Time `json:"time"`
+	Error       string    `json:"error"`
+	Prompt      string    `json:"prompt"`
+	Model       string    `json:"model"`
+	System      string    `json:"system"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+	Messages    []Message `json:"messages"`
+	Scope       string    `json:"scope,omitempty"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Speaker     string    `json:"speaker,omitempty"`
+	Private     bool      `json:"private,omitempty"`
+}
+
+func failuresFile() string { return filepath.Join(homeDir, failuresFilePath) }
+
+func loadFailures() []FailedRequest {
+	var fs []FailedRequest
+	if data, err := os.ReadFile(failuresFile()); err == nil {
+		_ = json.Unmarshal(data, &fs)
+	}
+	return fs
+}
+
+func saveFailures(fs []FailedRequest) error {
+	if len(fs) > maxFailures {
+		fs = fs[len(fs)-maxFailures:]
+	}
+	data, err := json.MarshalIndent(fs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(failuresFile(), data, 0o600)
+}
+
+// recordFailure keeps a failed request for retry-last.
+func recordFailure(f FailedRequest, err error) {
+	if ephemeral || errors.Is(err, errNotSent) || (f.Private && !logPrivate(getConfig())) {
+		return
+	}
+	f.Time, f.Error = time.Now().UTC(), err.Error()
+	defer lockFile(failuresFile())()
+	if err := saveFailures(append(loadFailures(), f)); err != nil {
+		log.Printf("failure journal: %v", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "(request saved; `go-chat retry-last` sends it again)")
+}
+
+func runRetryLast(args []string) {
+	fset := flag.NewFlagSet("retry-last", flag.ExitOnError)
+	model := fset.String("model", "", "Send it to this model instead")
+	list := fset.Bool("list", false, "List the failed requests kept")
+	fset.BoolVar(&forceBudget, "force", false, "Send it even if it breaks a budget limit")
+	fset.DurationVar(&requestTimeout, "timeout", defaultRequestTimeout, "Give up after this long (0 for no limit)")
+	fset.Parse(args)
+	httpClient.Timeout = requestTimeout
+
+	fs := loadFailures()
+	if *list {
+		for _, f := range fs {
+			fmt.Printf("%s  %s  %s\n    %s\n", f.Time.Local().Format("2006-01-02 15:04"), f.Model, truncateRunes(oneLine(f.Prompt), 60), f.Error)
+		}
+		return
+	}
+	if len(fs) == 0 {
+		fmt.Println("no failed request to retry")
+		return
+	}
+	f := fs[len(fs)-1]
+	if *model != "" {
+		f.Model = *model
+	}
+	fmt.Fprintf(os.Stderr, "retrying %q with %s (failed %s: %s)\n",
+		truncateRunes(oneLine(f.Prompt), 60), f.Model, f.Time.Local().Format("15:04"), f.Error)
+
+	tw := termWrapper()
+	answer, err := queryGPTWith(f.Model, f.System, f.Temperature, f.MaxTokens, f.Messages, tw.wrap(printToken), queryParams{})
+	if err != nil {
+		updateFailure(f, func(fr *FailedRequest) { fr.Error = err.Error() })
+		log.Fatal(err)
+	}
+	tw.finish(answer)
+	if !strings.HasSuffix(answer, "\n") {
+		fmt.Println()
+	}
+
+	opts := chatOptions{Namespace: f.Namespace, Speaker: f.Speaker, Private: f.Private}
+	finishTurn(f.Prompt, answer, f.Scope, nil, opts)
+	if !f.Private {
+		summarizeDayLogs(f.Scope, f.Namespace)
+	}
+
+	updateFailure(f, nil)
+}
+
+// updateFailure applies fn to the journal entry for f, or removes it if
+// fn is nil.
+func updateFailure(f FailedRequest, fn func(*FailedRequest)) {
+	defer lockFile(failuresFile())()
+	fs := loadFailures()
+	for i := len(fs) - 1; i >= 0; i-- {
+		if !fs[i].Time.Equal(f.Time) || fs[i].Prompt != f.Prompt {
+			continue
+		}
+		if fn == nil {
+			fs = append(fs[:i], fs[i+1:]...)
+		} else {
+			fn(&fs[i])
+		}
+		break
+	}
+	if err := saveFailures(fs); err != nil {
+		log.Printf("failure journal: %v", err)
+	}
+}
+
+func oneLine(s string) string { return strings.Join(strings.Fields(s), " ") }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Relevance feedback: /memgood and /membad in interactive mode vote on the
+// memories injected into the previous answer. Votes are kept per memory in
+// ~/.go-chat-memory-feedback.json and nudge its retrieval score by
+// feedbackStep each, up to feedbackMax either way, so a memory that keeps
+// helping surfaces more readily and a misleading one drops below min_score.
+const (
+	feedbackFilePath = ".go-chat-memory-feedback.json"
+
+	feedbackStep = 0.05
+	feedbackMax  = 0.2
+)
+
+// lastMemories are the memories injected into the previous answer in this
+// process, for /memgood and /membad.
+var lastMemories []VectorMemory
+
+func loadFeedback() map[string]int {
+	votes := map[string]int{}
+	if data, err := os.ReadFile(filepath.Join(homeDir, feedbackFilePath)); err == nil {
+		_ = json.Unmarshal(data, &votes)
+	}
+	return votes
+}
+
+// recordFeedback adds one vote (+1 or -1) to each memory.
+func recordFeedback(mems []VectorMemory, vote int) error {
+	defer lockFile(feedbackFilePath)()
+	votes := loadFeedback()
+	for _, m := range mems {
+		k := memoryKey(m)
+		votes[k] += vote
+		if votes[k] == 0 {
+			delete(votes, k)
+		}
+	}
+	data, err := json.MarshalIndent(votes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(homeDir, feedbackFilePath), data, 0o644)
+}
+
+func feedbackBoost(votes int) float64 {
+	return max(-feedbackMax, min(feedbackMax, float64(votes)*feedbackStep))
+}
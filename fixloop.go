@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// -fix-loop builds the Go code in an answer in a scratch module and, while
+// `go build` or `go vet` complain, hands the errors back to the model. Only
+// the last version is shown and logged.
+var (
+	fixLoop   bool
+	fixRounds int
+)
+
+const (
+	defaultFixRounds = 3
+	fixStepTimeout   = 2 * time.Minute
+)
+
+// fixGoCode returns the answer with its largest Go block replaced by a
+// version that builds, or by the last attempt if none did. Answers without
+// Go code come back unchanged.
+func fixGoCode(userPrompt, answer string, rounds int) string {
+	orig := ""
+	for _, b := range codeBlocks(answer) {
+		if b.lang == "go" && len(b.code) > len(orig) {
+			orig = b.code
+		}
+	}
+	if orig == "" {
+		return answer
+	}
+
+	code := orig
+	for round := 1; ; round++ {
+		problems, err := checkGoCode(code)
+		if err != nil {
+			log.Printf("fix loop: %v", err)
+			break
+		}
+		if problems == "" {
+			fmt.Fprintf(os.Stderr, "fix loop: code builds (attempt %d)\n", round)
+			break
+		}
+		if round >= rounds {
+			fmt.Fprintf(os.Stderr, "fix loop: still failing after %d attempts:\n%s", rounds, problems)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "fix loop: attempt %d failed, revising...\n", round)
+
+		msgs := []Message{
+			{Role: "user", Content: userPrompt},
+			{Role: "assistant", Content: "```go\n" + code + "```"},
+			{Role: "user", Content: "That doesn't build:\n```\n" + problems + "```\nReply with the corrected code in one ```go block."},
+		}
+		reply, err := queryGPTStream(modelExec, prompt("fix-code"), 0.2, 4096, msgs, nil)
+		if err != nil {
+			log.Printf("fix loop: %v", err)
+			break
+		}
+		fixed, err := goCodeBlock(reply)
+		if err != nil {
+			log.Printf("fix loop: %v", err)
+			break
+		}
+		code = fixed
+	}
+	return strings.Replace(answer, orig, code, 1)
+}
+
+// checkGoCode builds and vets code as the only file of a fresh module. It
+// returns the tool output when either fails, and an error only when the
+// check itself couldn't run.
+func checkGoCode(code string) (string, error) {
+	dir, err := os.MkdirTemp("", "go-chat-fix-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "code.go"), []byte(code), 0o644); err != nil {
+		return "", err
+	}
+
+	goCmd := func(args ...string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), fixStepTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+
+	if out, err := goCmd("mod", "init", "fixloop"); err != nil {
+		return "", fmt.Errorf("go mod init: %v: %s", err, out)
+	}
+	// Third-party imports need fetching; if that fails, build says why.
+	goCmd("mod", "tidy")
+	for _, step := range [][]string{{"build", "./..."}, {"vet", "./..."}} {
+		out, err := goCmd(step...)
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				return "", err
+			}
+			return out, nil
+		}
+	}
+	return "", nil
+}
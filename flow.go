@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// `go-chat flow standup` runs a recurring workflow: go-chat asks the
+// flow's questions one by one, then the model turns the answers into the
+// flow's output format (a standup post, a weekly review). Flows are
+// defined under "flows" in the config, overriding the built-in ones by
+// name:
+//
+//	"flows": {"standup": {"questions": ["Yesterday?", "Today?", "Blockers?"],
+//	          "format": "Slack post with bold headings", "slack_webhook": "$SLACK_WEBHOOK"}}
+//
+// With a slack_webhook the result can be posted once confirmed.
+type Flow struct {
+	Description  string   `json:"description,omitempty"`
+	Questions    []string `json:"questions"`
+	Format       string   `json:"format,omitempty"`        // how to compile the answers
+	SlackWebhook string   `json:"slack_webhook,omitempty"` // $VARS are expanded
+}
+
+var builtinFlows = map[string]Flow{
+	"standup": {
+		Description: "daily standup update",
+		Questions: []string{
+			"What did you get done since the last standup?",
+			"What are you working on today?",
+			"Anything blocking you or that you need help with?",
+		},
+		Format: "A short standup post for a team chat channel with *Yesterday*, *Today* and *Blockers* sections of terse bullet points. Write 'None' under Blockers if there are none.",
+	},
+	"weekly": {
+		Description: "weekly review",
+		Questions: []string{
+			"What were the main things you finished this week?",
+			"What didn't go to plan, and why?",
+			"What did you learn?",
+			"What are your top priorities for next week?",
+		},
+		Format: "A weekly review in Markdown with sections Wins, Misses, Lessons and Next week. Keep it honest and specific, a few bullets per section.",
+	},
+}
+
+// flows returns the built-in flows with the configured ones over them.
+func flows(cfg Config) map[string]Flow {
+	all := map[string]Flow{}
+	for name, f := range builtinFlows {
+		all[name] = f
+	}
+	for name, f := range cfg.Flows {
+		all[name] = f
+	}
+	return all
+}
+
+func runFlow(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat flow list | go-chat flow [-y] [-no-post] <name>")
+		os.Exit(2)
+	}
+	cfg := getConfig()
+	all := flows(cfg)
+
+	if args[0] == "list" {
+		names := make([]string, 0, len(all))
+		for n := range all {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		for _, n := range names {
+			f := all[n]
+			post := ""
+			if f.SlackWebhook != "" {
+				post = " (posts to Slack)"
+			}
+			fmt.Printf("%-12s %d questions  %s%s\n", n, len(f.Questions), f.Description, post)
+		}
+		return
+	}
+
+	fset := flag.NewFlagSet("flow", flag.ExitOnError)
+	yes := fset.Bool("y", false, "Post to Slack without asking")
+	noPost := fset.Bool("no-post", false, "Don't offer to post the result")
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		log.Fatal("usage: go-chat flow [-y] [-no-post] <name>")
+	}
+	name := fset.Arg(0)
+	f, ok := all[name]
+	if !ok || len(f.Questions) == 0 {
+		log.Fatalf("flow: no flow %q (see go-chat flow list)", name)
+	}
+
+	var answers strings.Builder
+	for i, q := range f.Questions {
+		fmt.Printf("(%d/%d) %s\n> ", i+1, len(f.Questions), q)
+		a, err := stdin.ReadString('\n')
+		a = strings.TrimSpace(a)
+		if a == "" {
+			a = "(no answer)"
+		}
+		fmt.Fprintf(&answers, "Q: %s\nA: %s\n\n", q, a)
+		if err != nil {
+			fmt.Println()
+			break
+		}
+	}
+
+	format := f.Format
+	if format == "" {
+		format = "A concise summary in Markdown."
+	}
+	msg := fmt.Sprintf("Flow: %s\nOutput format: %s\n\nMy answers:\n\n%s", name, format, answers.String())
+	fmt.Println()
+	out, err := queryGPTStream(modelCreative, prompt("flow-compile"), 0.4, 1024, []Message{{Role: "user", Content: msg}}, printToken)
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("flow: %v", err)
+	}
+	if err := appendLog(ChatLog{Request: "flow: " + name + "\n\n" + answers.String(), Response: out, Namespace: storedNamespace(activeNamespace)}); err != nil {
+		log.Printf("log: %v", err)
+	}
+
+	hook := os.ExpandEnv(f.SlackWebhook)
+	if hook == "" || *noPost {
+		return
+	}
+	if !*yes && !confirm("Post this to Slack?") {
+		return
+	}
+	if err := postSlack(hook, out); err != nil {
+		log.Fatalf("flow: posting to Slack: %v", err)
+	}
+	fmt.Println("posted")
+}
+
+// postSlack sends text to a Slack incoming webhook.
+func postSlack(hook, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(hook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
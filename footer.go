@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// -show-context (or "context_footer": true) prints a dim footer under each
+// answer on the terminal listing the memories and documents that went into
+// its prompt, with their ids, so an odd answer can be traced to a stale
+// memory. The footer is not logged.
+const footerSnippet = 70
+
+var (
+	showContext bool
+	lastSources []IndexChunk // the documents injected into the previous answer
+)
+
+func contextFooter() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "context: %d memories, %d documents", len(lastMemories), len(lastSources))
+	for _, m := range lastMemories {
+		ns := ""
+		if m.Namespace != "" {
+			ns = "[" + m.Namespace + "] "
+		}
+		fmt.Fprintf(&b, "\n  memory %s  %s%s", memoryID(m.Text), ns, truncateRunes(oneLine(m.Text), footerSnippet))
+	}
+	for i, c := range lastSources {
+		cite := chunkCitation(i+1, c)
+		cite.Source = tildePath(cite.Source)
+		fmt.Fprintf(&b, "\n  doc    %s  %s", cite, truncateRunes(oneLine(c.Text), footerSnippet))
+	}
+	return b.String()
+}
+
+// printContextFooter prints the footer if it is turned on.
+func printContextFooter() {
+	if !showContext && !getConfig().ContextFooter {
+		return
+	}
+	footer := contextFooter()
+	if quietMode {
+		fmt.Fprintln(os.Stderr, footer)
+		return
+	}
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		footer = "\033[2m" + footer + "\033[0m"
+	}
+	fmt.Println(footer)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Fusion (-fusion) answers through several branches at once and combines
+// what they say. By default there are two: a logical left brain and a
+// creative right brain, merged by the executive. "fusion" in the config
+// sets any number of branches, each with its own model, system prompt and
+// temperature, and how they are combined:
+//
+//	"fusion": {"mode": "vote", "branches": [
+//	  {"name": "a", "model": "gpt-4o", "temperature": 0.2},
+//	  {"name": "b", "model": "gpt-4o-mini", "temperature": 0.7},
+//	  {"name": "c", "model": "gpt-4o-mini", "temperature": 1}]}
+//
+// "merge" (the default) has the aggregator write one answer from all the
+// branches, following "aggregate" if given. "vote" picks the answer most
+// branches agree on, which suits factual questions.
+//
+// A branch may name a "provider" too, so one fusion can mix backends.
+// "roles" does the same for the default setup without redefining it: the
+// logic and creative brains, the exec aggregator and the memory step that
+// summarises the history for the branches each take a model and provider:
+//
+//	"fusion": {"roles": {
+//	  "logic":    {"provider": "ollama", "model": "llama3.1"},
+//	  "creative": {"model": "gpt-4o-mini"},
+//	  "exec":     {"provider": "anthropic", "model": "claude-sonnet-4-5"}}}
+//
+// Roles left out, and an empty provider, keep the defaults.
+const (
+	fusionMerge = "merge"
+	fusionVote  = "vote"
+
+	defaultBranchTokens = 512
+
+	roleLogic    = "logic"
+	roleCreative = "creative"
+	roleExec     = "exec"
+	roleMemory   = "memory"
+)
+
+type FusionConfig struct {
+	Mode      string                `json:"mode,omitempty"`      // merge or vote
+	Branches  []FusionBranch        `json:"branches,omitempty"`  // default: left and right brains
+	Model     string                `json:"model,omitempty"`     // merges or judges; default the exec role's
+	Aggregate string                `json:"aggregate,omitempty"` // merge instructions; default the fusion-exec prompt
+	Roles     map[string]FusionRole `json:"roles,omitempty"`     // logic, creative, exec and memory
+}
+
+type FusionBranch struct {
+	Name        string   `json:"name"`
+	Provider    string   `json:"provider,omitempty"` // default the configured provider
+	Model       string   `json:"model,omitempty"`
+	System      string   `json:"system,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+}
+
+// FusionRole sets the model and provider of one of the default roles.
+type FusionRole struct {
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// role returns the model and provider for a role, model being the
+// default.
+func (fc FusionConfig) role(name, model string) (string, string) {
+	for r := range fc.Roles {
+		if r != roleLogic && r != roleCreative && r != roleExec && r != roleMemory {
+			warnOnce("fusion role:"+r, "fusion: unknown role %q (want logic, creative, exec or memory)", r)
+		}
+	}
+	r := fc.Roles[name]
+	return cmp.Or(r.Model, model), r.Provider
+}
+
+func fusionBranches(fc FusionConfig) []FusionBranch {
+	if len(fc.Branches) > 0 {
+		return fc.Branches
+	}
+	logic, creative := 0.2, 0.9
+	left := FusionBranch{Name: "left", System: prompt("fusion-logic"), Temperature: &logic}
+	left.Model, left.Provider = fc.role(roleLogic, modelLogic)
+	right := FusionBranch{Name: "right", System: prompt("fusion-creative"), Temperature: &creative}
+	right.Model, right.Provider = fc.role(roleCreative, modelCreative)
+	return []FusionBranch{left, right}
+}
+
+// branchTag is the tag a branch's answer is wrapped in for the aggregator,
+// e.g. <LEFT>.
+func branchTag(name string) string {
+	return "<" + strings.ToUpper(name) + ">"
+}
+
+// runBranches asks every branch in parallel and returns their answers in
+// branch order.
+func runBranches(branches []FusionBranch, msgs []Message) ([]string, error) {
+	answers := make([]string, len(branches))
+	errs := make([]error, len(branches))
+	var wg sync.WaitGroup
+	for i, b := range branches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			temp := 0.7
+			if b.Temperature != nil {
+				temp = *b.Temperature
+			}
+			system := cmp.Or(b.System, "Answer the question.")
+			answers[i], errs[i] = queryGPTWith(cmp.Or(b.Model, modelExec), system, temp, cmp.Or(b.MaxTokens, defaultBranchTokens), msgs, nil, queryParams{Provider: b.Provider})
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("fusion branch %s: %w", b.Name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+// fuse runs the fusion branches on a prompt and combines their answers,
+// streaming the result to onToken.
+func fuse(cfg Config, system, mem, userPrompt string, onToken func(string), params queryParams) (string, error) {
+	fc := cfg.Fusion
+	branches := fusionBranches(fc)
+	model, provider := fc.role(roleExec, modelExec)
+	model, params.Provider = cmp.Or(fc.Model, model), provider
+	branchMsgs := []Message{{Role: "system", Content: tagMem + mem + tagEnd}, {Role: "user", Content: userPrompt}}
+	answers, err := runBranches(branches, branchMsgs)
+	if err != nil {
+		return "", err
+	}
+
+	switch cmp.Or(fc.Mode, fusionMerge) {
+	case fusionMerge:
+		var tagged strings.Builder
+		tagged.WriteString(tagMem + mem)
+		for i, b := range branches {
+			tagged.WriteString(branchTag(b.Name) + answers[i])
+		}
+		tagged.WriteString(tagEnd)
+		execMsgs := []Message{
+			{Role: "system", Content: system},
+			{Role: "system", Content: tagged.String()},
+			{Role: "user", Content: userPrompt},
+		}
+		return queryGPTWith(model, cmp.Or(fc.Aggregate, prompt("fusion-exec")), 0.55, 1024, execMsgs, onToken, params)
+	case fusionVote:
+		return voteAnswers(model, userPrompt, answers, onToken, params)
+	}
+	return "", fmt.Errorf("unknown fusion mode %q (want merge or vote)", fc.Mode)
+}
+
+// normAnswer reduces an answer to what matters for an exact vote.
+func normAnswer(s string) string {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	return strings.TrimRight(s, ".!")
+}
+
+// voteAnswers returns the answer most of answers agree on. Short answers
+// that a majority give word for word win outright; otherwise the judge
+// model decides which conclusion most share and restates it.
+func voteAnswers(judge, userPrompt string, answers []string, onToken func(string), params queryParams) (string, error) {
+	counts := map[string]int{}
+	for _, a := range answers {
+		counts[normAnswer(a)]++
+	}
+	for _, a := range answers {
+		if n := counts[normAnswer(a)]; 2*n > len(answers) && len(a) < 200 {
+			if onToken != nil {
+				onToken(a)
+			}
+			return a, nil
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Question: %s\n\n", userPrompt)
+	for i, a := range answers {
+		fmt.Fprintf(&b, "<ANSWER %d>\n%s\n", i+1, strings.TrimSpace(a))
+	}
+	b.WriteString(tagEnd)
+	return queryGPTWith(judge, prompt("fusion-vote"), 0.2, 1024, []Message{{Role: "user", Content: b.String()}}, onToken, params)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// `go-chat gen tests <dir>` has the model write table-driven tests for a
+// package's exported functions, then runs them and feeds failures back
+// until they pass or the rounds run out.
+const defaultGenRounds = 3
+
+func runGen(args []string) {
+	if len(args) == 0 || args[0] != "tests" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat gen tests [-rounds N] [-y] <package dir>")
+		os.Exit(2)
+	}
+	fset := flag.NewFlagSet("gen tests", flag.ExitOnError)
+	rounds := fset.Int("rounds", defaultGenRounds, "Attempts at getting the tests to pass")
+	yes := fset.Bool("y", false, "Write the test file without asking")
+	fset.Parse(args[1:])
+	dir := "."
+	if fset.NArg() > 0 {
+		dir = fset.Arg(0)
+	}
+	if err := genTests(dir, *rounds, *yes); err != nil {
+		log.Fatalf("gen tests: %v", err)
+	}
+}
+
+func genTests(dir string, rounds int, yes bool) error {
+	pkg, err := readGoPackage(dir)
+	if err != nil {
+		return err
+	}
+	if len(pkg.exported) == 0 {
+		return fmt.Errorf("no exported functions in %s", dir)
+	}
+
+	var req strings.Builder
+	fmt.Fprintf(&req, "Package %s. Exported functions:\n\n", pkg.name)
+	for _, sig := range pkg.exported {
+		req.WriteString(sig + "\n")
+	}
+	for name, src := range pkg.files {
+		fmt.Fprintf(&req, "\n%s:\n```go\n%s\n```\n", name, src)
+	}
+	if n := tokens(req.String()); n > contextWindowTokens/2 {
+		return fmt.Errorf("package is too large to send (%d tokens)", n)
+	}
+
+	msgs := []Message{{Role: "user", Content: req.String()}}
+	fmt.Fprintf(os.Stderr, "writing tests for %d functions...\n", len(pkg.exported))
+	answer := queryGPT(modelExec, prompt("gen-tests"), 0.2, 4096, msgs, false)
+	code, err := goCodeBlock(answer)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(dir, pkg.name+"_gen_test.go")
+	fmt.Println(code)
+	question := "Write " + out + "?"
+	if _, err := os.Stat(out); err == nil {
+		question = "Overwrite " + out + "?"
+	}
+	if !yes && !confirm(question) {
+		return nil
+	}
+
+	for round := 1; ; round++ {
+		if err := os.WriteFile(out, []byte(code), 0o644); err != nil {
+			return err
+		}
+		cmd := exec.Command("go", "test", ".")
+		cmd.Dir = dir
+		result, err := cmd.CombinedOutput()
+		if err == nil {
+			fmt.Printf("tests pass (round %d)\n", round)
+			return nil
+		}
+		if round >= rounds {
+			fmt.Print(string(result))
+			return fmt.Errorf("tests still failing after %d rounds; left %s in place", rounds, out)
+		}
+
+		fmt.Fprintf(os.Stderr, "round %d failed, revising...\n", round)
+		msgs = append(msgs,
+			Message{Role: "assistant", Content: answer},
+			Message{Role: "user", Content: "`go test` failed:\n```\n" + string(result) + "```\n" +
+				"Fix the test file. If a case fails because the code under test is wrong, drop the case and add a comment saying why. Reply with the whole file."},
+		)
+		answer = queryGPT(modelExec, prompt("gen-tests"), 0.2, 4096, msgs, false)
+		if code, err = goCodeBlock(answer); err != nil {
+			return err
+		}
+	}
+}
+
+type goPackage struct {
+	name     string
+	files    map[string]string // base name to source
+	exported []string          // signatures
+}
+
+// readGoPackage reads the non-test Go files in dir.
+func readGoPackage(dir string) (*goPackage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	pkg := &goPackage{files: map[string]string{}}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, err
+		}
+		if pkg.name == "" {
+			pkg.name = f.Name.Name
+		} else if f.Name.Name != pkg.name {
+			continue // e.g. a package main tool alongside
+		}
+		pkg.files[name] = string(src)
+		for _, d := range f.Decls {
+			fd, ok := d.(*ast.FuncDecl)
+			if !ok || !fd.Name.IsExported() || !exportedRecv(fd) {
+				continue
+			}
+			sig := *fd
+			sig.Body, sig.Doc = nil, nil
+			var b bytes.Buffer
+			printer.Fprint(&b, fset, &sig)
+			pkg.exported = append(pkg.exported, b.String())
+		}
+	}
+	if pkg.name == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return pkg, nil
+}
+
+// exportedRecv reports whether a function is a plain function or a method
+// on an exported type.
+func exportedRecv(fd *ast.FuncDecl) bool {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return true
+	}
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.IsExported()
+	case *ast.IndexExpr:
+		id, ok := t.X.(*ast.Ident)
+		return ok && id.IsExported()
+	case *ast.IndexListExpr:
+		id, ok := t.X.(*ast.Ident)
+		return ok && id.IsExported()
+	}
+	return false
+}
+
+// goCodeBlock returns the largest fenced Go block in an answer, gofmt'ed
+// when it parses.
+func goCodeBlock(answer string) (string, error) {
+	best := ""
+	for _, b := range codeBlocks(answer) {
+		if (b.lang == "go" || b.lang == "") && len(b.code) > len(best) {
+			best = b.code
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no Go code in the answer")
+	}
+	if src, err := format.Source([]byte(best)); err == nil {
+		best = string(src)
+	}
+	return best, nil
+}
+
+type codeBlock struct {
+	lang string
+	code string
+}
+
+// codeBlocks returns the fenced code blocks in markdown text, in order.
+func codeBlocks(text string) []codeBlock {
+	var out []codeBlock
+	var cur *codeBlock
+	var body strings.Builder
+	for _, l := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(l)
+		if !strings.HasPrefix(trimmed, "```") {
+			if cur != nil {
+				body.WriteString(l + "\n")
+			}
+			continue
+		}
+		if cur == nil {
+			cur = &codeBlock{lang: strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))}
+			body.Reset()
+			continue
+		}
+		cur.code = body.String()
+		out = append(out, *cur)
+		cur = nil
+	}
+	return out
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// `go-chat gh describe` drafts a pull request title and description for
+// the current branch from its commits and its diff against the base
+// branch, filling in a template: -template, else "github.template" in the
+// config (a file, relative to the home directory like system_template),
+// else the repository's own pull request template, else a plain Summary,
+// Changes and Testing layout. Files the ignore rules exclude are left out
+// of the diff. With -push the draft is sent to GitHub once confirmed,
+// updating the branch's open pull request or opening one; that needs
+// $GITHUB_TOKEN (or $GH_TOKEN) and an origin remote on GitHub.
+type GitHubConfig struct {
+	Template string `json:"template,omitempty"`
+	APIURL   string `json:"api_url,omitempty"` // default https://api.github.com; for GitHub Enterprise
+}
+
+const (
+	ghDiffChars     = 12000 // of the branch diff sent to the model
+	defaultGHAPIURL = "https://api.github.com"
+	defaultPRLayout = "## Summary\n\n## Changes\n\n## Testing\n"
+)
+
+var prTemplatePaths = []string{
+	".github/pull_request_template.md", ".github/PULL_REQUEST_TEMPLATE.md",
+	"PULL_REQUEST_TEMPLATE.md", "docs/pull_request_template.md",
+}
+
+// githubRemote matches the owner and repository in a GitHub remote URL.
+var githubRemote = regexp.MustCompile(`github\.com[:/]([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+func runGH(args []string) {
+	if len(args) == 0 || args[0] != "describe" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat gh describe [-base B] [-template F] [-push] [-y] [-model M]")
+		os.Exit(2)
+	}
+	fset := flag.NewFlagSet("gh describe", flag.ExitOnError)
+	base := fset.String("base", "", "Branch the pull request merges into (default origin's default branch)")
+	tmplFile := fset.String("template", "", "Template file for the description")
+	push := fset.Bool("push", false, "Offer to send the title and description to GitHub")
+	yes := fset.Bool("y", false, "Push without asking")
+	model := fset.String("model", modelExec, "Model to write with")
+	fset.Parse(args[1:])
+
+	root, err := repoRoot()
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	if *base == "" {
+		*base = defaultBaseBranch()
+	}
+	branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	commits, err := gitCommits(*base+"..HEAD", false)
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	if len(commits) == 0 {
+		log.Fatalf("gh describe: %s has no commits that aren't on %s", branch, *base)
+	}
+	diff, err := branchDiff(root, *base)
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	layout, err := prTemplate(root, *tmplFile)
+	if err != nil {
+		log.Fatalf("gh describe: template: %v", err)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "Branch %s into %s.\n\nTemplate:\n%s\n\nCommits:\n", branch, *base, fenced("markdown", layout))
+	for _, c := range commits {
+		fmt.Fprintf(&msg, "- %s %s\n", c.Hash, c.Subject)
+		if c.Body != "" {
+			fmt.Fprintf(&msg, "  %s\n", strings.ReplaceAll(c.Body, "\n", "\n  "))
+		}
+	}
+	fmt.Fprintf(&msg, "\nDiff:\n%s", fenced("diff", truncateRunes(diff, ghDiffChars)))
+
+	out, err := queryGPTStream(*model, prompt("pr-describe"), 0.3, 2048, []Message{{Role: "user", Content: msg.String()}}, nil)
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	var pr struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+	if i < 0 || j < i || json.Unmarshal([]byte(out[i:j+1]), &pr) != nil || pr.Title == "" {
+		log.Fatalf("gh describe: no title and description in the reply:\n%s", out)
+	}
+	fmt.Printf("%s\n\n%s\n", pr.Title, strings.TrimSpace(pr.Body))
+	if err := appendLog(ChatLog{Request: "gh describe: " + branch, Response: pr.Title + "\n\n" + pr.Body, Namespace: storedNamespace(activeNamespace)}); err != nil {
+		log.Printf("log: %v", err)
+	}
+
+	if !*push {
+		return
+	}
+	owner, repo, err := githubRepo()
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	if !*yes && !confirm(fmt.Sprintf("\nSend this to the %s pull request on %s/%s?", branch, owner, repo)) {
+		return
+	}
+	link, err := pushPullRequest(owner, repo, branch, *base, pr.Title, pr.Body)
+	if err != nil {
+		log.Fatalf("gh describe: %v", err)
+	}
+	fmt.Println(link)
+}
+
+func gitOutput(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// defaultBaseBranch is origin's default branch, or main.
+func defaultBaseBranch() string {
+	if ref, err := gitOutput("symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return ref
+	}
+	return "main"
+}
+
+// branchDiff is the diff of HEAD against where it left base, without the
+// files the ignore rules exclude.
+func branchDiff(root, base string) (string, error) {
+	names, err := gitOutput("diff", "--name-only", base+"...HEAD")
+	if err != nil {
+		return "", err
+	}
+	ig := newIgnorer(getConfig())
+	paths := []string{"diff", "--stat", "--patch", base + "...HEAD", "--"}
+	for _, n := range strings.Split(names, "\n") {
+		if n != "" && !ig.excluded(filepath.Join(root, n)) {
+			paths = append(paths, ":(top)"+n)
+		}
+	}
+	if len(paths) == 5 {
+		return "", nil
+	}
+	return gitOutput(paths...)
+}
+
+// prTemplate returns the layout the description should follow.
+func prTemplate(root, file string) (string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		return string(data), err
+	}
+	if t := getConfig().GitHub.Template; t != "" {
+		return includeFile(t)
+	}
+	for _, p := range prTemplatePaths {
+		if data, err := os.ReadFile(filepath.Join(root, p)); err == nil {
+			return string(data), nil
+		}
+	}
+	return defaultPRLayout, nil
+}
+
+// githubRepo is the owner and name of the origin remote on GitHub.
+func githubRepo() (string, string, error) {
+	remote, err := gitOutput("remote", "get-url", "origin")
+	if err != nil {
+		return "", "", err
+	}
+	m := githubRemote.FindStringSubmatch(remote)
+	if m == nil {
+		return "", "", fmt.Errorf("origin (%s) is not a GitHub repository", remote)
+	}
+	return m[1], m[2], nil
+}
+
+// pushPullRequest updates the open pull request for branch, or opens one,
+// and returns its URL.
+func pushPullRequest(owner, repo, branch, base, title, body string) (string, error) {
+	token := cmp.Or(os.Getenv("GITHUB_TOKEN"), os.Getenv("GH_TOKEN"))
+	if token == "" {
+		return "", errors.New("GITHUB_TOKEN is not set")
+	}
+	api := strings.TrimSuffix(cmp.Or(getConfig().GitHub.APIURL, defaultGHAPIURL), "/")
+	pulls := fmt.Sprintf("%s/repos/%s/%s/pulls", api, owner, repo)
+
+	var open []struct {
+		Number int `json:"number"`
+	}
+	q := url.Values{"head": {owner + ":" + branch}, "state": {"open"}}
+	if err := githubRequest(token, http.MethodGet, pulls+"?"+q.Encode(), nil, &open); err != nil {
+		return "", err
+	}
+	var pr struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if len(open) > 0 {
+		err := githubRequest(token, http.MethodPatch, fmt.Sprintf("%s/%d", pulls, open[0].Number),
+			map[string]string{"title": title, "body": body}, &pr)
+		return pr.HTMLURL, err
+	}
+	// Strip "origin/" so GitHub gets the branch name.
+	base = strings.TrimPrefix(base, "origin/")
+	err := githubRequest(token, http.MethodPost, pulls,
+		map[string]string{"title": title, "body": body, "head": branch, "base": base}, &pr)
+	return pr.HTMLURL, err
+}
+
+func githubRequest(token, method, url string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("github: %s %s: %s – %s", method, req.URL.Path, resp.Status, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
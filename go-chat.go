@@ -2,142 +2,115 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"context"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"maps"
 	"math"
 	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/chroma/styles"
-	tiktoken "github.com/pkoukk/tiktoken-go"
 )
 
 var (
 	_ = styles.Fallback
 )
-var (
-	encoder *tiktoken.Tiktoken
-)
+var useFusion = new(bool)
 
-var useFusion *bool
+// queryGPT is the CLI entry point: streamed answers go to stdout and any
+// failure is fatal. Long-running modes use queryGPTStream instead.
+func queryGPT(model, systemPrompt string, temp float64, maxTok int,
+	msgs []Message, stream bool) string {
 
-func init() {
-	var err error
-	encoder, err = tiktoken.EncodingForModel("gpt-4o")
+	var onToken func(string)
+	if stream {
+		onToken = printToken
+	}
+	answer, err := queryGPTStream(model, systemPrompt, temp, maxTok, msgs, onToken)
 	if err != nil {
-		log.Fatalf("tiktoken init: %v", err)
+		log.Fatal(err)
 	}
+	return answer
 }
 
-func tokens(s string) int     { return len(encoder.EncodeOrdinary(s)) }
-func tokensMsg(m Message) int { return 4 + tokens(m.Role) + tokens(m.Content) }
+func printToken(s string) { fmt.Print(s) }
 
-func queryGPT(model, systemPrompt string, temp float64, maxTok int,
-	msgs []Message, stream bool) string {
+// queryGPTStream sends a chat and returns the answer. If onToken is non-nil
+// the response is streamed and each piece of text is passed to it.
+func queryGPTStream(model, systemPrompt string, temp float64, maxTok int,
+	msgs []Message, onToken func(string)) (string, error) {
 
-	msgs = append([]Message{{Role: "system", Content: systemPrompt}}, msgs...)
+	return queryGPTWith(model, systemPrompt, temp, maxTok, msgs, onToken, queryParams{})
+}
 
-	payload := map[string]any{
-		"model":             model,
-		"messages":          msgs,
-		"temperature":       temp,
-		"max_tokens":        maxTok,
-		"top_p":             0.96,
-		"frequency_penalty": 0.3,
-		"presence_penalty":  0.0,
-		"stream":            stream,
-	}
+// queryParams holds the less common request settings.
+type queryParams struct {
+	Stop     []string
+	Seed     *int   // see deterministic.go
+	Provider string // instead of the configured one; see fusion.go
+}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
-		log.Fatalf("encode payload: %v", err)
-	}
+func queryGPTWith(model, systemPrompt string, temp float64, maxTok int,
+	msgs []Message, onToken func(string), params queryParams) (string, error) {
 
-	req, err := http.NewRequestWithContext(
-		context.Background(),
-		http.MethodPost,
-		apiURL+"/v1/chat/completions",
-		&buf,
-	)
-	if err != nil {
-		log.Fatalf("new request: %v", err)
+	if deterministic {
+		temp = 0
+	}
+	if params.Seed == nil {
+		params.Seed = requestSeed()
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	resp, err := httpClient.Do(req)
+	p, err := providerByName(cmp.Or(params.Provider, providerName(getConfig())))
 	if err != nil {
-		log.Fatalf("http: %v", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		log.Fatalf("openai: %s – %s", resp.Status, body)
+		return "", err
 	}
+	model = resolveModel(p, model)
+	msgs = append([]Message{{Role: "system", Content: systemPrompt}}, msgs...)
 
-	if !stream {
-		var out struct {
-			Choices []struct {
-				Message Message `json:"message"`
-			} `json:"choices"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-			log.Fatalf("decode: %v", err)
+	// The model may answer with tool calls instead of text; run them, feed
+	// the results back and ask again until it produces an answer.
+	for round := 0; ; round++ {
+		if err := checkBudget(p, model, "", msgs, maxTok); err != nil {
+			return "", err
 		}
-		resp.Body.Close()
-		return out.Choices[0].Message.Content
-	}
-
-	reader := bufio.NewReader(resp.Body)
-	var answer strings.Builder
-
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				log.Printf("stream read: %v", err)
-			}
-			break
+		req := ChatRequest{Model: model, Messages: msgs, Temperature: temp, MaxTokens: maxTok, Stop: params.Stop, Seed: params.Seed}
+		if round < maxToolRounds {
+			req.Tools = toolDefinitions()
 		}
-		if !strings.HasPrefix(line, "data:") {
-			continue
+		var reply Message
+		if onToken != nil {
+			reply, err = p.Stream(req, onToken)
+		} else {
+			reply, err = p.Complete(req)
 		}
-		data := strings.TrimSpace(line[len("data:"):])
-		if data == "[DONE]" {
-			break
+		if err != nil {
+			return "", err
 		}
-
-		var chunk struct {
-			Choices []struct {
-				Delta struct {
-					Content string `json:"content"`
-				} `json:"delta"`
-			} `json:"choices"`
+		if u := reply.Usage; u != nil {
+			recordSpendTokens(model, u.Input, u.Output)
+		} else {
+			recordSpend(p, model, "", msgs, reply.Content+reply.toolCallText())
 		}
-		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-			continue
+		if len(reply.ToolCalls) == 0 {
+			return reply.Content, nil
 		}
-		if len(chunk.Choices) == 0 {
-			continue
+		msgs = append(msgs, reply)
+		for _, tc := range reply.ToolCalls {
+			msgs = append(msgs, runToolCall(tc))
 		}
-		text := chunk.Choices[0].Delta.Content
-		fmt.Print(text)
-		answer.WriteString(text)
 	}
-	resp.Body.Close()
-
-	return answer.String()
 }
 
 func clearChatLog() {
@@ -147,39 +120,78 @@ func clearChatLog() {
 }
 
 func dailyLogPath() string {
-	return filepath.Join(logDirPath, time.Now().Format("2006-01-02")+".json")
+	return filepath.Join(logDirPath, logDay(time.Now())+".json")
 }
 
-func appendLog(req, resp string) error {
+// logDays returns the dates (YYYY-MM-DD) that have a log file, oldest first.
+func logDays() []string {
+	matches, _ := filepath.Glob(filepath.Join(logDirPath, "*.json"))
+	days := make([]string, 0, len(matches))
+	for _, m := range matches {
+		days = append(days, strings.TrimSuffix(filepath.Base(m), ".json"))
+	}
+	sort.Strings(days)
+	return days
+}
+
+func readDayLog(day string) ([]ChatLog, error) {
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return nil, fmt.Errorf("bad date %q", day)
+	}
+	data, err := os.ReadFile(filepath.Join(logDirPath, day+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var logs []ChatLog
+	if err := json.Unmarshal(data, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+func appendLog(entry ChatLog) error {
+	if ephemeral {
+		keepEphemeral(entry)
+		return nil
+	}
 	var logs []ChatLog
 	p := dailyLogPath()
+	defer lockFile(p)()
 	if data, err := os.ReadFile(p); err == nil {
 		_ = json.Unmarshal(data, &logs)
 	}
-	logs = append(logs, ChatLog{Timestamp: time.Now(), Request: req, Response: resp})
+	entry.Timestamp = time.Now().UTC()
+	logs = append(logs, entry)
 	data, _ := json.MarshalIndent(logs, "", "  ")
-	return os.WriteFile(p, data, 0o644)
+	return writeFileAtomic(p, data, 0o644)
 }
 
 func printChatLog(n int) {
-	p := dailyLogPath()
-	data, err := os.ReadFile(p)
+	day := logDay(time.Now())
+	logs, err := readDayLog(day)
 	if err != nil {
 		log.Fatalf("read log: %v", err)
 	}
-	var logs []ChatLog
-	_ = json.Unmarshal(data, &logs)
-
 	if n > 0 && len(logs) > n {
 		logs = logs[len(logs)-n:]
 	}
-	for _, l := range logs {
-		fmt.Printf("%s\n> %s\n%s\n\n",
-			l.Timestamp.Format(time.RFC822), l.Request, l.Response)
+	entries := make([]dayLog, len(logs))
+	for i, l := range logs {
+		entries[i] = dayLog{day, l}
 	}
+	printLogEntries(entries, false)
 }
 
+// getConfig returns the config, with the project's overrides (see
+// project.go).
 func getConfig() Config {
+	cfg := readConfig()
+	applyProject(&cfg)
+	return cfg
+}
+
+// readConfig reads the config file alone, for changing and saving it.
+func readConfig() Config {
 	var cfg Config
 
 	data, err := os.ReadFile(configFilePath)
@@ -206,14 +218,16 @@ func getConfig() Config {
 }
 
 func savePersonality(p string) {
-	cfg := getConfig()
+	defer lockFile(configFilePath)()
+	cfg := readConfig()
 	cfg.Personality = p
 	saveConfig(cfg)
 	fmt.Println("personality saved")
 }
 
 func updateConfig(user, ai, bio string) {
-	cfg := getConfig()
+	defer lockFile(configFilePath)()
+	cfg := readConfig()
 	if user != "" {
 		cfg.UserName = user
 	}
@@ -229,15 +243,34 @@ func updateConfig(user, ai, bio string) {
 
 func saveConfig(c Config) {
 	data, _ := json.MarshalIndent(c, "", "  ")
-	_ = os.WriteFile(configFilePath, data, 0o644)
+	_ = writeFileAtomic(configFilePath, data, 0o644)
 }
 
 func enterInteractiveMode() {
-	r := bufio.NewReader(os.Stdin)
-	fmt.Println("interactive mode – type 'exit' to quit")
+	recoverMemories()
+	r := stdin
+	fmt.Println("interactive mode – type 'exit' to quit, '/last' to page the previous answer, '/ns name' to switch namespace, '/memgood' or '/membad' to rate the memories used, '/bookmark note #tag' to save the last answer, '/snip #tag' to keep its code blocks, '/private' to stop remembering (or start a prompt with !private), '/short', '/normal' or '/detailed' to set the answer length, '/invite persona' to add another assistant")
+	var turns, shared []Message // shared leaves out private turns
+	defer func() { summarizeSession(shared) }()
+	pty := newParty()
+	promptStr := "> "
+	if p := currentPersona(getConfig()); p != nil && p.Theme.Prompt != "" {
+		promptStr = p.Theme.Prompt
+	}
+	if ephemeral {
+		ephemeralNotice()
+		promptStr = ephemeralTag + promptStr
+	}
 	for {
-		fmt.Print("> ")
-		line, _ := r.ReadString('\n')
+		if privateMode {
+			fmt.Print("[private] ")
+		}
+		fmt.Print(promptStr)
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			break
+		}
 		line = strings.TrimSpace(line)
 		if line == "exit" {
 			break
@@ -245,23 +278,163 @@ func enterInteractiveMode() {
 		if line == "" {
 			continue
 		}
-		sendChat(line)
+		if ns, ok := strings.CutPrefix(line, "/ns"); ok && (ns == "" || ns[0] == ' ') {
+			ns = strings.TrimSpace(ns)
+			if ns == "" {
+				fmt.Println("namespace:", cmp.Or(activeNamespace, defaultNamespace))
+			} else if err := validNamespace(ns); err != nil {
+				fmt.Println(err)
+			} else {
+				activeNamespace = ns
+				fmt.Println("namespace:", ns)
+			}
+			continue
+		}
+		if line == "/private" {
+			privateMode = !privateMode
+			if privateMode {
+				fmt.Println("private: answers are still given but not remembered or summarised")
+			} else {
+				fmt.Println("private mode off")
+			}
+			continue
+		}
+		if line == "/memgood" || line == "/membad" {
+			rateLastMemories(line == "/memgood")
+			continue
+		}
+		if note, ok := strings.CutPrefix(line, "/bookmark"); ok && (note == "" || note[0] == ' ') {
+			if ephemeral {
+				fmt.Println("not saved: this session is ephemeral")
+			} else if len(turns) == 0 {
+				fmt.Println("no answer yet")
+			} else if bm, err := addBookmark(turns[len(turns)-2].Content, turns[len(turns)-1].Content, note); err != nil {
+				fmt.Println("bookmark:", err)
+			} else {
+				fmt.Println("bookmarked", bm.ID)
+			}
+			continue
+		}
+		if tags, ok := strings.CutPrefix(line, "/snip"); ok && (tags == "" || tags[0] == ' ') {
+			if ephemeral {
+				fmt.Println("not saved: this session is ephemeral")
+				continue
+			}
+			if len(turns) == 0 {
+				fmt.Println("no answer yet")
+				continue
+			}
+			var tt []string
+			for _, t := range strings.Fields(tags) {
+				tt = append(tt, strings.ToLower(strings.TrimPrefix(t, "#")))
+			}
+			added, err := snipAnswer(turns[len(turns)-2].Content, turns[len(turns)-1].Content, tt)
+			switch {
+			case err != nil:
+				fmt.Println("snip:", err)
+			case len(added) == 0:
+				fmt.Println("no new code blocks in the last answer")
+			}
+			for _, s := range added {
+				fmt.Println("saved", s.line())
+			}
+			continue
+		}
+		if line == "/last" {
+			if lastAnswer == "" {
+				fmt.Println("no answer yet")
+			} else {
+				page(lastAnswer)
+			}
+			continue
+		}
+		if pty.command(line) {
+			continue
+		}
+		sessionLength := answerLength
+		if l, rest, ok := cutLengthCommand(line); ok {
+			answerLength = l
+			if rest == "" {
+				fmt.Println("answer length:", l)
+				continue
+			}
+			line = rest
+		}
+		_, private := cutPrivate(line)
+		if privateMode && !private {
+			line, private = privatePrefix+" "+line, true
+		}
+		if pty.active() {
+			sendChatWith(line, pty.options(pty.pick(line)))
+		} else {
+			sendChat(line)
+		}
+		answerLength = sessionLength
+		turn := []Message{
+			{Role: "user", Content: line},
+			{Role: "assistant", Content: lastAnswer},
+		}
+		turns = append(turns, turn...)
+		if !private {
+			shared = append(shared, turn...)
+		}
+	}
+}
+
+func rateLastMemories(good bool) {
+	if len(lastMemories) == 0 {
+		fmt.Println("no memories were used for the last answer")
+		return
+	}
+	vote, verb := -1, "downweighted"
+	if good {
+		vote, verb = 1, "upweighted"
+	}
+	if err := recordFeedback(lastMemories, vote); err != nil {
+		fmt.Println("feedback:", err)
+		return
 	}
+	fmt.Printf("%s %d memories\n", verb, len(lastMemories))
 }
 
 type AppState struct {
 	CheckInEnabled bool      `json:"check_in_enabled"`
 	LastChecked    time.Time `json:"last_checked"`
+	DoNotDisturb   time.Time `json:"dnd_until"` // see dnd.go
 }
 
+// runAsDaemon checks in every half hour, or when "checkin_schedule"
+// fires, until SIGINT or SIGTERM; a check-in under way when the signal
+// arrives is finished first. While it must keep quiet (see dnd.go) it
+// wakes when that ends instead. `go-chat daemon status` reports on it
+// (see daemon.go).
 func runAsDaemon() {
+	ctx, stop := signalContext()
+	defer stop()
+	defer startDaemon()()
 	for {
 		checkInUser()
-		time.Sleep(30 * time.Minute)
+		now, wait := time.Now(), 30*time.Minute
+		if sched := checkInSchedule(); sched != nil {
+			if next := sched.next(now); !next.IsZero() {
+				wait = next.Sub(now)
+			}
+		}
+		if until := quietUntil(now, getConfig(), getState()); until.After(now) {
+			wait = min(wait, until.Sub(now))
+		}
+		updateDaemonStatus(func(ds *daemonStatus) { ds.NextWake = now.Add(wait) })
+		select {
+		case <-ctx.Done():
+			log.Print("daemon stopped")
+			return
+		case <-time.After(wait):
+		}
 	}
 }
 
 func toggleCheckInFeature() {
+	defer lockFile(stateFilePath)()
 	st := getState()
 	st.CheckInEnabled = !st.CheckInEnabled
 	saveState(st)
@@ -269,14 +442,33 @@ func toggleCheckInFeature() {
 }
 
 func checkInUser() {
+	unlock := lockFile(stateFilePath)
 	st := getState()
-	if !st.CheckInEnabled || time.Since(st.LastChecked) < 2*time.Hour {
+	due := checkInDue(st)
+	if !st.CheckInEnabled || due.IsZero() || time.Now().Before(due) || quietReason(time.Now(), getConfig(), st) != "" {
+		unlock()
 		return
 	}
+	firstToday := logDay(st.LastChecked) != logDay(time.Now())
 	st.LastChecked = time.Now()
 	saveState(st)
+	unlock()
 
-	sendChat("Hey there! Just checking in – how are you doing?")
+	trackCheckIn()
+	msg := prompt("checkin")
+	if firstToday {
+		msg += weatherBriefing(getConfig().Weather)
+	}
+	sendChat(msg)
+}
+
+// checkInDue is when the next check-in falls due, or the zero time if the
+// schedule never fires again.
+func checkInDue(st AppState) time.Time {
+	if sched := checkInSchedule(); sched != nil {
+		return sched.next(st.LastChecked)
+	}
+	return st.LastChecked.Add(2 * time.Hour)
 }
 
 func getState() AppState {
@@ -291,19 +483,45 @@ func getState() AppState {
 
 func saveState(st AppState) {
 	data, _ := json.MarshalIndent(st, "", "  ")
-	_ = os.WriteFile(stateFilePath, data, 0o644)
+	_ = writeFileAtomic(stateFilePath, data, 0o644)
+}
+
+// stdin is shared so that prompts and confirmations don't lose input to
+// each other's buffers.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on the terminal; anything but y/yes is no.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	ans, _ := stdin.ReadString('\n')
+	ans = strings.ToLower(strings.TrimSpace(ans))
+	return ans == "y" || ans == "yes"
 }
 
 func promptUserForInstructions(filePath string) {
+	if abs, err := filepath.Abs(filePath); err == nil && newIgnorer(getConfig()).excluded(abs) {
+		log.Fatalf("%s is excluded by ignore rules; not sending it", filePath)
+	}
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		log.Fatalf("read file: %v", err)
 	}
+	text, lang, err := uploadText(filePath, content)
+	if err != nil {
+		log.Fatalf("%s %v", filePath, err)
+	}
+	text, lang, err = condenseIfLarge(getConfig(), filepath.Base(filePath), lang, text)
+	if errors.Is(err, errNotSent) {
+		fmt.Println(err)
+		return
+	} else if err != nil {
+		log.Fatalf("upload: %v", err)
+	}
 	fmt.Print("What should I do with this file? ")
-	instr, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	instr, _ := stdin.ReadString('\n')
 	instr = strings.TrimSpace(instr)
 
-	sendChat(instr + "\n\n```text\n" + string(content) + "\n```")
+	sendChat(instr + "\n\n" + fenced(lang, text))
 }
 
 var (
@@ -319,20 +537,33 @@ const (
 	modelCreative  = "gpt-4o-mini"
 	modelSummarise = "gpt-4o-mini"
 
+	defaultEmbeddingModel = "text-embedding-3-small"
+
 	contextWindowTokens = 128000 // gpt‑4o context window
 )
 
 const (
-	tagMem   = "<MEMORY>"
-	tagLeft  = "<LEFT>"
-	tagRight = "<RIGHT>"
-	tagEnd   = "</END>"
+	tagMem = "<MEMORY>"
+	tagEnd = "</END>"
 )
 
 type ChatLog struct {
-	Timestamp time.Time `json:"timestamp"`
-	Request   string    `json:"request"`
-	Response  string    `json:"response"`
+	Timestamp time.Time        `json:"timestamp"`
+	Request   string           `json:"request"`
+	Response  string           `json:"response"`
+	Scope     string           `json:"scope,omitempty"`
+	Namespace string           `json:"namespace,omitempty"`
+	Speaker   string           `json:"speaker,omitempty"` // which assistant answered, when several did
+	Citations []Citation       `json:"citations,omitempty"`
+	Grounding *GroundingReport `json:"grounding,omitempty"`
+	Private   bool             `json:"private,omitempty"` // kept out of summaries; see private.go
+
+	// Condensed versions of over-long turns for the history; see turncap.go.
+	RequestDigest  string `json:"request_digest,omitempty"`
+	ResponseDigest string `json:"response_digest,omitempty"`
+
+	ResponseID string `json:"response_id,omitempty"` // provider-side history; see responses.go
+	Tone       string `json:"tone,omitempty"`        // adaptive tone in effect; see tone.go
 }
 
 type State struct {
@@ -345,11 +576,102 @@ type Config struct {
 	AIName      string `json:"ai_name"`
 	Bio         string `json:"bio"`
 	Personality string `json:"personality"`
+	Provider    string `json:"provider,omitempty"` // chat backend; empty = OpenAI, see provider.go
+
+	Anthropic  AnthropicConfig  `json:"anthropic,omitempty"`  // Claude models; see anthropic.go
+	Ollama     OllamaConfig     `json:"ollama,omitempty"`     // local models; see ollama.go
+	Bedrock    BedrockConfig    `json:"bedrock,omitempty"`    // models in AWS; see bedrock.go
+	OpenRouter OpenRouterConfig `json:"openrouter,omitempty"` // model fallback; see openrouter.go
+
+	ModelAliases map[string]string `json:"model_aliases,omitempty"` // "fast": "gpt-4o-mini"; see models.go
+
+	Server       ServerConfig              `json:"server,omitempty"`
+	MemoryScopes map[string]ScopeRetrieval `json:"memory_scopes,omitempty"`
+	Audit        AuditConfig               `json:"audit,omitempty"`
+	Budget       BudgetConfig              `json:"budget,omitempty"`
+	Index        IndexConfig               `json:"index,omitempty"`
+	Ignore       []string                  `json:"ignore,omitempty"` // extra ignore globs, see ignore.go
+
+	// MirrorLanguage answers in the language the prompt was written in;
+	// PreferredLanguage is used otherwise (e.g. "German").
+	MirrorLanguage    bool   `json:"mirror_language,omitempty"`
+	PreferredLanguage string `json:"preferred_language,omitempty"`
+
+	// TimeContext (default on) tells the model the local time and locale;
+	// Timezone and Locale override the environment's.
+	TimeContext *bool  `json:"time_context,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+
+	// EnvContext tells the model the working directory, shell, git state
+	// and last failed command; see envcontext.go.
+	EnvContext bool `json:"env_context,omitempty"`
+
+	// DayRolloverHour (0-23) is when a new daily log starts; see logDay.
+	DayRolloverHour int `json:"day_rollover_hour,omitempty"`
+
+	Namespace string `json:"namespace,omitempty"` // default namespace
+
+	// EmbeddingModel is what memories and the index were embedded with.
+	// Change it with `go-chat memory reembed -model`, not by hand.
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+
+	// QuantizeEmbeddings stores memory embeddings as int8; see quantize.go.
+	QuantizeEmbeddings bool `json:"quantize_embeddings,omitempty"`
+
+	ANN ANNConfig `json:"ann,omitempty"` // see ann.go
+
+	ExitSummary string `json:"exit_summary,omitempty"` // ask, auto or never; see session.go
+	LogPrivate  *bool  `json:"log_private,omitempty"`  // log !private exchanges (default on); see private.go
+	AutoSnip    bool   `json:"auto_snip,omitempty"`    // file every answer's code blocks; see snip.go
+
+	// UploadMaxTokens is the largest -f upload sent whole (default
+	// 20000); bigger files are summarised first, see upload.go.
+	UploadMaxTokens int  `json:"upload_max_tokens,omitempty"`
+	Resume          bool `json:"resume,omitempty"` // carry over the previous day; see resume.go
+
+	Flows    map[string]Flow `json:"flows,omitempty"`    // see flow.go
+	Tracking TrackingConfig  `json:"tracking,omitempty"` // check-in questions; see track.go
+
+	JournalDir string `json:"journal_dir,omitempty"` // default ~/.go-chat-journal; see journal.go
+
+	SystemTemplate string        `json:"system_template,omitempty"` // template file for the system prompt; see systemprompt.go
+	Context        ContextConfig `json:"context,omitempty"`         // what survives trimming; see contextbudget.go
+	HistoryBackend string        `json:"history_backend,omitempty"` // local (default) or responses; see responses.go
+	AdaptiveTone   bool          `json:"adaptive_tone,omitempty"`   // be concise with a frustrated user; see tone.go
+	ContextFooter  bool          `json:"context_footer,omitempty"`  // as -show-context; see footer.go
+	LintContext    string        `json:"lint_context,omitempty"`    // as -lint; see lint.go
+	Calculator     *bool         `json:"calculator,omitempty"`      // the calculate tool (default on); see calc.go
+
+	Changelog ChangelogConfig `json:"changelog,omitempty"` // see changelog.go
+	GitHub    GitHubConfig    `json:"github,omitempty"`    // see gh.go
+
+	CheckInSchedule string        `json:"checkin_schedule,omitempty"` // cron expression for daemon check-ins; see cron.go
+	ActiveHours     string        `json:"active_hours,omitempty"`     // when the daemon may speak, e.g. 09:00-18:00; see dnd.go
+	ActiveDays      string        `json:"active_days,omitempty"`      // e.g. mon-fri; see dnd.go
+	Weather         WeatherConfig `json:"weather,omitempty"`          // forecast tool and check-in briefing; see weather.go
+
+	Contacts map[string]Contact `json:"contacts,omitempty"` // shorthand for people; see contacts.go
+
+	Fusion FusionConfig `json:"fusion,omitempty"` // branches for -fusion; see fusion.go
+
+	Persona  string             `json:"persona,omitempty"` // installed persona pack
+	Profile  string             `json:"profile,omitempty"` // default profile name
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	Pinned     []string `json:"pinned,omitempty"` // files sent with every prompt; see project.go
+	pinnedRoot string   // set when the project file pinned them
+
+	Plugins []string `json:"plugins,omitempty"` // go-chat-<name> executables to enable; see plugins.go
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+
+	Usage *TokenUsage `json:"-"` // reported by the provider, if it does
 }
 
 var (
@@ -361,19 +683,10 @@ var (
 )
 
 func init() {
-	if apiKey == "" {
-		log.Fatal("OPENAI_API_KEY env missing")
-	}
 	if apiURL == "" {
 		apiURL = defaultAPIBase
 	}
 
-	var err error
-	encoder, err = tiktoken.EncodingForModel(modelExec)
-	if err != nil {
-		log.Fatalf("tokeniser: %v", err)
-	}
-
 	usr, err := user.Current()
 	if err != nil {
 		log.Fatalf("user.Current(): %v", err)
@@ -382,15 +695,92 @@ func init() {
 	logDirPath = filepath.Join(homeDir, ".go-chat-logs")
 	stateFilePath = filepath.Join(homeDir, ".go-chat-state")
 	configFilePath = filepath.Join(homeDir, ".go-chat-config")
+	assetsDirPath = filepath.Join(homeDir, ".go-chat-assets")
+	wasmPluginsDirPath = filepath.Join(homeDir, ".go-chat-plugins")
+	pluginCacheFilePath = filepath.Join(homeDir, ".go-chat-plugin-cache.json")
+	personasDirPath = filepath.Join(homeDir, ".go-chat-personas")
+	tokensFilePath = filepath.Join(homeDir, ".go-chat-tokens")
+	auditFilePath = filepath.Join(homeDir, ".go-chat-audit.jsonl")
+	spendFilePath = filepath.Join(homeDir, ".go-chat-spend.json")
+	indexFilePath = filepath.Join(homeDir, ".go-chat-index.json")
+	batchesFilePath = filepath.Join(homeDir, ".go-chat-batches.json")
+	lastFailFilePath = filepath.Join(homeDir, ".go-chat-lastfail")
+	daemonFilePath = filepath.Join(homeDir, ".go-chat-daemon.json")
+	deferredFilePath = filepath.Join(homeDir, ".go-chat-deferred.json")
 
 	if err := os.MkdirAll(logDirPath, 0o755); err != nil {
 		log.Fatalf("mkdir logs: %v", err)
 	}
 
-	httpClient = &http.Client{Timeout: 30 * time.Second}
+	httpClient = &http.Client{
+		Timeout:   defaultRequestTimeout,
+		Transport: auditTransport{base: http.DefaultTransport},
+	}
+}
+
+// subcommands are dispatched on the first argument before flag parsing;
+// anything else is treated as flags plus a prompt. Their names are
+// reserved as a prompt's first word: `go-chat -- log the error above`
+// sends everything after -- as the prompt.
+var subcommands = map[string]func(args []string){
+	"assets":     runAssets,
+	"plugins":    runPlugins,
+	"serve":      runServe,
+	"token":      runToken,
+	"audit":      runAudit,
+	"index":      runIndex,
+	"gen":        runGen,
+	"notebook":   runNotebook,
+	"repo":       runRepo,
+	"persona":    runPersona,
+	"task":       runTask,
+	"memory":     runMemory,
+	"log":        runLog,
+	"bookmarks":  runBookmarks,
+	"snip":       runSnip,
+	"logs":       runLogs,
+	"data":       runData,
+	"sql":        runSQL,
+	"flow":       runFlow,
+	"track":      runTrack,
+	"journal":    runJournal,
+	"wipe":       runWipe,
+	"retry-last": runRetryLast,
+	"edit":       runEdit,
+	"changelog":  runChangelog,
+	"gh":         runGH,
+	"regex":      runRegex,
+	"jq":         runJQ,
+	"cron":       runCron,
+	"batch":      runBatch,
+	"shell-hook": runShellHook,
+	"dnd":        runDND,
+	"models":     runModels,
+	"daemon":     runDaemon,
+}
+
+// usage lists the subcommands too, since a prompt can't start with one.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: go-chat [flags] [--] prompt...")
+	fmt.Fprintln(out, "       go-chat SUBCOMMAND [args]")
+	fmt.Fprintf(out, "\nsubcommands (and any go-chat-NAME plugin on PATH):\n  %s\n", strings.Join(slices.Sorted(maps.Keys(subcommands)), " "))
+	fmt.Fprintln(out, "\nA prompt that starts with one of these words needs -- first: go-chat -- log the error above")
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] != "--" {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+		if p, ok := findPlugin(os.Args[1]); ok {
+			os.Exit(runPluginCommand(p, os.Args[2:]))
+		}
+	}
+
 	useFusion = flag.Bool("fusion", false, "Use multi-model fusion mode")
 	clearLog := flag.Bool("c", false, "Clear chat log")
 	personality := flag.String("p", "", "Set AI personality")
@@ -403,7 +793,48 @@ func main() {
 	setUser := flag.String("u", "", "Set user name")
 	setAI := flag.String("ai", "", "Set AI name")
 	setBio := flag.String("b", "", "Set bio")
+	flag.StringVar(&profileName, "profile", "", "Use a named profile from the config")
+	flag.StringVar(&providerFlag, "provider", "", "Chat backend to use instead of the config's: openai, anthropic, ollama, bedrock, openrouter or a provider plugin")
+	flag.BoolVar(&forceBudget, "force", false, "Send requests even if they break a budget limit")
+	flag.BoolVar(&assumeYes, "y", false, "Send large prompts without showing their cost first")
+	flag.StringVar(&personaName, "persona", "", "Use an installed persona pack")
+	flag.BoolVar(&usePager, "pager", false, "Show answers in $PAGER instead of streaming them")
+	flag.BoolVar(&quietMode, "q", false, "Print nothing but the answer on stdout, for pipelines")
+	flag.StringVar(&inputFormat, "input-format", "", "Read piped stdin as text (added to the prompt) or json (a messages array)")
+	flag.StringVar(&outputFile, "o", "", "Write the answer to this file instead of stdout: .json, .txt, or as written (.md)")
+	flag.BoolVar(&overwriteOutput, "overwrite", false, "Let -o replace an existing file")
+	flag.BoolVar(&groundedMode, "grounded", false, "Check answers against the indexed sources and flag unsupported claims")
+	flag.StringVar(&activeNamespace, "ns", "", "Memory namespace (topic) for this session")
+	flag.BoolVar(&searchAllNamespaces, "all-ns", false, "Retrieve memories from every namespace")
+	flag.BoolVar(&resumeMode, "resume", false, "Continue the previous day's conversation")
+	flag.BoolVar(&autoSession, "auto-session", false, "Switch namespace automatically when a prompt starts a new topic")
+	flag.BoolVar(&fixLoop, "fix-loop", false, "Build and vet Go code in the answer and have the model fix it until it compiles")
+	flag.IntVar(&fixRounds, "fix-rounds", defaultFixRounds, "Attempts for -fix-loop and -diff")
+	flag.IntVar(&sampleCount, "samples", 0, "Sample N answers and return the one most agree on")
+	flag.IntVar(&seedFlag, "seed", -1, "Seed to send with every request, where the provider supports it")
+	flag.BoolVar(&ephemeral, "ephemeral", false, "Keep this session in memory only: no logs, memories or summaries")
+	flag.BoolVar(&deterministic, "deterministic", false, "Reproducible answers: temperature 0, a fixed seed, no history and a cached system prompt")
+	flag.DurationVar(&requestTimeout, "timeout", defaultRequestTimeout, "Give up on a request to the model after this long (0 for no limit)")
+	flag.DurationVar(&firstTokenTimeout, "first-token-timeout", 0, "Give up on a streamed answer that hasn't started after this long")
+	flag.StringVar(&answerLength, "length", "", "Answer length: short, normal or detailed")
+	flag.BoolVar(&envContextFlag, "env", false, "Tell the model the working directory, shell, git status and last failed command")
+	flag.BoolVar(&showContext, "show-context", false, "List the memories and documents used under each answer")
+	flag.StringVar(&diffFiles, "diff", "", "Answer only with a unified diff against these comma-separated files, checked to apply")
+	flag.BoolVar(&verboseTools, "v", false, "Print each tool call the model makes and its result")
+	flag.StringVar(&lintMode, "lint", "", "Check the memories for a prompt before sending it: similarity, model or off")
+	flag.Usage = usage
 	flag.Parse()
+	if err := validLength(answerLength); err != nil {
+		log.Fatal(err)
+	}
+	httpClient.Timeout = requestTimeout
+
+	if activeNamespace == "" {
+		activeNamespace = getConfig().Namespace
+	}
+	if err := validNamespace(activeNamespace); err != nil {
+		log.Fatal(err)
+	}
 
 	switch {
 	case *clearLog:
@@ -422,27 +853,45 @@ func main() {
 		enterInteractiveMode()
 		return
 	case *daemon:
+		recoverMemories()
 		runAsDaemon()
 		return
 	case *toggle:
 		toggleCheckInFeature()
 		return
 	case *upload != "":
+		recoverMemories()
 		promptUserForInstructions(*upload)
 		return
 	}
 
-	if args := flag.Args(); len(args) > 0 {
-		sendChat(strings.Join(args, " "))
-	} else {
-		fmt.Println("No prompt given. Use -h.")
+	userPrompt, context, err := pipeInput(strings.Join(flag.Args(), " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+	if strings.TrimSpace(userPrompt) == "" {
+		fmt.Fprintln(os.Stderr, "No prompt given. Use -h.")
+		os.Exit(exitUsage)
+	}
+	if outputFile != "" {
+		if err := checkOutputFile(outputFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ephemeral {
+		ephemeralNotice()
+	}
+	recoverMemories()
+	if err := sendChatWith(userPrompt, chatOptions{Messages: context}); errors.Is(err, errNotSent) {
+		os.Exit(exitNotSent)
 	}
 }
 
 func trimHistory(hist []Message, limit int) []Message {
-	total := 0
+	total, counter := 0, configuredProvider()
 	for i := len(hist) - 1; i >= 0; i-- {
-		total += tokensMsg(hist[i])
+		total += tokensMsgFor(counter, hist[i])
 		if total > limit {
 			return hist[i+1:]
 		}
@@ -450,16 +899,25 @@ func trimHistory(hist []Message, limit int) []Message {
 	return hist
 }
 
-func buildHistory(system, latest string) []Message {
-	hist := trimHistory(getChatHistory(), contextWindowTokens-2048)
+// chatHistory is the untrimmed history for a turn: the -resume context
+// and the day's turns.
+func chatHistory(scope, ns string) []Message {
+	return append(resumeContext(scope, ns), getChatHistory(scope, ns)...)
+}
 
+func buildHistory(system, latest string, hist []Message) []Message {
 	return append(
 		[]Message{{Role: "system", Content: system}},
 		append(hist, Message{Role: "user", Content: latest})...,
 	)
 }
 
-func summarizeDayLogs() {
+// summarizeDayLogs summarises today's conversation in one memory scope and
+// stores the summary in that scope.
+func summarizeDayLogs(scope, ns string) {
+	if ephemeral {
+		return
+	}
 	p := dailyLogPath()
 
 	data, err := os.ReadFile(p)
@@ -472,61 +930,358 @@ func summarizeDayLogs() {
 	}
 
 	var msgs []Message
-	for _, l := range logs {
+	for _, l := range sharedLogs(logs) {
+		if normScope(l.Scope) != normScope(scope) || !inNamespace(l.Namespace, ns) {
+			continue
+		}
 		msgs = append(msgs, Message{Role: "user", Content: l.Request})
 		msgs = append(msgs, Message{Role: "assistant", Content: l.Response})
 	}
+	if len(msgs) == 0 {
+		return
+	}
 
-	summary := queryGPT(
+	summary, err := queryGPTStream(
 		modelSummarise,
-		"Summarize this conversation to preserve key facts, decisions, tone, and ongoing themes.",
-		0.4, 512, msgs, false,
+		prompt("summarize-day"),
+		0.4, 512, msgs, nil,
 	)
+	if err != nil {
+		log.Printf("summarise: %v", err)
+		return
+	}
 
-	saveVectorMemory(summary)
+	saveDaySummary(logDay(time.Now()), scope, ns, summary)
+	saveVectorMemory(summary, scope, ns)
 }
 
+// sendChat answers a prompt on the terminal: streamed and wrapped to the
+// terminal width, or in the pager with -pager. With -fix-loop the answer
+// is only shown once its Go code builds.
 func sendChat(userPrompt string) {
-	cfg := getConfig()
-	relevant := getRelevantMemories(userPrompt, 3)
-	memories := strings.Join(relevant, "\n\n")
+	sendChatWith(userPrompt, chatOptions{})
+}
 
-	system := fmt.Sprintf(
-		"You are %s. User = %s. Bio: %s. Personality: %s.\nYour relevant memories:\n%s",
-		cfg.AIName, cfg.UserName, cfg.Bio, cfg.Personality, memories,
-	)
+// sendChatWith is sendChat for a turn with its speaker already chosen (see
+// party.go); answers with a Speaker are labelled with it. It returns
+// errNotSent if the user declined to send the prompt.
+func sendChatWith(userPrompt string, opts chatOptions) error {
+	activeNamespace = checkTopic(userPrompt)
+	opts.Grounded, opts.Namespace, opts.AllNamespaces = groundedMode, activeNamespace, searchAllNamespaces
+	opts.ConfirmLarge, opts.RecordFailure = true, true
+	opts.Tone = observeTone(userPrompt)
+	opts.Length = cmp.Or(opts.Length, answerLength)
+	label := ""
+	if opts.Speaker != "" && !quietMode {
+		label = "[" + opts.Speaker + "] "
+	}
+	if fixLoop {
+		opts.Revise = func(answer string) string {
+			return fixGoCode(userPrompt, answer, fixRounds)
+		}
+	}
+	if diffFiles != "" {
+		files, err := diffTargets()
+		if err != nil {
+			log.Fatalf("diff: %v", err)
+		}
+		userPrompt = diffPrompt(userPrompt, files)
+		opts.Revise = func(answer string) string {
+			return reviseDiff(userPrompt, answer, files, fixRounds)
+		}
+	}
 
-	if !*useFusion {
-		msgs := buildHistory(system, userPrompt)
-		answer := queryGPT(modelExec, system, 0.6, 1024, msgs, true)
-		if err := appendLog(userPrompt, answer); err != nil {
-			log.Printf("append log: %v", err)
+	if usePager || fixLoop || diffFiles != "" || outputFile != "" {
+		answer, err := respond(userPrompt, opts)
+		if errors.Is(err, errNotSent) {
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		} else if err != nil {
+			return chatFailed(err)
 		}
+		lastAnswer = answer
+		defer autoSnip(userPrompt, answer)
+		if outputFile != "" {
+			if err := writeOutput(outputFile, userPrompt, answer, opts.Speaker); err != nil {
+				log.Fatalf("-o: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "answer written to %s\n", outputFile)
+			printContextFooter()
+			return nil
+		}
+		if usePager {
+			page(label + answer)
+			printContextFooter()
+			return nil
+		}
+		if diffFiles == "" {
+			answer = termWrapper().finish(label + answer)
+		}
+		fmt.Print(answer)
+		if !strings.HasSuffix(answer, "\n") {
+			fmt.Println()
+		}
+		printContextFooter()
+		return nil
+	}
 
-		summarizeDayLogs()
+	// The label is printed with the first token, so that nothing precedes
+	// a large-prompt confirmation.
+	tw := termWrapper()
+	emit := tw.wrap(printToken)
+	color := answerColor(opts.Persona)
+	if quietMode {
+		color = ""
+	}
+	started := false
+	opts.OnToken = func(s string) {
+		if !started {
+			started = true
+			fmt.Print(color + label)
+		}
+		emit(s)
+	}
+	answer, err := respond(userPrompt, opts)
+	if errors.Is(err, errNotSent) {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	} else if err != nil {
+		return chatFailed(err)
+	}
+	tw.finish(answer)
+	if color != "" && started {
+		fmt.Print("\033[0m")
+	}
+	lastAnswer = answer
+	defer autoSnip(userPrompt, answer)
+	if !strings.HasSuffix(answer, "\n") {
+		fmt.Println()
+	}
+	printContextFooter()
+	return nil
+}
 
-		return
+// chatOptions carries the per-turn settings that differ between callers.
+type chatOptions struct {
+	// MemoryScope decides which history and memories the turn can see and
+	// where it writes; empty means private (the owner).
+	MemoryScope string
+	// OnToken receives the final answer as it streams; nil disables
+	// streaming.
+	OnToken func(string)
+	// Grounded checks the answer's claims against the indexed sources it
+	// drew on.
+	Grounded bool
+	// Namespace is the topic the turn belongs to; history and memories
+	// from other namespaces stay out unless AllNamespaces is set, which
+	// widens memory retrieval (not history) to every namespace.
+	Namespace     string
+	AllNamespaces bool
+	// Revise, if set, may rewrite the finished answer before it is logged.
+	// Only useful without OnToken, since streamed text can't be taken back.
+	Revise func(answer string) string
+	// Persona answers as an installed persona rather than the configured
+	// assistant. When several assistants share a conversation, Speaker is
+	// the one answering (logged with the turn) and Others the rest.
+	Persona string
+	Speaker string
+	Others  []string
+	// ConfirmLarge asks on the terminal before sending a prompt over the
+	// budget's confirm_above_tokens.
+	ConfirmLarge bool
+	// Private keeps the turn out of summaries and memories (see
+	// private.go); a "!private" prompt sets it too.
+	Private bool
+	// Length is short, normal or detailed; see length.go.
+	Length string
+	// Tone adjusts the persona for the turn; see tone.go.
+	Tone string
+	// RecordFailure keeps the request for `go-chat retry-last` if it
+	// fails (see failures.go).
+	RecordFailure bool
+	// ResponseID is set by respond when the answer is stored by the
+	// provider (see responses.go), to be logged with the turn.
+	ResponseID string
+	// Messages are sent between the history and the prompt, such as a
+	// conversation piped in with -input-format json (see pipe.go).
+	Messages []Message
+}
+
+// respond runs one turn as the configured assistant: memories, history,
+// optional fusion, then logging and summarising. The final answer is
+// streamed to opts.OnToken.
+func respond(userPrompt string, opts chatOptions) (string, error) {
+	if p, ok := cutPrivate(userPrompt); ok {
+		userPrompt, opts.Private = p, true
+	}
+	cfg := getConfig()
+	scope := normScope(opts.MemoryScope)
+	prof := currentProfile(cfg)
+	pp, err := newPostProcessor(prof)
+	if err != nil {
+		return "", err
+	}
+	onToken := pp.wrap(opts.OnToken)
+	params := queryParams{Stop: prof.Stop}
+	ns := opts.Namespace
+	searchNS := ns
+	if opts.AllNamespaces {
+		searchNS = allNamespaces
+	}
+	relevant := getRelevantMemories(userPrompt, scope, searchNS)
+
+	persona := currentPersona(cfg)
+	if opts.Persona != "" {
+		if persona, err = loadPersona(opts.Persona); err != nil {
+			return "", err
+		}
+	}
+	personality, model, temp := cfg.Personality, modelExec, 0.6
+	if persona != nil {
+		personality, model = persona.System, cmp.Or(persona.Model, model)
+		if persona.Temperature != nil {
+			temp = *persona.Temperature
+		}
+	}
+
+	if opts.Tone != "" {
+		personality += "\n" + prompt("tone-"+opts.Tone)
+	}
+
+	ctx := fitContext(cfg.Context, contextParts{
+		Bio:         expandPromptVars(cfg.Bio),
+		Personality: expandPromptVars(personality),
+		Memories:    relevant,
+		Sources:     retrieveSources(userPrompt, scope),
+		History:     chatHistory(scope, ns),
+		Latest:      userPrompt,
+	})
+	if opts.ConfirmLarge {
+		ctx.Memories = lintContext(cfg, userPrompt, ctx.Memories)
+	}
+	lastMemories, lastSources = ctx.Memories, ctx.Sources
+	texts := make([]string, len(ctx.Memories))
+	for i, m := range ctx.Memories {
+		texts[i] = m.Text
+	}
+	sources := ctx.Sources
+	var maxTok int
+	data := systemData{
+		Name:        cmp.Or(opts.Speaker, cfg.AIName),
+		User:        cfg.UserName,
+		Bio:         ctx.Bio,
+		Personality: ctx.Personality,
+		Memories:    strings.Join(texts, "\n\n"),
+		MemoryList:  texts,
+		Others:      opts.Others,
+		Language:    languageInstruction(cfg, userPrompt),
+		Time:        timeContext(cfg),
+		Environment: envContext(cfg),
+		Contacts:    contactsContext(cfg, userPrompt),
+		Pinned:      pinnedContext(cfg),
+	}
+	maxTok, data.Length = lengthPreset(opts.Length)
+	if len(sources) > 0 {
+		data.Sources = sourcesPrompt(sources)
+	}
+	system := renderSystemPrompt(cfg, data)
+
+	history := buildHistory(system, userPrompt, slices.Concat(ctx.History, opts.Messages))
+	if deterministic {
+		key := strings.Join([]string{userPrompt, opts.Speaker, opts.Persona, cfg.Persona, personaName, profileName, scope, ns}, "\x00")
+		system = stableSystemPrompt(memoryID(key), system)
+		history = buildHistory(system, userPrompt, opts.Messages)
+	}
+	msgs := withExamples(history, append(prof.exampleMessages(), persona.examples()...))
+	if opts.ConfirmLarge {
+		if err := confirmLargePrompt(cfg, model, system, msgs, maxTok); err != nil {
+			return "", err
+		}
 	}
 
-	// Fusion path (as-is)
-	mem := queryGPT(modelSummarise, "Summarise the dialogue so far.", 0.4, 512, buildHistory(system, userPrompt), false)
+	if !*useFusion {
+		ask := queryGPTWith
+		if usesResponses(cfg, opts) {
+			ask = askResponses(lastResponseID(scope, ns), &opts.ResponseID)
+		}
+		if sampleCount > 1 {
+			ask = func(model, system string, temp float64, _ int, msgs []Message, onToken func(string), params queryParams) (string, error) {
+				return sampleAnswer(model, system, temp, msgs, userPrompt, onToken, params)
+			}
+		}
+		answer, err := ask(model, system, temp, maxTok, msgs, onToken, params)
+		if err != nil {
+			if opts.RecordFailure {
+				recordFailure(FailedRequest{Prompt: userPrompt, Model: model, System: system, Temperature: temp, MaxTokens: maxTok,
+					Messages: msgs, Scope: scope, Namespace: ns, Speaker: opts.Speaker, Private: opts.Private}, err)
+			}
+			return "", err
+		}
+		answer = pp.finish(answer)
+		if opts.Revise != nil {
+			answer = opts.Revise(answer)
+		}
+		answer = finishTurn(userPrompt, answer, scope, sources, opts)
 
-	leftMsgs := []Message{{Role: "system", Content: tagMem + mem + tagEnd}, {Role: "user", Content: userPrompt}}
+		if !opts.Private {
+			summarizeDayLogs(scope, ns)
+		}
 
-	left := queryGPT(modelLogic, "Answer logically.", 0.2, 512, leftMsgs, false)
-	right := queryGPT(modelCreative, "Answer creatively.", 0.9, 512, leftMsgs, false)
+		return answer, nil
+	}
 
-	execMsgs := []Message{
-		{Role: "system", Content: system},
-		{Role: "system", Content: fmt.Sprintf("%s%s%s%s%s%s%s", tagMem, mem, tagLeft, left, tagRight, right, tagEnd)},
-		{Role: "user", Content: userPrompt},
+	// Fusion: the history summary goes to every branch (see fusion.go).
+	memModel, memProvider := cfg.Fusion.role(roleMemory, modelSummarise)
+	mem, err := queryGPTWith(memModel, prompt("fusion-memory"), 0.4, 512, buildHistory(system, userPrompt, ctx.History), nil, queryParams{Provider: memProvider})
+	if err != nil {
+		return "", err
+	}
+	answer, err := fuse(cfg, system, mem, userPrompt, onToken, params)
+	if err != nil {
+		return "", err
 	}
+	answer = pp.finish(answer)
+	if opts.Revise != nil {
+		answer = opts.Revise(answer)
+	}
+	return finishTurn(userPrompt, answer, scope, sources, opts), nil
+}
 
-	answer := queryGPT(modelExec, "Combine the information inside the tags into one balanced answer.", 0.55, 1024, execMsgs, true)
+// finishTurn logs a finished answer and returns it with the source list
+// and grounding report appended, streaming those after the answer text
+// when the turn streams.
+func finishTurn(userPrompt, answer, scope string, sources []IndexChunk, opts chatOptions) string {
+	entry := ChatLog{Request: userPrompt, Response: answer, Scope: scope, Namespace: storedNamespace(opts.Namespace), Speaker: opts.Speaker, Private: opts.Private, ResponseID: opts.ResponseID, Tone: opts.Tone}
+	entry.Citations = citedSources(answer, sources)
+
+	var notes []string
+	if len(entry.Citations) > 0 {
+		notes = append(notes, renderCitations(entry.Citations))
+	}
+	if opts.Grounded {
+		report, err := checkGrounding(answer, sources)
+		if err != nil {
+			log.Printf("grounding: %v", err)
+		} else {
+			entry.Grounding = report
+			notes = append(notes, report.render())
+		}
+	}
 
-	if err := appendLog(userPrompt, answer); err != nil {
-		log.Printf("append log: %v", err)
+	if !opts.Private || logPrivate(getConfig()) {
+		entry.RequestDigest, entry.ResponseDigest = digestTurn(userPrompt), digestTurn(answer)
+		if err := appendLog(entry); err != nil {
+			log.Printf("append log: %v", err)
+		}
+	}
+	if len(notes) == 0 {
+		return answer
+	}
+	extra := "\n\n" + strings.Join(notes, "\n\n") + "\n"
+	if opts.OnToken != nil {
+		opts.OnToken(extra)
 	}
+	return answer + extra
 }
 
 var promptFilePath string
@@ -595,13 +1350,16 @@ func init() {
 	}
 }
 
-func getChatHistory() []Message {
-	var msgs []Message
-
+// getChatHistory returns today's exchanges from one memory scope only, so
+// conversations don't see each other's history.
+func getChatHistory(scope, ns string) []Message {
+	if ephemeral {
+		return historyMessages(ephemeralLog, scope, ns)
+	}
 	data, err := os.ReadFile(dailyLogPath())
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return msgs // no history yet
+			return nil // no history yet
 		}
 		log.Fatalf("read chat log: %v", err)
 	}
@@ -610,11 +1368,23 @@ func getChatHistory() []Message {
 	if err := json.Unmarshal(data, &logs); err != nil {
 		log.Fatalf("parse chat log: %v", err)
 	}
+	return historyMessages(logs, scope, ns)
+}
 
+// historyMessages turns one day's log into chat turns for scope and ns.
+func historyMessages(logs []ChatLog, scope, ns string) []Message {
+	var msgs []Message
 	for _, l := range logs {
+		if normScope(l.Scope) != normScope(scope) || !inNamespace(l.Namespace, ns) {
+			continue
+		}
+		resp := cappedTurn(l.Response, l.ResponseDigest)
+		if l.Speaker != "" {
+			resp = "[" + l.Speaker + "] " + resp
+		}
 		msgs = append(msgs,
-			Message{Role: "user", Content: l.Request},
-			Message{Role: "assistant", Content: l.Response},
+			Message{Role: "user", Content: cappedTurn(l.Request, l.RequestDigest)},
+			Message{Role: "assistant", Content: resp},
 		)
 	}
 	return msgs
@@ -622,62 +1392,128 @@ func getChatHistory() []Message {
 
 type VectorMemory struct {
 	Text      string    `json:"text"`
-	Embedding []float32 `json:"embedding"`
+	Embedding []float32 `json:"embedding,omitempty"`
+	Scope     string    `json:"scope,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+
+	// Q8 and Scale replace Embedding on disk when quantizing (quantize.go).
+	Q8    []byte  `json:"q8,omitempty"`
+	Scale float32 `json:"scale,omitempty"`
 }
 
 const vectorStorePath = ".go-chat-memory-vectors.json"
 
-func embedText(text string) ([]float32, error) {
-	payload := map[string]any{
-		"model": "text-embedding-3-small",
-		"input": text,
+func embeddingModel() string {
+	if m := getConfig().EmbeddingModel; m != "" {
+		return expandModel(m)
 	}
+	if p, err := activeProvider(); err == nil {
+		if e, ok := p.(embeddingModels); ok {
+			return e.EmbeddingModel()
+		}
+	}
+	return defaultEmbeddingModel
+}
 
-	body, _ := json.Marshal(payload)
-	req, _ := http.NewRequest("POST", apiURL+"/v1/embeddings", bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	resp, err := httpClient.Do(req)
+func embedText(text string) ([]float32, error) {
+	vecs, err := embedTexts(embeddingModel(), []string{text})
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return vecs[0], nil
+}
 
-	var out struct {
-		Data []struct {
-			Embedding []float32 `json:"embedding"`
-		} `json:"data"`
+// embedTexts embeds a batch of texts in one request, in order.
+func embedTexts(model string, texts []string) ([][]float32, error) {
+	if dir, ok := localModelDir(model); ok {
+		return embedLocal(dir, texts)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+	p, err := activeProvider()
+	if err != nil {
 		return nil, err
 	}
-	if len(out.Data) == 0 {
-		return nil, errors.New("no embeddings returned")
-	}
-	return out.Data[0].Embedding, nil
+	return p.Embed(model, texts)
 }
 
-func saveVectorMemory(text string) {
+// saveVectorMemory embeds and stores a memory, journalling it first (see
+// memwal.go) so it survives a crash or a failed embedding call.
+func saveVectorMemory(text, scope, ns string) {
+	if ephemeral {
+		return
+	}
+	rec := newWALRecord(text, scope, ns)
+	journaled := true
+	if err := journalMemory(rec); err != nil {
+		log.Printf("memory journal: %v", err)
+		journaled = false
+	}
+
 	vec, err := embedText(text)
 	if err != nil {
-		log.Printf("embedding error: %v", err)
+		log.Printf("embedding error: %v (will retry next run)", err)
 		return
 	}
 
-	p := filepath.Join(homeDir, vectorStorePath)
-	var store []VectorMemory
+	if journaled {
+		if err := commitMemories([]walRecord{rec}, [][]float32{vec}); err != nil {
+			log.Printf("save memory: %v", err)
+		}
+		return
+	}
+	defer lockFile(vectorStorePath)()
+	store := loadVectorStore()
+	store = append(store, VectorMemory{Text: text, Embedding: vec, Scope: scope, Namespace: storedNamespace(ns)})
+	saveVectorStore(store)
+}
 
-	if data, err := os.ReadFile(p); err == nil {
+func loadVectorStore() []VectorMemory {
+	var store []VectorMemory
+	if data, err := os.ReadFile(filepath.Join(homeDir, vectorStorePath)); err == nil {
 		_ = json.Unmarshal(data, &store)
 	}
+	for i, m := range store {
+		if m.Q8 != nil {
+			store[i].Embedding = dequantize(m.Q8, m.Scale)
+			store[i].Q8, store[i].Scale = nil, 0
+		}
+	}
+	return store
+}
 
-	store = append(store, VectorMemory{Text: text, Embedding: vec})
-	data, _ := json.MarshalIndent(store, "", "  ")
-	_ = os.WriteFile(p, data, 0644)
+func saveVectorStore(store []VectorMemory) error {
+	onDisk := store
+	if getConfig().QuantizeEmbeddings {
+		onDisk = make([]VectorMemory, len(store))
+		for i, m := range store {
+			m.Q8, m.Scale = quantize(m.Embedding)
+			m.Embedding = nil
+			onDisk[i] = m
+		}
+	}
+	data, err := json.MarshalIndent(onDisk, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(homeDir, vectorStorePath), data, 0o644); err != nil {
+		return err
+	}
+	syncANN(store, false)
+	return nil
 }
 
+// memoryID is a short stable identifier derived from the memory text, so
+// memories can be addressed without storing ids.
+func memoryID(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:6])
+}
+
+// cosineSim is 0 for vectors of different lengths, which come from
+// different embedding models and can't be compared.
 func cosineSim(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
 	var sum, normA, normB float64
 	for i := range a {
 		sum += float64(a[i] * b[i])
@@ -690,35 +1526,38 @@ func cosineSim(a, b []float32) float64 {
 	return sum / (math.Sqrt(normA) * math.Sqrt(normB))
 }
 
-func getRelevantMemories(prompt string, topK int) []string {
+// getRelevantMemories returns the memories most similar to the prompt among
+// those visible from the given scope.
+func getRelevantMemories(prompt, scope, ns string) []VectorMemory {
 	vec, err := embedText(prompt)
 	if err != nil {
 		return nil
 	}
 
-	p := filepath.Join(homeDir, vectorStorePath)
-	var store []VectorMemory
-	if data, err := os.ReadFile(p); err == nil {
-		_ = json.Unmarshal(data, &store)
-	}
-
-	type Scored struct {
-		Text  string
-		Score float64
+	all := loadVectorStore()
+	keep := func(m VectorMemory) bool { return canRead(scope, m.Scope) && inNamespace(m.Namespace, ns) }
+	cfg, want := getConfig(), 0
+	for _, s := range readableScopes(scope) {
+		want += scopeRetrieval(cfg, s).TopK
 	}
-	var scored []Scored
-	for _, mem := range store {
-		score := cosineSim(mem.Embedding, vec)
-		scored = append(scored, Scored{Text: mem.Text, Score: score})
+	if ids, ok := annCandidatesFor(all, vec, keep, want); ok {
+		near := make([]VectorMemory, len(ids))
+		for i, id := range ids {
+			near[i] = all[id]
+		}
+		all = near
 	}
 
-	sort.Slice(scored, func(i, j int) bool {
-		return scored[i].Score > scored[j].Score
-	})
+	var store []VectorMemory
+	for _, m := range all {
+		if inNamespace(m.Namespace, ns) {
+			store = append(store, m)
+		}
+	}
 
-	var top []string
-	for i := 0; i < topK && i < len(scored); i++ {
-		top = append(top, scored[i].Text)
+	var top []VectorMemory
+	for _, m := range rankMemories(store, vec, scope) {
+		top = append(top, m.VectorMemory)
 	}
 	return top
 }
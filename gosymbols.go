@@ -0,0 +1,277 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// For Go repositories, repo ask also resolves the identifiers a question
+// names (`respond`, loadIndex(), Index.upsert, ChunkRule...) with
+// go/packages and hands the model their exact declarations plus a few
+// call sites, rather than hoping whole-file chunks happen to contain them.
+const (
+	maxSymbolSnippets = 8
+	maxSymbolRefs     = 3
+	refContextLines   = 2
+)
+
+// codeIdentRe finds words that look like code rather than prose: backticked,
+// dotted, called, or with inner capitals or underscores.
+var codeIdentRe = regexp.MustCompile("`([A-Za-z_][\\w.]*)`|([A-Za-z_]\\w*(?:\\.[A-Za-z_]\\w*)+)|([A-Za-z_]\\w*)\\(\\)|\\b([a-z]+[A-Z_]\\w*|[A-Z][a-z0-9]+[A-Z_]\\w*)\\b")
+
+// codeIdentifiers returns the bare names the question refers to; for
+// x.Y both parts are kept, since either may be the declaration.
+func codeIdentifiers(question string) map[string]bool {
+	names := map[string]bool{}
+	for _, m := range codeIdentRe.FindAllStringSubmatch(question, -1) {
+		for _, g := range m[1:] {
+			for _, part := range strings.Split(g, ".") {
+				if part != "" {
+					names[part] = true
+				}
+			}
+		}
+	}
+	return names
+}
+
+// goSymbolContext returns definition and reference snippets for the
+// identifiers in the question, or nil when root isn't a Go module or
+// nothing resolves.
+func goSymbolContext(root, question string) []IndexChunk {
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+		return nil
+	}
+	wanted := codeIdentifiers(question)
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	pkgs := checkModule(root)
+	if len(pkgs) == 0 {
+		return nil
+	}
+	fset := pkgs[0].fset
+
+	src := sourceCache{}
+	var defs, refs []IndexChunk
+	found := map[types.Object]bool{}
+	for _, pkg := range pkgs {
+		for id, obj := range pkg.info.Defs {
+			if obj == nil || !wanted[id.Name] || !isDeclared(obj, pkg.types) {
+				continue
+			}
+			found[obj] = true
+			start, end := declRange(pkg.syntax, id)
+			if c, ok := src.snippet(fset, start, end, "definition of "+id.Name); ok {
+				defs = append(defs, c)
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		perObj := map[types.Object]int{}
+		for id, obj := range pkg.info.Uses {
+			if !found[obj] || perObj[obj] >= maxSymbolRefs {
+				continue
+			}
+			perObj[obj]++
+			pos := fset.Position(id.Pos())
+			label := "use of " + id.Name
+			if fn := enclosingFunc(pkg.syntax, id.Pos()); fn != "" {
+				label += " in " + fn
+			}
+			if c, ok := src.lines(pos.Filename, pos.Line-refContextLines, pos.Line+refContextLines, label); ok {
+				refs = append(refs, c)
+			}
+		}
+	}
+
+	byPosition := func(a, b IndexChunk) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.StartLine, b.StartLine))
+	}
+	slices.SortFunc(defs, byPosition)
+	slices.SortFunc(refs, byPosition)
+	out := append(defs, refs...)
+	if len(out) > maxSymbolSnippets {
+		out = out[:maxSymbolSnippets]
+	}
+	return out
+}
+
+type checkedPackage struct {
+	fset   *token.FileSet
+	types  *types.Package
+	info   *types.Info
+	syntax []*ast.File
+}
+
+// checkModule parses the module's packages with go/packages and
+// type-checks them itself, in import order. Packages from outside the
+// module are stood in for by empty ones: that keeps this fast and
+// independent of export data, and only the module's own symbols matter
+// here. The resulting type errors are ignored.
+func checkModule(root string) []*checkedPackage {
+	fset := token.NewFileSet()
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedImports,
+		Dir:  root,
+		Fset: fset,
+	}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil
+	}
+
+	local := map[string]*packages.Package{}
+	for _, p := range pkgs {
+		local[p.PkgPath] = p
+	}
+	done := map[string]*types.Package{}
+	var out []*checkedPackage
+
+	var check func(p *packages.Package) *types.Package
+	check = func(p *packages.Package) *types.Package {
+		if tp, ok := done[p.PkgPath]; ok {
+			return tp
+		}
+		done[p.PkgPath] = types.NewPackage(p.PkgPath, p.Name) // breaks cycles
+		imp := importerFunc(func(path string) (*types.Package, error) {
+			if dep, ok := local[path]; ok {
+				return check(dep), nil
+			}
+			return types.NewPackage(path, pathBase(path)), nil
+		})
+		info := &types.Info{
+			Defs: map[*ast.Ident]types.Object{},
+			Uses: map[*ast.Ident]types.Object{},
+		}
+		conf := types.Config{Importer: imp, Error: func(error) {}}
+		tp, _ := conf.Check(p.PkgPath, fset, p.Syntax, info)
+		done[p.PkgPath] = tp
+		out = append(out, &checkedPackage{fset: fset, types: tp, info: info, syntax: p.Syntax})
+		return tp
+	}
+	for _, p := range pkgs {
+		check(p)
+	}
+	return out
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+func pathBase(importPath string) string {
+	return importPath[strings.LastIndex(importPath, "/")+1:]
+}
+
+// isDeclared limits matches to package-level objects, methods and struct
+// fields: locals named like the question's words are noise.
+func isDeclared(obj types.Object, pkg *types.Package) bool {
+	if obj.Parent() == pkg.Scope() {
+		return true
+	}
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Type().(*types.Signature).Recv() != nil
+	case *types.Var:
+		return o.IsField()
+	}
+	return false
+}
+
+// declRange returns the extent of the declaration that defines id: the
+// whole function, or the single type/var/const spec, with its doc comment.
+// Fields of types declared inside functions get just their own line.
+func declRange(files []*ast.File, id *ast.Ident) (token.Pos, token.Pos) {
+	for _, f := range files {
+		if id.Pos() < f.Pos() || id.Pos() > f.End() {
+			continue
+		}
+		var start, end token.Pos = id.Pos(), id.End()
+		ast.Inspect(f, func(n ast.Node) bool {
+			if n == nil || id.Pos() < n.Pos() || id.Pos() >= n.End() {
+				return n == nil
+			}
+			switch d := n.(type) {
+			case *ast.FuncDecl:
+				start, end = d.Pos(), d.End()
+				if d.Doc != nil {
+					start = d.Doc.Pos()
+				}
+				// Keep looking for fields of types local to the function.
+				return id != d.Name
+			case *ast.Field:
+				start, end = d.Pos(), d.End()
+				return false
+			case *ast.GenDecl:
+				start, end = d.Pos(), d.End()
+				if d.Doc != nil {
+					start = d.Doc.Pos()
+				}
+				if len(d.Specs) == 1 {
+					return false
+				}
+			case *ast.TypeSpec, *ast.ValueSpec:
+				start, end = n.Pos(), n.End()
+				return false
+			}
+			return true
+		})
+		return start, end
+	}
+	return id.Pos(), id.End()
+}
+
+func enclosingFunc(files []*ast.File, pos token.Pos) string {
+	for _, f := range files {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok && fd.Pos() <= pos && pos < fd.End() {
+				return fd.Name.Name
+			}
+		}
+	}
+	return ""
+}
+
+// sourceCache reads each file once for snippet extraction.
+type sourceCache map[string][]string
+
+func (sc sourceCache) snippet(fset *token.FileSet, start, end token.Pos, label string) (IndexChunk, bool) {
+	s, e := fset.Position(start), fset.Position(end)
+	return sc.lines(s.Filename, s.Line, e.Line, label)
+}
+
+// lines returns lines from..to (1-based, clamped) of file as a chunk whose
+// text starts with a comment naming what it shows.
+func (sc sourceCache) lines(file string, from, to int, label string) (IndexChunk, bool) {
+	ls, ok := sc[file]
+	if !ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return IndexChunk{}, false
+		}
+		ls = strings.Split(string(data), "\n")
+		sc[file] = ls
+	}
+	from, to = max(from, 1), min(to, len(ls))
+	if from > to {
+		return IndexChunk{}, false
+	}
+	return IndexChunk{
+		Source:    file,
+		StartLine: from,
+		EndLine:   to,
+		Text:      fmt.Sprintf("// %s\n%s", label, strings.Join(ls[from-1:to], "\n")),
+	}, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// groundedMode is set by -grounded: answers drawing on the document index
+// get a second pass that checks each claim against the retrieved excerpts.
+var groundedMode bool
+
+// GroundingReport is the outcome of the check, kept in the log entry.
+type GroundingReport struct {
+	Claims      int      `json:"claims"`
+	Unsupported []string `json:"unsupported,omitempty"`
+	NoSources   bool     `json:"no_sources,omitempty"`
+}
+
+// checkGrounding asks a model to split the answer into claims and say which
+// of them the excerpts support.
+func checkGrounding(answer string, sources []IndexChunk) (*GroundingReport, error) {
+	if len(sources) == 0 {
+		return &GroundingReport{NoSources: true}, nil
+	}
+	msgs := []Message{{
+		Role:    "user",
+		Content: sourcesPrompt(sources) + "\n\n" + tagMem + answer + tagEnd,
+	}}
+	out, err := queryGPTStream(modelLogic, prompt("grounding-check"), 0, 1024, msgs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, errors.New("grounding check: no JSON in reply")
+	}
+	var parsed struct {
+		Claims []struct {
+			Claim     string `json:"claim"`
+			Supported bool   `json:"supported"`
+		} `json:"claims"`
+	}
+	if err := json.Unmarshal([]byte(out[i:j+1]), &parsed); err != nil {
+		return nil, fmt.Errorf("grounding check: %w", err)
+	}
+
+	r := &GroundingReport{Claims: len(parsed.Claims)}
+	for _, c := range parsed.Claims {
+		if !c.Supported {
+			r.Unsupported = append(r.Unsupported, c.Claim)
+		}
+	}
+	return r, nil
+}
+
+// render formats the report shown beneath the answer.
+func (r *GroundingReport) render() string {
+	var b strings.Builder
+	switch {
+	case r.NoSources:
+		b.WriteString("Grounding: no indexed sources matched; nothing above is backed by your documents.")
+	case len(r.Unsupported) == 0:
+		fmt.Fprintf(&b, "Grounding: all %d claims supported by the sources.", r.Claims)
+	default:
+		fmt.Fprintf(&b, "Grounding: %d of %d claims not supported by the sources:",
+			len(r.Unsupported), r.Claims)
+		for _, c := range r.Unsupported {
+			fmt.Fprintf(&b, "\n  ! %s", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"os"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"go-chat/api/gochatpb"
+)
+
+// grpcServer implements gochatpb.GoChatServer on top of the same files the
+// CLI uses. See api/gochat/v1/gochat.proto.
+type grpcServer struct {
+	gochatpb.UnimplementedGoChatServer
+}
+
+func (s *grpcServer) Chat(req *gochatpb.ChatRequest, stream gochatpb.GoChat_ChatServer) error {
+	if req.GetPrompt() == "" {
+		return status.Error(codes.InvalidArgument, "empty prompt")
+	}
+
+	done, err := startTurn(stream.Context(), req.GetPrompt())
+	if _, full := queueFull(err); full {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	} else if errors.Is(err, errTurnDeferred) {
+		return status.Error(codes.Aborted, err.Error())
+	} else if errors.Is(err, errShuttingDown) {
+		return status.Error(codes.Unavailable, err.Error())
+	} else if err != nil {
+		return status.FromContextError(err).Err()
+	}
+	defer done()
+
+	var sendErr error
+	_, err = respond(req.GetPrompt(), chatOptions{
+		MemoryScope: identityFrom(stream.Context()).MemoryScope,
+		OnToken: func(text string) {
+			if sendErr == nil {
+				sendErr = stream.Send(&gochatpb.ChatChunk{Text: text})
+			}
+		},
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return stream.Send(&gochatpb.ChatChunk{Done: true})
+}
+
+func (s *grpcServer) ListSessions(ctx context.Context, _ *gochatpb.ListSessionsRequest) (*gochatpb.ListSessionsResponse, error) {
+	return &gochatpb.ListSessionsResponse{Ids: logDays()}, nil
+}
+
+func (s *grpcServer) GetSession(ctx context.Context, req *gochatpb.GetSessionRequest) (*gochatpb.Session, error) {
+	logs, err := readDayLog(req.GetId())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "no session %q", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	scope := identityFrom(ctx).MemoryScope
+	out := &gochatpb.Session{Id: req.GetId()}
+	for _, l := range logs {
+		if !canRead(scope, l.Scope) {
+			continue
+		}
+		out.Exchanges = append(out.Exchanges, &gochatpb.Exchange{
+			Timestamp: timestamppb.New(l.Timestamp),
+			Request:   l.Request,
+			Response:  l.Response,
+		})
+	}
+	return out, nil
+}
+
+func (s *grpcServer) ListMemories(ctx context.Context, req *gochatpb.ListMemoriesRequest) (*gochatpb.ListMemoriesResponse, error) {
+	if err := validNamespace(req.GetNamespace()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	scope, ns := identityFrom(ctx).MemoryScope, cmp.Or(req.GetNamespace(), allNamespaces)
+	var store []VectorMemory
+	for _, m := range loadVectorStore() {
+		if canRead(scope, m.Scope) && inNamespace(m.Namespace, ns) {
+			store = append(store, m)
+		}
+	}
+
+	if q := req.GetQuery(); q != "" {
+		vec, err := embedText(q)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		sort.SliceStable(store, func(i, j int) bool {
+			return cosineSim(store[i].Embedding, vec) > cosineSim(store[j].Embedding, vec)
+		})
+	}
+	if n := int(req.GetLimit()); n > 0 && len(store) > n {
+		store = store[:n]
+	}
+
+	out := &gochatpb.ListMemoriesResponse{}
+	for _, m := range store {
+		out.Memories = append(out.Memories, &gochatpb.Memory{Id: memoryID(m.Text), Text: m.Text, Scope: normScope(m.Scope), Namespace: m.Namespace})
+	}
+	return out, nil
+}
+
+func (s *grpcServer) AddMemory(ctx context.Context, req *gochatpb.AddMemoryRequest) (*gochatpb.Memory, error) {
+	if req.GetText() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty text")
+	}
+	if err := validNamespace(req.GetNamespace()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	vec, err := embedText(req.GetText())
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	turnMu.Lock()
+	defer turnMu.Unlock()
+	// Through the journal like any other memory (see memwal.go); a memory
+	// that can't be stored is taken out again so the error stands.
+	rec := newWALRecord(req.GetText(), identityFrom(ctx).MemoryScope, req.GetNamespace())
+	if err := journalMemory(rec); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := commitMemories([]walRecord{rec}, [][]float32{vec}); err != nil {
+		unlock := lockFile(walFile())
+		dropWAL(map[string]bool{rec.ID: true})
+		unlock()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &gochatpb.Memory{Id: memoryID(rec.Text), Text: rec.Text, Scope: normScope(rec.Scope), Namespace: rec.Namespace}, nil
+}
+
+func (s *grpcServer) DeleteMemory(ctx context.Context, req *gochatpb.DeleteMemoryRequest) (*gochatpb.DeleteMemoryResponse, error) {
+	turnMu.Lock()
+	defer turnMu.Unlock()
+
+	scope := identityFrom(ctx).MemoryScope
+	defer lockFile(vectorStorePath)()
+	store := loadVectorStore()
+	kept := store[:0]
+	for _, m := range store {
+		if memoryID(m.Text) != req.GetId() || !canRead(scope, m.Scope) {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) == len(store) {
+		return nil, status.Errorf(codes.NotFound, "no memory %q", req.GetId())
+	}
+	if err := saveVectorStore(kept); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &gochatpb.DeleteMemoryResponse{}, nil
+}
+
+func (s *grpcServer) GetConfig(ctx context.Context, _ *gochatpb.GetConfigRequest) (*gochatpb.Config, error) {
+	return configToProto(getConfig()), nil
+}
+
+func (s *grpcServer) UpdateConfig(ctx context.Context, req *gochatpb.UpdateConfigRequest) (*gochatpb.Config, error) {
+	turnMu.Lock()
+	defer turnMu.Unlock()
+	defer lockFile(configFilePath)()
+
+	cfg := readConfig()
+	in := req.GetConfig()
+	if v := in.GetUserName(); v != "" {
+		cfg.UserName = v
+	}
+	if v := in.GetAiName(); v != "" {
+		cfg.AIName = v
+	}
+	if v := in.GetBio(); v != "" {
+		cfg.Bio = v
+	}
+	if v := in.GetPersonality(); v != "" {
+		cfg.Personality = v
+	}
+	if v := in.GetProvider(); v != "" {
+		cfg.Provider = v
+	}
+	saveConfig(cfg)
+	return configToProto(cfg), nil
+}
+
+func configToProto(c Config) *gochatpb.Config {
+	return &gochatpb.Config{
+		UserName:    c.UserName,
+		AiName:      c.AIName,
+		Bio:         c.Bio,
+		Personality: c.Personality,
+		Provider:    c.Provider,
+	}
+}
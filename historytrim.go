@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// HistoryTrim picks what of the history is kept when it is over budget.
+// "recent" (the default) keeps the latest turns. "ends" also keeps the
+// first KeepFirst exchanges, so a long session doesn't lose the task it
+// started with, and fills the rest from the end, at most KeepLast
+// exchanges if that is set. "relevant" keeps the first KeepFirst and last
+// KeepLast exchanges and fills the budget with the ones in between most
+// similar to the prompt, in their original order; it costs one embeddings
+// request when trimming is needed. An exchange is a user message and the
+// replies to it.
+type HistoryTrim struct {
+	Strategy  string `json:"strategy,omitempty"`
+	KeepFirst int    `json:"keep_first,omitempty"` // default 1
+	KeepLast  int    `json:"keep_last,omitempty"`  // default 2 for relevant
+}
+
+const (
+	trimRecent   = "recent"
+	trimEnds     = "ends"
+	trimRelevant = "relevant"
+)
+
+// exchanges splits hist into exchanges.
+func exchanges(hist []Message) [][]Message {
+	var out [][]Message
+	for i, m := range hist {
+		if m.Role == "user" || i == 0 {
+			out = append(out, nil)
+		}
+		out[len(out)-1] = append(out[len(out)-1], m)
+	}
+	return out
+}
+
+func exchangeTokens(ex []Message) int {
+	n, counter := 0, configuredProvider()
+	for _, m := range ex {
+		n += tokensMsgFor(counter, m)
+	}
+	return n
+}
+
+// trim cuts hist to limit tokens, latest is the prompt being answered.
+func (t HistoryTrim) trim(hist []Message, limit int, latest string) []Message {
+	strategy := cmp.Or(t.Strategy, trimRecent)
+	if strategy != trimRecent && strategy != trimEnds && strategy != trimRelevant {
+		warnOnce("history_trim", "context: unknown history_trim strategy %q, using recent", strategy)
+		strategy = trimRecent
+	}
+	if strategy == trimRecent || exchangeTokens(hist) <= limit {
+		return trimHistory(hist, limit)
+	}
+
+	exs := exchanges(hist)
+	keepFirst := t.KeepFirst
+	if keepFirst == 0 {
+		keepFirst = 1
+	}
+	keepLast := t.KeepLast
+	if keepLast == 0 && strategy == trimRelevant {
+		keepLast = 2
+	}
+	keep := make([]bool, len(exs))
+	used := 0
+	take := func(i int) bool {
+		n := exchangeTokens(exs[i])
+		if keep[i] || used+n > limit {
+			return false
+		}
+		keep[i], used = true, used+n
+		return true
+	}
+
+	// The most recent exchange comes before the first ones, so the
+	// conversation still follows on if only one fits.
+	take(len(exs) - 1)
+	for i := 0; i < min(keepFirst, len(exs)); i++ {
+		take(i)
+	}
+	if strategy == trimEnds {
+		for i, n := len(exs)-2, 1; i >= 0 && (keepLast == 0 || n < keepLast); i-- {
+			if keep[i] {
+				continue
+			}
+			if !take(i) {
+				break
+			}
+			n++
+		}
+	} else {
+		for i := len(exs) - 2; i >= max(len(exs)-keepLast, 0); i-- {
+			take(i)
+		}
+		for _, i := range rankExchanges(exs, keep, latest) {
+			take(i)
+		}
+	}
+
+	var out []Message
+	for i, ex := range exs {
+		if keep[i] {
+			out = append(out, ex...)
+		}
+	}
+	return out
+}
+
+// rankExchanges returns the indexes of the exchanges not yet kept, most
+// similar to latest first. If embedding fails they are ranked newest
+// first, as with "recent".
+func rankExchanges(exs [][]Message, keep []bool, latest string) []int {
+	var idx []int
+	var texts []string
+	for i := len(exs) - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		var b strings.Builder
+		for _, m := range exs[i] {
+			b.WriteString(m.Content + "\n")
+		}
+		idx = append(idx, i)
+		texts = append(texts, b.String())
+	}
+	if len(idx) == 0 {
+		return nil
+	}
+	vecs, err := embedTexts(embeddingModel(), append(texts, latest))
+	if err != nil {
+		log.Printf("history_trim: %v", err)
+		return idx
+	}
+	q := vecs[len(vecs)-1]
+	score := make(map[int]float64, len(idx))
+	for j, i := range idx {
+		score[i] = cosineSim(vecs[j], q)
+	}
+	ranked := slices.Clone(idx)
+	sort.SliceStable(ranked, func(a, b int) bool { return score[ranked[a]] > score[ranked[b]] })
+	return ranked
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Ignore rules keep files out of the document index and file uploads.
+// They use gitignore syntax and come from, in increasing precedence:
+//
+//   - the built-in defaults below (keys, vendored code)
+//   - "ignore" globs in the config, matched against the base name, or the
+//     full path when they contain a slash
+//   - .gochatignore files in the file's directory and every parent, so
+//     ~/.gochatignore applies to everything under home
+//
+// The last matching rule wins, and !pattern re-includes. Binary files are
+// always skipped, whatever the rules say.
+const ignoreFileName = ".gochatignore"
+
+var defaultIgnores = []string{
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.kdbx",
+	"id_rsa*", "id_ecdsa*", "id_ed25519*",
+	".env", ".env.*", ".netrc", ".git/",
+	"vendor/", "node_modules/",
+}
+
+type ignoreRule struct {
+	base    string // directory the rule is relative to
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+type ignorer struct {
+	global []ignoreRule
+	names  []string // per-directory ignore files, lowest precedence first
+	files  map[string][]ignoreRule
+}
+
+func newIgnorer(cfg Config) *ignorer {
+	ig := &ignorer{names: []string{ignoreFileName}, files: map[string][]ignoreRule{}}
+	for _, p := range append(append([]string{}, defaultIgnores...), cfg.Ignore...) {
+		if r, ok := parseIgnoreRule(string(filepath.Separator), p); ok {
+			ig.global = append(ig.global, r)
+		}
+	}
+	return ig
+}
+
+// rulesFor reads the ignore files in dir, once.
+func (ig *ignorer) rulesFor(dir string) []ignoreRule {
+	if rules, ok := ig.files[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	for _, name := range ig.names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if r, ok := parseIgnoreRule(dir, sc.Text()); ok {
+				rules = append(rules, r)
+			}
+		}
+		f.Close()
+	}
+	ig.files[dir] = rules
+	return rules
+}
+
+// ignored reports whether the rules exclude path itself. Walks call it on
+// every entry and skip excluded directories, so parents are covered.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	rules := ig.global
+	var dirs []string
+	for d := filepath.Dir(path); ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules = append(rules, ig.rulesFor(dirs[i])...)
+	}
+
+	out := false
+	for _, r := range rules {
+		if r.match(path, isDir) {
+			out = !r.negate
+		}
+	}
+	return out
+}
+
+// excluded is ignored for a single file reached without a walk: it is
+// excluded if any directory above it is.
+func (ig *ignorer) excluded(path string) bool {
+	for d := filepath.Dir(path); d != filepath.Dir(d); d = filepath.Dir(d) {
+		if ig.ignored(d, true) {
+			return true
+		}
+	}
+	return ig.ignored(path, false)
+}
+
+func (r ignoreRule) match(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, err := filepath.Rel(r.base, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return r.re.MatchString(filepath.ToSlash(rel))
+}
+
+// parseIgnoreRule turns one gitignore line into a rule. Patterns without a
+// slash match a name at any depth; others are anchored to base.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	r := ignoreRule{base: base}
+	if p, ok := strings.CutPrefix(line, "!"); ok {
+		r.negate, line = true, p
+	}
+	if p, ok := strings.CutSuffix(line, "/"); ok {
+		r.dirOnly, line = true, p
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// globToRegexp translates a glob with ** support into a regexp body.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			if j := strings.IndexByte(glob[i:], ']'); j > 0 {
+				class := glob[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += j
+			} else {
+				b.WriteString(`\[`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		isDir, want   bool
+	}{
+		{"*.log", "/p/a.log", false, true},
+		{"*.log", "/p/sub/deep/a.log", false, true},
+		{"*.log", "/p/a.log.txt", false, false},
+		{"a?c", "/p/abc", false, true},
+		{"a?c", "/p/a/c", false, false},
+		{"[ab].txt", "/p/b.txt", false, true},
+		{"[!ab].txt", "/p/b.txt", false, false},
+		{"[!ab].txt", "/p/c.txt", false, true},
+		{"a.b", "/p/axb", false, false},
+		{"build/", "/p/build", true, true},
+		{"build/", "/p/build", false, false},
+		{"build/", "/p/x/build", true, true},
+		{"/build", "/p/build", false, true},
+		{"/build", "/p/x/build", false, false},
+		{"docs/*.md", "/p/docs/a.md", false, true},
+		{"docs/*.md", "/p/docs/x/a.md", false, false},
+		{"docs/*.md", "/p/x/docs/a.md", false, false},
+		{"**/cache", "/p/cache", true, true},
+		{"**/cache", "/p/a/b/cache", true, true},
+		{"logs/**", "/p/logs/a/b.txt", false, true},
+		{"a/**/b", "/p/a/b", false, true},
+		{"a/**/b", "/p/a/x/y/b", false, true},
+		{"*.log", "/other/a.log", false, false},
+		{"*", "/p", true, false},
+	} {
+		r, ok := parseIgnoreRule("/p", tc.pattern)
+		if !ok {
+			t.Errorf("parseIgnoreRule(%q) failed", tc.pattern)
+			continue
+		}
+		if got := r.match(tc.path, tc.isDir); got != tc.want {
+			t.Errorf("%q matches %s (dir %v) = %v, want %v", tc.pattern, tc.path, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestParseIgnoreRule(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment"} {
+		if _, ok := parseIgnoreRule("/p", line); ok {
+			t.Errorf("parseIgnoreRule(%q) made a rule", line)
+		}
+	}
+	r, ok := parseIgnoreRule("/p", "!keep.log  ")
+	if !ok || !r.negate || r.dirOnly || !r.match("/p/keep.log", false) {
+		t.Errorf(`parseIgnoreRule("!keep.log  ") = %+v, %v`, r, ok)
+	}
+}
+
+func TestIgnorer(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, text string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(ignoreFileName, "*.log\n!keep.log\ntmp/\n")
+	write(filepath.Join("sub", ignoreFileName), "keep.log\n!*.pem\n")
+	ig := newIgnorer(Config{Ignore: []string{"*.bak", "/secret/*"}})
+
+	for _, tc := range []struct {
+		path        string
+		isDir, want bool
+	}{
+		{"a.txt", false, false},
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, true},
+		{"tmp", true, true},
+		{"tmp", false, false},
+		{"a.bak", false, true},
+		{"x/.env", false, true},
+		{"x/.env.local", false, true},
+		{"id_rsa.pub", false, true},
+		{"server.pem", false, true},
+		{"sub/server.pem", false, false},
+		{"node_modules", true, true},
+		{".git", true, true},
+	} {
+		if got := ig.ignored(filepath.Join(root, tc.path), tc.isDir); got != tc.want {
+			t.Errorf("ignored(%s, dir %v) = %v, want %v", tc.path, tc.isDir, got, tc.want)
+		}
+	}
+
+	if !ig.ignored("/secret/x", false) || ig.ignored("/a/secret/x", false) {
+		t.Error(`config glob "/secret/*" should match from the root only`)
+	}
+	if !ig.excluded(filepath.Join(root, "tmp", "a.txt")) {
+		t.Error("excluded should cover files under an ignored directory")
+	}
+	if ig.excluded(filepath.Join(root, "sub", "a.txt")) {
+		t.Error("excluded(sub/a.txt) = true, want false")
+	}
+}
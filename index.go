@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// The document index holds files and web pages the assistant can answer
+// from, split into chunks and embedded like memories. It lives in
+// ~/.go-chat-index.json and is managed with `go-chat index`. Each chunk
+// carries a memory scope, so a document indexed as private never reaches a
+// team or global conversation.
+//
+// Sources are recorded with their content hash and mtime, so re-running
+// `index add` (or `index update`) only re-embeds what changed.
+var indexFilePath string
+
+type Index struct {
+	Sources map[string]*IndexedSource `json:"sources"`
+	Chunks  []IndexChunk              `json:"chunks"`
+}
+
+type IndexedSource struct {
+	SHA256  string    `json:"sha256"`
+	ModTime time.Time `json:"mod_time,omitempty"` // files only
+	Scope   string    `json:"scope,omitempty"`
+	Indexed time.Time `json:"indexed"`
+}
+
+type IndexChunk struct {
+	Source    string    `json:"source"` // absolute path or URL
+	StartLine int       `json:"start_line,omitempty"`
+	EndLine   int       `json:"end_line,omitempty"`
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+	Scope     string    `json:"scope,omitempty"`
+}
+
+const (
+	defaultChunkLines   = 40
+	defaultChunkOverlap = 10
+
+	maxIndexFileSize = 1 << 20
+)
+
+func loadIndex() *Index {
+	ix := &Index{}
+	if data, err := os.ReadFile(indexFilePath); err == nil {
+		_ = json.Unmarshal(data, ix)
+	}
+	if ix.Sources == nil {
+		ix.Sources = map[string]*IndexedSource{}
+	}
+	return ix
+}
+
+func saveIndex(ix *Index) error {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(indexFilePath, data, 0o600)
+}
+
+func runIndex(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat index add [-scope private|team|global] [-force] [-dry-run] [<path|url>...] | update | status | list | remove <path|url>...")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "add":
+		fset := flag.NewFlagSet("index add", flag.ExitOnError)
+		scope := fset.String("scope", memScopePrivate, "Memory scope the documents are visible to")
+		force := fset.Bool("force", false, "Re-embed sources even if unchanged")
+		dryRun := fset.Bool("dry-run", false, "List the files that would be indexed and stop")
+		fset.Parse(args[1:])
+		if err := validMemScope(*scope); err != nil {
+			log.Fatalf("index add: %v", err)
+		}
+		targets := fset.Args()
+		if len(targets) == 0 {
+			targets = getConfig().Index.Roots
+		}
+		if len(targets) == 0 {
+			log.Fatal("index add: nothing to index (name paths, or set index roots in " + projectFileName + ")")
+		}
+
+		defer lockFile(indexFilePath)()
+		ix := loadIndex()
+		ig := newIgnorer(getConfig())
+		for _, target := range targets {
+			if *dryRun {
+				docs, err := readSources(target, ig)
+				if err != nil {
+					log.Fatalf("index add: %v", err)
+				}
+				for _, d := range docs {
+					fmt.Printf("%6d lines  %s\n", strings.Count(strings.TrimRight(d.text, "\n"), "\n")+1, d.source)
+				}
+				continue
+			}
+			err := ix.addTarget(target, *scope, *force, ig, func(src, status string) {
+				fmt.Printf("%s: %s\n", src, status)
+			})
+			if err != nil {
+				log.Fatalf("index add: %v", err)
+			}
+		}
+		if *dryRun {
+			return
+		}
+		if err := saveIndex(ix); err != nil {
+			log.Fatalf("index add: %v", err)
+		}
+
+	case "update":
+		defer lockFile(indexFilePath)()
+		ix := loadIndex()
+		for _, src := range ix.sortedSources() {
+			s := ix.Sources[src]
+			docs, err := readSources(src, newIgnorer(getConfig()))
+			if errors.Is(err, fs.ErrNotExist) {
+				ix.remove(src, false)
+				fmt.Printf("%s: removed\n", src)
+				continue
+			}
+			if err != nil {
+				log.Printf("%s: %v", src, err)
+				continue
+			}
+			if len(docs) == 0 {
+				ix.remove(src, false)
+				fmt.Printf("%s: removed (excluded or binary)\n", src)
+				continue
+			}
+			for _, d := range docs {
+				status, err := ix.upsert(d, normScope(s.Scope), false)
+				if err != nil {
+					log.Fatalf("index update: %s: %v", d.source, err)
+				}
+				if status != "unchanged" {
+					fmt.Printf("%s: %s\n", d.source, status)
+				}
+			}
+		}
+		if n := ix.dropOrphans(); n > 0 {
+			fmt.Printf("dropped %d orphaned chunks\n", n)
+		}
+		if err := saveIndex(ix); err != nil {
+			log.Fatalf("index update: %v", err)
+		}
+
+	case "status":
+		ix := loadIndex()
+		var stale, missing int
+		for _, src := range ix.sortedSources() {
+			state := ix.sourceState(src)
+			switch state {
+			case "stale":
+				stale++
+			case "missing":
+				missing++
+			}
+			if state != "ok" {
+				fmt.Printf("%-8s %s\n", state, src)
+			}
+		}
+		orphans := 0
+		for _, c := range ix.Chunks {
+			if _, ok := ix.Sources[c.Source]; !ok {
+				orphans++
+			}
+		}
+		fmt.Printf("%d sources, %d chunks: %d stale, %d missing, %d orphaned chunks\n",
+			len(ix.Sources), len(ix.Chunks), stale, missing, orphans)
+		if stale+missing+orphans > 0 {
+			fmt.Println("run `go-chat index update` to refresh")
+		}
+
+	case "list":
+		ix := loadIndex()
+		counts := map[string]int{}
+		for _, c := range ix.Chunks {
+			counts[c.Source]++
+		}
+		for _, src := range ix.sortedSources() {
+			s := ix.Sources[src]
+			fmt.Printf("%-8s %5d  %s  %s\n", normScope(s.Scope), counts[src], s.Indexed.Local().Format(time.DateOnly), src)
+		}
+
+	case "remove":
+		if len(args) < 2 {
+			log.Fatal("usage: go-chat index remove <path|url>...")
+		}
+		defer lockFile(indexFilePath)()
+		ix := loadIndex()
+		removed := 0
+		for _, target := range args[1:] {
+			removed += ix.remove(sourceKey(target), true)
+		}
+		if err := saveIndex(ix); err != nil {
+			log.Fatalf("index remove: %v", err)
+		}
+		fmt.Printf("removed %d chunks\n", removed)
+
+	default:
+		log.Fatalf("unknown index command %q", args[0])
+	}
+}
+
+// addTarget indexes a URL, file or directory, reporting each source's
+// status. Files that disappeared from an indexed directory are dropped.
+func (ix *Index) addTarget(target, scope string, force bool, ig *ignorer, report func(src, status string)) error {
+	docs, err := readSources(target, ig)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, d := range docs {
+		seen[d.source] = true
+		status, err := ix.upsert(d, scope, force)
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.source, err)
+		}
+		report(d.source, status)
+	}
+	if !isURL(target) {
+		for src := range ix.Sources {
+			if isUnder(src, sourceKey(target)) && !seen[src] {
+				ix.remove(src, false)
+				report(src, "removed")
+			}
+		}
+	}
+	return nil
+}
+
+// upsert (re)indexes a document unless its content is unchanged, and
+// reports what it did.
+func (ix *Index) upsert(d document, scope string, force bool) (string, error) {
+	sum := sha256.Sum256([]byte(d.text))
+	hash := hex.EncodeToString(sum[:])
+
+	if s, ok := ix.Sources[d.source]; ok && !force && s.SHA256 == hash {
+		s.ModTime = d.modTime
+		if normScope(s.Scope) == scope {
+			return "unchanged", nil
+		}
+		s.Scope = scope
+		for i := range ix.Chunks {
+			if ix.Chunks[i].Source == d.source {
+				ix.Chunks[i].Scope = scope
+			}
+		}
+		return "moved to " + scope, nil
+	}
+
+	chunks, err := embedDocument(d, scope)
+	if err != nil {
+		return "", err
+	}
+	ix.remove(d.source, false)
+	ix.Chunks = append(ix.Chunks, chunks...)
+	ix.Sources[d.source] = &IndexedSource{SHA256: hash, ModTime: d.modTime, Scope: scope, Indexed: time.Now()}
+	return fmt.Sprintf("%d chunks", len(chunks)), nil
+}
+
+// remove drops a source, or with under every source inside it too, and
+// returns how many chunks went.
+func (ix *Index) remove(source string, under bool) int {
+	match := func(s string) bool { return s == source || under && isUnder(s, source) }
+	for s := range ix.Sources {
+		if match(s) {
+			delete(ix.Sources, s)
+		}
+	}
+	kept := ix.Chunks[:0]
+	for _, c := range ix.Chunks {
+		if !match(c.Source) {
+			kept = append(kept, c)
+		}
+	}
+	n := len(ix.Chunks) - len(kept)
+	ix.Chunks = kept
+	return n
+}
+
+// dropOrphans removes chunks whose source is no longer recorded.
+func (ix *Index) dropOrphans() int {
+	kept := ix.Chunks[:0]
+	for _, c := range ix.Chunks {
+		if _, ok := ix.Sources[c.Source]; ok {
+			kept = append(kept, c)
+		}
+	}
+	n := len(ix.Chunks) - len(kept)
+	ix.Chunks = kept
+	return n
+}
+
+// sourceState is "ok", "stale" or "missing" for files. Checking a URL would
+// mean fetching it, so URLs are always "ok" here; update re-fetches them.
+func (ix *Index) sourceState(src string) string {
+	if isURL(src) {
+		return "ok"
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return "missing"
+	}
+	s := ix.Sources[src]
+	if info.ModTime().Equal(s.ModTime) {
+		return "ok"
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return "missing"
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != s.SHA256 {
+		return "stale"
+	}
+	return "ok"
+}
+
+func (ix *Index) sortedSources() []string {
+	out := make([]string, 0, len(ix.Sources))
+	for s := range ix.Sources {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isUnder(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// sourceKey is how a command-line target is recorded: URLs as given, paths
+// made absolute.
+func sourceKey(target string) string {
+	if isURL(target) {
+		return target
+	}
+	if abs, err := filepath.Abs(target); err == nil {
+		return abs
+	}
+	return target
+}
+
+type document struct {
+	source  string
+	text    string
+	lines   bool // line numbers are meaningful (files, not web pages)
+	modTime time.Time
+}
+
+// readSources reads a URL, a file, or every text file under a directory.
+// Hidden files and directories are skipped, as are binary and oversized
+// files and anything the ignore rules exclude (see ignore.go).
+func readSources(target string, ig *ignorer) ([]document, error) {
+	if isURL(target) {
+		text, err := fetchText(target)
+		if err != nil {
+			return nil, err
+		}
+		return []document{{source: target, text: text}}, nil
+	}
+
+	root := sourceKey(target)
+	if ig.excluded(root) {
+		return nil, nil
+	}
+	var docs []document
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && (strings.HasPrefix(d.Name(), ".") || ig.ignored(path, d.IsDir())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxIndexFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !isText(data) {
+			return nil
+		}
+		docs = append(docs, document{source: path, text: string(data), lines: true, modTime: info.ModTime()})
+		return nil
+	})
+	return docs, err
+}
+
+func isText(data []byte) bool {
+	head := data[:min(len(data), 8000)]
+	return !bytes.Contains(head, []byte{0}) && utf8.Valid(head)
+}
+
+// fetchText downloads a page and, for HTML, keeps only the visible text.
+func fetchText(url string) (string, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body := io.LimitReader(resp.Body, maxIndexFileSize)
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		data, err := io.ReadAll(body)
+		return string(data), err
+	}
+
+	var b strings.Builder
+	z := html.NewTokenizer(body)
+	skip := 0
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return b.String(), nil
+			}
+			return "", z.Err()
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				if t := strings.TrimSpace(string(z.Text())); t != "" {
+					b.WriteString(t + "\n")
+				}
+			}
+		}
+	}
+}
+
+func embedDocument(d document, scope string) ([]IndexChunk, error) {
+	rule := chunkRule(getConfig().Index, d.source)
+	var out []IndexChunk
+	for _, c := range chunkDocument(d, rule) {
+		vec, err := embedText(d.source + "\n" + c.text)
+		if err != nil {
+			return nil, err
+		}
+		ic := IndexChunk{Source: d.source, Text: c.text, Embedding: vec, Scope: scope}
+		if d.lines {
+			ic.StartLine, ic.EndLine = c.start, c.end
+		}
+		out = append(out, ic)
+	}
+	return out, nil
+}
@@ -29,7 +29,7 @@ read -p "Enter a personality for the AI: " ai_personality
 
 # Build the Go binary
 echo "Compiling the Go Chat application..."
-go build -o go-chat go-chat.go
+go build -o go-chat .
 
 # Copy the binary to /usr/local/bin
 echo "Copying the binary to /usr/local/bin..."
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// `go-chat journal` is a guided journaling session: the model asks an
+// opening question (picking up from the last entry), then follow-ups to
+// each answer, until an empty line. The entry is written to a dated
+// Markdown file in ~/.go-chat-journal ("journal_dir" in the config moves
+// it), apart from the chat logs, and its summary is saved as a memory so
+// later conversations can recall it.
+const (
+	journalDirName  = ".go-chat-journal"
+	journalMaxTurns = 12
+)
+
+func journalDir() string {
+	if d := getConfig().JournalDir; d != "" {
+		return os.ExpandEnv(d)
+	}
+	return filepath.Join(homeDir, journalDirName)
+}
+
+func journalDays() []string {
+	matches, _ := filepath.Glob(filepath.Join(journalDir(), "????-??-??.md"))
+	days := make([]string, len(matches))
+	for i, m := range matches {
+		days[i] = strings.TrimSuffix(filepath.Base(m), ".md")
+	}
+	slices.Sort(days)
+	return days
+}
+
+// lastJournalSummary returns the summary line of the newest entry.
+func lastJournalSummary() (string, string) {
+	days := journalDays()
+	for i := len(days) - 1; i >= 0; i-- {
+		data, err := os.ReadFile(filepath.Join(journalDir(), days[i]+".md"))
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		for j := len(lines) - 1; j >= 0; j-- {
+			if s, ok := strings.CutPrefix(lines[j], "_Summary: "); ok {
+				return days[i], strings.TrimSuffix(s, "_")
+			}
+		}
+	}
+	return "", ""
+}
+
+func runJournal(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			for _, d := range journalDays() {
+				fmt.Println(d)
+			}
+			return
+		case "show":
+			day := logDay(time.Now())
+			if len(args) > 1 {
+				day = args[1]
+			}
+			data, err := os.ReadFile(filepath.Join(journalDir(), day+".md"))
+			if err != nil {
+				log.Fatalf("journal: no entry for %s", day)
+			}
+			fmt.Print(string(data))
+			return
+		default:
+			fmt.Fprintln(os.Stderr, "usage: go-chat journal [list | show [YYYY-MM-DD]]")
+			os.Exit(2)
+		}
+	}
+
+	system := prompt("journal")
+	if day, s := lastJournalSummary(); s != "" {
+		system += fmt.Sprintf("\n\nTheir last entry (%s): %s", day, s)
+	}
+	now := time.Now()
+	msgs := []Message{{Role: "user", Content: fmt.Sprintf("It's %s. I'd like to journal.", now.In(userLocation(getConfig())).Format("Monday 2 January, 15:04"))}}
+
+	fmt.Println("(an empty line ends the entry)")
+	var turns []Message
+	for i := 0; i < journalMaxTurns; i++ {
+		fmt.Println()
+		q, err := queryGPTStream(modelCreative, system, 0.7, 200, msgs, printToken)
+		fmt.Println()
+		if err != nil {
+			log.Fatalf("journal: %v", err)
+		}
+		fmt.Print("> ")
+		ans, readErr := stdin.ReadString('\n')
+		ans = strings.TrimSpace(ans)
+		if ans == "" {
+			break
+		}
+		msgs = append(msgs, Message{Role: "assistant", Content: q}, Message{Role: "user", Content: ans})
+		turns = append(turns, Message{Role: "assistant", Content: q}, Message{Role: "user", Content: ans})
+		if readErr != nil {
+			break
+		}
+	}
+	if len(turns) == 0 {
+		fmt.Println("\nnothing written")
+		return
+	}
+
+	summary, err := queryGPTStream(modelSummarise, prompt("journal-summary"), 0.3, 200, turns, nil)
+	if err != nil {
+		log.Printf("journal summary: %v", err)
+	}
+	summary = strings.Join(strings.Fields(summary), " ")
+
+	var b strings.Builder
+	day := logDay(now)
+	path := filepath.Join(journalDir(), day+".md")
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(&b, "# %s\n", day)
+	}
+	fmt.Fprintf(&b, "\n## %s\n\n", now.In(userLocation(getConfig())).Format("15:04"))
+	for i := 0; i+1 < len(turns); i += 2 {
+		fmt.Fprintf(&b, "_%s_\n\n%s\n\n", strings.TrimSpace(turns[i].Content), turns[i+1].Content)
+	}
+	if summary != "" {
+		fmt.Fprintf(&b, "_Summary: %s_\n", summary)
+	}
+
+	if err := os.MkdirAll(journalDir(), 0o700); err != nil {
+		log.Fatalf("journal: %v", err)
+	}
+	unlock := lockFile(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = f.WriteString(b.String())
+		err = cmp.Or(f.Close(), err)
+	}
+	unlock()
+	if err != nil {
+		log.Fatalf("journal: %v", err)
+	}
+	fmt.Printf("\nsaved to %s\n", path)
+
+	if summary != "" {
+		saveVectorMemory(fmt.Sprintf("Journal entry, %s: %s", day, summary), memScopePrivate, activeNamespace)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageInstruction returns the line added to the system prompt telling
+// the model which language to answer in, or "" to leave it alone. With
+// mirror_language on, the prompt's own language wins; preferred_language
+// is the fallback when detection isn't confident (or mirroring is off).
+func languageInstruction(cfg Config, userPrompt string) string {
+	lang := ""
+	if cfg.MirrorLanguage {
+		lang = detectLanguage(userPrompt)
+	}
+	if lang == "" {
+		lang = cfg.PreferredLanguage
+	}
+	if lang == "" {
+		return ""
+	}
+	return "Always answer in " + lang + "."
+}
+
+// scriptLanguages maps scripts that (nearly) identify a language on their
+// own. Han is checked after kana so Japanese isn't reported as Chinese.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "Japanese"},
+	{unicode.Katakana, "Japanese"},
+	{unicode.Hangul, "Korean"},
+	{unicode.Han, "Chinese"},
+	{unicode.Cyrillic, "Russian"},
+	{unicode.Greek, "Greek"},
+	{unicode.Arabic, "Arabic"},
+	{unicode.Hebrew, "Hebrew"},
+	{unicode.Thai, "Thai"},
+	{unicode.Devanagari, "Hindi"},
+}
+
+// stopwords are frequent short words used to tell Latin-script languages
+// apart. Only words that are rare in the other listed languages are used.
+var stopwords = map[string][]string{
+	"English":    {"the", "and", "is", "are", "what", "how", "you", "this", "that", "with", "for", "can", "my", "of", "to"},
+	"Spanish":    {"el", "los", "las", "es", "qué", "cómo", "por", "para", "una", "con", "pero", "está", "y", "mi", "del"},
+	"French":     {"le", "les", "est", "je", "vous", "une", "pour", "avec", "que", "qui", "mais", "dans", "et", "mon", "pas"},
+	"German":     {"der", "die", "das", "und", "ist", "ich", "nicht", "mit", "wie", "was", "ein", "eine", "auf", "für", "mein"},
+	"Italian":    {"il", "gli", "è", "che", "sono", "come", "per", "una", "non", "della", "con", "mio", "perché", "cosa", "questo"},
+	"Portuguese": {"o", "os", "é", "não", "você", "uma", "com", "para", "como", "meu", "isso", "está", "do", "da", "que"},
+	"Dutch":      {"de", "het", "een", "en", "is", "ik", "niet", "wat", "hoe", "met", "voor", "van", "mijn", "dat", "zijn"},
+}
+
+// detectLanguage guesses the language of s, returning "" when there's too
+// little signal to be sure. It is deliberately simple: script ranges first,
+// then stopword counts for Latin-script text.
+func detectLanguage(s string) string {
+	counts := map[string]int{}
+	letters := 0
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, sl := range scriptLanguages {
+			if unicode.Is(sl.table, r) {
+				counts[sl.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if counts["Japanese"] > 0 {
+		return "Japanese"
+	}
+	best, bestN := "", 0
+	for lang, n := range counts {
+		if n > bestN {
+			best, bestN = lang, n
+		}
+	}
+	if bestN*2 >= letters {
+		return best
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := map[string]int{}
+	for _, w := range words {
+		for lang, list := range stopwords {
+			for _, sw := range list {
+				if w == sw {
+					scores[lang]++
+				}
+			}
+		}
+	}
+	best, bestN = "", 0
+	tie := false
+	for lang, n := range scores {
+		switch {
+		case n > bestN:
+			best, bestN, tie = lang, n, false
+		case n == bestN:
+			tie = true
+		}
+	}
+	if bestN < 2 || tie {
+		return ""
+	}
+	return best
+}
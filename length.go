@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// -length short|normal|detailed (or /short, /normal and /detailed in
+// interactive mode, for the rest of the session or, followed by a prompt,
+// for that prompt) picks how long answers are: a max_tokens limit plus an
+// instruction in the system prompt, so "just give me the command" and
+// "explain in depth" don't mean editing settings by hand.
+const (
+	lengthShort    = "short"
+	lengthNormal   = "normal"
+	lengthDetailed = "detailed"
+)
+
+var answerLengths = []string{lengthShort, lengthNormal, lengthDetailed}
+
+var answerLength string // -length
+
+// lengthPreset returns the max_tokens and the system prompt instruction
+// for a length; normal (or none) is the default 1024 and no instruction.
+func lengthPreset(length string) (maxTok int, instruction string) {
+	switch length {
+	case lengthShort:
+		return 256, prompt("length-short")
+	case lengthDetailed:
+		return 4096, prompt("length-detailed")
+	}
+	return 1024, ""
+}
+
+func validLength(length string) error {
+	if length != "" && !slices.Contains(answerLengths, length) {
+		return fmt.Errorf("unknown length %q (want %v)", length, answerLengths)
+	}
+	return nil
+}
+
+// cutLengthCommand parses "/short", "/normal" or "/detailed", optionally
+// followed by a prompt.
+func cutLengthCommand(line string) (length, rest string, ok bool) {
+	for _, l := range answerLengths {
+		if r, found := strings.CutPrefix(line, "/"+l); found && (r == "" || r[0] == ' ') {
+			return l, strings.TrimSpace(r), true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Context linting looks over the memories picked for a turn before it is
+// sent and flags ones that seem to contradict each other or the prompt, so
+// a stale memory can be dropped before it skews the answer. -lint (or
+// "lint_context" in the config) picks how:
+//
+//   - "similarity" compares the memories' embeddings: two memories that are
+//     nearly the same but not identical may be an old and a new version of
+//     one fact, and a memory close to a prompt that corrects something
+//     ("actually", "no longer", ...) may be what it corrects. It costs one
+//     embeddings request for the prompt.
+//   - "model" asks modelLogic to point out contradictions, falling back to
+//     "similarity" if that fails.
+//
+// The problems are listed on the terminal with the memories involved, and
+// the ones picked are left out of this turn and get a /membad vote (see
+// feedback.go). Without a terminal, or with -y, they are only listed.
+const (
+	lintOff        = "off"
+	lintSimilarity = "similarity"
+	lintModel      = "model"
+
+	lintSimilar  = 0.9  // memories this close may be versions of one fact
+	lintRelevant = 0.75 // a correcting prompt this close may update a memory
+)
+
+var lintMode string
+
+var correctionCue = regexp.MustCompile(`(?i)\b(actually|no longer|not anymore|any ?more|changed|switched|moved|instead|now|these days|wrong|outdated|used to)\b`)
+
+// contextIssue is a problem found with some of a turn's memories, numbered
+// from 1.
+type contextIssue struct {
+	Memories []int  `json:"memories"`
+	Reason   string `json:"reason"`
+}
+
+func lintSetting(cfg Config) string {
+	m := lintMode
+	if m == "" {
+		m = cfg.LintContext
+	}
+	switch m {
+	case "", lintOff:
+		return ""
+	case lintSimilarity, lintModel:
+		return m
+	}
+	warnOnce("lint_context", "unknown lint mode %q (use similarity or model)", m)
+	return ""
+}
+
+// lintContext checks mems against each other and the prompt and returns
+// the ones to send.
+func lintContext(cfg Config, userPrompt string, mems []VectorMemory) []VectorMemory {
+	mode := lintSetting(cfg)
+	if mode == "" || len(mems) == 0 {
+		return mems
+	}
+	var issues []contextIssue
+	var err error
+	if mode == lintModel {
+		if issues, err = lintWithModel(userPrompt, mems); err != nil {
+			log.Printf("context lint: %v; comparing embeddings instead", err)
+		}
+	}
+	if mode == lintSimilarity || err != nil {
+		issues = lintBySimilarity(userPrompt, mems)
+	}
+	if len(issues) == 0 {
+		return mems
+	}
+
+	fmt.Fprintf(os.Stderr, "context check: %d possible problem(s) with the memories for this prompt\n", len(issues))
+	var involved []int
+	for _, is := range issues {
+		involved = append(involved, is.Memories...)
+	}
+	slices.Sort(involved)
+	for _, n := range slices.Compact(involved) {
+		fmt.Fprintf(os.Stderr, "  [%d] %s  %s\n", n, memoryID(mems[n-1].Text), truncateRunes(oneLine(mems[n-1].Text), footerSnippet))
+	}
+	for _, is := range issues {
+		nums := make([]string, len(is.Memories))
+		for i, n := range is.Memories {
+			nums[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", strings.Join(nums, ", "), is.Reason)
+	}
+	if assumeYes || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return mems
+	}
+
+	fmt.Fprint(os.Stderr, "Drop which memories? (numbers, Enter for none) ")
+	ans, _ := stdin.ReadString('\n')
+	drop := map[int]bool{}
+	for _, f := range strings.FieldsFunc(ans, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		if n, err := strconv.Atoi(f); err == nil && n >= 1 && n <= len(mems) {
+			drop[n] = true
+		}
+	}
+	if len(drop) == 0 {
+		return mems
+	}
+	var keep, dropped []VectorMemory
+	for i, m := range mems {
+		if drop[i+1] {
+			dropped = append(dropped, m)
+		} else {
+			keep = append(keep, m)
+		}
+	}
+	if err := recordFeedback(dropped, -1); err != nil {
+		log.Printf("memory feedback: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "(left out %d memories)\n", len(dropped))
+	return keep
+}
+
+// lintBySimilarity flags near-identical memories and memories close to a
+// prompt that corrects something.
+func lintBySimilarity(userPrompt string, mems []VectorMemory) []contextIssue {
+	var issues []contextIssue
+	for i := range mems {
+		for j := i + 1; j < len(mems); j++ {
+			a, b := mems[i], mems[j]
+			if len(a.Embedding) == 0 || len(b.Embedding) == 0 || oneLine(a.Text) == oneLine(b.Text) {
+				continue
+			}
+			if cosineSim(a.Embedding, b.Embedding) >= lintSimilar {
+				issues = append(issues, contextIssue{Memories: []int{i + 1, j + 1}, Reason: "nearly the same memory twice; one may be out of date"})
+			}
+		}
+	}
+	if !correctionCue.MatchString(userPrompt) {
+		return issues
+	}
+	vec, err := embedText(userPrompt)
+	if err != nil {
+		return issues
+	}
+	for i, m := range mems {
+		if len(m.Embedding) > 0 && cosineSim(vec, m.Embedding) >= lintRelevant {
+			issues = append(issues, contextIssue{Memories: []int{i + 1}, Reason: "the prompt may correct this memory"})
+		}
+	}
+	return issues
+}
+
+// lintWithModel asks modelLogic which memories contradict each other or
+// the prompt.
+func lintWithModel(userPrompt string, mems []VectorMemory) ([]contextIssue, error) {
+	var b strings.Builder
+	b.WriteString("Prompt:\n" + userPrompt + "\n\nMemories:\n")
+	for i, m := range mems {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, m.Text)
+	}
+	out, err := queryGPTStream(modelLogic, prompt("lint-context"), 0, 512, []Message{{Role: "user", Content: b.String()}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, errors.New("no JSON in reply")
+	}
+	var parsed struct {
+		Issues []contextIssue `json:"issues"`
+	}
+	if err := json.Unmarshal([]byte(out[i:j+1]), &parsed); err != nil {
+		return nil, err
+	}
+	var issues []contextIssue
+	for _, is := range parsed.Issues {
+		is.Memories = slices.DeleteFunc(is.Memories, func(n int) bool { return n < 1 || n > len(mems) })
+		if len(is.Memories) > 0 {
+			issues = append(issues, is)
+		}
+	}
+	return issues, nil
+}
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// An embedding model named local:NAME is run in-process instead of through
+// the provider's embeddings API, so memories and the index work offline
+// and a prompt costs no embeddings request. NAME is a sentence-transformers
+// model downloaded from Hugging Face into ~/.go-chat-models/NAME (or a
+// path to one): BERT-style encoders such as all-MiniLM-L6-v2 or
+// bge-small-en-v1.5, as config.json, vocab.txt and model.safetensors, plus
+// 1_Pooling/config.json and sentence_bert_config.json where the model has
+// them.
+//
+//	go-chat memory reembed -model local:all-MiniLM-L6-v2
+//
+// switches to one. Vectors are pooled as the model says (mean by default,
+// or [CLS]) and normalised. The model is loaded once per process.
+const (
+	localModelPrefix = "local:"
+	localModelsDir   = ".go-chat-models"
+	defaultLocalLen  = 256 // tokens, when the model doesn't say
+)
+
+// localModelDir is the directory a local:NAME model is in; ok is false for
+// other models.
+func localModelDir(model string) (string, bool) {
+	name, ok := strings.CutPrefix(model, localModelPrefix)
+	if !ok {
+		return "", false
+	}
+	if rest, ok := strings.CutPrefix(name, "~/"); ok {
+		return filepath.Join(homeDir, rest), true
+	}
+	if filepath.IsAbs(name) {
+		return name, true
+	}
+	return filepath.Join(homeDir, localModelsDir, name), true
+}
+
+var localModels struct {
+	sync.Mutex
+	loaded map[string]*bertModel
+}
+
+// embedLocal embeds texts with the model in dir, several at once.
+func embedLocal(dir string, texts []string) ([][]float32, error) {
+	localModels.Lock()
+	m, ok := localModels.loaded[dir]
+	if !ok {
+		var err error
+		if m, err = loadBERT(dir); err != nil {
+			localModels.Unlock()
+			return nil, fmt.Errorf("local embeddings: %w", err)
+		}
+		if localModels.loaded == nil {
+			localModels.loaded = map[string]*bertModel{}
+		}
+		localModels.loaded[dir] = m
+	}
+	localModels.Unlock()
+
+	vecs := make([][]float32, len(texts))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, t := range texts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			vecs[i] = m.embed(t)
+		}()
+	}
+	wg.Wait()
+	return vecs, nil
+}
+
+type bertConfig struct {
+	Hidden    int     `json:"hidden_size"`
+	Layers    int     `json:"num_hidden_layers"`
+	Heads     int     `json:"num_attention_heads"`
+	MaxPos    int     `json:"max_position_embeddings"`
+	Eps       float64 `json:"layer_norm_eps"`
+	Act       string  `json:"hidden_act"`
+	ModelType string  `json:"model_type"`
+}
+
+type bertModel struct {
+	cfg    bertConfig
+	maxLen int
+	cls    bool // pool with [CLS] instead of the mean
+
+	vocab            map[string]int
+	lower            bool
+	unk, start, stop int // [UNK], [CLS] and [SEP]
+
+	word, pos, typ []float32 // embedding tables, a row per id
+	embNorm        layerNorm
+	layers         []bertLayer
+}
+
+type bertLayer struct {
+	query, key, value, attnOut linear
+	attnNorm                   layerNorm
+	up, down                   linear
+	outNorm                    layerNorm
+}
+
+// linear is a dense layer with PyTorch's weight layout, a row per output.
+type linear struct {
+	w, b    []float32
+	in, out int
+}
+
+type layerNorm struct{ g, b []float32 }
+
+func loadBERT(dir string) (*bertModel, error) {
+	m := &bertModel{lower: true}
+	if err := readJSONFile(filepath.Join(dir, "config.json"), &m.cfg); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no model in %s; download a sentence-transformers model's files there", dir)
+	} else if err != nil {
+		return nil, err
+	}
+	c := &m.cfg
+	if c.ModelType != "" && c.ModelType != "bert" {
+		return nil, fmt.Errorf("%s: model type %q isn't supported, only bert", dir, c.ModelType)
+	}
+	if c.Hidden == 0 || c.Layers == 0 || c.Heads == 0 || c.MaxPos < 3 || c.Hidden%c.Heads != 0 {
+		return nil, fmt.Errorf("%s: config.json doesn't describe a BERT model", dir)
+	}
+	if c.Eps == 0 {
+		c.Eps = 1e-12
+	}
+	switch c.Act {
+	case "", "gelu", "gelu_new", "gelu_pytorch_tanh":
+	default:
+		return nil, fmt.Errorf("%s: activation %q isn't supported", dir, c.Act)
+	}
+
+	// The optional files: their absence means the defaults.
+	var tok struct {
+		Lower *bool `json:"do_lower_case"`
+	}
+	var st struct {
+		MaxLen int `json:"max_seq_length"`
+	}
+	var pool struct {
+		CLS bool `json:"pooling_mode_cls_token"`
+	}
+	for name, v := range map[string]any{"tokenizer_config.json": &tok, "sentence_bert_config.json": &st, "1_Pooling/config.json": &pool} {
+		if err := readJSONFile(filepath.Join(dir, name), v); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if tok.Lower != nil {
+		m.lower = *tok.Lower
+	}
+	m.cls = pool.CLS
+	m.maxLen = min(cmp.Or(st.MaxLen, defaultLocalLen), c.MaxPos)
+
+	if err := m.loadVocab(filepath.Join(dir, "vocab.txt")); err != nil {
+		return nil, err
+	}
+	tensors, err := readSafetensors(filepath.Join(dir, "model.safetensors"))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.loadWeights(tensors); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return m, nil
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (m *bertModel) loadVocab(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	m.vocab = map[string]int{}
+	for i, tok := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		m.vocab[strings.TrimRight(tok, "\r")] = i
+	}
+	for _, t := range []struct {
+		name string
+		id   *int
+	}{{"[UNK]", &m.unk}, {"[CLS]", &m.start}, {"[SEP]", &m.stop}} {
+		id, ok := m.vocab[t.name]
+		if !ok {
+			return fmt.Errorf("%s: no %s token", path, t.name)
+		}
+		*t.id = id
+	}
+	return nil
+}
+
+type tensor struct {
+	shape []int
+	data  []float32
+}
+
+// readSafetensors reads every tensor in a safetensors file as float32.
+func readSafetensors(path string) (map[string]tensor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%s: too short", path)
+	}
+	n := binary.LittleEndian.Uint64(data)
+	if n > uint64(len(data)-8) {
+		return nil, fmt.Errorf("%s: bad header", path)
+	}
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(data[8:8+n], &header); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	body := data[8+n:]
+	out := map[string]tensor{}
+	for name, raw := range header {
+		if name == "__metadata__" {
+			continue
+		}
+		var h struct {
+			Dtype   string `json:"dtype"`
+			Shape   []int  `json:"shape"`
+			Offsets [2]int `json:"data_offsets"`
+		}
+		if err := json.Unmarshal(raw, &h); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		if h.Offsets[0] < 0 || h.Offsets[0] > h.Offsets[1] || h.Offsets[1] > len(body) {
+			return nil, fmt.Errorf("%s: %s: bad offsets", path, name)
+		}
+		b := body[h.Offsets[0]:h.Offsets[1]]
+		width := map[string]int{"F32": 4, "F16": 2, "BF16": 2}[h.Dtype]
+		if width == 0 {
+			continue // not a weight BERT uses, such as position_ids
+		}
+		count := 1
+		for _, d := range h.Shape {
+			if count *= d; d < 0 || count > len(b) {
+				return nil, fmt.Errorf("%s: %s: bad shape %v", path, name, h.Shape)
+			}
+		}
+		if count*width != len(b) {
+			return nil, fmt.Errorf("%s: %s: %d bytes for %s %v", path, name, len(b), h.Dtype, h.Shape)
+		}
+		var vals []float32
+		switch h.Dtype {
+		case "F32":
+			vals = make([]float32, len(b)/4)
+			for i := range vals {
+				vals[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+			}
+		case "F16":
+			vals = make([]float32, len(b)/2)
+			for i := range vals {
+				vals[i] = float16(binary.LittleEndian.Uint16(b[2*i:]))
+			}
+		case "BF16":
+			vals = make([]float32, len(b)/2)
+			for i := range vals {
+				vals[i] = math.Float32frombits(uint32(binary.LittleEndian.Uint16(b[2*i:])) << 16)
+			}
+		}
+		out[strings.TrimPrefix(name, "bert.")] = tensor{h.Shape, vals}
+	}
+	return out, nil
+}
+
+// float16 converts an IEEE half-precision float.
+func float16(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	case exp != 0:
+		return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+	case frac == 0:
+		return math.Float32frombits(sign)
+	}
+	// Subnormal: 2^-24 per step.
+	v := float32(frac) / (1 << 24)
+	if sign != 0 {
+		v = -v
+	}
+	return v
+}
+
+func (m *bertModel) loadWeights(t map[string]tensor) error {
+	var missing []string
+	get := func(name string, size ...int) []float32 {
+		x, ok := t[name]
+		if !ok {
+			missing = append(missing, name)
+			return nil
+		}
+		want := 1
+		for _, s := range size {
+			want *= s
+		}
+		if len(x.data) != want {
+			missing = append(missing, fmt.Sprintf("%s (shape %v, want %v)", name, x.shape, size))
+			return nil
+		}
+		return x.data
+	}
+	// Older checkpoints call the layer norm parameters gamma and beta.
+	norm := func(prefix string) layerNorm {
+		if _, ok := t[prefix+".gamma"]; ok {
+			return layerNorm{get(prefix+".gamma", m.cfg.Hidden), get(prefix+".beta", m.cfg.Hidden)}
+		}
+		return layerNorm{get(prefix+".weight", m.cfg.Hidden), get(prefix+".bias", m.cfg.Hidden)}
+	}
+	dense := func(prefix string, in, out int) linear {
+		return linear{get(prefix+".weight", out, in), get(prefix+".bias", out), in, out}
+	}
+
+	h := m.cfg.Hidden
+	if word, ok := t["embeddings.word_embeddings.weight"]; ok && len(word.shape) == 2 && word.shape[1] == h && word.shape[0] >= len(m.vocab) {
+		m.word = word.data
+	} else {
+		missing = append(missing, "embeddings.word_embeddings.weight")
+	}
+	m.pos = get("embeddings.position_embeddings.weight", m.cfg.MaxPos, h)
+	if typ, ok := t["embeddings.token_type_embeddings.weight"]; ok && len(typ.data) >= h {
+		m.typ = typ.data[:h] // every token is type 0
+	}
+	m.embNorm = norm("embeddings.LayerNorm")
+	for i := range m.cfg.Layers {
+		p := fmt.Sprintf("encoder.layer.%d.", i)
+		inter := 0
+		if up, ok := t[p+"intermediate.dense.weight"]; ok && len(up.shape) == 2 {
+			inter = up.shape[0]
+		}
+		m.layers = append(m.layers, bertLayer{
+			query:    dense(p+"attention.self.query", h, h),
+			key:      dense(p+"attention.self.key", h, h),
+			value:    dense(p+"attention.self.value", h, h),
+			attnOut:  dense(p+"attention.output.dense", h, h),
+			attnNorm: norm(p + "attention.output.LayerNorm"),
+			up:       dense(p+"intermediate.dense", h, inter),
+			down:     dense(p+"output.dense", inter, h),
+			outNorm:  norm(p + "output.LayerNorm"),
+		})
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("model.safetensors is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// embed runs the encoder over one text and pools the result.
+func (m *bertModel) embed(text string) []float32 {
+	ids := m.tokenize(text)
+	n, h := len(ids), m.cfg.Hidden
+	x := make([]float32, n*h)
+	for i, id := range ids {
+		row := x[i*h : (i+1)*h]
+		for j := range row {
+			row[j] = m.word[id*h+j] + m.pos[i*h+j]
+			if m.typ != nil {
+				row[j] += m.typ[j]
+			}
+		}
+	}
+	m.embNorm.apply(x, n, m.cfg.Eps)
+	for _, l := range m.layers {
+		x = m.layer(l, x, n)
+	}
+
+	out := make([]float32, h)
+	if m.cls {
+		copy(out, x[:h])
+	} else {
+		for i := range n {
+			for j := range out {
+				out[j] += x[i*h+j]
+			}
+		}
+		for j := range out {
+			out[j] /= float32(n)
+		}
+	}
+	var sum float64
+	for _, v := range out {
+		sum += float64(v) * float64(v)
+	}
+	if s := float32(math.Sqrt(sum)); s > 0 {
+		for j := range out {
+			out[j] /= s
+		}
+	}
+	return out
+}
+
+func (m *bertModel) layer(l bertLayer, x []float32, n int) []float32 {
+	h, heads := m.cfg.Hidden, m.cfg.Heads
+	d := h / heads
+	q, k, v := l.query.apply(x, n), l.key.apply(x, n), l.value.apply(x, n)
+	ctx := make([]float32, n*h)
+	scale := float32(1 / math.Sqrt(float64(d)))
+	scores := make([]float32, n)
+	for hd := range heads {
+		off := hd * d
+		for i := range n {
+			qi := q[i*h+off : i*h+off+d]
+			top := float32(math.Inf(-1))
+			for j := range n {
+				scores[j] = dot(qi, k[j*h+off:j*h+off+d]) * scale
+				top = max(top, scores[j])
+			}
+			var sum float32
+			for j := range scores {
+				scores[j] = float32(math.Exp(float64(scores[j] - top)))
+				sum += scores[j]
+			}
+			ci := ctx[i*h+off : i*h+off+d]
+			for j := range n {
+				p := scores[j] / sum
+				vj := v[j*h+off : j*h+off+d]
+				for t := range ci {
+					ci[t] += p * vj[t]
+				}
+			}
+		}
+	}
+	attn := l.attnOut.apply(ctx, n)
+	for i := range attn {
+		attn[i] += x[i]
+	}
+	l.attnNorm.apply(attn, n, m.cfg.Eps)
+
+	ff := l.up.apply(attn, n)
+	for i, y := range ff {
+		ff[i] = m.gelu(y)
+	}
+	out := l.down.apply(ff, n)
+	for i := range out {
+		out[i] += attn[i]
+	}
+	l.outNorm.apply(out, n, m.cfg.Eps)
+	return out
+}
+
+func (m *bertModel) gelu(x float32) float32 {
+	if m.cfg.Act == "" || m.cfg.Act == "gelu" {
+		return float32(0.5 * float64(x) * (1 + math.Erf(float64(x)/math.Sqrt2)))
+	}
+	y := float64(x)
+	return float32(0.5 * y * (1 + math.Tanh(math.Sqrt(2/math.Pi)*(y+0.044715*y*y*y))))
+}
+
+// apply runs the layer over n rows of x, spread over the CPUs.
+func (l linear) apply(x []float32, n int) []float32 {
+	out := make([]float32, n*l.out)
+	workers := min(runtime.GOMAXPROCS(0), n)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				xi, oi := x[i*l.in:(i+1)*l.in], out[i*l.out:(i+1)*l.out]
+				for o := range oi {
+					oi[o] = dot(xi, l.w[o*l.in:(o+1)*l.in]) + l.b[o]
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return s0 + s1 + s2 + s3
+}
+
+// apply normalises each of the n rows of x in place.
+func (ln layerNorm) apply(x []float32, n int, eps float64) {
+	h := len(ln.g)
+	for i := range n {
+		row := x[i*h : (i+1)*h]
+		var mean, variance float64
+		for _, v := range row {
+			mean += float64(v)
+		}
+		mean /= float64(h)
+		for _, v := range row {
+			variance += (float64(v) - mean) * (float64(v) - mean)
+		}
+		inv := 1 / math.Sqrt(variance/float64(h)+eps)
+		for j, v := range row {
+			row[j] = float32((float64(v)-mean)*inv)*ln.g[j] + ln.b[j]
+		}
+	}
+}
+
+// tokenize splits text into WordPiece ids between [CLS] and [SEP], the
+// way BERT's tokenizer does, cut to the model's length.
+func (m *bertModel) tokenize(text string) []int {
+	ids := []int{m.start}
+	limit := m.maxLen - 1
+	for _, word := range m.basicTokens(text) {
+		ids = append(ids, m.wordPieces(word)...)
+		if len(ids) >= limit {
+			ids = ids[:limit]
+			break
+		}
+	}
+	return append(ids, m.stop)
+}
+
+// basicTokens splits on whitespace and punctuation, and around CJK
+// characters, lowercasing and dropping accents if the model is uncased.
+func (m *bertModel) basicTokens(text string) []string {
+	if m.lower {
+		text = norm.NFD.String(strings.ToLower(text))
+	}
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range text {
+		switch {
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r) && !unicode.IsSpace(r):
+		case m.lower && unicode.Is(unicode.Mn, r):
+		case unicode.IsSpace(r):
+			flush()
+		case isBERTPunct(r) || unicode.Is(unicode.Han, r):
+			flush()
+			words = append(words, string(r))
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush()
+	return words
+}
+
+func isBERTPunct(r rune) bool {
+	return r >= 33 && r <= 47 || r >= 58 && r <= 64 || r >= 91 && r <= 96 || r >= 123 && r <= 126 || unicode.IsPunct(r)
+}
+
+// wordPieces splits a word greedily into the longest pieces in the
+// vocabulary; a word that can't be split is [UNK].
+func (m *bertModel) wordPieces(word string) []int {
+	runes := []rune(word)
+	if len(runes) > 100 {
+		return []int{m.unk}
+	}
+	var ids []int
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		id := -1
+		for ; end > start; end-- {
+			piece := string(runes[start:end])
+			if start > 0 {
+				piece = "##" + piece
+			}
+			if i, ok := m.vocab[piece]; ok {
+				id = i
+				break
+			}
+		}
+		if id < 0 {
+			return []int{m.unk}
+		}
+		ids = append(ids, id)
+		start = end
+	}
+	return ids
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The models in testdata are tiny random BERTs (2 layers, hidden size 8)
+// made by testdata/gen_tinybert.py, which also computes their embeddings
+// with a plain float64 forward pass; the references below are its output.
+const goldenText = "Hello worlds! unable zzz Café"
+
+func TestLocalEmbedGolden(t *testing.T) {
+	for _, tc := range []struct {
+		dir  string
+		want []float32
+	}{
+		{"tinybert-mean", []float32{-0.10139, 0.63430, -0.33607, -0.07638, 0.22115, 0.41700, -0.41980, 0.26379}},
+		{"tinybert-cls", []float32{-0.10156, 0.63336, -0.33641, -0.07502, 0.22076, 0.41847, -0.41965, 0.26416}},
+	} {
+		t.Run(tc.dir, func(t *testing.T) {
+			m, err := loadBERT(filepath.Join("testdata", tc.dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// [CLS] hello world ##s ! un ##able [UNK] cafe [SEP]
+			if ids, want := m.tokenize(goldenText), []int{2, 4, 5, 6, 9, 7, 8, 1, 10, 3}; !slices.Equal(ids, want) {
+				t.Fatalf("tokenize = %v, want %v", ids, want)
+			}
+			got := m.embed(goldenText)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d dimensions, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if math.Abs(float64(got[i]-tc.want[i])) > 1e-4 {
+					t.Fatalf("embedding = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadSafetensorsCorrupt(t *testing.T) {
+	good, err := os.ReadFile(filepath.Join("testdata", "tinybert-mean", "model.safetensors"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := binary.LittleEndian.Uint64(good)
+	header, body := string(good[8:8+n]), good[8+n:]
+	withHeader := func(old, new string) []byte {
+		if !strings.Contains(header, old) {
+			t.Fatalf("fixture header has no %s", old)
+		}
+		h := strings.Replace(header, old, new, 1)
+		out := binary.LittleEndian.AppendUint64(nil, uint64(len(h)))
+		return append(append(out, h...), body...)
+	}
+	withLength := func(v uint64) []byte {
+		return append(binary.LittleEndian.AppendUint64(nil, v), good[8:]...)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":              nil,
+		"short":              good[:5],
+		"header past end":    withLength(uint64(len(good))),
+		"huge header length": withLength(math.MaxUint64),
+		"truncated header":   good[:8+n/2],
+		"truncated body":     good[:len(good)-100],
+		"not json":           withHeader(`{"bert`, `["bert`),
+		"negative offset":    withHeader(`"data_offsets": [0, 416]`, `"data_offsets": [-4, 416]`),
+		"offsets reversed":   withHeader(`"data_offsets": [0, 416]`, `"data_offsets": [416, 0]`),
+		"shape too big":      withHeader(`"shape": [13, 8]`, `"shape": [13, 9]`),
+		"negative shape":     withHeader(`"shape": [13, 8]`, `"shape": [-13, -8]`),
+		"wrong dtype width":  withHeader(`"dtype": "F32", "shape": [13, 8]`, `"dtype": "F16", "shape": [13, 8]`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "model.safetensors")
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readSafetensors(path); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
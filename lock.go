@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A daemon, an interactive session and a server can all run against the
+// same home directory. Shared state files are written with writeFileAtomic,
+// so a reader never sees half a file, and every read-modify-write of one
+// holds lockFile on it, so concurrent processes (and server goroutines)
+// don't drop each other's changes. Locks are advisory flocks on files in
+// ~/.go-chat-locks; don't take the same file's lock twice in one call chain.
+var (
+	locksMu   sync.Mutex
+	fileLocks = map[string]*sync.Mutex{}
+)
+
+// lockFile takes the lock for path, waiting for other holders, and returns
+// the function that releases it.
+func lockFile(path string) (unlock func()) {
+	locksMu.Lock()
+	mu := fileLocks[path]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		fileLocks[path] = mu
+	}
+	locksMu.Unlock()
+	mu.Lock()
+
+	dir := filepath.Join(homeDir, ".go-chat-locks")
+	_ = os.MkdirAll(dir, 0o755)
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(path)+".lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err == nil {
+		err = flock(f)
+	}
+	if err != nil {
+		log.Printf("lock %s: %v", filepath.Base(path), err)
+	}
+	return func() {
+		if f != nil {
+			f.Close() // releases the flock
+		}
+		mu.Unlock()
+	}
+}
+
+// writeFileAtomic replaces path with data via a temporary file and rename.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// flock is a no-op where flock(2) isn't available; lockFile still
+// serialises writers within one process.
+func flock(*os.File) error { return nil }
+
+// processAlive can't tell here, so journal recovery treats every process as
+// gone; commitMemories keeps that from storing a memory twice.
+func processAlive(int) bool { return false }
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// `go-chat logs analyze app.log` distils a log file locally before the
+// model sees it: lines are reduced to signatures (timestamps, numbers, ids
+// and quoted values masked) and counted, error signatures are listed
+// first, and a histogram shows when things happened. The model gets that
+// view, a few thousand tokens, instead of the whole file.
+const (
+	logSampleLen   = 300 // characters kept of an example line
+	logHistBuckets = 24
+)
+
+var (
+	logTimeRe = regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?|[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]?`)
+	logErrRe  = regexp.MustCompile(`(?i)\b(error|err|fatal|panic|exception|traceback|fail(ed|ure)?|critical|crit|severe)\b`)
+	logWarnRe = regexp.MustCompile(`(?i)\b(warn(ing)?)\b`)
+
+	logMasks = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		{regexp.MustCompile(`"[^"]*"|'[^']*'`), `"…"`},
+		{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+		{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+		{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]{12,}\b`), "<hex>"},
+		{regexp.MustCompile(`\d+(\.\d+)?`), "<n>"},
+	}
+
+	logTimeLayouts = []string{
+		time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700", "2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05,999999999", "2006-01-02T15:04:05.999999999",
+		"Jan _2 15:04:05", "02/Jan/2006:15:04:05 -0700",
+	}
+)
+
+type logSig struct {
+	example     string
+	count       int
+	level       string // "error", "warn" or ""
+	first, last time.Time
+}
+
+type logDigest struct {
+	lines      int
+	sigs       []*logSig
+	times      []time.Time
+	errTimes   []time.Time
+	head, tail []string
+}
+
+func parseLogTime(s string) (time.Time, bool) {
+	for _, layout := range logTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() == 0 { // syslog stamps have no year
+				t = t.AddDate(time.Now().Year(), 0, 0)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// logSignature masks the variable parts of a line.
+func logSignature(line string) string {
+	for _, m := range logMasks {
+		line = m.re.ReplaceAllString(line, m.repl)
+	}
+	return strings.Join(strings.Fields(line), " ")
+}
+
+func digestLog(r io.Reader) (*logDigest, error) {
+	d := &logDigest{}
+	bySig := map[string]*logSig{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		d.lines++
+		if len(d.head) < 10 {
+			d.head = append(d.head, truncateRunes(line, logSampleLen))
+		}
+		d.tail = append(d.tail, truncateRunes(line, logSampleLen))
+		if len(d.tail) > 20 {
+			d.tail = d.tail[1:]
+		}
+
+		rest := line
+		var at time.Time
+		if m := logTimeRe.FindStringSubmatchIndex(line); m != nil {
+			if t, ok := parseLogTime(line[m[2]:m[3]]); ok {
+				at = t
+				rest = line[m[1]:]
+				d.times = append(d.times, t)
+			}
+		}
+
+		sig := logSignature(rest)
+		s := bySig[sig]
+		if s == nil {
+			s = &logSig{example: truncateRunes(line, logSampleLen), first: at}
+			switch {
+			case logErrRe.MatchString(rest):
+				s.level = "error"
+			case logWarnRe.MatchString(rest):
+				s.level = "warn"
+			}
+			bySig[sig] = s
+			d.sigs = append(d.sigs, s)
+		}
+		s.count++
+		if !at.IsZero() {
+			s.last = at
+			if s.level == "error" {
+				d.errTimes = append(d.errTimes, at)
+			}
+		}
+	}
+	slices.SortStableFunc(d.sigs, func(a, b *logSig) int { return b.count - a.count })
+	return d, sc.Err()
+}
+
+// histogram buckets the timed lines (and errors among them) over the
+// file's time span.
+func (d *logDigest) histogram() string {
+	if len(d.times) < 2 {
+		return ""
+	}
+	lo, hi := slices.MinFunc(d.times, time.Time.Compare), slices.MaxFunc(d.times, time.Time.Compare)
+	span := hi.Sub(lo)
+	if span <= 0 {
+		return ""
+	}
+	step := span / logHistBuckets
+	for _, s := range []time.Duration{time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour} {
+		if step <= s {
+			step = s
+			break
+		}
+	}
+	start := lo.Truncate(step)
+	n := int(hi.Sub(start)/step) + 1
+	all, errs := make([]int, n), make([]int, n)
+	for _, t := range d.times {
+		all[int(t.Sub(start)/step)]++
+	}
+	for _, t := range d.errTimes {
+		errs[int(t.Sub(start)/step)]++
+	}
+
+	peak := slices.Max(all)
+	var b strings.Builder
+	fmt.Fprintf(&b, "lines per %s (errors in brackets):\n", step)
+	for i := range all {
+		bar := strings.Repeat("#", (all[i]*40+peak-1)/peak)
+		fmt.Fprintf(&b, "%s %-40s %d [%d]\n", start.Add(time.Duration(i)*step).Format("2006-01-02 15:04:05"), bar, all[i], errs[i])
+	}
+	return b.String()
+}
+
+// view renders the digest for the model, with up to top signatures of
+// each kind.
+func (d *logDigest) view(name string, top int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Log file %s: %d lines, %d distinct line patterns", name, d.lines, len(d.sigs))
+	if len(d.times) > 0 {
+		fmt.Fprintf(&b, ", %s to %s", slices.MinFunc(d.times, time.Time.Compare).Format(time.RFC3339), slices.MaxFunc(d.times, time.Time.Compare).Format(time.RFC3339))
+	}
+	b.WriteString(".\nPatterns mask numbers, ids, addresses and quoted values; counts are how often each occurred.\n\n")
+
+	section := func(title string, keep func(*logSig) bool) {
+		shown := 0
+		for _, s := range d.sigs {
+			if !keep(s) {
+				continue
+			}
+			if shown == 0 {
+				b.WriteString("## " + title + "\n")
+			}
+			if shown == top {
+				b.WriteString("…\n")
+				break
+			}
+			fmt.Fprintf(&b, "%6d× %s\n", s.count, s.example)
+			if !s.first.IsZero() && s.count > 1 {
+				fmt.Fprintf(&b, "        first %s, last %s\n", s.first.Format(time.DateTime), s.last.Format(time.DateTime))
+			}
+			shown++
+		}
+		if shown > 0 {
+			b.WriteString("\n")
+		}
+	}
+	section("Errors", func(s *logSig) bool { return s.level == "error" })
+	section("Warnings", func(s *logSig) bool { return s.level == "warn" })
+	section("Most frequent other lines", func(s *logSig) bool { return s.level == "" })
+
+	if h := d.histogram(); h != "" {
+		b.WriteString("## Timeline\n" + h + "\n")
+	}
+	b.WriteString("## First lines\n" + strings.Join(d.head, "\n") + "\n\n")
+	b.WriteString("## Last lines\n" + strings.Join(d.tail, "\n") + "\n")
+	return b.String()
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
+func runLogs(args []string) {
+	if len(args) == 0 || args[0] != "analyze" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat logs analyze [-q question] [-top N] [-print] <file|->")
+		os.Exit(2)
+	}
+	fset := flag.NewFlagSet("logs analyze", flag.ExitOnError)
+	question := fset.String("q", "", "What to ask about the log (default: diagnose problems)")
+	top := fset.Int("top", 25, "Patterns to show per section")
+	printOnly := fset.Bool("print", false, "Print the distilled view instead of sending it")
+	fset.Parse(args[1:])
+	if fset.NArg() != 1 {
+		log.Fatal("usage: go-chat logs analyze [-q question] [-top N] [-print] <file|->")
+	}
+
+	name := fset.Arg(0)
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		log.Fatalf("logs analyze: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			log.Fatalf("logs analyze: %v", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			log.Fatalf("logs analyze: %v", err)
+		}
+	}
+	if !isText(data) {
+		log.Fatalf("logs analyze: %s is not a text log", name)
+	}
+
+	d, err := digestLog(bytes.NewReader(data))
+	if err != nil {
+		log.Fatalf("logs analyze: %v", err)
+	}
+	view := d.view(name, *top)
+	if *printOnly {
+		fmt.Print(view)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d lines (about %d tokens) distilled to about %d tokens\n", d.lines, tokens(string(data)), tokens(view))
+
+	q := *question
+	if q == "" {
+		q = prompt("logs-analyze")
+	}
+	sendChat(q + "\n\n" + fenced("text", view))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// dayLog is one entry of a daily log, with the day it's filed under.
+type dayLog struct {
+	Day string
+	ChatLog
+}
+
+func runLog(args []string) {
+	if len(args) > 0 && args[0] == "browse" {
+		runLogBrowse()
+		return
+	}
+
+	fset := flag.NewFlagSet("log", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go-chat log [-date YYYY-MM-DD | -since YYYY-MM-DD | -all] [-session ns] [-grep regexp] [-reverse] [-n N]")
+		fmt.Fprintln(os.Stderr, "       go-chat log browse")
+		fset.PrintDefaults()
+	}
+	date := fset.String("date", "", "Show this day's log (default today)")
+	since := fset.String("since", "", "Show every day from this date on")
+	all := fset.Bool("all", false, "Show the whole history")
+	session := fset.String("session", "", "Only this namespace")
+	grep := fset.String("grep", "", "Only entries whose prompt or answer match this regexp")
+	reverse := fset.Bool("reverse", false, "Newest first")
+	n := fset.Int("n", 0, "Show only the last N entries")
+	fset.Parse(args)
+
+	for _, d := range []string{*date, *since} {
+		if _, err := time.Parse(time.DateOnly, d); d != "" && err != nil {
+			log.Fatalf("log: bad date %q", d)
+		}
+	}
+	var re *regexp.Regexp
+	if *grep != "" {
+		var err error
+		if re, err = regexp.Compile(*grep); err != nil {
+			log.Fatalf("log: -grep: %v", err)
+		}
+	}
+	if *session != "" {
+		if err := validNamespace(*session); err != nil {
+			log.Fatalf("log: %v", err)
+		}
+	}
+
+	var days []string
+	switch {
+	case *all:
+		days = logDays()
+	case *since != "":
+		for _, d := range logDays() {
+			if d >= *since {
+				days = append(days, d)
+			}
+		}
+	default:
+		days = []string{cmp.Or(*date, logDay(time.Now()))}
+	}
+
+	var entries []dayLog
+	for _, d := range days {
+		logs, err := readDayLog(d)
+		if err != nil {
+			if len(days) == 1 {
+				log.Fatalf("log: no log for %s", d)
+			}
+			continue
+		}
+		for _, l := range logs {
+			if *session != "" && !inNamespace(l.Namespace, *session) {
+				continue
+			}
+			if re != nil && !re.MatchString(l.Request) && !re.MatchString(l.Response) {
+				continue
+			}
+			entries = append(entries, dayLog{d, l})
+		}
+	}
+
+	if *n > 0 && len(entries) > *n {
+		entries = entries[len(entries)-*n:]
+	}
+	if *reverse {
+		slices.Reverse(entries)
+	}
+	printLogEntries(entries, len(days) > 1)
+}
+
+// printLogEntries prints entries in the -a format, with a heading for
+// each day when showing more than one.
+func printLogEntries(entries []dayLog, headings bool) {
+	loc := userLocation(getConfig())
+	day := ""
+	for _, e := range entries {
+		if headings && e.Day != day {
+			day = e.Day
+			fmt.Printf("=== %s ===\n\n", day)
+		}
+		who := ""
+		if e.Speaker != "" {
+			who = " [" + e.Speaker + "]"
+		}
+		fmt.Printf("%s%s\n> %s\n%s\n\n",
+			e.Timestamp.In(loc).Format(time.RFC822), who, e.Request, e.Response)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// `go-chat memory export/import` move memories between machines, or
+// between a CLI and a server, as JSON lines. The first line is a header
+// naming the format, its version and the embedding model; each further
+// line is one memory. Embeddings are reused on import only when the model
+// matches, otherwise the text is embedded again.
+const (
+	memoryExportFormat  = "go-chat-memories"
+	memoryExportVersion = 1
+)
+
+type memoryExportHeader struct {
+	Format         string    `json:"format"`
+	Version        int       `json:"version"`
+	Exported       time.Time `json:"exported"`
+	EmbeddingModel string    `json:"embedding_model,omitempty"`
+}
+
+type memoryRecord struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Scope     string    `json:"scope,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+func runMemory(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat memory export [-scope S] [-ns N] [-no-embeddings] <file|-> | import [-reembed] <file|-> | reembed -model M [-batch N] | quantize [-dry-run]")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "export":
+		fset := flag.NewFlagSet("memory export", flag.ExitOnError)
+		scope := fset.String("scope", "", "Only export this memory scope")
+		ns := fset.String("ns", "", "Only export this namespace")
+		noEmb := fset.Bool("no-embeddings", false, "Leave embeddings out (smaller; re-embedded on import)")
+		fset.Parse(args[1:])
+		if fset.NArg() != 1 {
+			log.Fatal("memory export: need one output file (- for stdout)")
+		}
+
+		w := io.Writer(os.Stdout)
+		if name := fset.Arg(0); name != "-" {
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+			if err != nil {
+				log.Fatalf("memory export: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		n, err := exportMemories(w, *scope, *ns, !*noEmb)
+		if err != nil {
+			log.Fatalf("memory export: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "exported %d memories\n", n)
+
+	case "import":
+		fset := flag.NewFlagSet("memory import", flag.ExitOnError)
+		reembed := fset.Bool("reembed", false, "Embed every memory again, even if the model matches")
+		fset.Parse(args[1:])
+		if fset.NArg() != 1 {
+			log.Fatal("memory import: need one input file (- for stdin)")
+		}
+
+		r := io.Reader(os.Stdin)
+		if name := fset.Arg(0); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				log.Fatalf("memory import: %v", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		added, skipped, err := importMemories(r, *reembed)
+		if err != nil {
+			log.Fatalf("memory import: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "imported %d memories (%d already present)\n", added, skipped)
+
+	case "reembed":
+		fset := flag.NewFlagSet("memory reembed", flag.ExitOnError)
+		model := fset.String("model", "", "Embedding model to switch to")
+		batch := fset.Int("batch", 64, "Texts per embeddings request")
+		fset.Parse(args[1:])
+		if *model == "" || fset.NArg() != 0 || *batch < 1 {
+			log.Fatal("usage: go-chat memory reembed -model M [-batch N]")
+		}
+		if err := reembedAll(expandModel(*model), *batch); err != nil {
+			log.Fatalf("memory reembed: %v", err)
+		}
+
+	case "quantize":
+		runMemoryQuantize(args[1:])
+
+	default:
+		log.Fatalf("unknown memory command %q", args[0])
+	}
+}
+
+func exportMemories(w io.Writer, scope, ns string, withEmbeddings bool) (int, error) {
+	enc := json.NewEncoder(w)
+	hdr := memoryExportHeader{Format: memoryExportFormat, Version: memoryExportVersion, Exported: time.Now().UTC()}
+	if withEmbeddings {
+		hdr.EmbeddingModel = embeddingModel()
+	}
+	if err := enc.Encode(hdr); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range loadVectorStore() {
+		if scope != "" && normScope(m.Scope) != normScope(scope) {
+			continue
+		}
+		if ns != "" && !inNamespace(m.Namespace, ns) {
+			continue
+		}
+		rec := memoryRecord{ID: memoryID(m.Text), Text: m.Text, Scope: m.Scope, Namespace: m.Namespace}
+		if withEmbeddings {
+			rec.Embedding = m.Embedding
+		}
+		if err := enc.Encode(rec); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// importMemories adds the records that aren't already in the store (same
+// text, scope and namespace).
+func importMemories(r io.Reader, reembed bool) (added, skipped int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1<<20), 64<<20)
+	if !sc.Scan() {
+		return 0, 0, errors.Join(errors.New("empty file"), sc.Err())
+	}
+	var hdr memoryExportHeader
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil || hdr.Format != memoryExportFormat {
+		return 0, 0, errors.New("not a go-chat memory export")
+	}
+	if hdr.Version < 1 || hdr.Version > memoryExportVersion {
+		return 0, 0, fmt.Errorf("export version %d is not supported (this go-chat reads up to %d)", hdr.Version, memoryExportVersion)
+	}
+	keepEmbeddings := !reembed && hdr.EmbeddingModel == embeddingModel()
+
+	defer lockFile(vectorStorePath)()
+	store := loadVectorStore()
+	have := map[[3]string]bool{}
+	for _, m := range store {
+		have[[3]string{m.Text, normScope(m.Scope), storedNamespace(m.Namespace)}] = true
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		var rec memoryRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return added, skipped, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := validMemScope(rec.Scope); err != nil {
+			return added, skipped, fmt.Errorf("line %d: %w", line, err)
+		}
+		key := [3]string{rec.Text, normScope(rec.Scope), storedNamespace(rec.Namespace)}
+		if rec.Text == "" || have[key] {
+			skipped++
+			continue
+		}
+
+		vec := rec.Embedding
+		if !keepEmbeddings || len(vec) == 0 {
+			if vec, err = embedText(rec.Text); err != nil {
+				return added, skipped, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		store = append(store, VectorMemory{Text: rec.Text, Embedding: vec, Scope: rec.Scope, Namespace: storedNamespace(rec.Namespace)})
+		have[key] = true
+		added++
+	}
+	if err := sc.Err(); err != nil {
+		return added, skipped, err
+	}
+	saveVectorStore(store)
+	return added, skipped, nil
+}
+
+// reembedAll switches every memory and index chunk to another embedding
+// model. Nothing is written until all texts are embedded, and if saving
+// fails part way the old files are put back, so the stores never hold a mix
+// of models.
+func reembedAll(model string, batch int) error {
+	defer lockFile(vectorStorePath)()
+	defer lockFile(indexFilePath)()
+	store := loadVectorStore()
+	ix := loadIndex()
+
+	texts := make([]string, 0, len(store)+len(ix.Chunks))
+	for _, m := range store {
+		texts = append(texts, m.Text)
+	}
+	for _, c := range ix.Chunks {
+		texts = append(texts, c.Source+"\n"+c.Text) // as embedDocument does
+	}
+
+	vecs := make([][]float32, 0, len(texts))
+	for i := 0; i < len(texts); i += batch {
+		got, err := embedTexts(model, texts[i:min(i+batch, len(texts))])
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return fmt.Errorf("%w (nothing changed)", err)
+		}
+		for _, v := range got {
+			if len(v) == 0 || (len(vecs) > 0 && len(v) != len(vecs[0])) {
+				fmt.Fprintln(os.Stderr)
+				return errors.New("model returned inconsistent embeddings (nothing changed)")
+			}
+		}
+		vecs = append(vecs, got...)
+		fmt.Fprintf(os.Stderr, "\rre-embedded %d/%d", len(vecs), len(texts))
+	}
+	fmt.Fprintln(os.Stderr)
+
+	oldStore := loadVectorStore()
+	oldIndex := loadIndex()
+	for i := range store {
+		store[i].Embedding = vecs[i]
+	}
+	for i := range ix.Chunks {
+		ix.Chunks[i].Embedding = vecs[len(store)+i]
+	}
+
+	if err := saveVectorStore(store); err != nil {
+		saveVectorStore(oldStore)
+		return fmt.Errorf("save memories: %w (rolled back)", err)
+	}
+	if err := saveIndex(ix); err != nil {
+		saveVectorStore(oldStore)
+		saveIndex(oldIndex)
+		return fmt.Errorf("save index: %w (rolled back)", err)
+	}
+
+	unlock := lockFile(configFilePath)
+	from := embeddingModel()
+	cfg := readConfig()
+	cfg.EmbeddingModel = model
+	saveConfig(cfg)
+	unlock()
+	syncANN(store, true)
+	fmt.Fprintf(os.Stderr, "switched %d memories and %d index chunks from %s to %s\n", len(store), len(ix.Chunks), from, model)
+	return nil
+}
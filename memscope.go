@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// Memory scopes keep what the assistant learns in one conversation from
+// surfacing in another. A conversation reads its own scope plus the wider
+// ones, and writes (history, summaries, memories) only to its own:
+//
+//	private  the owner: the CLI, and server tokens issued without -memory
+//	team     shared with trusted users (e.g. OIDC logins)
+//	global   anyone, e.g. a public channel
+//
+// Log entries and memories written before scopes existed have no scope and
+// count as private.
+const (
+	memScopePrivate = "private"
+	memScopeTeam    = "team"
+	memScopeGlobal  = "global"
+)
+
+var memScopes = []string{memScopePrivate, memScopeTeam, memScopeGlobal}
+
+// ScopeRetrieval tunes how many memories a scope contributes per prompt and
+// how similar they must be. Set per scope under "memory_scopes" in config.
+type ScopeRetrieval struct {
+	TopK     int     `json:"top_k"`
+	MinScore float64 `json:"min_score"`
+}
+
+const defaultMemoryTopK = 3
+
+func normScope(s string) string {
+	if s == "" {
+		return memScopePrivate
+	}
+	return s
+}
+
+func validMemScope(s string) error {
+	if !slices.Contains(memScopes, normScope(s)) {
+		return fmt.Errorf("unknown memory scope %q (want private, team or global)", s)
+	}
+	return nil
+}
+
+// readableScopes lists the scopes visible from a conversation in scope s.
+func readableScopes(s string) []string {
+	i := slices.Index(memScopes, normScope(s))
+	if i < 0 {
+		return []string{memScopeGlobal}
+	}
+	return memScopes[i:]
+}
+
+func canRead(conv, item string) bool {
+	return slices.Contains(readableScopes(conv), normScope(item))
+}
+
+func scopeRetrieval(cfg Config, scope string) ScopeRetrieval {
+	r, ok := cfg.MemoryScopes[scope]
+	if !ok || r.TopK == 0 {
+		r.TopK = defaultMemoryTopK
+	}
+	return r
+}
+
+// rankMemories scores the store against vec and returns, for each scope
+// readable from conv, that scope's best matches under its retrieval
+// settings, merged best first. Scores include relevance feedback.
+func rankMemories(store []VectorMemory, vec []float32, conv string) []scoredMemory {
+	cfg := getConfig()
+	votes := loadFeedback()
+
+	var out []scoredMemory
+	for _, scope := range readableScopes(conv) {
+		r := scopeRetrieval(cfg, scope)
+
+		var scored []scoredMemory
+		for _, mem := range store {
+			if normScope(mem.Scope) != scope {
+				continue
+			}
+			s := cosineSim(mem.Embedding, vec)
+			if len(votes) > 0 {
+				s += feedbackBoost(votes[memoryKey(mem)])
+			}
+			if s >= r.MinScore {
+				scored = append(scored, scoredMemory{VectorMemory: mem, Score: s})
+			}
+		}
+		sort.Slice(scored, func(i, j int) bool { return scored[i].Score > scored[j].Score })
+		if len(scored) > r.TopK {
+			scored = scored[:r.TopK]
+		}
+		out = append(out, scored...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+type scoredMemory struct {
+	VectorMemory
+	Score float64
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Memories (day summaries, ones extracted at the end of a session) are
+// journalled before they are embedded: a line in
+// ~/.go-chat-memory-wal.jsonl holds the text until the memory is safely in
+// the store. If go-chat dies in between, or the embedding call fails, the
+// next run embeds and stores it. Records whose process is still running
+// are left to that process.
+const memoryWALPath = ".go-chat-memory-wal.jsonl"
+
+type walRecord struct {
+	ID        string `json:"id"`
+	PID       int    `json:"pid"`
+	Text      string `json:"text"`
+	Scope     string `json:"scope,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func walFile() string { return filepath.Join(homeDir, memoryWALPath) }
+
+// journalMemory appends rec to the journal and syncs it to disk.
+func journalMemory(rec walRecord) error {
+	defer lockFile(walFile())()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(walFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readWAL returns the journalled records. A line torn by a crash mid-append
+// is skipped. The caller holds the journal lock.
+func readWAL() []walRecord {
+	data, err := os.ReadFile(walFile())
+	if err != nil {
+		return nil
+	}
+	var recs []walRecord
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for sc.Scan() {
+		var r walRecord
+		if json.Unmarshal(sc.Bytes(), &r) == nil && r.ID != "" {
+			recs = append(recs, r)
+		}
+	}
+	return recs
+}
+
+// dropWAL removes the records in done from the journal. The caller holds
+// the journal lock.
+func dropWAL(done map[string]bool) error {
+	var buf bytes.Buffer
+	for _, r := range readWAL() {
+		if done[r.ID] {
+			continue
+		}
+		data, _ := json.Marshal(r)
+		buf.Write(append(data, '\n'))
+	}
+	if buf.Len() == 0 {
+		if err := os.Remove(walFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(walFile(), buf.Bytes(), 0o600)
+}
+
+// commitMemories adds embedded journal records to the store and then drops
+// them from the journal. Records already gone from the journal were stored
+// by another process and are skipped.
+func commitMemories(recs []walRecord, vecs [][]float32) error {
+	defer lockFile(vectorStorePath)()
+	defer lockFile(walFile())()
+
+	pending := map[string]bool{}
+	for _, r := range readWAL() {
+		pending[r.ID] = true
+	}
+	store := loadVectorStore()
+	done := map[string]bool{}
+	for i, r := range recs {
+		if pending[r.ID] {
+			store = append(store, VectorMemory{Text: r.Text, Embedding: vecs[i], Scope: r.Scope, Namespace: r.Namespace})
+			done[r.ID] = true
+		}
+	}
+	if len(done) == 0 {
+		return nil
+	}
+	if err := saveVectorStore(store); err != nil {
+		return err
+	}
+	return dropWAL(done)
+}
+
+// recoverMemories stores memories left in the journal by a run that
+// crashed or couldn't reach the embeddings API.
+func recoverMemories() {
+	if fileSize(walFile()) == 0 {
+		return
+	}
+	unlock := lockFile(walFile())
+	recs := readWAL()
+	unlock()
+
+	var ready []walRecord
+	var vecs [][]float32
+	for _, r := range recs {
+		if r.PID != os.Getpid() && processAlive(r.PID) {
+			continue
+		}
+		vec, err := embedText(r.Text)
+		if err != nil {
+			log.Printf("memory journal: %v (will retry next run)", err)
+			return
+		}
+		ready = append(ready, r)
+		vecs = append(vecs, vec)
+	}
+	if len(ready) == 0 {
+		return
+	}
+	if err := commitMemories(ready, vecs); err != nil {
+		log.Printf("memory journal: %v (will retry next run)", err)
+		return
+	}
+	log.Printf("recovered %d unsaved memories", len(ready))
+}
+
+func newWALRecord(text, scope, ns string) walRecord {
+	return walRecord{
+		ID:        fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano()),
+		PID:       os.Getpid(),
+		Text:      text,
+		Scope:     scope,
+		Namespace: storedNamespace(ns),
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Model aliases are short names for models:
+//
+//	"model_aliases": {"fast": "gpt-4o-mini", "smart": "gpt-4o"}
+//
+// An alias works anywhere a model name does (-model flags, profiles,
+// personas, fusion roles, "embedding_model"), and the model it stands for
+// is what's sent and priced. Aliases don't chain.
+//
+// `go-chat models` lists the models the provider serves, from its
+// /v1/models, with the aliases that pick each; a word narrows the list.
+
+// expandModel is the model an alias stands for, or model itself.
+func expandModel(model string) string {
+	if m := getConfig().ModelAliases[model]; m != "" {
+		return m
+	}
+	return model
+}
+
+func runModels(args []string) {
+	fset := flag.NewFlagSet("models", flag.ExitOnError)
+	provider := fset.String("provider", "", "List this provider's models instead of the configured one's")
+	rest := parseInterspersed(fset, args)
+	if len(rest) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat models [-provider P] [filter]")
+		os.Exit(2)
+	}
+	cfg := getConfig()
+	name := cmp.Or(*provider, providerName(cfg))
+	p, err := providerByName(name)
+	if err != nil {
+		log.Fatalf("models: %v", err)
+	}
+	l, ok := p.(modelLister)
+	if !ok {
+		log.Fatalf("models: %s can't list its models", name)
+	}
+	ids, err := l.ListModels()
+	if err != nil {
+		log.Fatalf("models: %v", err)
+	}
+	slices.Sort(ids)
+
+	// Aliases are shown against the model they pick on this provider.
+	aliases := map[string][]string{}
+	for a := range cfg.ModelAliases {
+		m := resolveModel(p, a)
+		aliases[m] = append(aliases[m], a)
+	}
+	filter := ""
+	if len(rest) == 1 {
+		filter = strings.ToLower(rest[0])
+	}
+	for _, id := range ids {
+		if !strings.Contains(strings.ToLower(id), filter) {
+			continue
+		}
+		if as := aliases[id]; len(as) > 0 {
+			slices.Sort(as)
+			fmt.Printf("%s  (%s)\n", id, strings.Join(as, ", "))
+		} else {
+			fmt.Println(id)
+		}
+	}
+	if filter != "" {
+		return
+	}
+	for m, as := range aliases {
+		if !slices.Contains(ids, m) {
+			slices.Sort(as)
+			fmt.Fprintf(os.Stderr, "%s: %s isn't served by %s\n", strings.Join(as, ", "), m, name)
+		}
+	}
+}
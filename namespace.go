@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Namespaces split history and memories by topic (work, health, gamedev)
+// within a memory scope, so unrelated parts of life don't leak into each
+// other's answers. A session runs in one namespace, picked with -ns or the
+// config's "namespace" and switched in interactive mode with /ns; memory
+// retrieval stays inside it unless -all-ns is given. Entries without a
+// namespace belong to "default".
+const (
+	defaultNamespace = "default"
+	allNamespaces    = "*"
+)
+
+var (
+	activeNamespace     string
+	searchAllNamespaces bool
+)
+
+var namespaceRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+func validNamespace(ns string) error {
+	if ns != "" && !namespaceRe.MatchString(ns) {
+		return fmt.Errorf("bad namespace %q (letters, digits, - and _)", ns)
+	}
+	return nil
+}
+
+// storedNamespace is the value written to logs and memories: empty for
+// the default namespace, so older files need no migration.
+func storedNamespace(ns string) string {
+	if ns == defaultNamespace {
+		return ""
+	}
+	return ns
+}
+
+// inNamespace reports whether an entry tagged item is visible from ns.
+func inNamespace(item, ns string) bool {
+	return ns == allNamespaces || storedNamespace(item) == storedNamespace(ns)
+}
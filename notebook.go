@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// `go-chat notebook run file.md` treats a markdown file as a prompt
+// notebook. Every ```prompt block is sent in order, as one conversation in
+// which the prose and code since the previous prompt come along as
+// context, and the answer is written back under the block. With -exec,
+// sh/bash/python/go blocks are run too and their output inserted.
+//
+// Inserted sections sit between <!-- answer --> or <!-- output --> markers
+// and are replaced on every run, so a notebook can be re-run to refresh it.
+const (
+	nbAnswerStart = "<!-- answer -->"
+	nbAnswerEnd   = "<!-- /answer -->"
+	nbOutputStart = "<!-- output -->"
+	nbOutputEnd   = "<!-- /output -->"
+
+	nbExecTimeout = 2 * time.Minute
+)
+
+// nbItem is a run of plain lines or one fenced block.
+type nbItem struct {
+	lines []string // raw lines, fences included
+	lang  string   // set for fenced blocks
+	body  string
+}
+
+func runNotebook(args []string) {
+	if len(args) == 0 || args[0] != "run" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat notebook run [-exec] [-o out.md] <notebook.md>")
+		os.Exit(2)
+	}
+	fset := flag.NewFlagSet("notebook run", flag.ExitOnError)
+	execCode := fset.Bool("exec", false, "Run sh, bash, python and go blocks and insert their output")
+	outPath := fset.String("o", "", "Write the result here instead of updating the notebook")
+	fset.Parse(args[1:])
+	if fset.NArg() != 1 {
+		log.Fatal("notebook run: need exactly one notebook")
+	}
+	path := fset.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("notebook run: %v", err)
+	}
+	items := parseNotebook(string(data))
+
+	var out strings.Builder
+	var msgs []Message
+	var pending strings.Builder // context since the last prompt
+	n := 0
+	for _, it := range items {
+		out.WriteString(strings.Join(it.lines, "\n") + "\n")
+
+		switch {
+		case it.lang == "prompt":
+			n++
+			fmt.Fprintf(os.Stderr, "prompt %d...\n", n)
+			content := strings.TrimSpace(it.body)
+			if ctx := strings.TrimSpace(pending.String()); ctx != "" {
+				content = ctx + "\n\n" + content
+			}
+			pending.Reset()
+			msgs = append(msgs, Message{Role: "user", Content: content})
+			answer, err := queryGPTStream(modelExec, prompt("notebook"), 0.2, 2048, msgs, nil)
+			if err != nil {
+				log.Fatalf("notebook run: prompt %d: %v", n, err)
+			}
+			answer = strings.TrimSpace(answer)
+			msgs = append(msgs, Message{Role: "assistant", Content: answer})
+			fmt.Fprintf(&out, "%s\n%s\n%s\n", nbAnswerStart, answer, nbAnswerEnd)
+
+		case it.lang != "" && *execCode && nbRunnable(it.lang):
+			fmt.Fprintf(os.Stderr, "running %s block...\n", it.lang)
+			result := strings.TrimRight(runNotebookCode(filepath.Dir(path), it.lang, it.body), "\n")
+			fmt.Fprintf(&out, "%s\n```text\n%s\n```\n%s\n", nbOutputStart, result, nbOutputEnd)
+			pending.WriteString(strings.Join(it.lines, "\n") + "\nOutput:\n```text\n" + result + "\n```\n")
+
+		default:
+			pending.WriteString(strings.Join(it.lines, "\n") + "\n")
+		}
+	}
+
+	dest := path
+	if *outPath != "" {
+		dest = *outPath
+	}
+	if err := os.WriteFile(dest, []byte(strings.TrimRight(out.String(), "\n")+"\n"), 0o644); err != nil {
+		log.Fatalf("notebook run: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "%d prompts answered, wrote %s\n", n, dest)
+}
+
+// parseNotebook splits a notebook into prose runs and fenced blocks,
+// dropping sections inserted by earlier runs.
+func parseNotebook(text string) []nbItem {
+	var items []nbItem
+	var prose []string
+	flush := func() {
+		if len(prose) > 0 {
+			items = append(items, nbItem{lines: prose})
+			prose = nil
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		trimmed := strings.TrimSpace(l)
+
+		if trimmed == nbAnswerStart || trimmed == nbOutputStart {
+			end := nbAnswerEnd
+			if trimmed == nbOutputStart {
+				end = nbOutputEnd
+			}
+			for i < len(lines) && strings.TrimSpace(lines[i]) != end {
+				i++
+			}
+			continue
+		}
+
+		fence, ok := fenceOf(trimmed)
+		if !ok {
+			prose = append(prose, l)
+			continue
+		}
+		flush()
+		block := nbItem{lines: []string{l}, lang: strings.TrimSpace(strings.TrimPrefix(trimmed, fence))}
+		if block.lang == "" {
+			block.lang = "text"
+		}
+		var body []string
+		for i++; i < len(lines); i++ {
+			block.lines = append(block.lines, lines[i])
+			if strings.TrimSpace(lines[i]) == fence {
+				break
+			}
+			body = append(body, lines[i])
+		}
+		block.body = strings.Join(body, "\n")
+		items = append(items, block)
+	}
+	flush()
+	return items
+}
+
+// fenceOf returns the backtick fence opening a block, if the line is one.
+func fenceOf(line string) (string, bool) {
+	n := len(line) - len(strings.TrimLeft(line, "`"))
+	if n < 3 {
+		return "", false
+	}
+	return line[:n], true
+}
+
+func nbRunnable(lang string) bool {
+	switch lang {
+	case "sh", "bash", "shell", "python", "python3", "go":
+		return true
+	}
+	return false
+}
+
+// runNotebookCode runs a block from the notebook's directory and returns
+// its combined output, noting failures rather than stopping the run.
+func runNotebookCode(dir, lang, code string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), nbExecTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch lang {
+	case "sh", "shell":
+		cmd = exec.CommandContext(ctx, "sh", "-c", code)
+	case "bash":
+		cmd = exec.CommandContext(ctx, "bash", "-c", code)
+	case "python", "python3":
+		cmd = exec.CommandContext(ctx, "python3", "-")
+		cmd.Stdin = strings.NewReader(code)
+	case "go":
+		tmp, err := os.MkdirTemp("", "go-chat-nb-")
+		if err != nil {
+			return err.Error()
+		}
+		defer os.RemoveAll(tmp)
+		file := filepath.Join(tmp, "main.go")
+		if err := os.WriteFile(file, []byte(code), 0o644); err != nil {
+			return err.Error()
+		}
+		cmd = exec.CommandContext(ctx, "go", "run", file)
+	}
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("%s\n(%v)", strings.TrimRight(string(out), "\n"), err)
+	}
+	return string(out)
+}
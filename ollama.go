@@ -0,0 +1,64 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"strings"
+)
+
+// The ollama provider chats with models run locally by Ollama, at
+// $OLLAMA_HOST or http://localhost:11434, and embeds memories with them
+// too, so nothing needs an OpenAI key. Ollama serves the OpenAI chat
+// completions and embeddings APIs, so openAIProvider does the talking.
+// go-chat's OpenAI model names map to "model", or "small_model" for the
+// mini and nano ones; other names are sent as they are.
+//
+//	"provider": "ollama",
+//	"ollama": {"model": "llama3.1", "embedding_model": "nomic-embed-text"}
+type OllamaConfig struct {
+	Model          string `json:"model,omitempty"`           // default llama3.1
+	SmallModel     string `json:"small_model,omitempty"`     // default model
+	EmbeddingModel string `json:"embedding_model,omitempty"` // default nomic-embed-text
+}
+
+const (
+	ollamaName        = "ollama"
+	defaultOllamaURL  = "http://localhost:11434"
+	defaultOllamaChat = "llama3.1"
+	defaultOllamaEmb  = "nomic-embed-text"
+)
+
+type ollamaProvider struct{ *openAIProvider }
+
+func init() {
+	registerProvider(ollamaName, ollamaProvider{&openAIProvider{name: ollamaName, baseURL: ollamaURL()}})
+}
+
+// ollamaURL reads $OLLAMA_HOST, which Ollama itself accepts without a
+// scheme ("0.0.0.0:11434").
+func ollamaURL() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		return defaultOllamaURL
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return host
+}
+
+func (ollamaProvider) ResolveModel(model string) string {
+	if !strings.HasPrefix(model, "gpt-") {
+		return model
+	}
+	cfg := getConfig().Ollama
+	chat := cmp.Or(cfg.Model, defaultOllamaChat)
+	if strings.Contains(model, "-mini") || strings.Contains(model, "-nano") {
+		return cmp.Or(cfg.SmallModel, chat)
+	}
+	return chat
+}
+
+func (ollamaProvider) EmbeddingModel() string {
+	return cmp.Or(getConfig().Ollama.EmbeddingModel, defaultOllamaEmb)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// openAIProvider speaks the OpenAI chat completions and embeddings APIs,
+// which many other services also offer. The default "openai" provider
+// uses $OPENAI_API_KEY and $OPENAI_API_BASE.
+type openAIProvider struct {
+	name    string
+	baseURL string // default apiURL
+	keyEnv  string // environment variable holding the API key; empty if none is needed
+}
+
+func init() {
+	registerProvider(defaultProvider, &openAIProvider{name: defaultProvider, keyEnv: "OPENAI_API_KEY"})
+}
+
+// statusError is an error response from an API, kept with its HTTP status
+// for callers that treat some differently.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string { return e.msg }
+
+func (p *openAIProvider) base() string {
+	if p.baseURL != "" {
+		return strings.TrimSuffix(p.baseURL, "/")
+	}
+	return apiURL
+}
+
+func (p *openAIProvider) key() string {
+	if p.name == defaultProvider {
+		return apiKey
+	}
+	return os.Getenv(p.keyEnv)
+}
+
+func (p *openAIProvider) Complete(req ChatRequest) (Message, error) { return p.chat(req, nil) }
+
+func (p *openAIProvider) Stream(req ChatRequest, onToken func(string)) (Message, error) {
+	return p.chat(req, onToken)
+}
+
+func (p *openAIProvider) chat(r ChatRequest, onToken func(string)) (Message, error) {
+	if p.keyEnv != "" && p.key() == "" {
+		return Message{}, fmt.Errorf("%s: %s env missing", p.name, p.keyEnv)
+	}
+	stream := onToken != nil
+	payload := map[string]any{
+		"model":             r.Model,
+		"messages":          r.Messages,
+		"temperature":       r.Temperature,
+		"max_tokens":        r.MaxTokens,
+		"top_p":             0.96,
+		"frequency_penalty": 0.3,
+		"presence_penalty":  0.0,
+		"stream":            stream,
+	}
+	if len(r.Stop) > 0 {
+		payload["stop"] = r.Stop
+	}
+	if r.Seed != nil {
+		payload["seed"] = *r.Seed
+	}
+	if len(r.Tools) > 0 {
+		payload["tools"] = r.Tools
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(payload); err != nil {
+		return Message{}, fmt.Errorf("encode payload: %w", err)
+	}
+
+	ctx, gotToken, done := watchFirstToken(stream)
+	defer done()
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		p.base()+"/v1/chat/completions",
+		&buf,
+	)
+	if err != nil {
+		return Message{}, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := p.key(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Message{}, requestErr(ctx, fmt.Errorf("http: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return Message{}, &statusError{status: resp.StatusCode, msg: fmt.Sprintf("%s: %s – %s", p.name, resp.Status, body)}
+	}
+
+	if !stream {
+		var out struct {
+			Choices []struct {
+				Message Message `json:"message"`
+			} `json:"choices"`
+		}
+		err := json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if err != nil {
+			return Message{}, requestErr(ctx, fmt.Errorf("decode: %w", err))
+		}
+		if len(out.Choices) == 0 {
+			return Message{}, fmt.Errorf("%s: no choices returned", p.name)
+		}
+		return out.Choices[0].Message, nil
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var answer strings.Builder
+	var calls []ToolCall
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				resp.Body.Close()
+				return Message{}, requestErr(ctx, fmt.Errorf("stream read: %w", err))
+			}
+			break
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(line[len("data:"):])
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int          `json:"index"`
+						ID       string       `json:"id"`
+						Function ToolFunction `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" || len(delta.ToolCalls) > 0 {
+			gotToken()
+		}
+		for _, d := range delta.ToolCalls {
+			for len(calls) <= d.Index {
+				calls = append(calls, ToolCall{Type: "function"})
+			}
+			if d.ID != "" {
+				calls[d.Index].ID = d.ID
+			}
+			calls[d.Index].Function.Name += d.Function.Name
+			calls[d.Index].Function.Arguments += d.Function.Arguments
+		}
+		if text := delta.Content; text != "" {
+			onToken(text)
+			answer.WriteString(text)
+		}
+	}
+	resp.Body.Close()
+
+	return Message{Role: "assistant", Content: answer.String(), ToolCalls: calls}, nil
+}
+
+func (p *openAIProvider) Embed(model string, texts []string) ([][]float32, error) {
+	if p.keyEnv != "" && p.key() == "" {
+		return nil, fmt.Errorf("%s: %s env missing", p.name, p.keyEnv)
+	}
+	payload := map[string]any{
+		"model": model,
+		"input": texts,
+	}
+
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest("POST", p.base()+"/v1/embeddings", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key := p.key(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embeddings: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings: got %d for %d inputs", len(out.Data), len(texts))
+	}
+	vecs := make([][]float32, len(texts))
+	for _, d := range out.Data {
+		if d.Index < 0 || d.Index >= len(vecs) {
+			return nil, fmt.Errorf("embeddings: bad index %d", d.Index)
+		}
+		vecs[d.Index] = d.Embedding
+	}
+	return vecs, nil
+}
+
+// ListModels lists the models the API serves; Ollama and OpenRouter answer
+// at the same path.
+func (p *openAIProvider) ListModels() ([]string, error) {
+	if p.keyEnv != "" && p.key() == "" {
+		return nil, fmt.Errorf("%s: %s env missing", p.name, p.keyEnv)
+	}
+	req, _ := http.NewRequest("GET", p.base()+"/v1/models", nil)
+	if key := p.key(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s: %s", p.name, resp.Status, bytes.TrimSpace(b))
+	}
+
+	var out struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(out.Data))
+	for i, d := range out.Data {
+		ids[i] = d.ID
+	}
+	return ids, nil
+}
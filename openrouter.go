@@ -0,0 +1,126 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The openrouter provider sends chats through OpenRouter, using
+// $OPENROUTER_API_KEY and $OPENROUTER_API_BASE. "models" lists the models
+// to use in order of preference: go-chat's OpenAI model names map to the
+// first, and when a model is rate limited (429) or unavailable the next
+// one is asked instead, with a note in the log saying which answered.
+// "small_models" does the same for the mini and nano names. Without a
+// list, OpenAI names are sent as openai/<name>; OpenRouter model ids
+// ("anthropic/claude-sonnet-4.5") are sent as they are, falling back along
+// the list they're in.
+//
+//	"provider": "openrouter",
+//	"openrouter": {"models": ["anthropic/claude-sonnet-4.5", "openai/gpt-4o"]}
+//
+// OpenRouter has no embeddings API, so embeddings go to the default
+// provider.
+type OpenRouterConfig struct {
+	Models      []string `json:"models,omitempty"`
+	SmallModels []string `json:"small_models,omitempty"` // default models
+}
+
+const (
+	openRouterName = "openrouter"
+	defaultORURL   = "https://openrouter.ai/api"
+)
+
+type openRouterProvider struct{ *openAIProvider }
+
+func init() {
+	registerProvider(openRouterName, openRouterProvider{&openAIProvider{
+		name:    openRouterName,
+		baseURL: cmp.Or(os.Getenv("OPENROUTER_API_BASE"), defaultORURL),
+		keyEnv:  "OPENROUTER_API_KEY",
+	}})
+}
+
+func (openRouterProvider) ResolveModel(model string) string {
+	if !strings.HasPrefix(model, "gpt-") {
+		return model
+	}
+	cfg := getConfig().OpenRouter
+	list := cfg.Models
+	small := strings.Contains(model, "-mini") || strings.Contains(model, "-nano")
+	if small && len(cfg.SmallModels) > 0 {
+		list = cfg.SmallModels
+	}
+	if len(list) == 0 {
+		return "openai/" + model
+	}
+	return list[0]
+}
+
+// fallbacks is the model followed by those after it in the configured
+// list it's in.
+func (openRouterProvider) fallbacks(model string) []string {
+	cfg := getConfig().OpenRouter
+	for _, list := range [][]string{cfg.Models, cfg.SmallModels} {
+		if i := slices.Index(list, model); i >= 0 {
+			return list[i:]
+		}
+	}
+	return []string{model}
+}
+
+func (o openRouterProvider) Complete(req ChatRequest) (Message, error) {
+	return o.withFallback(req, nil)
+}
+
+func (o openRouterProvider) Stream(req ChatRequest, onToken func(string)) (Message, error) {
+	return o.withFallback(req, onToken)
+}
+
+// withFallback asks each model in turn until one answers. A model that
+// fails after its answer has started streaming isn't retried.
+func (o openRouterProvider) withFallback(req ChatRequest, onToken func(string)) (Message, error) {
+	models := o.fallbacks(req.Model)
+	var errs []error
+	for i, model := range models {
+		req.Model = model
+		started := false
+		var tokenFn func(string)
+		if onToken != nil {
+			tokenFn = func(s string) {
+				started = true
+				onToken(s)
+			}
+		}
+		reply, err := o.chat(req, tokenFn)
+		if err == nil {
+			if i > 0 {
+				log.Printf("openrouter: answered by %s", model)
+			}
+			return reply, nil
+		}
+		var se *statusError
+		if started || !errors.As(err, &se) || !unavailable(se.status) {
+			return Message{}, err
+		}
+		if i+1 < len(models) {
+			log.Printf("openrouter: %s unavailable (%d), trying %s", model, se.status, models[i+1])
+		}
+		errs = append(errs, err)
+	}
+	return Message{}, errors.Join(errs...)
+}
+
+// unavailable says whether a status means another model may do better:
+// rate limits, models OpenRouter can't route to and upstream failures.
+func unavailable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusNotFound || status >= http.StatusInternalServerError
+}
+
+func (openRouterProvider) Embed(model string, texts []string) ([][]float32, error) {
+	return providers[defaultProvider].Embed(model, texts)
+}
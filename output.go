@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// outputFile is set by -o: the answer to the prompt is written there
+// instead of to stdout, in a format chosen by the extension. .json gets
+// the prompt and answer as an object, .txt the answer as plain text with
+// the Markdown taken out, and anything else (.md included) the answer as
+// the model wrote it. Missing directories are created, and an existing
+// file is only replaced with -overwrite.
+var (
+	outputFile      string
+	overwriteOutput bool // -overwrite
+)
+
+// checkOutputFile refuses to go on when -o would overwrite a file, so
+// nothing is sent only to be thrown away.
+func checkOutputFile(path string) error {
+	if overwriteOutput {
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("-o %s: is a directory", path)
+		}
+		return fmt.Errorf("-o %s: file exists (use -overwrite to replace it)", path)
+	}
+	return nil
+}
+
+type answerFile struct {
+	Prompt  string    `json:"prompt"`
+	Answer  string    `json:"answer"`
+	Speaker string    `json:"speaker,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// writeOutput writes an answer to path in the format its extension asks
+// for.
+func writeOutput(path, userPrompt, answer, speaker string) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var err error
+		data, err = json.MarshalIndent(answerFile{userPrompt, answer, speaker, time.Now()}, "", "  ")
+		if err != nil {
+			return err
+		}
+	case ".txt":
+		data = []byte(plainText(answer))
+	default:
+		data = []byte(answer)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0o644)
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^#{1,6}\s+`)
+	mdImage   = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdBold    = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalic  = regexp.MustCompile(`(^|[^\w*])\*([^*\s]|[^*\s][^*]*[^*\s])\*([^\w*]|$)`)
+	mdCode    = regexp.MustCompile("`([^`]+)`")
+)
+
+// plainText takes the Markdown out of an answer: headings, emphasis,
+// inline code and fences go, and links keep their URL in brackets. Code
+// inside fences is left alone.
+func plainText(md string) string {
+	lines := strings.Split(md, "\n")
+	out := lines[:0]
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			line = mdHeading.ReplaceAllString(line, "")
+			line = mdImage.ReplaceAllString(line, "$1 ($2)")
+			line = mdLink.ReplaceAllString(line, "$1 ($2)")
+			line = mdBold.ReplaceAllString(line, "$1$2")
+			line = mdItalic.ReplaceAllString(line, "$1$2$3")
+			line = mdCode.ReplaceAllString(line, "$1")
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
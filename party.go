@@ -0,0 +1,105 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// In interactive mode, /invite name brings an installed persona into the
+// conversation alongside the configured assistant. Answers then take turns
+// between the participants, or go to whoever a line addresses with @name.
+// Each answer is shown and logged under its speaker's name, and the others'
+// replies appear labelled in every participant's history.
+type party struct {
+	members []string // persona names; "" is the configured assistant
+	next    int
+}
+
+func newParty() *party { return &party{members: []string{""}} }
+
+func (p *party) active() bool { return len(p.members) > 1 }
+
+// label is the name a member's answers are shown and logged under.
+func (p *party) label(member string) string {
+	if member != "" {
+		return member
+	}
+	cfg := getConfig()
+	return cmp.Or(personaName, cfg.Persona, cfg.AIName)
+}
+
+func (p *party) invite(name string) error {
+	if _, err := loadPersona(name); err != nil {
+		return err
+	}
+	for _, m := range p.members {
+		if p.label(m) == name {
+			return fmt.Errorf("%s is already here", name)
+		}
+	}
+	p.members = append(p.members, name)
+	return nil
+}
+
+func (p *party) dismiss(name string) error {
+	i := slices.Index(p.members, name)
+	if i <= 0 {
+		return fmt.Errorf("%s wasn't invited", name)
+	}
+	p.members = slices.Delete(p.members, i, i+1)
+	p.next %= len(p.members)
+	return nil
+}
+
+// pick chooses who answers line: the member it addresses with a leading
+// @name, otherwise the next in turn.
+func (p *party) pick(line string) string {
+	i := p.next
+	if name, ok := strings.CutPrefix(line, "@"); ok {
+		name, _, _ = strings.Cut(name, " ")
+		name = strings.TrimRight(name, ",:")
+		if j := slices.IndexFunc(p.members, func(m string) bool { return p.label(m) == name }); j >= 0 {
+			i = j
+		}
+	}
+	p.next = (i + 1) % len(p.members)
+	return p.members[i]
+}
+
+// options sets up a turn answered by member.
+func (p *party) options(member string) chatOptions {
+	opts := chatOptions{Persona: member, Speaker: p.label(member)}
+	for _, m := range p.members {
+		if m != member {
+			opts.Others = append(opts.Others, p.label(m))
+		}
+	}
+	return opts
+}
+
+// command handles /invite and /dismiss, reporting whether line was one.
+func (p *party) command(line string) bool {
+	cmd, name, _ := strings.Cut(line, " ")
+	name = strings.TrimSpace(name)
+	var err error
+	switch cmd {
+	case "/invite":
+		err = p.invite(name)
+	case "/dismiss":
+		err = p.dismiss(name)
+	default:
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		return true
+	}
+	labels := make([]string, len(p.members))
+	for i, m := range p.members {
+		labels[i] = p.label(m)
+	}
+	fmt.Println("in this conversation:", strings.Join(labels, ", "))
+	return true
+}
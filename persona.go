@@ -0,0 +1,386 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Persona packs bundle a character for the assistant: a directory (or a
+// .tar.gz/.zip of one, locally or at a URL) holding
+//
+//	persona.json    {"name", "description", "model", "temperature",
+//	                 "theme": {"color": "magenta", "prompt": "🧙 "}}
+//	system.txt      the system prompt, used in place of the personality
+//	examples.jsonl  optional few-shot turns, {"user": "...", "assistant": "..."}
+//
+// `go-chat persona install` copies packs into ~/.go-chat-personas/<name>;
+// -persona name (or "persona" in the config) picks one.
+const (
+	personaManifest = "persona.json"
+	personaSystem   = "system.txt"
+	personaExamples = "examples.jsonl"
+
+	maxPersonaPackSize = 10 << 20
+)
+
+var (
+	personasDirPath string
+	personaName     string
+)
+
+type Persona struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Model       string       `json:"model,omitempty"`
+	Temperature *float64     `json:"temperature,omitempty"`
+	Theme       PersonaTheme `json:"theme,omitempty"`
+
+	System   string    `json:"-"`
+	Examples []Message `json:"-"`
+}
+
+type PersonaTheme struct {
+	Color  string `json:"color,omitempty"`  // answer colour, see personaColors
+	Prompt string `json:"prompt,omitempty"` // interactive prompt instead of "> "
+}
+
+var personaColors = map[string]string{
+	"red":     "\033[31m",
+	"green":   "\033[32m",
+	"yellow":  "\033[33m",
+	"blue":    "\033[34m",
+	"magenta": "\033[35m",
+	"cyan":    "\033[36m",
+}
+
+// currentPersona returns the persona selected by -persona or the config,
+// or nil for none.
+func currentPersona(cfg Config) *Persona {
+	name := personaName
+	if name == "" {
+		name = cfg.Persona
+	}
+	if name == "" {
+		return nil
+	}
+	p, err := loadPersona(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return p
+}
+
+// loadPersona reads an installed persona.
+func loadPersona(name string) (*Persona, error) {
+	if name == "" || validNamespace(name) != nil {
+		return nil, fmt.Errorf("bad persona name %q", name)
+	}
+	p, err := readPersona(filepath.Join(personasDirPath, name))
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %v (see go-chat persona list)", name, err)
+	}
+	return p, nil
+}
+
+func readPersona(dir string) (*Persona, error) {
+	data, err := os.ReadFile(filepath.Join(dir, personaManifest))
+	if err != nil {
+		return nil, err
+	}
+	p := &Persona{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", personaManifest, err)
+	}
+	if err := validNamespace(p.Name); err != nil || p.Name == "" {
+		return nil, fmt.Errorf("%s: bad name %q", personaManifest, p.Name)
+	}
+	if c := p.Theme.Color; c != "" && personaColors[c] == "" {
+		return nil, fmt.Errorf("%s: unknown theme color %q", personaManifest, c)
+	}
+
+	sys, err := os.ReadFile(filepath.Join(dir, personaSystem))
+	if err != nil {
+		return nil, err
+	}
+	p.System = strings.TrimSpace(string(sys))
+
+	f, err := os.Open(filepath.Join(dir, personaExamples))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var ex Example
+		if err := json.Unmarshal(sc.Bytes(), &ex); err != nil || ex.User == "" || ex.Assistant == "" {
+			return nil, fmt.Errorf("%s:%d: want {\"user\": ..., \"assistant\": ...}", personaExamples, line)
+		}
+		p.Examples = append(p.Examples,
+			Message{Role: "user", Content: ex.User},
+			Message{Role: "assistant", Content: ex.Assistant},
+		)
+	}
+	return p, sc.Err()
+}
+
+// answerColor is the escape sequence that starts an answer in the colour
+// of the named persona (or the current one), if it has one and stdout is a
+// terminal.
+func answerColor(name string) string {
+	p := currentPersona(getConfig())
+	if name != "" {
+		p, _ = loadPersona(name)
+	}
+	if p == nil || termWidth() == 0 {
+		return ""
+	}
+	return personaColors[p.Theme.Color]
+}
+
+// examples returns the persona's few-shot turns; none for a nil persona.
+func (p *Persona) examples() []Message {
+	if p == nil {
+		return nil
+	}
+	return p.Examples
+}
+
+func runPersona(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat persona install <dir|archive|url> | list | remove <name>")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "install":
+		if len(args) != 2 {
+			log.Fatal("usage: go-chat persona install <dir|archive|url>")
+		}
+		if err := installPersona(args[1]); err != nil {
+			log.Fatalf("persona install: %v", err)
+		}
+
+	case "list":
+		entries, _ := os.ReadDir(personasDirPath)
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Println("no personas installed (go-chat persona install <pack>)")
+		}
+		for _, n := range names {
+			p, err := readPersona(filepath.Join(personasDirPath, n))
+			if err != nil {
+				fmt.Printf("%-16s (broken: %v)\n", n, err)
+				continue
+			}
+			fmt.Printf("%-16s %s\n", n, p.Description)
+		}
+
+	case "remove":
+		if len(args) != 2 || validNamespace(args[1]) != nil {
+			log.Fatal("usage: go-chat persona remove <name>")
+		}
+		dir := filepath.Join(personasDirPath, args[1])
+		if _, err := os.Stat(dir); err != nil {
+			log.Fatalf("persona remove: %v", err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Fatalf("persona remove: %v", err)
+		}
+		fmt.Println("removed", args[1])
+
+	default:
+		log.Fatalf("unknown persona command %q", args[0])
+	}
+}
+
+func installPersona(src string) error {
+	dir := src
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		tmp, err := os.MkdirTemp("", "go-chat-persona-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		if dir, err = unpackPersona(src, tmp); err != nil {
+			return err
+		}
+	}
+
+	p, err := readPersona(dir)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(personasDirPath, p.Name)
+	if _, err := os.Stat(dst); err == nil && !confirm(fmt.Sprintf("Replace installed persona %q?", p.Name)) {
+		return errors.New("aborted")
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for _, f := range []string{personaManifest, personaSystem, personaExamples} {
+		data, err := os.ReadFile(filepath.Join(dir, f))
+		if errors.Is(err, os.ErrNotExist) && f == personaExamples {
+			os.Remove(filepath.Join(dst, f))
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, f), data, 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("installed %s (%s); use it with -persona %s\n", p.Name, dst, p.Name)
+	return nil
+}
+
+// unpackPersona extracts a pack archive, read from a file or URL, into tmp
+// and returns the directory holding persona.json.
+func unpackPersona(src, tmp string) (string, error) {
+	var data []byte
+	var err error
+	if isURL(src) {
+		var resp *http.Response
+		if resp, err = httpClient.Get(src); err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s: %s", src, resp.Status)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, maxPersonaPackSize+1))
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxPersonaPackSize {
+		return "", errors.New("pack is larger than 10MB")
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		err = unzipPersona(data, tmp)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		err = untarPersona(data, tmp)
+	default:
+		return "", errors.New("not a persona directory, .zip or .tar.gz")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Packs are often archived with a top-level directory.
+	if _, err := os.Stat(filepath.Join(tmp, personaManifest)); err == nil {
+		return tmp, nil
+	}
+	entries, _ := os.ReadDir(tmp)
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(tmp, entries[0].Name()), nil
+	}
+	return "", fmt.Errorf("no %s in pack", personaManifest)
+}
+
+// packPath maps an archive entry into dir, refusing ones that escape it.
+func packPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if !isUnder(p, dir) {
+		return "", fmt.Errorf("bad path %q in pack", name)
+	}
+	return p, nil
+}
+
+func unzipPersona(data []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		p, err := packPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writePackFile(p, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func untarPersona(data []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := packPath(dir, h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writePackFile(p, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writePackFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxPersonaPackSize))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// go-chat in a pipeline. With -q only the answer goes to stdout, as the
+// model wrote it: no colour, speaker label or wrapping, and the context
+// footer, notices and questions go to stderr with the log. Input piped to
+// stdin is read when there's no prompt argument, or with -q or
+// -input-format, and is added after the prompt:
+//
+//	git diff | go-chat -q "write a commit message" | go-chat -q "translate to German"
+//
+// With -input-format json, stdin holds a conversation instead, either an
+// array of {"role", "content"} messages or {"messages": [...], "prompt":
+// "..."}. The messages are sent before the prompt; without a prompt (or
+// argument), a final user message is the prompt. Other programs can pass
+// structured context this way.
+//
+// The exit status says what happened, with or without -q.
+const (
+	exitError   = 1 // the request failed
+	exitUsage   = 2 // no prompt, or input that can't be read
+	exitNotSent = 3 // declined when asked to confirm the cost
+)
+
+var (
+	quietMode   bool   // -q
+	inputFormat string // -input-format: text (default) or json
+)
+
+// pipeInput returns the prompt and any earlier messages, reading stdin
+// when it is piped.
+func pipeInput(args string) (string, []Message, error) {
+	switch inputFormat {
+	case "", "text", "json":
+	default:
+		return "", nil, fmt.Errorf("-input-format %q: want text or json", inputFormat)
+	}
+	piped := !term.IsTerminal(int(os.Stdin.Fd()))
+	if !piped || (args != "" && !quietMode && inputFormat == "") {
+		if inputFormat == "json" {
+			return "", nil, errors.New("-input-format json: nothing piped to stdin")
+		}
+		return args, nil, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", nil, fmt.Errorf("read stdin: %w", err)
+	}
+
+	if inputFormat != "json" {
+		in := strings.TrimSpace(string(data))
+		if args == "" || in == "" {
+			return args + in, nil, nil
+		}
+		return args + "\n\n" + in, nil, nil
+	}
+
+	var in struct {
+		Prompt   string    `json:"prompt"`
+		Messages []Message `json:"messages"`
+	}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &in.Messages)
+	} else {
+		err = json.Unmarshal(data, &in)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("-input-format json: %w", err)
+	}
+	for i, m := range in.Messages {
+		if m.Role != "system" && m.Role != "user" && m.Role != "assistant" {
+			return "", nil, fmt.Errorf("-input-format json: message %d: role %q is not system, user or assistant", i+1, m.Role)
+		}
+	}
+	prompt, msgs := args, in.Messages
+	if prompt == "" {
+		prompt = in.Prompt
+	}
+	if n := len(msgs); prompt == "" && n > 0 && msgs[n-1].Role == "user" {
+		prompt, msgs = msgs[n-1].Content, msgs[:n-1]
+	}
+	return prompt, msgs, nil
+}
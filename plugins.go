@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Plugins are executables named go-chat-<name> found on PATH, in the style of
+// git and kubectl. Each one describes itself when run with --describe:
+//
+//	{"kind": "tool", "description": "...", "parameters": {...json schema}}
+//
+// kind is one of:
+//
+//	command   go-chat <name> args… runs the plugin with the terminal attached
+//	tool      the model may call it; it receives {"arguments": {...}} on stdin
+//	provider  chats are sent to it when config "provider" names it; it
+//	          receives {"model", "messages", "temperature", "max_tokens",
+//	          "stop", "seed"} (seed is null unless one was asked for)
+//
+// tool and provider plugins answer with {"content": "...", "error": "..."} on
+// stdout. Plugins see GOCHAT_CONFIG and GOCHAT_HOME in their environment.
+//
+// Only the plugins listed in config "plugins", and the one "provider" names,
+// are described and offered to the model; anything else on PATH is left
+// alone until the user runs it as a command. Descriptions are cached in
+// ~/.go-chat-plugin-cache.json until the executable changes.
+//
+// A tool call that takes longer than pluginCallTimeout is killed, so a
+// stuck plugin can't hold up the turn (or, under serve, every client).
+// Untrusted tools should be WASM plugins instead (see wasm.go).
+const (
+	pluginPrefix      = "go-chat-"
+	pluginCallTimeout = 60 * time.Second // executable and WASM tools alike
+)
+
+type PluginManifest struct {
+	Kind        string          `json:"kind"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type Plugin struct {
+	Name string
+	Path string
+	PluginManifest
+	wasm *WasmPlugin
+}
+
+type pluginResponse struct {
+	Content string `json:"content"`
+	Error   string `json:"error"`
+}
+
+type pluginCacheEntry struct {
+	Size     int64          `json:"size"`
+	ModTime  time.Time      `json:"mod_time"`
+	Manifest PluginManifest `json:"manifest"`
+}
+
+var (
+	plugins     map[string]*Plugin
+	pluginsOnce sync.Once
+
+	pluginCacheFilePath string
+)
+
+// loadPlugins describes the enabled plugins once per process and registers
+// the tool plugins with the tool registry.
+func loadPlugins() map[string]*Plugin {
+	pluginsOnce.Do(func() {
+		plugins = map[string]*Plugin{}
+		cfg := getConfig()
+		found := discoverPlugins()
+		enabled := slices.Clone(cfg.Plugins)
+		if _, ok := found[providerName(cfg)]; ok {
+			enabled = append(enabled, providerName(cfg))
+		}
+		for _, name := range enabled {
+			if _, dup := plugins[name]; dup {
+				continue
+			}
+			path, ok := found[name]
+			if !ok {
+				log.Printf("plugin %s: no %s%s on PATH", name, pluginPrefix, name)
+				continue
+			}
+			p, err := describePlugin(name, path)
+			if err != nil {
+				log.Print(err)
+				continue
+			}
+			plugins[name] = p
+			if p.Kind == "tool" {
+				registerTool(&Tool{
+					Name:        name,
+					Description: p.Description,
+					Parameters:  p.Parameters,
+					Run:         p.runTool,
+				})
+			}
+		}
+		for _, w := range discoverWasmPlugins() {
+			if _, dup := plugins[w.Name]; dup {
+				log.Printf("wasm plugin %s: shadowed by %s", w.Name, plugins[w.Name].Path)
+				continue
+			}
+			plugins[w.Name] = &Plugin{
+				Name: w.Name,
+				Path: w.Dir,
+				PluginManifest: PluginManifest{
+					Kind:        "tool",
+					Description: w.Description,
+					Parameters:  w.Parameters,
+				},
+				wasm: w,
+			}
+			registerTool(&Tool{
+				Name:        w.Name,
+				Description: w.Description,
+				Parameters:  w.Parameters,
+				Run:         w.runTool,
+			})
+		}
+	})
+	return plugins
+}
+
+// describePlugin runs the plugin with --describe, or reuses the answer
+// cached for an executable of the same size and modification time.
+func describePlugin(name, path string) (*Plugin, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	p := &Plugin{Name: name, Path: path}
+	cache := map[string]pluginCacheEntry{}
+	if data, err := os.ReadFile(pluginCacheFilePath); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	if e, ok := cache[path]; ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+		p.PluginManifest = e.Manifest
+		return p, nil
+	}
+
+	out, err := pluginCmd(path, "--describe").Output()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: describe: %w", name, err)
+	}
+	if err := json.Unmarshal(out, &p.PluginManifest); err != nil {
+		return nil, fmt.Errorf("plugin %s: bad manifest: %w", name, err)
+	}
+
+	defer lockFile(pluginCacheFilePath)()
+	if data, err := os.ReadFile(pluginCacheFilePath); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	cache[path] = pluginCacheEntry{info.Size(), info.ModTime(), p.PluginManifest}
+	data, _ := json.MarshalIndent(cache, "", "  ")
+	if err := writeFileAtomic(pluginCacheFilePath, data, 0o600); err != nil {
+		log.Printf("plugin cache: %v", err)
+	}
+	return p, nil
+}
+
+// namedPlugin is an enabled plugin, or failing that the go-chat-<name> on
+// PATH the caller was told to use.
+func namedPlugin(name string) (*Plugin, bool) {
+	if p, ok := loadPlugins()[name]; ok {
+		return p, true
+	}
+	path, ok := findPlugin(name)
+	if !ok {
+		return nil, false
+	}
+	p, err := describePlugin(name, path)
+	if err != nil {
+		log.Print(err)
+		return nil, false
+	}
+	return p, true
+}
+
+// discoverPlugins maps plugin names to executables. Earlier PATH entries win,
+// as they would in the shell.
+func discoverPlugins() map[string]string {
+	found := map[string]string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name, ok := strings.CutPrefix(e.Name(), pluginPrefix)
+			if !ok || name == "" || e.IsDir() {
+				continue
+			}
+			if _, dup := found[name]; dup {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if info, err := os.Stat(path); err != nil || info.Mode()&0o111 == 0 {
+				continue
+			}
+			found[name] = path
+		}
+	}
+	return found
+}
+
+func findPlugin(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return "", false
+	}
+	p, err := exec.LookPath(pluginPrefix + name)
+	return p, err == nil
+}
+
+func pluginCmd(path string, args ...string) *exec.Cmd {
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(),
+		"GOCHAT_CONFIG="+configFilePath,
+		"GOCHAT_HOME="+homeDir,
+	)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// runPluginCommand runs a command plugin attached to the terminal and returns
+// its exit code.
+func runPluginCommand(path string, args []string) int {
+	cmd := pluginCmd(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode()
+		}
+		log.Printf("plugin: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// callWithin sends one JSON request to the plugin and decodes its reply,
+// killing the plugin if it takes longer than limit (if set).
+func (p *Plugin) callWithin(req any, limit time.Duration) (string, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	cmd := pluginCmd(p.Path)
+	cmd.Stdin = bytes.NewReader(in)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.WaitDelay = time.Second // for children left holding stdout
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	var timedOut atomic.Bool
+	if limit > 0 {
+		t := time.AfterFunc(limit, func() { timedOut.Store(true); cmd.Process.Kill() })
+		defer t.Stop()
+	}
+	err = cmd.Wait()
+	if timedOut.Load() {
+		return "", fmt.Errorf("plugin %s: no answer within %s", p.Name, limit)
+	}
+	if err != nil {
+		return "", fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	out := stdout.Bytes()
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", fmt.Errorf("plugin %s: bad response: %w", p.Name, err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return resp.Content, nil
+}
+
+func (p *Plugin) runTool(args json.RawMessage) (string, error) {
+	return p.callWithin(map[string]any{"arguments": args}, pluginCallTimeout)
+}
+
+func runPlugins(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			if len(args) != 2 {
+				log.Fatal("usage: go-chat plugins install <dir>")
+			}
+			if err := installWasmPlugin(args[1]); err != nil {
+				log.Fatalf("install: %v", err)
+			}
+			return
+		default:
+			log.Fatalf("unknown plugins command %q", args[0])
+		}
+	}
+
+	ps := loadPlugins()
+	found := discoverPlugins()
+	names := make([]string, 0, len(ps)+len(found))
+	for n := range ps {
+		names = append(names, n)
+	}
+	for n := range found {
+		if _, ok := ps[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		fmt.Println("no plugins found (executables named " + pluginPrefix + "<name> on PATH, or WASM plugins in " + wasmPluginsDirPath + ")")
+		return
+	}
+	for _, n := range names {
+		p, ok := ps[n]
+		if !ok {
+			fmt.Printf("%-16s %-16s %s\n", n, "-", `not enabled; add it to "plugins" in the config`)
+			continue
+		}
+		kind := p.Kind
+		if p.wasm != nil {
+			kind += " (wasm)"
+		}
+		fmt.Printf("%-16s %-16s %s\n", n, kind, p.Description)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// postProcessor applies a profile's rewrite rules and line width to an
+// answer. It works line by line so it can sit between a stream and the
+// terminal: text is held back only until the end of the current line.
+type postProcessor struct {
+	rules []compiledRewrite
+	width int
+
+	emit    func(string)
+	pending strings.Builder
+	out     strings.Builder
+	inFence bool
+	started bool
+}
+
+type compiledRewrite struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// newPostProcessor returns nil when the profile has nothing to apply.
+func newPostProcessor(p Profile) (*postProcessor, error) {
+	if len(p.Rewrites) == 0 && p.MaxLineWidth <= 0 {
+		return nil, nil
+	}
+	pp := &postProcessor{width: p.MaxLineWidth}
+	for _, r := range p.Rewrites {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %q: %w", r.Pattern, err)
+		}
+		pp.rules = append(pp.rules, compiledRewrite{re: re, repl: r.Replace})
+	}
+	return pp, nil
+}
+
+// wrap returns the token callback to hand to the model call: it feeds the
+// processor, which passes finished lines on to onToken. A nil processor or
+// a non-streaming call leaves onToken as is.
+func (pp *postProcessor) wrap(onToken func(string)) func(string) {
+	if pp == nil || onToken == nil {
+		return onToken
+	}
+	pp.emit = onToken
+	pp.started = true
+	return pp.write
+}
+
+// finish returns the processed answer, flushing any partial last line to
+// the stream.
+func (pp *postProcessor) finish(answer string) string {
+	if pp == nil {
+		return answer
+	}
+	if !pp.started {
+		for _, l := range strings.SplitAfter(answer, "\n") {
+			pp.out.WriteString(pp.line(l))
+		}
+		return pp.out.String()
+	}
+	if pp.pending.Len() > 0 {
+		out := pp.line(pp.pending.String())
+		pp.pending.Reset()
+		pp.out.WriteString(out)
+		pp.emit(out)
+	}
+	return pp.out.String()
+}
+
+func (pp *postProcessor) write(s string) {
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			pp.pending.WriteString(s)
+			return
+		}
+		pp.pending.WriteString(s[:i+1])
+		out := pp.line(pp.pending.String())
+		pp.pending.Reset()
+		pp.out.WriteString(out)
+		pp.emit(out)
+		s = s[i+1:]
+	}
+}
+
+// line processes one line, including its trailing newline if it has one.
+// Code fences are left untouched so rewrites and wrapping can't break code.
+func (pp *postProcessor) line(l string) string {
+	body, nl := strings.CutSuffix(l, "\n")
+	nlStr := ""
+	if nl {
+		nlStr = "\n"
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(body), "```") {
+		pp.inFence = !pp.inFence
+		return l
+	}
+	if pp.inFence {
+		return l
+	}
+
+	orig := body
+	for _, r := range pp.rules {
+		body = r.re.ReplaceAllString(body, r.repl)
+	}
+	// A line that only held boilerplate disappears entirely.
+	if body != orig && strings.TrimSpace(body) == "" {
+		return ""
+	}
+	if pp.width > 0 {
+		body = wrapLine(body, pp.width)
+	}
+	return body + nlStr
+}
+
+// wrapLine breaks s at spaces so no line exceeds width, keeping the
+// original indentation on continuation lines. Words longer than width are
+// left whole.
+func wrapLine(s string, width int) string {
+	if len([]rune(s)) <= width {
+		return s
+	}
+	indent := s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+
+	var b strings.Builder
+	lineLen := 0
+	for i, w := range strings.Fields(s) {
+		wl := len([]rune(w))
+		switch {
+		case i == 0:
+			b.WriteString(indent)
+			lineLen = len(indent)
+		case lineLen+1+wl > width:
+			b.WriteString("\n" + indent)
+			lineLen = len(indent)
+		default:
+			b.WriteByte(' ')
+			lineLen++
+		}
+		b.WriteString(w)
+		lineLen += wl
+	}
+	return b.String()
+}
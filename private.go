@@ -0,0 +1,36 @@
+package main
+
+import "strings"
+
+// A prompt starting with "!private" (or every prompt while /private is on
+// in interactive mode) is answered as usual but kept out of what the
+// assistant remembers: day summaries and the memories made from them,
+// session summaries and the history carried over by -resume. The exchange
+// is still logged locally, marked private, unless "log_private" is false.
+const privatePrefix = "!private"
+
+var privateMode bool // toggled by /private
+
+// cutPrivate strips the private marker from a prompt.
+func cutPrivate(prompt string) (string, bool) {
+	rest, ok := strings.CutPrefix(prompt, privatePrefix)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\n') {
+		return prompt, false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+func logPrivate(cfg Config) bool {
+	return cfg.LogPrivate == nil || *cfg.LogPrivate
+}
+
+// sharedLogs drops private exchanges from a day's log.
+func sharedLogs(logs []ChatLog) []ChatLog {
+	var out []ChatLog
+	for _, l := range logs {
+		if !l.Private {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package main
+
+import "log"
+
+// A Profile bundles answer settings under a name in the config's
+// "profiles" map. The active one is picked with -profile, falling back to
+// the config's "profile" key; with neither, the zero Profile applies.
+type Profile struct {
+	// Stop sequences end the answer early when the model produces them.
+	Stop []string `json:"stop,omitempty"`
+	// Rewrites are regex replacements applied to each line of the answer
+	// before it is printed or logged.
+	Rewrites []RewriteRule `json:"rewrites,omitempty"`
+	// MaxLineWidth wraps prose lines longer than this many characters.
+	MaxLineWidth int `json:"max_line_width,omitempty"`
+	// Examples are few-shot exchanges sent after the system prompt to set
+	// the style, in order, as many as fit in ExampleTokens.
+	Examples      []Example `json:"examples,omitempty"`
+	ExampleTokens int       `json:"example_tokens,omitempty"`
+}
+
+// Example is one few-shot user/assistant exchange.
+type Example struct {
+	User      string `json:"user"`
+	Assistant string `json:"assistant"`
+}
+
+const defaultExampleTokens = 1000
+
+// exampleMessages returns the profile's examples that fit its token
+// budget, whole exchanges only.
+func (p Profile) exampleMessages() []Message {
+	budget := p.ExampleTokens
+	if budget == 0 {
+		budget = defaultExampleTokens
+	}
+	var msgs []Message
+	for _, ex := range p.Examples {
+		pair := []Message{{Role: "user", Content: ex.User}, {Role: "assistant", Content: ex.Assistant}}
+		budget -= tokensMsg(pair[0]) + tokensMsg(pair[1])
+		if budget < 0 {
+			break
+		}
+		msgs = append(msgs, pair...)
+	}
+	return msgs
+}
+
+// withExamples puts few-shot turns after the system message.
+func withExamples(msgs, examples []Message) []Message {
+	if len(examples) == 0 || len(msgs) == 0 {
+		return msgs
+	}
+	out := append([]Message{msgs[0]}, examples...)
+	return append(out, msgs[1:]...)
+}
+
+type RewriteRule struct {
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+}
+
+// profileName is set by the -profile flag.
+var profileName string
+
+func currentProfile(cfg Config) Profile {
+	name := profileName
+	if name == "" {
+		name = cfg.Profile
+	}
+	if name == "" {
+		return Profile{}
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		log.Fatalf("unknown profile %q", name)
+	}
+	return p
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A .gochat.toml in the current directory or one above it makes go-chat
+// project-aware, the way .editorconfig does for editors: the nearest one
+// overrides the config for everything run below it.
+//
+//	profile = "terse"
+//	persona = "reviewer"
+//	pinned = ["ARCHITECTURE.md", "docs/*.md"]
+//
+//	[index]
+//	roots = ["docs", "internal"]
+//
+//	[profiles.terse]
+//	stop = ["\n\n\n"]
+//	max_line_width = 100
+//
+// profile and persona replace the config's (-profile and -persona still
+// win), and profiles are added to its own, replacing any of the same name.
+// pinned files are sent with every prompt; index roots are what `go-chat
+// index add` indexes when it's given nothing. Paths are relative to the
+// directory holding the file and must stay inside it, symlinks included,
+// so a checked-out repository can't have go-chat send ~/.ssh to the model.
+const projectFileName = ".gochat.toml"
+
+type projectConfig struct {
+	path string // of the file itself
+
+	Profile  string             `json:"profile"`
+	Profiles map[string]Profile `json:"profiles"`
+	Persona  string             `json:"persona"`
+	Pinned   []string           `json:"pinned"`
+	Index    struct {
+		Roots []string `json:"roots"`
+	} `json:"index"`
+}
+
+// maxPinnedBytes caps each pinned file; the rest is left out.
+const maxPinnedBytes = 64 << 10
+
+// projectFile finds and reads the nearest project file once; it is nil
+// if there is none.
+var projectFile = sync.OnceValues(func() (*projectConfig, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, nil
+	}
+	for {
+		path := filepath.Join(dir, projectFileName)
+		if data, err := os.ReadFile(path); err == nil {
+			p, err := parseProjectConfig(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			p.path = path
+			return p, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+})
+
+func parseProjectConfig(data []byte) (*projectConfig, error) {
+	var tree map[string]any
+	if err := toml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	// The TOML keys are the config's JSON ones, so the config's types
+	// decode them, and misspelt keys are caught.
+	js, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	var p projectConfig
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// applyProject overrides cfg with the project file, if there is one. A
+// file that can't be read is reported and left out.
+func applyProject(cfg *Config) {
+	p, err := projectFile()
+	if err != nil {
+		warnOnce("project", "%v; ignoring it", err)
+		return
+	}
+	if p == nil {
+		return
+	}
+	dir := filepath.Dir(p.path)
+	resolve := func(key string, paths []string) []string {
+		var out []string
+		for _, s := range paths {
+			full := filepath.Join(dir, s)
+			if filepath.IsAbs(s) || strings.HasPrefix(s, "~") || !withinDir(dir, full) {
+				warnOnce("project:"+s, "%s: %s %q is outside the project; ignoring it", p.path, key, s)
+				continue
+			}
+			out = append(out, full)
+		}
+		return out
+	}
+
+	if p.Profile != "" {
+		cfg.Profile = p.Profile
+	}
+	if len(p.Profiles) > 0 {
+		profiles := maps.Clone(cfg.Profiles)
+		if profiles == nil {
+			profiles = map[string]Profile{}
+		}
+		maps.Copy(profiles, p.Profiles)
+		cfg.Profiles = profiles
+	}
+	if p.Persona != "" {
+		cfg.Persona = p.Persona
+	}
+	if len(p.Pinned) > 0 {
+		cfg.Pinned = resolve("pinned", p.Pinned)
+		cfg.pinnedRoot = dir
+	}
+	if len(p.Index.Roots) > 0 {
+		cfg.Index.Roots = resolve("index root", p.Index.Roots)
+	}
+}
+
+// withinDir reports whether path is dir or below it, going by the names
+// alone.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// pinnedContext returns the pinned files for the system prompt, or "" if
+// there are none. Patterns may be globs; paths in the config itself are
+// relative to the home directory.
+func pinnedContext(cfg Config) string {
+	var b strings.Builder
+	base, _ := os.Getwd()
+	if p, _ := projectFile(); p != nil {
+		base = filepath.Dir(p.path)
+	}
+	// Project pins may be symlinks; only follow those that stay inside.
+	root := cfg.pinnedRoot
+	if root != "" {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+	}
+	for _, pat := range cfg.Pinned {
+		if rest, ok := strings.CutPrefix(pat, "~/"); ok {
+			pat = filepath.Join(homeDir, rest)
+		} else if !filepath.IsAbs(pat) {
+			pat = filepath.Join(homeDir, pat)
+		}
+		matches, _ := filepath.Glob(pat)
+		if len(matches) == 0 {
+			warnOnce("pinned:"+pat, "pinned file %s not found", pat)
+		}
+		for _, m := range matches {
+			if root != "" {
+				if real, err := filepath.EvalSymlinks(m); err != nil || !withinDir(root, real) {
+					warnOnce("pinned:"+m, "pinned file %s leads outside the project; leaving it out", m)
+					continue
+				}
+			}
+			data, err := os.ReadFile(m)
+			if err != nil {
+				warnOnce("pinned:"+m, "pinned file: %v", err)
+				continue
+			}
+			if len(data) > maxPinnedBytes {
+				warnOnce("pinned:"+m, "pinned file %s is over %d KB; only the start is sent", m, maxPinnedBytes>>10)
+				data = data[:maxPinnedBytes]
+			}
+			name := m
+			if rel, err := filepath.Rel(base, m); err == nil && !strings.HasPrefix(rel, "..") {
+				name = rel
+			}
+			fmt.Fprintf(&b, "\n\n%s:\n```\n%s\n```", name, strings.TrimRight(string(data), "\n"))
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return prompt("pinned") + b.String()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// The personality and bio are Go templates evaluated at send time, so a
+// persona can refer to live context:
+//
+//	{{date}} {{time}} {{os}} {{cwd}} {{git_branch}} {{hostname}}
+//	{{env "NAME"}}  {{include "~/notes/persona.md"}}
+//
+// Relative include paths are taken from the home directory. Text that
+// fails to parse or run is used as written.
+var promptFuncs = template.FuncMap{
+	"date":       func() string { return time.Now().Format("Monday, 2006-01-02") },
+	"time":       func() string { return time.Now().Format("15:04") },
+	"os":         func() string { return runtime.GOOS + "/" + runtime.GOARCH },
+	"cwd":        func() string { wd, _ := os.Getwd(); return wd },
+	"git_branch": gitBranch,
+	"hostname":   func() string { h, _ := os.Hostname(); return h },
+	"env":        os.Getenv,
+	"include":    includeFile,
+}
+
+func expandPromptVars(text string) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+	t, err := template.New("prompt").Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		log.Printf("prompt template: %v", err)
+		return text
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, nil); err != nil {
+		log.Printf("prompt template: %v", err)
+		return text
+	}
+	return b.String()
+}
+
+func gitBranch() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func includeFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		path = filepath.Join(homeDir, rest)
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(homeDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Provider is a chat backend. Complete and Stream answer one round of a
+// chat; the tool loop, budgets and spend tracking stay in queryGPTWith, so
+// a provider only translates requests to its API. A provider that can't
+// call tools ignores ChatRequest.Tools and never returns tool calls.
+//
+// Providers register themselves by name in an init function, and config
+// "provider" or -provider picks one; empty means "openai" (see openai.go).
+// A provider plugin (see plugins.go) can be named there too.
+type Provider interface {
+	Complete(req ChatRequest) (Message, error)
+	Stream(req ChatRequest, onToken func(string)) (Message, error)
+	Embed(model string, texts []string) ([][]float32, error)
+}
+
+// ChatRequest is one round of a chat.
+type ChatRequest struct {
+	Model       string
+	Messages    []Message // starting with the system prompt
+	Temperature float64
+	MaxTokens   int
+	Stop        []string
+	Seed        *int
+	Tools       []map[string]any // in the chat completions format; see toolDefinitions
+}
+
+// TokenUsage is what a provider reports a request used.
+type TokenUsage struct {
+	Input, Output int
+}
+
+// A provider whose models aren't named like OpenAI's maps the names
+// go-chat asks for with ResolveModel and names its own default for
+// embeddings with EmbeddingModel (see ollama.go). One that can count
+// tokens exactly implements CountTokens (see anthropic.go), and one whose
+// models don't tokenize like gpt-4o estimates local counts with
+// EstimateTokens. ListModels serves `go-chat models` (see models.go).
+type (
+	modelResolver   interface{ ResolveModel(model string) string }
+	embeddingModels interface{ EmbeddingModel() string }
+	tokenCounter    interface {
+		CountTokens(req ChatRequest) (int, error)
+	}
+	tokenEstimator interface{ EstimateTokens(s string) int }
+	modelLister    interface{ ListModels() ([]string, error) }
+)
+
+const defaultProvider = "openai"
+
+var providers = map[string]Provider{}
+
+// providerFlag is set by the -provider flag.
+var providerFlag string
+
+func registerProvider(name string, p Provider) {
+	providers[name] = p
+}
+
+// activeProvider is the provider the config names.
+func activeProvider() (Provider, error) {
+	return providerByName(providerName(getConfig()))
+}
+
+// configuredProvider is the built-in provider the config names, or nil
+// for a provider plugin. Unlike activeProvider it never runs a plugin, so
+// it is cheap enough for counting tokens.
+func configuredProvider() Provider {
+	return providers[providerName(getConfig())]
+}
+
+// providerByName finds a built-in provider or a provider plugin.
+func providerByName(name string) (Provider, error) {
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+	if pl, ok := namedPlugin(name); ok && pl.Kind == "provider" {
+		return pluginProvider{pl}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q; built in: %s, or a provider plugin", name, strings.Join(providerNames(), ", "))
+}
+
+func providerName(cfg Config) string {
+	return cmp.Or(providerFlag, cfg.Provider, defaultProvider)
+}
+
+// providerModel is the model the active provider sends for model.
+func providerModel(model string) string {
+	p, _ := activeProvider()
+	return resolveModel(p, model)
+}
+
+// resolveModel is the model p sends for model, after any alias; p may be
+// nil.
+func resolveModel(p Provider, model string) string {
+	model = expandModel(model)
+	if r, ok := p.(modelResolver); ok {
+		return r.ResolveModel(model)
+	}
+	return model
+}
+
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pluginProvider sends chats to a provider plugin. Plugins don't do tools
+// or embeddings; embeddings go to the default provider.
+type pluginProvider struct{ p *Plugin }
+
+func (pp pluginProvider) Complete(req ChatRequest) (Message, error) { return pp.Stream(req, nil) }
+
+// Stream passes the whole answer as one token, since plugins answer in one
+// piece.
+func (pp pluginProvider) Stream(req ChatRequest, onToken func(string)) (Message, error) {
+	answer, err := pp.p.callWithin(map[string]any{
+		"model":       req.Model,
+		"messages":    req.Messages,
+		"temperature": req.Temperature,
+		"max_tokens":  req.MaxTokens,
+		"stop":        req.Stop,
+		"seed":        req.Seed,
+	}, pluginTimeout(onToken != nil))
+	if err != nil {
+		return Message{}, err
+	}
+	if onToken != nil {
+		onToken(answer)
+	}
+	return Message{Role: "assistant", Content: answer}, nil
+}
+
+func (pp pluginProvider) Embed(model string, texts []string) ([][]float32, error) {
+	return providers[defaultProvider].Embed(model, texts)
+}
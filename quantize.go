@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// With "quantize_embeddings" on, memory embeddings are written to disk as
+// int8 with one float scale per vector (base64 in the JSON) instead of a
+// float array, about a quarter of the size and much quicker to parse. They
+// are dequantized on load, so scoring works on float32 as before.
+// `go-chat memory quantize` converts an existing store and reports how much
+// the vectors moved.
+
+func quantize(v []float32) (q []byte, scale float32) {
+	var maxAbs float32
+	for _, x := range v {
+		maxAbs = max(maxAbs, float32(math.Abs(float64(x))))
+	}
+	if maxAbs == 0 {
+		return make([]byte, len(v)), 0
+	}
+	scale = maxAbs / 127
+	q = make([]byte, len(v))
+	for i, x := range v {
+		q[i] = byte(int8(math.Round(float64(x / scale))))
+	}
+	return q, scale
+}
+
+func dequantize(q []byte, scale float32) []float32 {
+	v := make([]float32, len(q))
+	for i, b := range q {
+		v[i] = float32(int8(b)) * scale
+	}
+	return v
+}
+
+func runMemoryQuantize(args []string) {
+	fset := flag.NewFlagSet("memory quantize", flag.ExitOnError)
+	dryRun := fset.Bool("dry-run", false, "Only report the accuracy loss and size saving")
+	fset.Parse(args)
+
+	cfg := getConfig()
+	if cfg.QuantizeEmbeddings && !*dryRun {
+		fmt.Println("memory store is already quantized")
+		return
+	}
+	defer lockFile(vectorStorePath)()
+	store := loadVectorStore()
+	if len(store) == 0 {
+		fmt.Println("no memories to quantize")
+	}
+
+	// Accuracy: similarity of each vector to its round-tripped self, and
+	// how often a memory's nearest neighbour stays the same.
+	deq := make([][]float32, len(store))
+	sum, worst := 0.0, 1.0
+	for i, m := range store {
+		deq[i] = dequantize(quantize(m.Embedding))
+		s := cosineSim(m.Embedding, deq[i])
+		sum += s
+		worst = min(worst, s)
+	}
+	same := 0
+	for i := range store {
+		if nearest(store, i, func(j int) []float32 { return store[j].Embedding }) ==
+			nearest(store, i, func(j int) []float32 { return deq[j] }) {
+			same++
+		}
+	}
+
+	before := fileSize(filepath.Join(homeDir, vectorStorePath))
+	if len(store) > 0 {
+		fmt.Printf("%d memories: mean cosine to original %.5f, worst %.5f, nearest neighbour unchanged for %d/%d\n",
+			len(store), sum/float64(len(store)), worst, same, len(store))
+	}
+	if *dryRun {
+		return
+	}
+
+	unlock := lockFile(configFilePath)
+	cfg = readConfig()
+	cfg.QuantizeEmbeddings = true
+	saveConfig(cfg)
+	unlock()
+	if err := saveVectorStore(store); err != nil {
+		log.Fatalf("memory quantize: %v", err)
+	}
+	fmt.Printf("memory store %d -> %d bytes\n", before, fileSize(filepath.Join(homeDir, vectorStorePath)))
+}
+
+// nearest returns the index of the memory most similar to store[i], other
+// than itself, using the vectors vec returns.
+func nearest(store []VectorMemory, i int, vec func(int) []float32) int {
+	best, bestSim := -1, math.Inf(-1)
+	for j := range store {
+		if j == i {
+			continue
+		}
+		if s := cosineSim(vec(i), vec(j)); s > bestSim {
+			best, bestSim = j, s
+		}
+	}
+	return best
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
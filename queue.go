@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Chat turns in server mode wait in a bounded queue before taking turnMu,
+// so a burst of requests is served in priority order instead of piling up
+// as blocked goroutines. When "server": {"queue": {"max_pending": N}}
+// requests are already waiting, new ones are turned away (HTTP 429 with
+// Retry-After, gRPC RESOURCE_EXHAUSTED). Priorities are per identity name;
+// higher goes first, ties in arrival order.
+type QueueConfig struct {
+	MaxPending int            `json:"max_pending,omitempty"`
+	Priorities map[string]int `json:"priorities,omitempty"`
+}
+
+const defaultMaxPending = 16
+
+// errQueueFull is returned when a turn can't even wait for a slot.
+type errQueueFull struct{ retryAfter time.Duration }
+
+func (e errQueueFull) Error() string {
+	return fmt.Sprintf("server busy, retry in %ds", retrySeconds(e.retryAfter))
+}
+
+func retrySeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+type turnQueue struct {
+	mu      sync.Mutex
+	closed  bool
+	busy    bool
+	waiting []*turnWaiter
+	seq     int
+	started time.Time
+	avgTurn time.Duration
+}
+
+type turnWaiter struct {
+	prio, seq int
+	turn      deferredTurn // saved if the server shuts down first
+	ready     chan struct{}
+	err       error // set when turned away instead
+}
+
+var chatQueue turnQueue
+
+// startTurn waits for the identity's chat turn to answer prompt and
+// returns the function that ends it.
+func startTurn(ctx context.Context, prompt string) (func(), error) {
+	id := identityFrom(ctx)
+	return startTurnFor(ctx, deferredTurn{Prompt: prompt, Identity: id.Name, MemoryScope: id.MemoryScope, Queued: time.Now()})
+}
+
+func startTurnFor(ctx context.Context, t deferredTurn) (func(), error) {
+	q := getConfig().Server.Queue
+	limit := q.MaxPending
+	if limit == 0 {
+		limit = defaultMaxPending
+	}
+	if err := chatQueue.acquire(ctx, q.Priorities[t.Identity], limit, t); err != nil {
+		return nil, err
+	}
+	turnMu.Lock()
+	return func() {
+		turnMu.Unlock()
+		chatQueue.release()
+	}, nil
+}
+
+func (q *turnQueue) acquire(ctx context.Context, prio, limit int, t deferredTurn) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return errShuttingDown
+	}
+	if !q.busy {
+		q.busy, q.started = true, time.Now()
+		q.mu.Unlock()
+		return nil
+	}
+	if len(q.waiting) >= limit {
+		wait := time.Duration(len(q.waiting)+1) * max(q.avgTurn, time.Second)
+		q.mu.Unlock()
+		return errQueueFull{retryAfter: wait}
+	}
+	q.seq++
+	w := &turnWaiter{prio: prio, seq: q.seq, turn: t, ready: make(chan struct{})}
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		o := q.waiting[i]
+		return o.prio < w.prio || o.prio == w.prio && o.seq > w.seq
+	})
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = w
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		q.mu.Lock()
+		for i, o := range q.waiting {
+			if o == w {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				q.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		q.mu.Unlock()
+		if w.err == nil {
+			q.release() // handed the turn just as we gave up
+		}
+		return ctx.Err()
+	}
+}
+
+// release ends the current turn and hands it to the first waiter.
+func (q *turnQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	took := time.Since(q.started)
+	if q.avgTurn == 0 {
+		q.avgTurn = took
+	} else {
+		q.avgTurn = (q.avgTurn*4 + took) / 5
+	}
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+	w := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	q.started = time.Now()
+	close(w.ready)
+}
+
+// close saves everyone waiting to be answered after a restart and turns
+// away any later requests; the turn in progress, if any, carries on.
+func (q *turnQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+
+	var fresh []deferredTurn
+	for _, w := range q.waiting {
+		if w.turn.ID == "" { // replayed turns are still saved
+			fresh = append(fresh, w.turn)
+		}
+	}
+	saveErr := saveDeferredTurns(fresh)
+	if saveErr != nil {
+		log.Printf("saving queued requests: %v", saveErr)
+	}
+	for _, w := range q.waiting {
+		w.err = errTurnDeferred
+		if saveErr != nil && w.turn.ID == "" {
+			w.err = errShuttingDown
+		}
+		close(w.ready)
+	}
+	q.waiting = nil
+}
+
+// queueFull reports whether err is a full-queue rejection and how long to
+// wait before retrying.
+func queueFull(err error) (time.Duration, bool) {
+	var full errQueueFull
+	if errors.As(err, &full) {
+		return full.retryAfter, true
+	}
+	return 0, false
+}
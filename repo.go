@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// `go-chat repo ask` answers questions about the git repository around the
+// working directory. It keeps the repo in the document index (honouring
+// .gitignore as well as the usual ignore rules, and re-embedding only
+// changed files) and answers from the best matching chunks only, citing
+// them as file:line ranges. In Go modules the symbols the question names
+// are resolved too (see gosymbols.go).
+const repoTopK = 6
+
+func runRepo(args []string) {
+	if len(args) == 0 || args[0] != "ask" {
+		fmt.Fprintln(os.Stderr, `usage: go-chat repo ask [-k N] "question"`)
+		os.Exit(2)
+	}
+	fset := flag.NewFlagSet("repo ask", flag.ExitOnError)
+	k := fset.Int("k", repoTopK, "Number of chunks to answer from")
+	fset.Parse(args[1:])
+	question := strings.Join(fset.Args(), " ")
+	if strings.TrimSpace(question) == "" {
+		log.Fatal("repo ask: no question")
+	}
+
+	root, err := repoRoot()
+	if err != nil {
+		log.Fatalf("repo ask: %v", err)
+	}
+
+	unlock := lockFile(indexFilePath)
+	ix := loadIndex()
+	ig := newIgnorer(getConfig())
+	ig.names = append([]string{".gitignore"}, ig.names...)
+	changed := 0
+	err = ix.addTarget(root, memScopePrivate, false, ig, func(_, status string) {
+		if status != "unchanged" {
+			changed++
+		}
+	})
+	if err != nil {
+		log.Fatalf("repo ask: index: %v", err)
+	}
+	if changed > 0 {
+		if err := saveIndex(ix); err != nil {
+			log.Fatalf("repo ask: %v", err)
+		}
+		log.Printf("indexed %d changed files in %s", changed, root)
+	}
+	unlock()
+
+	var inRepo []IndexChunk
+	for _, c := range ix.Chunks {
+		if isUnder(c.Source, root) {
+			inRepo = append(inRepo, c)
+		}
+	}
+	vec, err := embedText(question)
+	if err != nil {
+		log.Fatalf("repo ask: %v", err)
+	}
+	chunks := append(goSymbolContext(root, question), rankChunks(inRepo, vec, memScopePrivate, *k)...)
+	if len(chunks) == 0 {
+		log.Fatal("repo ask: nothing in the repository matches the question")
+	}
+	for i := range chunks {
+		if rel, err := filepath.Rel(root, chunks[i].Source); err == nil {
+			chunks[i].Source = rel
+		}
+	}
+
+	system := prompt("repo-ask") + "\n\n" + sourcesPrompt(chunks)
+	tw := termWrapper()
+	answer, err := queryGPTStream(modelExec, system, 0.2, 1024,
+		[]Message{{Role: "user", Content: question}}, tw.wrap(printToken))
+	if err != nil {
+		log.Fatalf("repo ask: %v", err)
+	}
+	tw.finish(answer)
+	if list := renderCitations(citedSources(answer, chunks)); list != "" {
+		fmt.Print("\n\n" + list)
+	}
+	fmt.Println()
+}
+
+// repoRoot finds the enclosing git work tree.
+func repoRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not inside a git repository")
+		}
+		dir = parent
+	}
+}
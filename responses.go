@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// With "history_backend": "responses" the conversation is kept by OpenAI's
+// Responses API: each answer's response id is logged with the turn, and
+// the next turn sends only the new prompt with previous_response_id
+// instead of re-sending the history. The system prompt (memories, time,
+// sources) is still sent each turn. The local log is written as before,
+// so search, export, summaries and memories keep working from it.
+//
+// The full history is sent, starting a new chain, for the first turn of a
+// day, when the previous turn has no response id (answered locally, by
+// another assistant, or before the switch) and when the provider no longer
+// has the previous response. Ephemeral sessions, -deterministic, -samples,
+// -fusion, group conversations and provider plugins always use the local
+// history, since the provider would keep a copy. Responses are stored by
+// the provider; `go-chat wipe` deletes the ones its logs name through the
+// API before removing the logs.
+const (
+	historyLocal     = "local"
+	historyResponses = "responses"
+)
+
+// usesResponses says whether a turn may use provider-side history.
+func usesResponses(cfg Config, opts chatOptions) bool {
+	if b := cfg.HistoryBackend; b != "" && b != historyLocal && b != historyResponses {
+		warnOnce("history_backend", "unknown history_backend %q, using local", b)
+	}
+	return cfg.HistoryBackend == historyResponses && providerName(cfg) == defaultProvider &&
+		!ephemeral && !deterministic && !*useFusion && sampleCount <= 1 && len(opts.Others) == 0
+}
+
+// lastResponseID returns the response id of today's latest turn in scope
+// and ns, or "" if it has none.
+func lastResponseID(scope, ns string) string {
+	logs, err := readDayLog(logDay(time.Now()))
+	if err != nil {
+		return ""
+	}
+	for i := len(logs) - 1; i >= 0; i-- {
+		l := logs[i]
+		if normScope(l.Scope) == normScope(scope) && inNamespace(l.Namespace, ns) {
+			return l.ResponseID
+		}
+	}
+	return ""
+}
+
+// responsesTools converts the tool definitions to the Responses API's
+// flatter form.
+func responsesTools() []map[string]any {
+	var out []map[string]any
+	for _, d := range toolDefinitions() {
+		fn := d["function"].(map[string]any)
+		out = append(out, map[string]any{
+			"type":        "function",
+			"name":        fn["name"],
+			"description": fn["description"],
+			"parameters":  fn["parameters"],
+		})
+	}
+	return out
+}
+
+type responseOutput struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Content   []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+type responseObject struct {
+	ID     string           `json:"id"`
+	Output []responseOutput `json:"output"`
+	Usage  struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func (r responseObject) text() string {
+	var b strings.Builder
+	for _, o := range r.Output {
+		for _, c := range o.Content {
+			if c.Type == "output_text" {
+				b.WriteString(c.Text)
+			}
+		}
+	}
+	return b.String()
+}
+
+// queryResponses answers msgs (which start with the system prompt) through
+// the Responses API, continuing from prev if it is set. It returns the
+// answer and the new response id.
+func queryResponses(model, system string, temp float64, maxTok int, msgs []Message, prev string,
+	onToken func(string)) (string, string, error) {
+
+	if apiKey == "" {
+		return "", "", errors.New("OPENAI_API_KEY env missing")
+	}
+	var input []any
+	for _, m := range msgs {
+		if m.Role == "system" && m.Content == system {
+			continue
+		}
+		input = append(input, map[string]any{"role": m.Role, "content": m.Content})
+	}
+	if prev != "" {
+		input = input[len(input)-1:]
+	}
+	full := msgs
+	for round := 0; ; round++ {
+		if err := checkBudget(providers[defaultProvider], model, "", full, maxTok); err != nil {
+			return "", "", err
+		}
+		payload := map[string]any{
+			"model":             model,
+			"instructions":      system,
+			"input":             input,
+			"temperature":       temp,
+			"max_output_tokens": maxTok,
+			"top_p":             0.96,
+			"truncation":        "auto",
+			"store":             true,
+			"stream":            onToken != nil,
+		}
+		if prev != "" {
+			payload["previous_response_id"] = prev
+		}
+		if defs := responsesTools(); round < maxToolRounds && len(defs) > 0 {
+			payload["tools"] = defs
+		}
+
+		res, err := postResponse(payload, onToken)
+		if err != nil {
+			return "", "", err
+		}
+		recordSpendTokens(model, res.Usage.InputTokens, res.Usage.OutputTokens)
+
+		var calls []any
+		for _, o := range res.Output {
+			if o.Type != "function_call" {
+				continue
+			}
+			out := runToolCall(ToolCall{ID: o.CallID, Type: "function", Function: ToolFunction{Name: o.Name, Arguments: o.Arguments}})
+			calls = append(calls, map[string]any{"type": "function_call_output", "call_id": o.CallID, "output": out.Content})
+			full = append(full, out)
+		}
+		if len(calls) == 0 {
+			return res.text(), res.ID, nil
+		}
+		prev, input = res.ID, calls
+	}
+}
+
+// deleteResponse deletes a stored response from the provider. One it no
+// longer has counts as deleted.
+func deleteResponse(id string) error {
+	if apiKey == "" {
+		return errors.New("OPENAI_API_KEY env missing")
+	}
+	req, err := http.NewRequest(http.MethodDelete, apiURL+"/v1/responses/"+url.PathEscape(id), nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("openai: %s – %s", resp.Status, msg)
+	}
+	return nil
+}
+
+func postResponse(payload map[string]any, onToken func(string)) (responseObject, error) {
+	var res responseObject
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return res, fmt.Errorf("encode payload: %w", err)
+	}
+	ctx, gotToken, done := watchFirstToken(onToken != nil)
+	defer done()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/v1/responses", bytes.NewReader(body))
+	if err != nil {
+		return res, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return res, requestErr(ctx, fmt.Errorf("http: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return res, &statusError{status: resp.StatusCode, msg: fmt.Sprintf("openai: %s – %s", resp.Status, msg)}
+	}
+	if onToken == nil {
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return res, requestErr(ctx, fmt.Errorf("decode: %w", err))
+		}
+		return res, nil
+	}
+
+	// Text arrives as output_text deltas; the finished response, with its
+	// id, tool calls and usage, comes with response.completed.
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return res, requestErr(ctx, fmt.Errorf("stream read: %w", err))
+			}
+			break
+		}
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		var ev struct {
+			Type     string         `json:"type"`
+			Delta    string         `json:"delta"`
+			Response responseObject `json:"response"`
+		}
+		if json.Unmarshal([]byte(strings.TrimSpace(data)), &ev) != nil {
+			continue
+		}
+		switch ev.Type {
+		case "response.output_text.delta":
+			gotToken()
+			onToken(ev.Delta)
+		case "response.completed":
+			return ev.Response, nil
+		case "response.failed", "error":
+			return res, fmt.Errorf("openai: response failed: %s", data)
+		}
+	}
+	return res, errors.New("openai: stream ended before the response completed")
+}
+
+// askResponses is queryGPTWith for a turn using provider-side history. If
+// the previous response is gone it starts a new chain from the local
+// history. The response id is stored in *id.
+func askResponses(prev string, id *string) func(string, string, float64, int, []Message, func(string), queryParams) (string, error) {
+	return func(model, system string, temp float64, maxTok int, msgs []Message, onToken func(string), _ queryParams) (string, error) {
+		answer, rid, err := queryResponses(model, system, temp, maxTok, msgs, prev, onToken)
+		var re *statusError
+		if prev != "" && errors.As(err, &re) && (re.status == http.StatusNotFound || re.status == http.StatusBadRequest) {
+			fmt.Fprintln(os.Stderr, "(previous response unavailable, sending the full history)")
+			answer, rid, err = queryResponses(model, system, temp, maxTok, msgs, "", onToken)
+		}
+		*id = rid
+		return answer, err
+	}
+}
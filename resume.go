@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// With -resume (or "resume": true in the config), history doesn't start
+// empty at midnight: the previous day's summary and the tail of its
+// transcript, in the same scope and namespace, come before today's turns.
+// summarizeDayLogs keeps the latest summary of each day in
+// ~/.go-chat-summary; days logged before that are summarised on first use.
+const resumeTailTokens = 2000
+
+var (
+	resumeMode bool
+	resumeFrom string // a particular day to continue, from log browse
+)
+
+func resuming(cfg Config) bool { return resumeMode || cfg.Resume }
+
+func summaryKey(day, scope, ns string) string {
+	return day + "/" + normScope(scope) + "/" + storedNamespace(ns)
+}
+
+func loadDaySummaries() map[string]string {
+	sums := map[string]string{}
+	if data, err := os.ReadFile(summaryFilePath); err == nil {
+		_ = json.Unmarshal(data, &sums)
+	}
+	return sums
+}
+
+func saveDaySummary(day, scope, ns, summary string) {
+	defer lockFile(summaryFilePath)()
+	sums := loadDaySummaries()
+	sums[summaryKey(day, scope, ns)] = summary
+	data, _ := json.MarshalIndent(sums, "", "  ")
+	_ = writeFileAtomic(summaryFilePath, data, 0o644)
+}
+
+// resumeContext returns the carried-over messages for a resumed
+// conversation, or none if resuming is off or there is no earlier day.
+func resumeContext(scope, ns string) []Message {
+	if !resuming(getConfig()) && resumeFrom == "" {
+		return nil
+	}
+	today := logDay(time.Now())
+	days := logDays()
+	for i := len(days) - 1; i >= 0; i-- {
+		if days[i] >= today || (resumeFrom != "" && days[i] != resumeFrom) {
+			continue
+		}
+		logs, err := readDayLog(days[i])
+		if err != nil {
+			continue
+		}
+		hist := historyMessages(sharedLogs(logs), scope, ns)
+		if len(hist) == 0 {
+			continue
+		}
+		return append(
+			[]Message{{Role: "system", Content: "Continuing the conversation from " + days[i] + ". Summary so far: " + daySummary(days[i], scope, ns, hist)}},
+			trimHistory(hist, resumeTailTokens)...,
+		)
+	}
+	return nil
+}
+
+func daySummary(day, scope, ns string, hist []Message) string {
+	if s, ok := loadDaySummaries()[summaryKey(day, scope, ns)]; ok {
+		return s
+	}
+	s, err := queryGPTStream(modelSummarise, prompt("summarize-day"), 0.4, 512, hist, nil)
+	if err != nil {
+		return "(unavailable)"
+	}
+	saveDaySummary(day, scope, ns, s)
+	return s
+}
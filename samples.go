@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// -samples N is self-consistency sampling: the model answers N times in
+// parallel, with short answers and enough temperature to vary its
+// reasoning, and the answer most samples reach is returned (see
+// voteAnswers). It costs N calls plus a judge, but is more reliable on
+// maths and logic questions, where one chain of reasoning can slip.
+const (
+	sampleTokens   = 512
+	sampleMinTemp  = 0.7
+	maxSampleCount = 10
+)
+
+var sampleCount int
+
+// sampleAnswer answers msgs sampleCount times and votes.
+func sampleAnswer(model, system string, temp float64, msgs []Message, userPrompt string, onToken func(string), params queryParams) (string, error) {
+	if sampleCount > maxSampleCount {
+		return "", fmt.Errorf("-samples is at most %d", maxSampleCount)
+	}
+	temp = max(temp, sampleMinTemp)
+	branches := make([]FusionBranch, sampleCount)
+	for i := range branches {
+		branches[i] = FusionBranch{Name: fmt.Sprint("sample ", i+1), Model: model, System: system, Temperature: &temp, MaxTokens: sampleTokens}
+	}
+	fmt.Fprintf(os.Stderr, "(sampling %d answers)\n", sampleCount)
+	answers, err := runBranches(branches, msgs)
+	if err != nil {
+		return "", err
+	}
+	return voteAnswers(model, userPrompt, answers, onToken, params)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"go-chat/api/gochatpb"
+)
+
+// turnMu serialises everything that reads-modifies-writes the log, memory
+// or config files, since server requests arrive concurrently.
+var turnMu sync.Mutex
+
+func runServe(args []string) {
+	fset := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fset.String("addr", "127.0.0.1:8080", "OpenAI-compatible HTTP listen address (empty to disable)")
+	grpcAddr := fset.String("grpc-addr", "127.0.0.1:9090", "gRPC listen address (empty to disable)")
+	web := fset.Bool("web", false, "Serve the web UI and WebSocket chat on -addr")
+	requireAuth := fset.Bool("auth", false, "Require API tokens (go-chat token issue) or OIDC bearer tokens")
+	tlsCert := fset.String("tls-cert", "", "TLS certificate file (PEM)")
+	tlsKey := fset.String("tls-key", "", "TLS private key file (PEM)")
+	autocertHosts := fset.String("autocert", "", "Comma-separated domains to get Let's Encrypt certificates for")
+	anonScope := fset.String("memory-scope", memScopePrivate, "Memory scope for requests when -auth is off")
+	fset.BoolVar(useFusion, "fusion", false, "Use multi-model fusion mode")
+	fset.IntVar(&sampleCount, "samples", 0, "Sample N answers and return the one most agree on")
+	fset.Parse(args)
+
+	recoverMemories()
+	if err := validMemScope(*anonScope); err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+	auth, err := newAuthenticator(getConfig().Server, *requireAuth, *anonScope)
+	if err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+	if !*requireAuth && (!isLoopback(*addr) || !isLoopback(*grpcAddr)) {
+		log.Fatal("serve: listening beyond localhost needs -auth")
+	}
+	if *requireAuth && *tlsCert == "" && *autocertHosts == "" && (!isLoopback(*addr) || !isLoopback(*grpcAddr)) {
+		log.Print("warning: tokens will cross the network in clear text; consider -tls-cert or -autocert")
+	}
+
+	tlsCfg, err := serverTLSConfig(*tlsCert, *tlsKey, *autocertHosts)
+	if err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+
+	errc := make(chan error, 2)
+	var srv *http.Server
+	var gs *grpc.Server
+
+	if *addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("POST /v1/chat/completions", auth.require(scopeChat, http.HandlerFunc(handleChatCompletions)))
+		mux.Handle("GET /v1/models", auth.require(scopeChat, http.HandlerFunc(handleModels)))
+		if *web {
+			registerWeb(mux, auth)
+		}
+
+		srv = &http.Server{Addr: *addr, Handler: mux, TLSConfig: tlsCfg, ReadHeaderTimeout: 10 * time.Second}
+		log.Printf("http listening on %s (web UI %v, tls %v)", *addr, *web, tlsCfg != nil)
+		go func() {
+			if tlsCfg != nil {
+				errc <- srv.ListenAndServeTLS("", "")
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+	}
+
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalf("grpc listen: %v", err)
+		}
+		opts := []grpc.ServerOption{
+			grpc.UnaryInterceptor(auth.unaryInterceptor),
+			grpc.StreamInterceptor(auth.streamInterceptor),
+		}
+		if tlsCfg != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+		}
+		gs = grpc.NewServer(opts...)
+		gochatpb.RegisterGoChatServer(gs, &grpcServer{})
+		log.Printf("grpc listening on %s", *grpcAddr)
+		go func() { errc <- gs.Serve(lis) }()
+	}
+
+	if *addr == "" && *grpcAddr == "" {
+		log.Fatal("serve: nothing to listen on")
+	}
+
+	go replayDeferredTurns()
+
+	ctx, stop := signalContext()
+	defer stop()
+	select {
+	case err := <-errc:
+		log.Fatal(err)
+	case <-ctx.Done():
+		shutdownServers(srv, gs)
+	}
+}
+
+// serverTLSConfig returns nil when TLS is off. With -autocert, certificates
+// are obtained via TLS-ALPN-01, so -addr must be reachable on port 443 for
+// each domain; they are cached in ~/.go-chat-autocert.
+func serverTLSConfig(certFile, keyFile, autocertHosts string) (*tls.Config, error) {
+	switch {
+	case autocertHosts != "" && (certFile != "" || keyFile != ""):
+		return nil, errors.New("use either -autocert or -tls-cert/-tls-key")
+	case autocertHosts != "":
+		var hosts []string
+		for _, h := range strings.Split(autocertHosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hosts = append(hosts, h)
+			}
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(filepath.Join(homeDir, ".go-chat-autocert")),
+		}
+		return m.TLSConfig(), nil
+	case certFile != "" || keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+	return nil, nil
+}
+
+// isLoopback reports whether a listen address only accepts local
+// connections. An empty (disabled) address counts as local.
+func isLoopback(addr string) bool {
+	if addr == "" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serverModel is the model name reported to OpenAI clients. Whatever model
+// they ask for, they get the configured assistant.
+const serverModel = "go-chat"
+
+type openAIRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+// handleChatCompletions answers the last user message as the configured
+// assistant. Earlier client messages are ignored: history comes from the
+// daily log, like any other go-chat turn.
+func handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req openAIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var userPrompt string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			userPrompt = req.Messages[i].Content
+			break
+		}
+	}
+	if strings.TrimSpace(userPrompt) == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "no user message")
+		return
+	}
+
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+
+	done, err := startTurn(r.Context(), userPrompt)
+	if wait, full := queueFull(err); full {
+		w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(wait)))
+		writeOpenAIError(w, http.StatusTooManyRequests, err.Error())
+		return
+	} else if errors.Is(err, errTurnDeferred) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "object": "chat.completion.deferred", "message": err.Error()})
+		return
+	} else if errors.Is(err, errShuttingDown) {
+		w.Header().Set("Retry-After", "5")
+		writeOpenAIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		return // client went away while queued
+	}
+	defer done()
+
+	if !req.Stream {
+		answer, err := respond(userPrompt, chatOptions{MemoryScope: identityFrom(r.Context()).MemoryScope})
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   serverModel,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       Message{Role: "assistant", Content: answer},
+				"finish_reason": "stop",
+			}},
+		})
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	chunk := func(delta map[string]string, finish any) {
+		data, _ := json.Marshal(map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   serverModel,
+			"choices": []map[string]any{{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	chunk(map[string]string{"role": "assistant"}, nil)
+	_, err = respond(userPrompt, chatOptions{
+		MemoryScope: identityFrom(r.Context()).MemoryScope,
+		OnToken: func(text string) {
+			chunk(map[string]string{"content": text}, nil)
+		},
+	})
+	if err != nil {
+		log.Printf("serve: %v", err)
+		chunk(map[string]string{}, "error")
+	} else {
+		chunk(map[string]string{}, "stop")
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func handleModels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"object": "list",
+		"data": []map[string]any{{
+			"id":       serverModel,
+			"object":   "model",
+			"owned_by": "go-chat",
+		}},
+	})
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": msg, "type": "invalid_request_error"},
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// When interactive mode ends, the session can be summarised in one pass:
+// a summary and any action items are printed, and the summary plus any
+// lasting facts are saved as memories. "exit_summary" in the config is
+// "ask" (the default), "auto" or "never".
+const (
+	exitSummaryAsk   = "ask"
+	exitSummaryAuto  = "auto"
+	exitSummaryNever = "never"
+)
+
+type sessionSummary struct {
+	Summary     string   `json:"summary"`
+	ActionItems []string `json:"action_items"`
+	Memories    []string `json:"memories"`
+}
+
+// summarizeSession runs on exit from interactive mode with the turns of
+// this session.
+func summarizeSession(turns []Message) {
+	if len(turns) == 0 || ephemeral {
+		return
+	}
+	switch mode := getConfig().ExitSummary; mode {
+	case exitSummaryNever:
+		return
+	case exitSummaryAuto:
+	case "", exitSummaryAsk:
+		if !confirm("Summarize this session and save its memories?") {
+			return
+		}
+	default:
+		log.Printf("unknown exit_summary %q (want ask, auto or never)", mode)
+		return
+	}
+
+	s, err := extractSessionSummary(turns)
+	if err != nil {
+		log.Printf("session summary: %v", err)
+		return
+	}
+
+	fmt.Printf("\nSummary:\n%s\n", s.Summary)
+	if len(s.ActionItems) > 0 {
+		fmt.Println("\nAction items:")
+		for _, a := range s.ActionItems {
+			fmt.Println("- " + a)
+		}
+	}
+
+	saved := 0
+	for _, m := range append([]string{s.Summary}, s.Memories...) {
+		if m = strings.TrimSpace(m); m != "" {
+			saveVectorMemory(m, memScopePrivate, activeNamespace)
+			saved++
+		}
+	}
+	fmt.Printf("\nsaved %d memories\n", saved)
+}
+
+func extractSessionSummary(turns []Message) (*sessionSummary, error) {
+	out, err := queryGPTStream(modelSummarise, prompt("session-summary"), 0.3, 1024, turns, nil)
+	if err != nil {
+		return nil, err
+	}
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, errors.New("no JSON in reply")
+	}
+	var s sessionSummary
+	if err := json.Unmarshal([]byte(out[i:j+1]), &s); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(s.Summary) == "" {
+		return nil, errors.New("empty summary")
+	}
+	return &s, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// On SIGINT or SIGTERM, serve stops taking new work and lets the turn in
+// progress finish, including its summary and memory writes, for up to
+// shutdownGrace. Requests still queued are saved to
+// ~/.go-chat-deferred.json and answered into the log when serve next
+// starts; their clients are told so. New requests are turned away with a
+// retry hint, and WebSocket clients are closed once their current answer
+// is sent. If the grace period runs out, the memory journal is left whole
+// before exiting. The daemon likewise finishes a check-in before exiting.
+const shutdownGrace = 30 * time.Second
+
+var (
+	errShuttingDown = errors.New("server is shutting down, retry shortly")
+	errTurnDeferred = errors.New("server is shutting down; the request was saved and will be answered into the session log when it restarts")
+)
+
+var deferredFilePath string
+
+// deferredTurn is a queued chat request saved at shutdown.
+type deferredTurn struct {
+	ID          string    `json:"id"`
+	Prompt      string    `json:"prompt"`
+	Identity    string    `json:"identity"`
+	MemoryScope string    `json:"memory_scope"`
+	Queued      time.Time `json:"queued"`
+}
+
+func loadDeferredTurns() []deferredTurn {
+	var ts []deferredTurn
+	if data, err := os.ReadFile(deferredFilePath); err == nil {
+		_ = json.Unmarshal(data, &ts)
+	}
+	return ts
+}
+
+func writeDeferredTurns(ts []deferredTurn) error {
+	if len(ts) == 0 {
+		if err := os.Remove(deferredFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, _ := json.MarshalIndent(ts, "", "  ")
+	return writeFileAtomic(deferredFilePath, data, 0o600)
+}
+
+// saveDeferredTurns adds ts to the saved requests.
+func saveDeferredTurns(ts []deferredTurn) error {
+	if len(ts) == 0 {
+		return nil
+	}
+	defer lockFile(deferredFilePath)()
+	now := time.Now().UnixNano()
+	for i := range ts {
+		ts[i].ID = fmt.Sprintf("%d-%d", now, i)
+	}
+	return writeDeferredTurns(append(loadDeferredTurns(), ts...))
+}
+
+func dropDeferredTurn(id string) {
+	defer lockFile(deferredFilePath)()
+	ts := slices.DeleteFunc(loadDeferredTurns(), func(t deferredTurn) bool { return t.ID == id })
+	if err := writeDeferredTurns(ts); err != nil {
+		log.Printf("saved requests: %v", err)
+	}
+}
+
+// replayDeferredTurns answers the requests saved at the last shutdown,
+// queueing alongside new ones. Each is dropped once answered, so any cut
+// short by another shutdown are tried again next time.
+func replayDeferredTurns() {
+	ts := loadDeferredTurns()
+	if len(ts) == 0 {
+		return
+	}
+	log.Printf("answering %d request(s) saved at the last shutdown", len(ts))
+	for _, t := range ts {
+		ctx := withIdentity(context.Background(), &Identity{Name: t.Identity, MemoryScope: t.MemoryScope})
+		done, err := startTurnFor(ctx, t)
+		if err != nil {
+			return // shutting down again
+		}
+		_, err = respond(t.Prompt, chatOptions{MemoryScope: t.MemoryScope})
+		done()
+		if err != nil {
+			log.Printf("saved request from %s: %v", t.Identity, err)
+		}
+		dropDeferredTurn(t.ID)
+	}
+}
+
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+func shutdownServers(srv *http.Server, gs *grpc.Server) {
+	log.Print("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	chatQueue.close()
+	closeWebSockets()
+
+	stopped := make(chan struct{})
+	go func() {
+		if gs != nil {
+			gs.GracefulStop()
+		}
+		if srv != nil {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("http shutdown: %v", err)
+			}
+		}
+		// WebSocket turns aren't tracked by srv.Shutdown; wait for the
+		// turn lock so nothing is mid-write when we exit.
+		turnMu.Lock()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Print("shutdown complete")
+	case <-ctx.Done():
+		if gs != nil {
+			gs.Stop()
+		}
+		// The turn may be journalling a memory. Wait for a write in
+		// progress to be synced and keep new ones out until exit, so the
+		// journal holds whole records for the next run to store.
+		lockFile(walFile())
+		log.Print("shutdown timed out; a turn was still running")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWS credentials are looked up the way the AWS CLI does: the
+// AWS_ACCESS_KEY_ID environment variables, then the AWS_PROFILE (or
+// default) profile in ~/.aws/credentials, then the role of the ECS task
+// or EC2 instance go-chat runs on. With a role nothing secret needs to
+// leave AWS.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time // zero if they don't
+}
+
+const (
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	ecsCredentialsHost  = "http://169.254.170.2"
+)
+
+var (
+	awsCreds   *awsCredentials
+	awsCredsMu sync.Mutex
+)
+
+func loadAWSCredentials() (*awsCredentials, error) {
+	awsCredsMu.Lock()
+	defer awsCredsMu.Unlock()
+	if c := awsCreds; c != nil && (c.Expires.IsZero() || time.Until(c.Expires) > 5*time.Minute) {
+		return c, nil
+	}
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		awsCreds = &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		return awsCreds, nil
+	}
+	if c, err := sharedAWSCredentials(); c != nil || err != nil {
+		awsCreds = c
+		return c, err
+	}
+	c, err := roleAWSCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, add them to ~/.aws/credentials, or run with an IAM role (%v)", err)
+	}
+	awsCreds = c
+	return c, nil
+}
+
+// sharedAWSCredentials reads the profile's keys from the shared
+// credentials file, or returns nil if it has none.
+func sharedAWSCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = filepath.Join(homeDir, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	profile := cmp.Or(os.Getenv("AWS_PROFILE"), "default")
+	var c awsCredentials
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "aws_access_key_id":
+			c.AccessKeyID = strings.TrimSpace(v)
+		case "aws_secret_access_key":
+			c.SecretAccessKey = strings.TrimSpace(v)
+		case "aws_session_token":
+			c.SessionToken = strings.TrimSpace(v)
+		}
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, nil
+	}
+	return &c, sc.Err()
+}
+
+// roleAWSCredentials fetches temporary credentials for the ECS task role,
+// or else the EC2 instance profile through IMDSv2.
+func roleAWSCredentials() (*awsCredentials, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	get := func(url, token string) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", url, resp.Status)
+		}
+		return body, nil
+	}
+
+	var body []byte
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		var err error
+		if body, err = get(ecsCredentialsHost+uri, ""); err != nil {
+			return nil, err
+		}
+	} else {
+		base := strings.TrimSuffix(cmp.Or(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), defaultIMDSEndpoint), "/")
+		req, _ := http.NewRequest(http.MethodPut, base+"/latest/api/token", nil)
+		req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		tok, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("instance metadata: %s", resp.Status)
+		}
+		role, err := get(base+"/latest/meta-data/iam/security-credentials/", string(tok))
+		if err != nil {
+			return nil, err
+		}
+		name, _, _ := strings.Cut(strings.TrimSpace(string(role)), "\n")
+		if name == "" {
+			return nil, errors.New("instance has no IAM role")
+		}
+		if body, err = get(base+"/latest/meta-data/iam/security-credentials/"+name, string(tok)); err != nil {
+			return nil, err
+		}
+	}
+	var out struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("role credentials: %w", err)
+	}
+	return &awsCredentials{out.AccessKeyID, out.SecretAccessKey, out.Token, out.Expiration}, nil
+}
+
+// signAWS signs req with Signature Version 4. req.URL must carry the
+// escaped path in RawPath when it has one.
+func signAWS(req *http.Request, body []byte, c *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", n, strings.Join(strings.Fields(req.Header.Get(n)), " "))
+	}
+	signed := strings.Join(names, ";")
+
+	// Services other than S3 sign the path escaped once more.
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		strings.Join(segments, "/"),
+		awsCanonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{day, region, service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+	key := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Del("Host") // sent from req.Host
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// awsCanonicalQuery encodes the query as SigV4 wants it: names and values
+// escaped with awsEscape (so spaces are %20, not +), sorted by name and
+// then value.
+func awsCanonicalQuery(q url.Values) string {
+	var pairs [][2]string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{awsEscape(k), awsEscape(v)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	out := make([]string, len(pairs))
+	for i, p := range pairs {
+		out[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(out, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, as
+// SigV4 requires.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
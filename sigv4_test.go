@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Vectors from the AWS Signature Version 4 test suite
+// (aws-sig-v4-test-suite), which all sign as AKIDEXAMPLE for service
+// "service" in us-east-1 at 20150830T123600Z.
+func TestSignAWSTestSuite(t *testing.T) {
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	const unreserved = "-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	for _, tc := range []struct {
+		name, method, target, sig string
+	}{
+		{"get-vanilla", "GET", "/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-empty-query-key", "GET", "/?Param1=value1", "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-query-order-value", "GET", "/?Param1=value2&Param1=value1", "5772eed61e12b33fae39ee5e7012498b51d56abc0abb7c60486157bd471c4694"},
+		{"get-vanilla-query-unreserved", "GET", "/?" + unreserved + "=" + unreserved, "9c3e54bfcdf0b19771a7f523ee5669cdf59bc7cc0884027167c21bb143a40197"},
+		{"post-vanilla", "POST", "/", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, "https://example.amazonaws.com"+tc.target, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signAWS(req, nil, creds, "us-east-1", "service", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tc.sig
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization:\n got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAWSCanonicalQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/?b=two+words&a-b=1&a=x%2Fy&a=*", nil)
+	got := awsCanonicalQuery(req.URL.Query())
+	want := strings.Join([]string{"a=%2A", "a=x%2Fy", "a-b=1", "b=two%20words"}, "&")
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
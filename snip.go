@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+// Snippets are code blocks kept from answers, in ~/.go-chat-snippets.json,
+// each with its language, a model-written title and tags. `/snip` in
+// interactive mode files the blocks of the last answer; with "auto_snip":
+// true in the config every answer's blocks are filed. `go-chat snip search
+// docker` finds them again.
+const snippetsFilePath = ".go-chat-snippets.json"
+
+type Snippet struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Lang    string    `json:"lang,omitempty"`
+	Title   string    `json:"title"`
+	Tags    []string  `json:"tags,omitempty"`
+	Code    string    `json:"code"`
+	Prompt  string    `json:"prompt,omitempty"`
+}
+
+func snippetsFile() string { return filepath.Join(homeDir, snippetsFilePath) }
+
+func loadSnippets() []Snippet {
+	var snips []Snippet
+	if data, err := os.ReadFile(snippetsFile()); err == nil {
+		_ = json.Unmarshal(data, &snips)
+	}
+	return snips
+}
+
+func saveSnippets(snips []Snippet) error {
+	data, err := json.MarshalIndent(snips, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(snippetsFile(), data, 0o644)
+}
+
+// snipAnswer files the code blocks of an answer, skipping ones already
+// stored, and returns the new snippets. tags are added to each.
+func snipAnswer(userPrompt, answer string, tags []string) ([]Snippet, error) {
+	var snips []Snippet
+	for _, b := range codeBlocks(answer) {
+		code := strings.TrimRight(b.code, "\n")
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		s := Snippet{
+			ID:      memoryID(b.lang + "\x00" + code),
+			Created: time.Now().UTC(),
+			Lang:    strings.ToLower(b.lang),
+			Code:    code,
+			Prompt:  userPrompt,
+		}
+		s.Title, s.Tags = describeSnippet(s)
+		for _, t := range tags {
+			if !slices.Contains(s.Tags, t) {
+				s.Tags = append(s.Tags, t)
+			}
+		}
+		snips = append(snips, s)
+	}
+	if len(snips) == 0 {
+		return nil, nil
+	}
+
+	defer lockFile(snippetsFile())()
+	all := loadSnippets()
+	var added []Snippet
+	for _, s := range snips {
+		if !slices.ContainsFunc(all, func(o Snippet) bool { return o.ID == s.ID }) {
+			all = append(all, s)
+			added = append(added, s)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	return added, saveSnippets(all)
+}
+
+// describeSnippet asks the model for a title and tags, falling back to the
+// first line of code and the language.
+func describeSnippet(s Snippet) (string, []string) {
+	first, _, _ := strings.Cut(strings.TrimSpace(s.Code), "\n")
+	title, tags := first, []string{}
+	if s.Lang != "" {
+		tags = append(tags, s.Lang)
+	}
+
+	msg := "```" + s.Lang + "\n" + s.Code + "\n```"
+	if s.Prompt != "" {
+		msg = "Asked: " + s.Prompt + "\n\n" + msg
+	}
+	out, err := queryGPTStream(modelSummarise, prompt("snippet-title"), 0.2, 128, []Message{{Role: "user", Content: msg}}, nil)
+	if err != nil {
+		return title, tags
+	}
+	var d struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i || json.Unmarshal([]byte(out[i:j+1]), &d) != nil || d.Title == "" {
+		return title, tags
+	}
+	for _, t := range d.Tags {
+		if t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "#")); t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return d.Title, tags
+}
+
+// autoSnip files an answer's code blocks when "auto_snip" is on.
+func autoSnip(userPrompt, answer string) {
+	if !getConfig().AutoSnip || ephemeral {
+		return
+	}
+	added, err := snipAnswer(userPrompt, answer, nil)
+	if err != nil {
+		log.Printf("snip: %v", err)
+	} else if len(added) > 0 {
+		fmt.Fprintf(os.Stderr, "(saved %d snippet(s); go-chat snip list)\n", len(added))
+	}
+}
+
+func (s Snippet) matches(words []string) bool {
+	hay := strings.ToLower(s.Title + " " + s.Lang + " " + strings.Join(s.Tags, " ") + " " + s.Code)
+	for _, w := range words {
+		if !strings.Contains(hay, strings.ToLower(w)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Snippet) line() string {
+	tags := ""
+	if len(s.Tags) > 0 {
+		tags = " #" + strings.Join(s.Tags, " #")
+	}
+	return fmt.Sprintf("%s  %-10s %s%s", s.ID, s.Lang, s.Title, tags)
+}
+
+func runSnip(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: go-chat snip search [-lang l] [-print] [-copy] <words>... | list | show [-copy] <id> | remove <id>")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "search", "list":
+		fset := flag.NewFlagSet("snip "+args[0], flag.ExitOnError)
+		lang := fset.String("lang", "", "Only snippets in this language")
+		printCode := fset.Bool("print", false, "Print the code of every match")
+		copyCode := fset.Bool("copy", false, "Copy the newest match to the clipboard")
+		fset.Parse(args[1:])
+		if args[0] == "search" && fset.NArg() == 0 {
+			log.Fatal("usage: go-chat snip search [-lang l] [-print] [-copy] <words>...")
+		}
+
+		var found []Snippet
+		for _, s := range slices.Backward(loadSnippets()) {
+			if (*lang == "" || strings.EqualFold(s.Lang, *lang)) && s.matches(fset.Args()) {
+				found = append(found, s)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Println("no snippets found")
+			return
+		}
+		for _, s := range found {
+			fmt.Println(s.line())
+			if *printCode {
+				fmt.Printf("\n%s\n\n", s.Code)
+			}
+		}
+		if *copyCode {
+			copySnippet(found[0])
+		}
+
+	case "show":
+		fset := flag.NewFlagSet("snip show", flag.ExitOnError)
+		copyCode := fset.Bool("copy", false, "Copy the code to the clipboard")
+		fset.Parse(args[1:])
+		if fset.NArg() != 1 {
+			log.Fatal("usage: go-chat snip show [-copy] <id>")
+		}
+		snips := loadSnippets()
+		i := slices.IndexFunc(snips, func(s Snippet) bool { return s.ID == fset.Arg(0) })
+		if i < 0 {
+			log.Fatalf("snip: no snippet %q", fset.Arg(0))
+		}
+		fmt.Println(snips[i].Code)
+		if *copyCode {
+			copySnippet(snips[i])
+		}
+
+	case "remove":
+		if len(args) != 2 {
+			log.Fatal("usage: go-chat snip remove <id>")
+		}
+		defer lockFile(snippetsFile())()
+		snips := loadSnippets()
+		kept := slices.DeleteFunc(slices.Clone(snips), func(s Snippet) bool { return s.ID == args[1] })
+		if len(kept) == len(snips) {
+			log.Fatalf("snip: no snippet %q", args[1])
+		}
+		if err := saveSnippets(kept); err != nil {
+			log.Fatalf("snip remove: %v", err)
+		}
+		fmt.Println("removed", args[1])
+
+	default:
+		log.Fatalf("unknown snip command %q", args[0])
+	}
+}
+
+func copySnippet(s Snippet) {
+	if err := clipboard.WriteAll(s.Code); err != nil {
+		log.Fatalf("snip: copy: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "copied %s to the clipboard\n", s.ID)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// `go-chat sql -dsn postgres://…` drafts SQL from questions. The model is
+// given the schema (table and column names and types, never rows) and
+// writes one query, which is shown and run after confirmation through the
+// database's own client: psql, mysql or sqlite3. Sessions are read-only
+// unless -write is given. The result is then summarised against the
+// question, or only printed with -raw, in which case no row data leaves
+// the machine.
+const (
+	sqlMaxAttempts = 3
+	sqlResultRows  = 100 // rows sent for the summary
+	sqlShownRows   = 50
+)
+
+// sqlClientNames maps each kind of database to its command-line client.
+var sqlClientNames = map[string]string{"postgres": "psql", "mysql": "mysql", "sqlite": "sqlite3"}
+
+type sqlClient struct {
+	kind  string // "postgres", "mysql" or "sqlite"
+	dsn   string
+	write bool
+}
+
+func newSQLClient(dsn string, write bool) (*sqlClient, error) {
+	c := &sqlClient{dsn: dsn, write: write}
+	scheme, rest, _ := strings.Cut(dsn, ":")
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		c.kind = "postgres"
+	case "mysql":
+		c.kind = "mysql"
+	case "sqlite", "sqlite3", "file":
+		c.kind, c.dsn = "sqlite", strings.TrimPrefix(rest, "//")
+	default:
+		switch strings.ToLower(filepath.Ext(dsn)) {
+		case ".db", ".sqlite", ".sqlite3":
+			c.kind = "sqlite"
+		default:
+			return nil, fmt.Errorf("unsupported DSN %q (want postgres://, mysql:// or sqlite:)", dsn)
+		}
+	}
+	client := sqlClientNames[c.kind]
+	if _, err := exec.LookPath(client); err != nil {
+		return nil, fmt.Errorf("%s needs the %s client on PATH", c.kind, client)
+	}
+	return c, nil
+}
+
+// command builds the client invocation for a query. Read-only is enforced
+// by the database: a read-only transaction default for postgres and mysql,
+// a read-only open for sqlite.
+func (c *sqlClient) command(query string) (*exec.Cmd, error) {
+	switch c.kind {
+	case "postgres":
+		// The password goes in the environment, not on the command line
+		// where other users can see it.
+		u, err := url.Parse(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		env := os.Environ()
+		if pw, ok := u.User.Password(); ok {
+			env = append(env, "PGPASSWORD="+pw)
+			u.User = url.User(u.User.Username())
+		}
+		if v := u.Query(); v.Has("password") {
+			env = append(env, "PGPASSWORD="+v.Get("password"))
+			v.Del("password")
+			u.RawQuery = v.Encode()
+		}
+		if !c.write {
+			env = append(env, "PGOPTIONS=-c default_transaction_read_only=on")
+		}
+		cmd := exec.Command("psql", u.String(), "-X", "-q", "--csv", "-v", "ON_ERROR_STOP=1", "-c", query)
+		cmd.Env = env
+		return cmd, nil
+
+	case "mysql":
+		u, err := url.Parse(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		args := []string{"--batch", "-h", u.Hostname()}
+		if p := u.Port(); p != "" {
+			args = append(args, "-P", p)
+		}
+		if name := u.User.Username(); name != "" {
+			args = append(args, "-u", name)
+		}
+		if !c.write {
+			args = append(args, "--init-command=SET SESSION TRANSACTION READ ONLY")
+		}
+		args = append(args, "-e", query, strings.TrimPrefix(u.Path, "/"))
+		cmd := exec.Command("mysql", args...)
+		cmd.Env = os.Environ()
+		if pw, ok := u.User.Password(); ok {
+			cmd.Env = append(cmd.Env, "MYSQL_PWD="+pw)
+		}
+		return cmd, nil
+	}
+
+	args := []string{"-bail", "-csv", "-header"}
+	if !c.write {
+		args = append(args, "-readonly")
+	}
+	return exec.Command("sqlite3", append(args, c.dsn, query)...), nil
+}
+
+// query runs SQL and returns the header and rows of its last result.
+func (c *sqlClient) query(q string) ([]string, [][]string, error) {
+	cmd, err := c.command(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, nil, errors.New(msg)
+		}
+		return nil, nil, err
+	}
+
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord = -1
+	if c.kind == "mysql" {
+		r.Comma, r.LazyQuotes = '\t', true
+	}
+	recs, err := r.ReadAll()
+	if err != nil || len(recs) == 0 {
+		return nil, nil, err
+	}
+	return recs[0], recs[1:], nil
+}
+
+// schema describes every table as "name(column type, ...)".
+func (c *sqlClient) schema() (string, error) {
+	q := map[string]string{
+		"postgres": `SELECT table_schema || '.' || table_name, column_name, data_type, is_nullable FROM information_schema.columns WHERE table_schema NOT IN ('pg_catalog', 'information_schema') ORDER BY table_schema, table_name, ordinal_position`,
+		"mysql":    `SELECT table_name, column_name, column_type, is_nullable FROM information_schema.columns WHERE table_schema = DATABASE() ORDER BY table_name, ordinal_position`,
+		"sqlite":   `SELECT m.name, p.name, p.type, CASE p."notnull" WHEN 1 THEN 'NO' ELSE 'YES' END FROM sqlite_master m JOIN pragma_table_info(m.name) p WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%' ORDER BY m.name, p.cid`,
+	}[c.kind]
+	_, rows, err := c.query(q)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	table := ""
+	for _, r := range rows {
+		if len(r) < 4 {
+			continue
+		}
+		if r[0] != table {
+			if table != "" {
+				b.WriteString(")\n")
+			}
+			table = r[0]
+			b.WriteString(table + "(")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(r[1] + " " + r[2])
+		if r[3] == "NO" {
+			b.WriteString(" not null")
+		}
+	}
+	if table == "" {
+		return "", errors.New("no tables found")
+	}
+	b.WriteString(")\n")
+	return b.String(), nil
+}
+
+// readOnlySQL guesses whether a statement only reads, to decide when -y
+// may run it without asking. It looks at every keyword, not just the
+// first, so a data-changing CTE or EXPLAIN ANALYZE of a write doesn't
+// pass, and it errs towards saying a statement writes. Only a read-only
+// session is enforced by the database; with -write this guess is all
+// there is. Several statements never count as reading only.
+func readOnlySQL(q string) bool {
+	if sqlStatements(q) > 1 {
+		return false
+	}
+	words := sqlWords(q)
+	if len(words) == 0 {
+		return true
+	}
+	switch words[0] {
+	case "SELECT", "WITH", "EXPLAIN", "SHOW", "DESCRIBE", "DESC", "VALUES", "TABLE":
+	default:
+		return false // PRAGMA among them: many set things
+	}
+	for _, w := range words {
+		switch w {
+		case "INSERT", "UPDATE", "DELETE", "MERGE", "INTO", "CREATE", "DROP", "ALTER", "TRUNCATE",
+			"COPY", "CALL", "ANALYZE", "ANALYSE":
+			return false
+		}
+	}
+	return true
+}
+
+// sqlStatements counts the statements in q: the semicolons outside
+// strings, quoted names and comments, plus any text after the last one.
+// Where dialects differ it errs towards counting more.
+func sqlStatements(q string) int {
+	n, pending := 0, false
+	for _, t := range sqlTokens(q) {
+		if t == ";" {
+			if pending {
+				n++
+			}
+			pending = false
+		} else {
+			pending = true
+		}
+	}
+	if pending {
+		n++
+	}
+	return n
+}
+
+// sqlWords returns the upper-cased bare words of q, leaving out strings,
+// quoted names and comments.
+func sqlWords(q string) []string {
+	var words []string
+	for _, t := range sqlTokens(q) {
+		if c := t[0]; c == '_' || unicode.IsLetter(rune(c)) {
+			words = append(words, strings.ToUpper(t))
+		}
+	}
+	return words
+}
+
+// sqlTokens splits q into words, quoted strings or names (kept whole,
+// quotes included) and single punctuation characters. Comments and
+// white space are dropped; an unterminated quote or comment runs to the
+// end.
+func sqlTokens(q string) []string {
+	var toks []string
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(q[i+1:], c)
+			if j < 0 {
+				toks = append(toks, q[i:])
+				return toks
+			}
+			toks = append(toks, q[i:i+j+2])
+			i += j + 2
+		case strings.HasPrefix(q[i:], "--"):
+			j := strings.IndexByte(q[i:], '\n')
+			if j < 0 {
+				return toks
+			}
+			i += j
+		case strings.HasPrefix(q[i:], "/*"):
+			j := strings.Index(q[i+2:], "*/")
+			if j < 0 {
+				return toks
+			}
+			i += j + 4
+		case c == '_' || c == '$' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(q) && (q[j] == '_' || q[j] == '$' || q[j] >= 0x80 || unicode.IsLetter(rune(q[j])) || unicode.IsDigit(rune(q[j]))) {
+				j++
+			}
+			toks = append(toks, q[i:j])
+			i = j
+		case unicode.IsSpace(rune(c)):
+			i++
+		default:
+			toks = append(toks, q[i:i+1])
+			i++
+		}
+	}
+	return toks
+}
+
+// sqlMetaCommand reports whether q has a line the client would take as
+// one of its own commands rather than SQL: a sqlite3 dot-command or a
+// psql backslash command, either of which can run shell commands.
+func sqlMetaCommand(q string) bool {
+	for _, line := range strings.Split(q, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, ".") || strings.HasPrefix(line, "\\") {
+			return true
+		}
+	}
+	return false
+}
+
+type sqlSession struct {
+	c      *sqlClient
+	system string
+	yes    bool
+	raw    bool
+	msgs   []Message // earlier questions and queries, for follow-ups
+}
+
+// ask drafts a query for a question, runs it once confirmed (feeding
+// errors back for a corrected query) and summarises the result.
+func (s *sqlSession) ask(question string) error {
+	s.msgs = append(s.msgs, Message{Role: "user", Content: question})
+	for attempt := 1; ; attempt++ {
+		out, err := queryGPTStream(modelExec, s.system, 0.1, 1024, s.msgs, nil)
+		if err != nil {
+			return err
+		}
+		s.msgs = append(s.msgs, Message{Role: "assistant", Content: out})
+		var query string
+		for _, b := range codeBlocks(out) {
+			if b.lang == "" || strings.EqualFold(b.lang, "sql") {
+				query = strings.TrimSpace(b.code)
+				break
+			}
+		}
+		if query == "" {
+			fmt.Println(strings.TrimSpace(out))
+			return nil
+		}
+
+		fmt.Printf("\n%s\n\n", query)
+		if sqlMetaCommand(query) {
+			fmt.Printf("(that is a %s command, not SQL; asking for SQL)\n", sqlClientNames[s.c.kind])
+			if attempt == sqlMaxAttempts {
+				return fmt.Errorf("giving up after %d attempts", attempt)
+			}
+			s.msgs = append(s.msgs, Message{Role: "user", Content: "I only run SQL, not client commands. Reply with a SQL query."})
+			continue
+		}
+		if sqlStatements(query) > 1 {
+			fmt.Println("(that is more than one statement; asking for one)")
+			if attempt == sqlMaxAttempts {
+				return fmt.Errorf("giving up after %d attempts", attempt)
+			}
+			s.msgs = append(s.msgs, Message{Role: "user", Content: "I only run one statement at a time. Reply with a single query."})
+			continue
+		}
+		reads := readOnlySQL(query)
+		if !reads && !s.c.write {
+			fmt.Println("(this statement writes; the session is read-only, use -write to allow it)")
+		}
+		if !(s.yes && reads && !s.c.write) && !confirm("Run it?") {
+			s.msgs = append(s.msgs, Message{Role: "user", Content: "I didn't run that query."})
+			return errNotSent
+		}
+
+		cols, rows, err := s.c.query(query)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			if attempt == sqlMaxAttempts {
+				return fmt.Errorf("giving up after %d attempts", attempt)
+			}
+			s.msgs = append(s.msgs, Message{Role: "user", Content: fmt.Sprintf("The query failed:\n%v\nReply with a corrected query.", err)})
+			continue
+		}
+
+		if len(cols) > 0 {
+			fmt.Print(csvText(cols, rows[:min(len(rows), sqlShownRows)]))
+		}
+		fmt.Printf("(%d rows)\n\n", len(rows))
+		if s.raw || len(cols) == 0 {
+			return nil
+		}
+
+		result := csvText(cols, rows[:min(len(rows), sqlResultRows)])
+		if len(rows) > sqlResultRows {
+			result += fmt.Sprintf("(%d rows, first %d shown)\n", len(rows), sqlResultRows)
+		}
+		msg := fmt.Sprintf("Question: %s\n\nQuery:\n%s\n\nResult:\n%s", question, fenced("sql", query), fenced("csv", result))
+		summary, err := queryGPTStream(modelLogic, prompt("sql-summary"), 0.3, 1024, []Message{{Role: "user", Content: msg}}, printToken)
+		fmt.Println()
+		if err != nil {
+			return err
+		}
+		if err := appendLog(ChatLog{Request: "sql: " + question, Response: fenced("sql", query) + "\n\n" + summary, Namespace: storedNamespace(activeNamespace)}); err != nil {
+			log.Printf("log: %v", err)
+		}
+		return nil
+	}
+}
+
+func runSQL(args []string) {
+	fset := flag.NewFlagSet("sql", flag.ExitOnError)
+	dsn := fset.String("dsn", os.Getenv("DATABASE_URL"), "Database to query: postgres://…, mysql://… or sqlite:path (default $DATABASE_URL)")
+	write := fset.Bool("write", false, "Allow statements that change data")
+	yes := fset.Bool("y", false, "Run read-only queries without asking (not with -write)")
+	raw := fset.Bool("raw", false, "Print results without sending them to the model")
+	fset.Parse(args)
+	if *dsn == "" {
+		log.Fatal(`usage: go-chat sql -dsn URL [-write] [-y] [-raw] ["question"]`)
+	}
+
+	c, err := newSQLClient(*dsn, *write)
+	if err != nil {
+		log.Fatalf("sql: %v", err)
+	}
+	schema, err := c.schema()
+	if err != nil {
+		log.Fatalf("sql: reading schema: %v", err)
+	}
+	mode := "read-only"
+	if *write {
+		mode = "allowed to change data"
+	}
+	s := &sqlSession{
+		c:      c,
+		system: fmt.Sprintf("%s\n\nDatabase: %s, %s.\nSchema:\n%s", prompt("sql-assistant"), c.kind, mode, schema),
+		yes:    *yes,
+		raw:    *raw,
+	}
+
+	if q := strings.TrimSpace(strings.Join(fset.Args(), " ")); q != "" {
+		if err := s.ask(q); err != nil && !errors.Is(err, errNotSent) {
+			log.Fatalf("sql: %v", err)
+		}
+		return
+	}
+	fmt.Printf("Connected to %s (%s). Ask a question, or an empty line to quit.\n", c.kind, mode)
+	for {
+		fmt.Print("sql> ")
+		line, readErr := stdin.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" || line == "exit" {
+			return
+		}
+		if err := s.ask(line); err != nil && !errors.Is(err, errNotSent) {
+			fmt.Printf("error: %v\n", err)
+		}
+		if readErr != nil {
+			return
+		}
+	}
+}
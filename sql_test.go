@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestSQLStatements(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want int
+	}{
+		{"", 0},
+		{"SELECT 1", 1},
+		{"SELECT 1;", 1},
+		{"SELECT 1; ;", 1},
+		{"SELECT 1; SELECT 2", 2},
+		{"SELECT ';' FROM t", 1},
+		{`SELECT "a;b" FROM t`, 1},
+		{"SELECT `a;b` FROM t", 1},
+		{"SELECT 'it''s; fine'", 1},
+		{"SELECT 1 -- trailing; comment", 1},
+		{"SELECT 1 /* ; */", 1},
+		{"SELECT 1; /* just a comment */", 1},
+		{"SELECT 1; -- DROP TABLE t", 1},
+		{"SELECT 1;\nDROP TABLE t", 2},
+		{"SELECT 'unterminated; DROP TABLE t", 1},
+	} {
+		if got := sqlStatements(tc.q); got != tc.want {
+			t.Errorf("sqlStatements(%q) = %d, want %d", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestReadOnlySQL(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want bool
+	}{
+		{"SELECT * FROM t", true},
+		{"  select name from users where id = 1", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"EXPLAIN SELECT * FROM t", true},
+		{"SHOW TABLES", true},
+		{"SELECT 'delete me' FROM t", true},
+		{`SELECT "update" FROM t`, true},
+		{"SELECT updated_at FROM t -- delete later", true},
+		{"SELECT * FROM t /* insert here */", true},
+		{"SELECT replace(name, 'a', 'b') FROM t", true},
+		{"DELETE FROM t", false},
+		{"insert into t values (1)", false},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", false},
+		{"EXPLAIN ANALYZE DELETE FROM t", false},
+		{"EXPLAIN ANALYZE SELECT * FROM t", false},
+		{"SELECT * INTO backup FROM t", false},
+		{"PRAGMA journal_mode = DELETE", false},
+		{"PRAGMA table_info(t)", false},
+		{"SELECT 1; DROP TABLE t", false},
+		{"SELECT ';'; DELETE FROM t", false},
+	} {
+		if got := readOnlySQL(tc.q); got != tc.want {
+			t.Errorf("readOnlySQL(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestSQLMetaCommand(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want bool
+	}{
+		{"SELECT 1", false},
+		{`SELECT * FROM t WHERE name LIKE 'a\_%'`, false},
+		{".shell rm -rf ~", true},
+		{"  .tables", true},
+		{`\! rm -rf ~`, true},
+		{"SELECT 1;\n\\! id", true},
+		{"SELECT 1;\n.system id", true},
+	} {
+		if got := sqlMetaCommand(tc.q); got != tc.want {
+			t.Errorf("sqlMetaCommand(%q) = %v, want %v", tc.q, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// The system prompt for a chat turn is rendered from a Go template,
+// prompts/system.txt by default. Set "system_template" to a file of your
+// own (relative paths are taken from the home directory) to control
+// exactly how it is assembled. The template gets systemData and the
+// functions personalities can use (see promptvars.go), plus join. A
+// template that fails to load, parse or run is reported and the default
+// is used.
+type systemData struct {
+	Name        string   // the assistant
+	User        string   // the user's name
+	Bio         string   // the user's bio, prompt variables expanded
+	Personality string   // from the config or persona, expanded
+	Memories    string   // relevant memories, blank-line separated
+	MemoryList  []string // the same, one per memory
+	Others      []string // other assistants in a group conversation
+	Language    string   // reply-language instruction, if any
+	Time        string   // current date and time, if enabled
+	Environment string   // working directory, git state etc., if enabled
+	Length      string   // answer length instruction, if any
+	Sources     string   // indexed documents to cite, if any
+	Contacts    string   // contacts the prompt mentions, if any
+	Pinned      string   // pinned files, if any
+}
+
+var systemFuncs = template.FuncMap{"join": strings.Join}
+
+func renderSystemPrompt(cfg Config, data systemData) string {
+	if cfg.SystemTemplate != "" {
+		text, err := includeFile(cfg.SystemTemplate)
+		if err == nil {
+			var out string
+			if out, err = execSystemTemplate(text, data); err == nil {
+				return out
+			}
+		}
+		log.Printf("system_template: %v", err)
+	}
+	out, err := execSystemTemplate(prompt("system"), data)
+	if err != nil {
+		log.Printf("system prompt template: %v", err)
+		return fmt.Sprintf("You are %s. User = %s. Bio: %s. Personality: %s.\nYour relevant memories:\n%s",
+			data.Name, data.User, data.Bio, data.Personality, data.Memories)
+	}
+	return out
+}
+
+func execSystemTemplate(text string, data systemData) (string, error) {
+	t, err := template.New("system").Funcs(promptFuncs).Funcs(systemFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// `go-chat task "..."` runs a small agent loop in the working directory:
+// the model plans steps, then works through each one with tool calls
+// (read, write and list files, run shell commands, plus any tool plugins).
+// Every write and command is shown and needs confirmation unless -y is
+// given. The run stops at -max-steps tool calls or once about -max-tokens
+// have been spent, and ends with a report that is also logged.
+const (
+	defaultTaskSteps  = 30
+	defaultTaskTokens = 200000
+
+	taskCommandTimeout = 2 * time.Minute
+	maxToolOutput      = 8 << 10
+)
+
+type taskRun struct {
+	root      string
+	yes       bool
+	maxSteps  int
+	maxTokens int
+
+	steps  int
+	tokens int
+	tools  map[string]*Tool
+	log    []string // actions and results, for the report
+}
+
+var errTaskBudget = errors.New("budget exhausted")
+
+func runTask(args []string) {
+	fset := flag.NewFlagSet("task", flag.ExitOnError)
+	yes := fset.Bool("y", false, "Run writes and commands without asking")
+	maxSteps := fset.Int("max-steps", defaultTaskSteps, "Maximum tool calls")
+	maxTokens := fset.Int("max-tokens", defaultTaskTokens, "Stop after roughly this many tokens sent and received")
+	fset.Parse(args)
+	task := strings.TrimSpace(strings.Join(fset.Args(), " "))
+	if task == "" {
+		log.Fatal(`usage: go-chat task [-y] [-max-steps N] [-max-tokens N] "what to do"`)
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("task: %v", err)
+	}
+	t := &taskRun{root: root, yes: *yes, maxSteps: *maxSteps, maxTokens: *maxTokens}
+	t.tools = t.builtinTools()
+	loadPlugins()
+	for name, tool := range toolRegistry {
+		if _, dup := t.tools[name]; !dup {
+			t.tools[name] = tool
+		}
+	}
+
+	var plan struct {
+		Steps []string `json:"steps"`
+	}
+	if err := t.ask(prompt("task-plan"), "Task: "+task+"\n\nFiles:\n"+t.listing(), &plan); err != nil {
+		log.Fatalf("task: plan: %v", err)
+	}
+	if len(plan.Steps) == 0 {
+		log.Fatal("task: the model returned an empty plan")
+	}
+	fmt.Println("Plan:")
+	for i, s := range plan.Steps {
+		fmt.Printf("%d. %s\n", i+1, s)
+	}
+	if !t.yes && !confirm("Carry out this plan?") {
+		return
+	}
+
+	var stopped error
+	for i, step := range plan.Steps {
+		fmt.Printf("\n== step %d/%d: %s\n", i+1, len(plan.Steps), step)
+		t.log = append(t.log, fmt.Sprintf("step %d: %s", i+1, step))
+		if err := t.runStep(task, plan.Steps, i); err != nil {
+			stopped = err
+			t.log = append(t.log, "stopped: "+err.Error())
+			fmt.Println("stopped:", err)
+			break
+		}
+	}
+
+	report, err := queryGPTStream(modelExec, prompt("task-report"), 0.3, 1024, []Message{{
+		Role:    "user",
+		Content: "Task: " + task + "\n\nPlan:\n" + numbered(plan.Steps) + "\n\nLog:\n" + strings.Join(t.log, "\n"),
+	}}, nil)
+	if err != nil {
+		report = strings.Join(t.log, "\n")
+	}
+	fmt.Printf("\n== report (%d tool calls, ~%d tokens)\n%s\n", t.steps, t.tokens, report)
+	if stopped != nil {
+		fmt.Println("\nThe task did not finish:", stopped)
+	}
+	if err := appendLog(ChatLog{Request: "task: " + task, Response: report, Namespace: storedNamespace(activeNamespace)}); err != nil {
+		log.Printf("append log: %v", err)
+	}
+}
+
+// runStep lets the model call tools until it declares step i done.
+func (t *taskRun) runStep(task string, steps []string, i int) error {
+	system := prompt("task-step") + "\n" + t.toolList()
+	msgs := []Message{{
+		Role:    "user",
+		Content: fmt.Sprintf("Task: %s\n\nPlan:\n%s\n\nDo step %d now: %s", task, numbered(steps), i+1, steps[i]),
+	}}
+	for {
+		var reply struct {
+			Tool string          `json:"tool"`
+			Args json.RawMessage `json:"args"`
+			Why  string          `json:"why"`
+			Done *string         `json:"done"`
+		}
+		raw, err := t.chat(system, msgs, &reply)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, Message{Role: "assistant", Content: raw})
+		if reply.Done != nil {
+			fmt.Println("done:", *reply.Done)
+			t.log = append(t.log, "  done: "+*reply.Done)
+			return nil
+		}
+
+		if t.steps >= t.maxSteps {
+			return fmt.Errorf("%w: %d tool calls", errTaskBudget, t.steps)
+		}
+		t.steps++
+		result := t.call(reply.Tool, reply.Args, reply.Why)
+		msgs = append(msgs, Message{Role: "user", Content: "Result:\n" + result})
+	}
+}
+
+// call runs one tool call, asking first unless it only reads.
+func (t *taskRun) call(name string, args json.RawMessage, why string) string {
+	tool, ok := t.tools[name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", name)
+	}
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	desc := fmt.Sprintf("%s %s", name, args)
+	if len(desc) > 300 {
+		desc = desc[:300] + "…"
+	}
+	fmt.Printf("-> %s\n   %s\n", desc, why)
+
+	readOnly := name == "read_file" || name == "list_files"
+	if !readOnly && !t.yes && !confirm("Run it?") {
+		t.log = append(t.log, "  declined: "+desc)
+		return "The user declined this action."
+	}
+	out, err := tool.Run(args)
+	if err != nil {
+		out = "error: " + err.Error()
+	}
+	if len(out) > maxToolOutput {
+		out = out[:maxToolOutput] + "\n[output truncated]"
+	}
+	status := "ok"
+	if err != nil {
+		status = "failed: " + err.Error()
+	}
+	t.log = append(t.log, fmt.Sprintf("  %s: %s", desc, status))
+	return out
+}
+
+// chat sends one turn, decodes the JSON reply into v and counts tokens
+// against the budget.
+func (t *taskRun) chat(system string, msgs []Message, v any) (string, error) {
+	sent := tokens(system)
+	for _, m := range msgs {
+		sent += tokensMsg(m)
+	}
+	if t.tokens+sent > t.maxTokens {
+		return "", fmt.Errorf("%w: ~%d tokens", errTaskBudget, t.tokens)
+	}
+	out, err := queryGPTStream(modelExec, system, 0.2, 4096, msgs, nil)
+	if err != nil {
+		return "", err
+	}
+	t.tokens += sent + tokens(out)
+
+	i, j := strings.Index(out, "{"), strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return "", errors.New("no JSON in reply")
+	}
+	if err := json.Unmarshal([]byte(out[i:j+1]), v); err != nil {
+		return "", err
+	}
+	return out[i : j+1], nil
+}
+
+func (t *taskRun) ask(system, user string, v any) error {
+	_, err := t.chat(system, []Message{{Role: "user", Content: user}}, v)
+	return err
+}
+
+func (t *taskRun) toolList() string {
+	names := make([]string, 0, len(t.tools))
+	for n := range t.tools {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, n := range names {
+		params := string(t.tools[n].Parameters)
+		if params == "" {
+			params = "{}"
+		}
+		fmt.Fprintf(&b, "- %s: %s Arguments: %s\n", n, t.tools[n].Description, params)
+	}
+	return b.String()
+}
+
+// listing names the files under root the model may look at, for planning.
+func (t *taskRun) listing() string {
+	ig := newIgnorer(getConfig())
+	var files []string
+	filepath.WalkDir(t.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == t.root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || ig.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && len(files) < 200 {
+			rel, _ := filepath.Rel(t.root, p)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return strings.Join(files, "\n")
+}
+
+// path resolves a tool's path argument, keeping it inside root and away
+// from ignored files.
+func (t *taskRun) path(p string) (string, error) {
+	abs := filepath.Clean(filepath.Join(t.root, p))
+	if filepath.IsAbs(p) {
+		abs = filepath.Clean(p)
+	}
+	if abs != t.root && !isUnder(abs, t.root) {
+		return "", fmt.Errorf("%s is outside %s", p, t.root)
+	}
+	if newIgnorer(getConfig()).excluded(abs) {
+		return "", fmt.Errorf("%s is excluded by ignore rules", p)
+	}
+	return abs, nil
+}
+
+func (t *taskRun) builtinTools() map[string]*Tool {
+	type pathArgs struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	tools := []*Tool{{
+		Name:        "read_file",
+		Description: "Read a file in the working directory.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string"}},"required":["path"]}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a pathArgs
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			p, err := t.path(a.Path)
+			if err != nil {
+				return "", err
+			}
+			data, err := os.ReadFile(p)
+			return string(data), err
+		},
+	}, {
+		Name:        "write_file",
+		Description: "Create or replace a file with the given complete content.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string"},"content":{"type":"string"}},"required":["path","content"]}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a pathArgs
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			p, err := t.path(a.Path)
+			if err != nil {
+				return "", err
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(p, []byte(a.Content), 0o644); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("wrote %d bytes to %s", len(a.Content), a.Path), nil
+		},
+	}, {
+		Name:        "list_files",
+		Description: "List the entries of a directory in the working directory.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string"}}}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a pathArgs
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			p, err := t.path(a.Path)
+			if err != nil {
+				return "", err
+			}
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return "", err
+			}
+			var b strings.Builder
+			for _, e := range entries {
+				name := e.Name()
+				if e.IsDir() {
+					name += "/"
+				}
+				b.WriteString(name + "\n")
+			}
+			return b.String(), nil
+		},
+	}, {
+		Name:        "run_command",
+		Description: "Run a shell command in the working directory and return its combined output and exit status.",
+		Parameters:  json.RawMessage(`{"type":"object","properties":{"command":{"type":"string"}},"required":["command"]}`),
+		Run: func(raw json.RawMessage) (string, error) {
+			var a struct {
+				Command string `json:"command"`
+			}
+			if err := json.Unmarshal(raw, &a); err != nil {
+				return "", err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), taskCommandTimeout)
+			defer cancel()
+			cmd := exec.CommandContext(ctx, "sh", "-c", a.Command)
+			cmd.Dir = t.root
+			var out bytes.Buffer
+			cmd.Stdout, cmd.Stderr = &out, &out
+			err := cmd.Run()
+			if err != nil {
+				// A failing command is a result for the model, not a tool error.
+				return fmt.Sprintf("%s\n[exit: %v]", out.String(), err), nil
+			}
+			return out.String() + "\n[exit: 0]", nil
+		},
+	}}
+
+	m := map[string]*Tool{}
+	for _, tool := range tools {
+		m[tool.Name] = tool
+	}
+	return m
+}
+
+func numbered(steps []string) string {
+	var b strings.Builder
+	for i, s := range steps {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, s)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// usePager is set by -pager: answers are collected and shown in $PAGER
+// instead of being streamed.
+var usePager bool
+
+// lastAnswer is the previous answer shown in this process, for /last.
+var lastAnswer string
+
+// termWidth returns the width of the terminal on stdout, or 0 when stdout
+// is not a terminal.
+func termWidth() int {
+	fd := int(os.Stdout.Fd())
+	if !term.IsTerminal(fd) {
+		return 0
+	}
+	w, _, err := term.GetSize(fd)
+	if err != nil {
+		return 0
+	}
+	return w
+}
+
+// termWrapper wraps streamed text to the terminal width. It is nil when
+// stdout is not a terminal or with -q, so piped output is left as the
+// model wrote it.
+func termWrapper() *postProcessor {
+	w := termWidth()
+	if w <= 0 || quietMode {
+		return nil
+	}
+	pp, _ := newPostProcessor(Profile{MaxLineWidth: w - 1})
+	return pp
+}
+
+// page shows text in $PAGER (less -R by default). When stdout is not a
+// terminal or the pager can't start, the text is printed instead.
+func page(text string) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(text)
+		return
+	}
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	if pp := termWrapper(); pp != nil {
+		text = pp.finish(text)
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Print(text)
+	}
+}
@@ -0,0 +1,79 @@
+# Writes a tiny random BERT in sentence-transformers layout into the current
+# directory and prints its embedding of the text in localembed_test.go.
+# Run with no argument for mean pooling (tinybert-mean), "cls" for tinybert-cls.
+import json, random, struct, math, sys
+random.seed(7)
+H, L, NH, I, P = 8, 2, 2, 16, 16
+vocab = ["[PAD]","[UNK]","[CLS]","[SEP]","hello","world","##s","un","##able","!","cafe","the","x"]
+open('vocab.txt','w').write("\n".join(vocab)+"\n")
+json.dump({"model_type":"bert","hidden_size":H,"num_hidden_layers":L,"num_attention_heads":NH,"intermediate_size":I,"max_position_embeddings":P,"layer_norm_eps":1e-12,"hidden_act":"gelu","vocab_size":len(vocab),"type_vocab_size":2}, open('config.json','w'))
+cls = len(sys.argv) > 1
+json.dump({"pooling_mode_cls_token": cls, "pooling_mode_mean_tokens": not cls}, open('1_Pooling/config.json','w'))
+T = {}
+def r(*shape):
+    n = 1
+    for s in shape: n *= s
+    return (list(shape), [random.uniform(-0.5,0.5) for _ in range(n)])
+T["bert.embeddings.word_embeddings.weight"] = r(len(vocab), H)
+T["bert.embeddings.position_embeddings.weight"] = r(P, H)
+T["bert.embeddings.token_type_embeddings.weight"] = (["F16"], [2, H], [0.5,-0.25,0.125,1.0,-1.0,0.0,0.75,2.0] + [0.0]*H)
+T["bert.embeddings.LayerNorm.weight"] = r(H); T["bert.embeddings.LayerNorm.bias"] = r(H)
+for l in range(L):
+    p = "bert.encoder.layer.%d." % l
+    for n in ["attention.self.query","attention.self.key","attention.self.value","attention.output.dense"]:
+        T[p+n+".weight"] = r(H,H); T[p+n+".bias"] = r(H)
+    T[p+"attention.output.LayerNorm.weight"] = r(H); T[p+"attention.output.LayerNorm.bias"] = r(H)
+    T[p+"intermediate.dense.weight"] = r(I,H); T[p+"intermediate.dense.bias"] = r(I)
+    T[p+"output.dense.weight"] = r(H,I); T[p+"output.dense.bias"] = r(H)
+    T[p+"output.LayerNorm.weight"] = r(H); T[p+"output.LayerNorm.bias"] = r(H)
+T["bert.embeddings.position_ids"] = (["I64"], [1,P], list(range(P)))
+header, body = {}, b""
+for name, t in T.items():
+    if t[0] == ["F16"]:
+        dt, shape, vals = "F16", t[1], t[2]; data = b"".join(struct.pack('<e', v) for v in vals)
+    elif t[0] == ["I64"]:
+        dt, shape, vals = "I64", t[1], t[2]; data = b"".join(struct.pack('<q', v) for v in vals)
+    else:
+        dt, shape, vals = "F32", t[0], t[1]; data = b"".join(struct.pack('<f', v) for v in vals)
+    header[name] = {"dtype": dt, "shape": shape, "data_offsets": [len(body), len(body)+len(data)]}
+    body += data
+header["__metadata__"] = {"format":"pt"}
+hb = json.dumps(header).encode()
+open('model.safetensors','wb').write(struct.pack('<Q', len(hb)) + hb + body)
+
+# reference forward pass, float32 rounding ignored
+def f32(v): return struct.unpack('<f', struct.pack('<f', v))[0]
+W = {k: (v[1] if v[0] not in (["F16"],["I64"]) else v[2]) for k,v in T.items()}
+W = {k: [f32(x) for x in v] for k,v in W.items()}
+def row(name, i, w): return W[name][i*w:(i+1)*w]
+def ln(x, pre):
+    g, b = W[pre+".weight"], W[pre+".bias"]
+    m = sum(x)/len(x); var = sum((a-m)**2 for a in x)/len(x)
+    return [(a-m)/math.sqrt(var+1e-12)*g[j]+b[j] for j,a in enumerate(x)]
+def lin(x, pre, i, o):
+    w, b = W[pre+".weight"], W[pre+".bias"]
+    return [sum(x[k]*w[r*i+k] for k in range(i))+b[r] for r in range(o)]
+def gelu(x): return 0.5*x*(1+math.erf(x/math.sqrt(2)))
+def forward(ids):
+    n = len(ids)
+    xs = [ln([a+b+c for a,b,c in zip(row("bert.embeddings.word_embeddings.weight", t, H), row("bert.embeddings.position_embeddings.weight", i, H), W["bert.embeddings.token_type_embeddings.weight"][:H])], "bert.embeddings.LayerNorm") for i,t in enumerate(ids)]
+    d = H//NH
+    for l in range(L):
+        p = "bert.encoder.layer.%d." % l
+        q = [lin(x, p+"attention.self.query", H, H) for x in xs]
+        k = [lin(x, p+"attention.self.key", H, H) for x in xs]
+        v = [lin(x, p+"attention.self.value", H, H) for x in xs]
+        ctx = [[0.0]*H for _ in range(n)]
+        for h in range(NH):
+            o = h*d
+            for i in range(n):
+                s = [sum(q[i][o+t]*k[j][o+t] for t in range(d))/math.sqrt(d) for j in range(n)]
+                mx = max(s); e = [math.exp(a-mx) for a in s]; z = sum(e)
+                for j in range(n):
+                    for t in range(d): ctx[i][o+t] += e[j]/z*v[j][o+t]
+        a = [ln([u+w for u,w in zip(lin(c, p+"attention.output.dense", H, H), x)], p+"attention.output.LayerNorm") for c,x in zip(ctx, xs)]
+        xs = [ln([u+w for u,w in zip(lin([gelu(y) for y in lin(x, p+"intermediate.dense", H, I)], p+"output.dense", I, H), x)], p+"output.LayerNorm") for x in a]
+    out = xs[0] if cls else [sum(x[j] for x in xs)/n for j in range(H)]
+    nrm = math.sqrt(sum(a*a for a in out)); return [a/nrm for a in out]
+ids = [2,4,5,6,9,7,8,1,10,3]  # Hello worlds! unable zzz Café
+print(" ".join("%.5f" % a for a in forward(ids)))
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// -timeout bounds each request to the model, streaming included (0 for no
+// limit), and -first-token-timeout gives up on a streamed answer that
+// hasn't started within the limit, so a stuck provider fails fast instead
+// of hanging a script or the terminal. Either way the command fails with
+// an error rather than keeping a partial answer. Provider plugins answer
+// in one piece, so for them the first token is the whole answer.
+const defaultRequestTimeout = 30 * time.Second
+
+var (
+	requestTimeout    = defaultRequestTimeout
+	firstTokenTimeout time.Duration
+)
+
+// watchFirstToken returns the context for a request, cancelled if it is
+// streamed and nothing arrives within -first-token-timeout; got is called
+// when something does, and done when the request is over.
+func watchFirstToken(stream bool) (ctx context.Context, got, done func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if !stream || firstTokenTimeout <= 0 {
+		return ctx, func() {}, func() { cancel(nil) }
+	}
+	t := time.AfterFunc(firstTokenTimeout, func() {
+		cancel(fmt.Errorf("no answer within %s (-first-token-timeout)", firstTokenTimeout))
+	})
+	return ctx, func() { t.Stop() }, func() { t.Stop(); cancel(nil) }
+}
+
+// requestErr explains an error from a request made with ctx if a timeout
+// caused it.
+func requestErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			return cause
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) {
+		return fmt.Errorf("no complete answer within %s (-timeout): %w", requestTimeout, err)
+	}
+	return err
+}
+
+// pluginTimeout is how long a provider plugin may take to answer.
+func pluginTimeout(stream bool) time.Duration {
+	d := requestTimeout
+	if stream && firstTokenTimeout > 0 && (d <= 0 || firstTokenTimeout < d) {
+		d = firstTokenTimeout
+	}
+	return d
+}
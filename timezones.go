@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // so zones resolve the same on every system
+)
+
+// The time_zones tool converts times between zones and finds meeting
+// slots inside everyone's working hours, so "a slot that works for Berlin
+// and PST" is computed from the tz database rather than guessed. Zones
+// may be IANA names, city names ("Berlin", "New York"), common
+// abbreviations ("PST", "CET"), contacts with a timezone, or "local" for
+// the user's own zone.
+const (
+	maxSlotDays  = 14
+	maxSlotsShow = 10
+)
+
+// zoneAbbrevs maps abbreviations to the zones people mean by them: "PST"
+// in July means Pacific time, which is then PDT.
+var zoneAbbrevs = map[string]string{
+	"UTC": "UTC", "GMT": "UTC", "Z": "UTC",
+	"PST": "America/Los_Angeles", "PDT": "America/Los_Angeles", "PT": "America/Los_Angeles",
+	"MST": "America/Denver", "MDT": "America/Denver", "MT": "America/Denver",
+	"CST": "America/Chicago", "CDT": "America/Chicago", "CT": "America/Chicago",
+	"EST": "America/New_York", "EDT": "America/New_York", "ET": "America/New_York",
+	"BST": "Europe/London", "WET": "Europe/Lisbon", "CET": "Europe/Paris", "CEST": "Europe/Paris",
+	"EET": "Europe/Athens", "MSK": "Europe/Moscow", "IST": "Asia/Kolkata", "SGT": "Asia/Singapore",
+	"HKT": "Asia/Hong_Kong", "JST": "Asia/Tokyo", "KST": "Asia/Seoul",
+	"AEST": "Australia/Sydney", "AEDT": "Australia/Sydney", "AET": "Australia/Sydney", "NZST": "Pacific/Auckland",
+}
+
+var zoneRegions = []string{"Europe", "America", "Asia", "Africa", "Australia", "Pacific", "Atlantic", "Indian",
+	"America/Argentina", "America/Indiana", "America/Kentucky"}
+
+var timeZonesOnce sync.Once
+
+func registerTimeZones() {
+	timeZonesOnce.Do(func() {
+		registerTool(&Tool{
+			Name: "time_zones",
+			Description: "Convert a time between time zones, or find meeting slots inside everyone's working hours, using the tz " +
+				"database. Use it instead of working out offsets yourself. Zones can be IANA names, cities, abbreviations " +
+				"like PST or CET, the user's contacts, or \"local\" for the user's zone.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"action":{"type":"string","enum":["convert","slots"]},` +
+				`"time":{"type":"string","description":"convert: the time, e.g. \"2026-03-14 15:00\", \"15:00\", \"3pm\" or \"now\""},` +
+				`"from":{"type":"string","description":"convert: zone the time is in (default local)"},` +
+				`"zones":{"type":"array","items":{"type":"string"},"description":"convert: zones to show it in; slots: everyone's zones"},` +
+				`"date":{"type":"string","description":"slots: first day to search, YYYY-MM-DD (default today)"},` +
+				`"days":{"type":"integer","description":"slots: days to search (default 5, at most 14)"},` +
+				`"start_hour":{"type":"number","description":"slots: working day start, local hour (default 9)"},` +
+				`"end_hour":{"type":"number","description":"slots: working day end, local hour (default 17)"},` +
+				`"minutes":{"type":"integer","description":"slots: meeting length (default 30)"},` +
+				`"weekends":{"type":"boolean","description":"slots: include Saturdays and Sundays"}},` +
+				`"required":["action","zones"]}`),
+			Run: func(args json.RawMessage) (string, error) {
+				var a zoneArgs
+				if err := json.Unmarshal(args, &a); err != nil {
+					return "", err
+				}
+				switch a.Action {
+				case "convert":
+					return convertTime(a)
+				case "slots":
+					return meetingSlots(a)
+				}
+				return "", fmt.Errorf("unknown action %q; use convert or slots", a.Action)
+			},
+		})
+	})
+}
+
+type zoneArgs struct {
+	Action    string   `json:"action"`
+	Time      string   `json:"time"`
+	From      string   `json:"from"`
+	Zones     []string `json:"zones"`
+	Date      string   `json:"date"`
+	Days      int      `json:"days"`
+	StartHour *float64 `json:"start_hour"`
+	EndHour   *float64 `json:"end_hour"`
+	Minutes   int      `json:"minutes"`
+	Weekends  bool     `json:"weekends"`
+}
+
+// findZone resolves a zone name, abbreviation or city.
+func findZone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "", "local", "me", "my time", "here":
+		return userLocation(getConfig()), nil
+	}
+	if z, ok := zoneAbbrevs[strings.ToUpper(name)]; ok {
+		name = z
+	} else if z, ok := contactZone(name); ok {
+		name = z
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc, nil
+	}
+	// Cities are the last part of zone names: "new york" is America/New_York.
+	city := strings.ReplaceAll(titleWords(name), " ", "_")
+	for _, region := range zoneRegions {
+		if loc, err := time.LoadLocation(region + "/" + city); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown time zone %q; try an IANA name such as Europe/Berlin", name)
+}
+
+func titleWords(s string) string {
+	words := strings.Fields(strings.ToLower(s))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+var clockLayouts = []string{"15:04", "3:04pm", "3pm", "3:04 pm", "3 pm"}
+
+// parseZoneTime reads a time in loc: a full date and time, or a time of
+// day today.
+func parseZoneTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	now := time.Now().In(loc)
+	if s == "" || strings.EqualFold(s, "now") {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 3pm", "2006-01-02 3:04pm"} {
+		if t, err := time.ParseInLocation(layout, strings.ToLower(s), loc); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range clockLayouts {
+		if t, err := time.Parse(layout, strings.ToLower(s)); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't read the time %q; use \"2006-01-02 15:04\", \"15:04\" or \"3pm\"", s)
+}
+
+func zoneLine(t time.Time) string {
+	return fmt.Sprintf("%s (%s, UTC%s)", t.Format("Mon 2006-01-02 15:04 MST"), t.Location(), t.Format("-07:00"))
+}
+
+func convertTime(a zoneArgs) (string, error) {
+	from, err := findZone(a.From)
+	if err != nil {
+		return "", err
+	}
+	t, err := parseZoneTime(a.Time, from)
+	if err != nil {
+		return "", err
+	}
+	lines := []string{zoneLine(t.In(from))}
+	for _, z := range a.Zones {
+		loc, err := findZone(z)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, "= "+zoneLine(t.In(loc)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+type interval struct{ start, end time.Time }
+
+// meetingSlots lists the times inside everyone's working hours, in UTC
+// and in each zone.
+func meetingSlots(a zoneArgs) (string, error) {
+	if len(a.Zones) == 0 {
+		return "", errors.New("no zones given")
+	}
+	var locs []*time.Location
+	for _, z := range a.Zones {
+		loc, err := findZone(z)
+		if err != nil {
+			return "", err
+		}
+		locs = append(locs, loc)
+	}
+	startHour, endHour := 9.0, 17.0
+	if a.StartHour != nil {
+		startHour = *a.StartHour
+	}
+	if a.EndHour != nil {
+		endHour = *a.EndHour
+	}
+	if startHour < 0 || endHour > 24 || startHour >= endHour {
+		return "", errors.New("working hours must run forwards within a day")
+	}
+	days := a.Days
+	if days <= 0 {
+		days = 5
+	}
+	days = min(days, maxSlotDays)
+	length := 30 * time.Minute
+	if a.Minutes > 0 {
+		length = time.Duration(a.Minutes) * time.Minute
+	}
+
+	first := time.Now().In(locs[0])
+	if a.Date != "" {
+		d, err := time.ParseInLocation(time.DateOnly, a.Date, locs[0])
+		if err != nil {
+			return "", fmt.Errorf("bad date %q", a.Date)
+		}
+		first = d
+	}
+	from := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, locs[0])
+	to := from.AddDate(0, 0, days)
+	if a.Date == "" && first.After(from) {
+		from = first // nothing earlier than now
+	}
+
+	// Intersect everyone's working hours over the search window.
+	free := []interval{{from, to}}
+	for _, loc := range locs {
+		var work []interval
+		day := time.Date(from.Year(), from.Month(), from.Day()-1, 0, 0, 0, 0, loc)
+		for ; day.Before(to.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+			if wd := day.Weekday(); !a.Weekends && (wd == time.Saturday || wd == time.Sunday) {
+				continue
+			}
+			work = append(work, interval{atHour(day, startHour), atHour(day, endHour)})
+		}
+		free = intersect(free, work)
+	}
+
+	var b strings.Builder
+	shown := 0
+	for _, iv := range free {
+		if iv.end.Sub(iv.start) < length {
+			continue
+		}
+		if shown == maxSlotsShow {
+			b.WriteString("(more slots not shown)\n")
+			break
+		}
+		shown++
+		fmt.Fprintf(&b, "%s – %s UTC (%s)\n", iv.start.UTC().Format("Mon 2006-01-02 15:04"), iv.end.UTC().Format("15:04"), shortDuration(iv.end.Sub(iv.start)))
+		for _, loc := range locs {
+			s, e := iv.start.In(loc), iv.end.In(loc)
+			fmt.Fprintf(&b, "  %s: %s – %s\n", loc, s.Format("Mon 15:04"), e.Format("Mon 15:04 MST"))
+		}
+	}
+	if shown == 0 {
+		return fmt.Sprintf("No %s slot within %g:00–%g:00 local for everyone in the %d days from %s.",
+			shortDuration(length), startHour, endHour, days, from.Format(time.DateOnly)), nil
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// shortDuration writes 90 minutes as 1h30m rather than 1h30m0s.
+func shortDuration(d time.Duration) string {
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+// atHour is the wall-clock hour h (which may be fractional) on day's date.
+func atHour(day time.Time, h float64) time.Time {
+	mins := int(h * 60)
+	return time.Date(day.Year(), day.Month(), day.Day(), mins/60, mins%60, 0, 0, day.Location())
+}
+
+// intersect returns the overlaps of two lists of intervals.
+func intersect(a, b []interval) []interval {
+	sortIntervals := func(ivs []interval) {
+		sort.Slice(ivs, func(i, j int) bool { return ivs[i].start.Before(ivs[j].start) })
+	}
+	sortIntervals(a)
+	sortIntervals(b)
+	var out []interval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		s, e := later(a[i].start, b[j].start), earlier(a[i].end, b[j].end)
+		if s.Before(e) {
+			out = append(out, interval{s, e})
+		}
+		if a[i].end.Before(b[j].end) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+)
+
+var (
+	encoder     *tiktoken.Tiktoken
+	encoderOnce sync.Once
+)
+
+// tokenizer loads the BPE encoder on first use, so commands that never count
+// tokens (-a, -t, -h …) skip the load and the first-run download entirely.
+// Downloaded BPE files are cached under the user cache dir instead of /tmp.
+//
+// If GOCHAT_TIKTOKEN_DIR is set, BPE files are read from there and nothing is
+// fetched. When no encoder can be loaded (airgapped, no files) it returns nil
+// and tokens() falls back to an estimate.
+func tokenizer() *tiktoken.Tiktoken {
+	encoderOnce.Do(func() {
+		if dir := os.Getenv("GOCHAT_TIKTOKEN_DIR"); dir != "" {
+			tiktoken.SetBpeLoader(dirBpeLoader{dir: dir})
+		} else if os.Getenv("TIKTOKEN_CACHE_DIR") == "" {
+			if dir, err := os.UserCacheDir(); err == nil {
+				os.Setenv("TIKTOKEN_CACHE_DIR", filepath.Join(dir, "go-chat", "tiktoken"))
+			}
+		}
+		var err error
+		encoder, err = tiktoken.EncodingForModel(modelExec)
+		if err != nil {
+			log.Printf("tokeniser unavailable, using estimates: %v", err)
+			encoder = nil
+		}
+	})
+	return encoder
+}
+
+// tokensFor counts s the way provider p, the one a request goes to, will
+// see it: with its own estimate if it has one (see tokenEstimator), else
+// gpt-4o's encoding. p may be nil.
+func tokensFor(p Provider, s string) int {
+	if e, ok := p.(tokenEstimator); ok {
+		return e.EstimateTokens(s)
+	}
+	if enc := tokenizer(); enc != nil {
+		return len(enc.EncodeOrdinary(s))
+	}
+	return approxTokens(s)
+}
+
+// tokens counts s for the configured provider. It reads the config, so
+// loops over many pieces use tokensFor with configuredProvider.
+func tokens(s string) int { return tokensFor(configuredProvider(), s) }
+
+func tokensMsg(m Message) int { return tokensMsgFor(configuredProvider(), m) }
+
+func tokensMsgFor(p Provider, m Message) int {
+	return 4 + tokensFor(p, m.Role) + tokensFor(p, m.Content)
+}
+
+// claudeTokens estimates Claude's count. Its tokenizer isn't published
+// and splits text finer than gpt-4o's encoding, so this goes by 3.5 bytes
+// a token; requests to Anthropic are counted by the API (see promptTokens).
+func claudeTokens(s string) int { return (2*len(s) + 6) / 7 }
+
+// approxTokens is the usual ~4 bytes per token rule of thumb, rounded up so
+// history trimming errs on the side of sending less.
+func approxTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+// dirBpeLoader serves BPE files from a local directory, matching on the file
+// name of the URL tiktoken would otherwise download (e.g. o200k_base.tiktoken).
+type dirBpeLoader struct {
+	dir string
+}
+
+func (l dirBpeLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	f, err := os.Open(filepath.Join(l.dir, path.Base(url)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		tok, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		ranks[string(tok)] = rank
+	}
+	return ranks, sc.Err()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// With "adaptive_tone": true each prompt typed at the terminal gets a quick
+// local check for frustration (no API call): phrases like "still not
+// working" or "that's wrong", shouting and "!!" each count. Once two of the
+// last five prompts look frustrated, the persona is told to be concise and
+// skip the jokes for the rest of the session, and turns answered that way
+// are logged with "tone": "concise".
+const (
+	toneConcise     = "concise"
+	toneWindow      = 5
+	toneTrigger     = 2 // frustrated prompts in the window
+	frustratedScore = 2
+)
+
+var frustrationRes = func() []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, p := range []string{
+		`wtf`, `ffs`, `ugh+`, `argh+`, `come on`, `seriously`, `useless`, `ridiculous`, `stupid`, `annoying`,
+		`frustrat\w*`, `wrong`, `not what i asked`, `i (?:just |already )?said`, `just answer`,
+		`still (?:not|doesn't|does not|broken)`, `(?:doesn't|does not|didn't) work`, `not working`,
+		`again`, `for the last time`, `stop`, `are you even`, `listen`,
+	} {
+		res = append(res, regexp.MustCompile(`\b`+p+`\b`))
+	}
+	return res
+}()
+
+var sessionTone struct {
+	recent []bool
+	tone   string
+}
+
+// frustration scores a prompt; frustratedScore or more counts as
+// frustrated.
+func frustration(prompt string) int {
+	p := strings.ToLower(prompt)
+	score := 0
+	for _, re := range frustrationRes {
+		if re.MatchString(p) {
+			score++
+		}
+	}
+	if strings.Contains(p, "!!") || strings.Contains(p, "?!") || strings.Contains(p, "!?") {
+		score++
+	}
+	letters, upper := 0, 0
+	for _, r := range prompt {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 8 && upper*10 >= letters*6 {
+		score++
+	}
+	return score
+}
+
+// observeTone records a prompt and returns the tone adjustment for its
+// answer, if any.
+func observeTone(prompt string) string {
+	if !getConfig().AdaptiveTone {
+		return ""
+	}
+	if sessionTone.tone != "" {
+		return sessionTone.tone
+	}
+	prompt, _ = cutPrivate(prompt)
+	sessionTone.recent = append(sessionTone.recent, frustration(prompt) >= frustratedScore)
+	if len(sessionTone.recent) > toneWindow {
+		sessionTone.recent = sessionTone.recent[1:]
+	}
+	n := 0
+	for _, f := range sessionTone.recent {
+		if f {
+			n++
+		}
+	}
+	if n >= toneTrigger {
+		sessionTone.tone = toneConcise
+		fmt.Fprintln(os.Stderr, "(keeping answers short and to the point for the rest of this session)")
+	}
+	return sessionTone.tone
+}
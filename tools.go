@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// maxToolRounds bounds how many times one request may bounce between the
+// model and tools before the model is made to answer without them.
+const maxToolRounds = 8
+
+// verboseTools (-v) prints each tool call and its result on stderr.
+var verboseTools bool
+
+type ToolFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// Tool is a function the model may call while answering. Parameters is a
+// JSON schema object describing the arguments Run receives.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	Run         func(args json.RawMessage) (string, error)
+}
+
+// toolCallText is the text of a reply's tool calls, for counting tokens.
+func (m Message) toolCallText() string {
+	var b strings.Builder
+	for _, tc := range m.ToolCalls {
+		b.WriteString(tc.Function.Name + tc.Function.Arguments)
+	}
+	return b.String()
+}
+
+var toolRegistry = map[string]*Tool{}
+
+func registerTool(t *Tool) {
+	toolRegistry[t.Name] = t
+}
+
+// toolDefinitions returns the registered tools in the chat completions
+// "tools" format, sorted by name so requests are stable.
+func toolDefinitions() []map[string]any {
+	loadPlugins()
+	registerCalculator()
+	registerWeather()
+	registerTimeZones()
+
+	names := make([]string, 0, len(toolRegistry))
+	for n := range toolRegistry {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	defs := make([]map[string]any, 0, len(names))
+	for _, n := range names {
+		t := toolRegistry[n]
+		params := t.Parameters
+		if len(params) == 0 {
+			params = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		defs = append(defs, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  params,
+			},
+		})
+	}
+	return defs
+}
+
+// runToolCall executes one tool call and wraps the result as a tool message.
+// Failures are reported back to the model rather than aborting the chat.
+func runToolCall(tc ToolCall) Message {
+	out := Message{Role: "tool", ToolCallID: tc.ID}
+
+	t, ok := toolRegistry[tc.Function.Name]
+	if !ok {
+		out.Content = fmt.Sprintf("error: unknown tool %q", tc.Function.Name)
+		return out
+	}
+	args := json.RawMessage(tc.Function.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	res, err := t.Run(args)
+	if verboseTools {
+		traceTool(t.Name, args, res, err)
+	}
+	if err != nil {
+		log.Printf("tool %s: %v", t.Name, err)
+		out.Content = "error: " + err.Error()
+		return out
+	}
+	out.Content = res
+	return out
+}
+
+func traceTool(name string, args json.RawMessage, res string, err error) {
+	out := "→ " + truncateRunes(oneLine(res), 500)
+	if err != nil {
+		out = "error: " + err.Error()
+	}
+	line := fmt.Sprintf("[%s %s] %s", name, args, out)
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		line = "\033[2m" + line + "\033[0m"
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
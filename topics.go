@@ -0,0 +1,93 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Topic detection: before a CLI turn, the prompt is compared with the last
+// few exchanges in the active namespace. If it's about something else, the
+// user is pointed at the namespace whose memories fit it best, or a new one
+// named after the prompt, so one day's log doesn't turn into a mash of
+// unrelated threads. With -auto-session go-chat switches by itself.
+const (
+	topicShiftScore = 0.25 // below this the prompt counts as a new topic
+	topicRecent     = 3    // exchanges compared against
+)
+
+var autoSession bool
+
+// checkTopic returns the namespace the prompt should go to: the active one,
+// or with -auto-session a better fit.
+func checkTopic(prompt string) string {
+	ns := cmp.Or(activeNamespace, defaultNamespace)
+	hist := getChatHistory(memScopePrivate, ns)
+	if len(hist) == 0 {
+		return activeNamespace
+	}
+	var recent []string
+	for i := max(0, len(hist)-2*topicRecent); i < len(hist); i += 2 {
+		recent = append(recent, hist[i].Content)
+	}
+
+	vecs, err := embedTexts(embeddingModel(), []string{prompt, strings.Join(recent, "\n")})
+	if err != nil || cosineSim(vecs[0], vecs[1]) >= topicShiftScore {
+		return activeNamespace
+	}
+
+	next := bestNamespaceFor(vecs[0], ns)
+	if next == "" {
+		next = topicName(prompt)
+	}
+	if autoSession {
+		fmt.Fprintf(os.Stderr, "new topic: switching to namespace %q\n", next)
+		return next
+	}
+	fmt.Fprintf(os.Stderr, "this looks like a new topic for %q; use -ns %s (or /ns %s) to keep it separate\n", ns, next, next)
+	return activeNamespace
+}
+
+// bestNamespaceFor returns the other namespace whose best memory matches vec
+// well enough, if any.
+func bestNamespaceFor(vec []float32, current string) string {
+	best, bestScore := "", topicShiftScore
+	for _, m := range loadVectorStore() {
+		if !canRead(memScopePrivate, m.Scope) || inNamespace(m.Namespace, current) {
+			continue
+		}
+		if s := cosineSim(m.Embedding, vec); s > bestScore {
+			best, bestScore = cmp.Or(m.Namespace, defaultNamespace), s
+		}
+	}
+	return best
+}
+
+var topicStopwords = map[string]bool{
+	"about": true, "could": true, "does": true, "from": true, "have": true,
+	"how": true, "please": true, "should": true, "that": true, "the": true,
+	"there": true, "this": true, "what": true, "when": true, "where": true,
+	"which": true, "while": true, "with": true, "would": true, "your": true,
+}
+
+// topicName makes a namespace name from the first two content words of the
+// prompt.
+func topicName(prompt string) string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(prompt), func(r rune) bool {
+		return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) > 3 && !topicStopwords[w] {
+			words = append(words, w)
+		}
+		if len(words) == 2 {
+			break
+		}
+	}
+	if len(words) == 0 {
+		return "topic"
+	}
+	return strings.Join(words, "-")
+}
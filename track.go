@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Check-ins can track habits and mood. With questions under "tracking" in
+// the config, the first check-in of each day asks them before chatting:
+//
+//	"tracking": {"questions": [
+//	  {"key": "mood", "question": "How's your mood, 1-5?", "min": 1, "max": 5},
+//	  {"key": "sleep", "question": "Hours slept?"},
+//	  {"key": "note", "question": "Anything on your mind?", "type": "text"}]}
+//
+// Answers go to ~/.go-chat-tracking.jsonl, one entry per check-in.
+// `go-chat track` answers them now and `go-chat track report` shows
+// weekly averages with a short summary of the trends.
+const trackingFilePath = ".go-chat-tracking.jsonl"
+
+type TrackingConfig struct {
+	Questions []TrackQuestion `json:"questions,omitempty"`
+}
+
+type TrackQuestion struct {
+	Key      string   `json:"key"`
+	Question string   `json:"question"`
+	Type     string   `json:"type,omitempty"` // "number" (default) or "text"
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+type TrackEntry struct {
+	Time   time.Time          `json:"time"`
+	Day    string             `json:"day"`
+	Values map[string]float64 `json:"values,omitempty"`
+	Notes  map[string]string  `json:"notes,omitempty"`
+}
+
+func trackingFile() string { return filepath.Join(homeDir, trackingFilePath) }
+
+func loadTracking() []TrackEntry {
+	f, err := os.Open(trackingFile())
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []TrackEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e TrackEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func appendTracking(e TrackEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	defer lockFile(trackingFile())()
+	f, err := os.OpenFile(trackingFile(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (q TrackQuestion) hint() string {
+	switch {
+	case q.Type == "text":
+		return ""
+	case q.Min != nil && q.Max != nil:
+		return fmt.Sprintf(" [%g-%g]", *q.Min, *q.Max)
+	case q.Min != nil:
+		return fmt.Sprintf(" [≥%g]", *q.Min)
+	case q.Max != nil:
+		return fmt.Sprintf(" [≤%g]", *q.Max)
+	}
+	return ""
+}
+
+// askTracking asks the configured questions; an empty answer skips one.
+// Nothing is saved if every question was skipped.
+func askTracking(qs []TrackQuestion) (TrackEntry, bool) {
+	now := time.Now()
+	e := TrackEntry{Time: now.UTC(), Day: logDay(now), Values: map[string]float64{}, Notes: map[string]string{}}
+	for _, q := range qs {
+		for {
+			fmt.Printf("%s%s ", q.Question, q.hint())
+			ans, err := stdin.ReadString('\n')
+			ans = strings.TrimSpace(ans)
+			if ans == "" {
+				if err != nil {
+					fmt.Println()
+				}
+				break
+			}
+			if q.Type == "text" {
+				e.Notes[q.Key] = ans
+				break
+			}
+			v, perr := strconv.ParseFloat(ans, 64)
+			if perr == nil && (q.Min == nil || v >= *q.Min) && (q.Max == nil || v <= *q.Max) {
+				e.Values[q.Key] = v
+				break
+			}
+			if err != nil {
+				break
+			}
+			fmt.Printf("  (a number%s, or Enter to skip)\n", q.hint())
+		}
+	}
+	return e, len(e.Values)+len(e.Notes) > 0
+}
+
+// trackCheckIn asks the tracking questions at the first check-in of the
+// day, when there are any and someone is at the terminal to answer.
+func trackCheckIn() {
+	qs := getConfig().Tracking.Questions
+	if len(qs) == 0 || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	today := logDay(time.Now())
+	if slices.ContainsFunc(loadTracking(), func(e TrackEntry) bool { return e.Day == today }) {
+		return
+	}
+	if e, ok := askTracking(qs); ok {
+		if err := appendTracking(e); err != nil {
+			log.Printf("tracking: %v", err)
+		}
+	}
+}
+
+// weekStart returns the Monday of a log day's week.
+func weekStart(day string) string {
+	t, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		return day
+	}
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)).Format(time.DateOnly)
+}
+
+// trackReport tabulates the numeric answers by week (mean, min–max, days
+// answered) and lists the text answers of the latest week.
+func trackReport(entries []TrackEntry, keys []string, weeks int) string {
+	byWeek := map[string][]TrackEntry{}
+	var order []string
+	for _, e := range entries {
+		w := weekStart(e.Day)
+		if _, ok := byWeek[w]; !ok {
+			order = append(order, w)
+		}
+		byWeek[w] = append(byWeek[w], e)
+	}
+	slices.Sort(order)
+	if len(order) > weeks {
+		order = order[len(order)-weeks:]
+	}
+
+	var b strings.Builder
+	for _, w := range order {
+		fmt.Fprintf(&b, "Week of %s (%d check-ins)\n", w, len(byWeek[w]))
+		for _, k := range keys {
+			var vals []float64
+			for _, e := range byWeek[w] {
+				if v, ok := e.Values[k]; ok {
+					vals = append(vals, v)
+				}
+			}
+			if len(vals) == 0 {
+				continue
+			}
+			sum := 0.0
+			for _, v := range vals {
+				sum += v
+			}
+			fmt.Fprintf(&b, "  %-12s avg %.1f  (%g-%g, %d answers)\n", k, sum/float64(len(vals)), slices.Min(vals), slices.Max(vals), len(vals))
+		}
+	}
+	if len(order) > 0 {
+		var notes []string
+		for _, e := range byWeek[order[len(order)-1]] {
+			for k, n := range e.Notes {
+				notes = append(notes, fmt.Sprintf("  %s %s: %s", e.Day, k, n))
+			}
+		}
+		if len(notes) > 0 {
+			slices.Sort(notes)
+			b.WriteString("\nNotes this week:\n" + strings.Join(notes, "\n") + "\n")
+		}
+	}
+	return b.String()
+}
+
+func runTrack(args []string) {
+	cfg := getConfig()
+	if len(args) == 0 {
+		if len(cfg.Tracking.Questions) == 0 {
+			log.Fatal(`track: no questions; add "tracking": {"questions": [...]} to the config`)
+		}
+		e, ok := askTracking(cfg.Tracking.Questions)
+		if !ok {
+			return
+		}
+		if err := appendTracking(e); err != nil {
+			log.Fatalf("track: %v", err)
+		}
+		fmt.Println("saved")
+		return
+	}
+	if args[0] != "report" {
+		fmt.Fprintln(os.Stderr, "usage: go-chat track | go-chat track report [-weeks N] [-raw]")
+		os.Exit(2)
+	}
+
+	fset := flag.NewFlagSet("track report", flag.ExitOnError)
+	weeks := fset.Int("weeks", 4, "Weeks to include")
+	raw := fset.Bool("raw", false, "Print the table without a summary from the model")
+	fset.Parse(args[1:])
+
+	entries := loadTracking()
+	if len(entries) == 0 {
+		fmt.Println("nothing tracked yet")
+		return
+	}
+	var keys []string
+	for _, q := range cfg.Tracking.Questions {
+		if q.Type != "text" {
+			keys = append(keys, q.Key)
+		}
+	}
+	var removed []string // answers to questions since taken out of the config
+	for _, e := range entries {
+		for k := range e.Values {
+			if !slices.Contains(keys, k) && !slices.Contains(removed, k) {
+				removed = append(removed, k)
+			}
+		}
+	}
+	slices.Sort(removed)
+	keys = append(keys, removed...)
+	report := trackReport(entries, keys, *weeks)
+	fmt.Print(report)
+	if *raw {
+		return
+	}
+	fmt.Println()
+	if _, err := queryGPTStream(modelLogic, prompt("track-report"), 0.4, 512, []Message{{Role: "user", Content: report}}, printToken); err != nil {
+		log.Fatalf("track report: %v", err)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// A single huge message, such as a pasted log, can push everything else
+// out of the history. Turns longer than "context": {"message_tokens": N}
+// (default 4000; -1 for no cap) are still sent whole when asked, but are
+// condensed before they are logged, and the history carries the condensed
+// version. Turns logged without one, or condensed before the cap was
+// lowered, are cut short in the history instead.
+const defaultMessageTokens = 4000
+
+func messageTokenCap() int {
+	if n := getConfig().Context.MessageTokens; n != 0 {
+		return n
+	}
+	return defaultMessageTokens
+}
+
+// digestTurn condenses text if it is over the cap, or returns "".
+func digestTurn(text string) string {
+	limit := messageTokenCap()
+	if limit < 0 || tokens(text) <= limit {
+		return ""
+	}
+	digest, err := queryGPTStream(modelSummarise, prompt("turn-digest"), 0.3, 512, []Message{{Role: "user", Content: text}}, nil)
+	if err != nil {
+		log.Printf("condense long message: %v", err)
+		return ""
+	}
+	return digest
+}
+
+// cappedTurn is text as it goes into the history.
+func cappedTurn(text, digest string) string {
+	limit := messageTokenCap()
+	if limit < 0 {
+		return text
+	}
+	n := tokens(text)
+	if n <= limit {
+		return text
+	}
+	if digest != "" && tokens(digest) <= limit {
+		return fmt.Sprintf("[long message of %d tokens, condensed]\n%s", n, digest)
+	}
+	return fmt.Sprintf("%s\n[long message of %d tokens, cut short]", fitTokens(text, limit), n)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Files uploaded with -f are fenced with their language so the model reads
+// them as code, not prose. Binary files are refused unless there is a way
+// to get text out of them: gzip is unpacked, .docx reduced to its text and
+// PDFs run through pdftotext when it is installed. What a compressed file
+// unpacks to is capped at maxUnpackedUpload, so a small gzip bomb is
+// refused rather than filling memory.
+const maxUnpackedUpload = 64 << 20
+
+var errUnpackedTooLarge = fmt.Errorf("unpacks to more than %d MiB; not sending it", maxUnpackedUpload>>20)
+
+var extLangs = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".mjs": "javascript",
+	".ts": "typescript", ".tsx": "tsx", ".jsx": "jsx", ".rs": "rust",
+	".java": "java", ".kt": "kotlin", ".c": "c", ".h": "c", ".cc": "cpp",
+	".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp", ".rb": "ruby", ".php": "php",
+	".swift": "swift", ".scala": "scala", ".lua": "lua", ".pl": "perl",
+	".sh": "bash", ".bash": "bash", ".zsh": "zsh", ".fish": "fish", ".ps1": "powershell",
+	".sql": "sql", ".html": "html", ".htm": "html", ".css": "css", ".scss": "scss",
+	".json": "json", ".jsonl": "json", ".yaml": "yaml", ".yml": "yaml",
+	".toml": "toml", ".ini": "ini", ".xml": "xml", ".md": "markdown",
+	".proto": "protobuf", ".tf": "hcl", ".hcl": "hcl", ".csv": "csv",
+	".diff": "diff", ".patch": "diff", ".log": "log", ".vim": "vim",
+}
+
+var fileLangs = map[string]string{
+	"Dockerfile": "dockerfile", "Makefile": "makefile", "GNUmakefile": "makefile",
+	"go.mod": "go", "CMakeLists.txt": "cmake", "Jenkinsfile": "groovy",
+}
+
+var shebangLangs = map[string]string{
+	"sh": "bash", "bash": "bash", "zsh": "zsh", "python": "python", "python3": "python",
+	"node": "javascript", "ruby": "ruby", "perl": "perl", "php": "php",
+}
+
+// fenceLang guesses the fence tag for a file from its name, then its
+// shebang line, then its content; "text" if nothing fits.
+func fenceLang(name string, content []byte) string {
+	if l, ok := fileLangs[filepath.Base(name)]; ok {
+		return l
+	}
+	if l, ok := extLangs[strings.ToLower(filepath.Ext(name))]; ok {
+		return l
+	}
+	if first, _, _ := bytes.Cut(content, []byte("\n")); bytes.HasPrefix(first, []byte("#!")) {
+		f := strings.Fields(string(first[2:]))
+		if len(f) > 0 {
+			prog := filepath.Base(f[0])
+			if prog == "env" && len(f) > 1 {
+				prog = f[1]
+			}
+			if l, ok := shebangLangs[prog]; ok {
+				return l
+			}
+		}
+	}
+	head := bytes.TrimSpace(content[:min(len(content), 512)])
+	switch {
+	case bytes.HasPrefix(head, []byte("<?xml")):
+		return "xml"
+	case bytes.HasPrefix(bytes.ToLower(head), []byte("<!doctype html")), bytes.HasPrefix(bytes.ToLower(head), []byte("<html")):
+		return "html"
+	case (bytes.HasPrefix(head, []byte("{")) || bytes.HasPrefix(head, []byte("["))) && json.Valid(content):
+		return "json"
+	case bytes.HasPrefix(head, []byte("package ")):
+		return "go"
+	}
+	return "text"
+}
+
+// fenced wraps text in a code fence long enough not to clash with any
+// fence inside it.
+func fenced(lang, text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + strings.TrimRight(text, "\n") + "\n" + fence
+}
+
+// uploadText returns the text to send for a file and its fence tag, or an
+// error saying why a binary file can't be sent.
+func uploadText(name string, content []byte) (string, string, error) {
+	if isText(content) {
+		return string(content), fenceLang(name, content), nil
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(name)); {
+	case bytes.HasPrefix(content, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return "", "", err
+		}
+		inner, err := io.ReadAll(io.LimitReader(zr, maxUnpackedUpload+1))
+		if err != nil {
+			return "", "", err
+		}
+		if len(inner) > maxUnpackedUpload {
+			return "", "", errUnpackedTooLarge
+		}
+		return uploadText(strings.TrimSuffix(name, filepath.Ext(name)), inner)
+
+	case ext == ".docx":
+		text, err := docxText(content)
+		return text, "text", err
+
+	case ext == ".pdf" || bytes.HasPrefix(content, []byte("%PDF-")):
+		if _, err := exec.LookPath("pdftotext"); err != nil {
+			return "", "", errors.New("is a PDF; install pdftotext (poppler-utils) to upload PDFs")
+		}
+		cmd := exec.Command("pdftotext", "-layout", "-", "-")
+		cmd.Stdin = bytes.NewReader(content)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", "", fmt.Errorf("pdftotext: %w", err)
+		}
+		return string(out), "text", nil
+	}
+	return "", "", fmt.Errorf("looks like a binary file (%s); not sending it", http.DetectContentType(content))
+}
+
+// docxText pulls the paragraphs out of a Word document.
+func docxText(content []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+	f, err := zr.Open("word/document.xml")
+	if err != nil {
+		return "", errors.New("not a Word document")
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	lr := &io.LimitedReader{R: f, N: maxUnpackedUpload + 1}
+	dec := xml.NewDecoder(lr)
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if lr.N <= 0 {
+			return "", errUnpackedTooLarge
+		}
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inText = t.Name.Local == "t"
+			if t.Name.Local == "tab" {
+				b.WriteByte('\t')
+			}
+		case xml.EndElement:
+			inText = false
+			if t.Name.Local == "p" {
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+}
+
+// Uploads over "upload_max_tokens" are too large to send whole. They are
+// map-reduced instead: split into chunks on line boundaries, each chunk
+// summarised, and the notes merged, so the question is asked about a
+// condensed view of the file.
+const (
+	defaultUploadMaxTokens = 20000
+	uploadChunkTokens      = 8000
+	uploadNoteTokens       = 700
+)
+
+// chunkByTokens splits text on line boundaries into pieces of about limit
+// tokens; a single line longer than that is cut up.
+func chunkByTokens(text string, limit int) []string {
+	var chunks []string
+	var cur strings.Builder
+	n, counter := 0, configuredProvider()
+	flush := func() {
+		if cur.Len() > 0 {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+			n = 0
+		}
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		t := tokensFor(counter, line)
+		for t > limit {
+			flush()
+			cut := min(len(line), limit*4)
+			for cut < len(line) && cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+			t = tokensFor(counter, line)
+		}
+		if n+t > limit {
+			flush()
+		}
+		cur.WriteString(line)
+		n += t
+	}
+	flush()
+	return chunks
+}
+
+// condenseUpload summarises each chunk of a large file, then merges the
+// notes (condensing them again first if they are still too long).
+func condenseUpload(name, lang string, chunks []string, limit int) (string, error) {
+	notes := make([]string, len(chunks))
+	for i, c := range chunks {
+		msg := fmt.Sprintf("Part %d of %d of %s:\n\n%s", i+1, len(chunks), name, fenced(lang, c))
+		note, err := queryGPTStream(modelSummarise, prompt("upload-chunk"), 0.2, uploadNoteTokens, []Message{{Role: "user", Content: msg}}, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return "", fmt.Errorf("summarising part %d: %w", i+1, err)
+		}
+		notes[i] = fmt.Sprintf("Part %d:\n%s", i+1, note)
+		fmt.Fprintf(os.Stderr, "\rsummarised %d/%d parts", i+1, len(chunks))
+	}
+	fmt.Fprintln(os.Stderr)
+
+	all := strings.Join(notes, "\n\n")
+	if tokens(all) > limit && len(chunks) > 1 {
+		return condenseUpload(name+" (notes)", "text", chunkByTokens(all, uploadChunkTokens), limit)
+	}
+	return queryGPTStream(modelSummarise, prompt("upload-combine"), 0.2, 2048, []Message{{Role: "user", Content: all}}, nil)
+}
+
+// condenseIfLarge returns text unchanged when it fits, or a condensed view
+// of it after the user agrees to the extra calls.
+func condenseIfLarge(cfg Config, name, lang, text string) (string, string, error) {
+	limit := cmp.Or(cfg.UploadMaxTokens, defaultUploadMaxTokens)
+	n := tokens(text)
+	if n <= limit {
+		return text, lang, nil
+	}
+	chunks := chunkByTokens(text, uploadChunkTokens)
+	q := fmt.Sprintf("%s is about %d tokens, too large to send whole. Summarise it in %d parts first", name, n, len(chunks))
+	if price, ok := modelPrice(cfg, modelSummarise); ok {
+		q += fmt.Sprintf(" (about %s with %s)", usd(estimateCost(price, n, len(chunks)*uploadNoteTokens)), modelSummarise)
+	}
+	if !assumeYes && !confirm(q+"?") {
+		return "", "", errNotSent
+	}
+	summary, err := condenseUpload(name, lang, chunks, limit)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("(Condensed summary of %s, which was too large to send whole: about %d tokens in %d parts.)\n\n%s", name, n, len(chunks), summary), "markdown", nil
+}
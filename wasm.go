@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// WASM plugins are sandboxed tools installed under ~/.go-chat-plugins/<name>/
+// as plugin.wasm plus manifest.json. They are WASI command modules speaking
+// the same stdin/stdout JSON protocol as executable tool plugins, but they
+// only get what the manifest declares:
+//
+//	{
+//	  "description": "...",
+//	  "parameters": {...json schema},
+//	  "capabilities": {
+//	    "read":  ["/home/me/notes"],   // mounted read-only at the same path
+//	    "write": ["/tmp/scratch"],     // mounted read-write
+//	    "net":   ["api.example.com"]   // hosts reachable via go_chat.http_request
+//	  }
+//	}
+//
+// There are no sockets in WASI, so network access goes through the host
+// import go_chat.http_request(ptr, len) -> (ptr<<32 | len). The request is
+// {"method", "url", "body"} JSON; the response ({"status", "body", "error"})
+// is written to memory obtained from the module's exported alloc(size).
+// Redirects are followed only to hosts the manifest lists, and a module
+// gets at most 256 MiB of memory.
+const (
+	wasmPluginBinary   = "plugin.wasm"
+	wasmPluginManifest = "manifest.json"
+	wasmMemoryPages    = 4096 // 64 KiB each: 256 MiB
+	wasmMaxRedirects   = 10
+)
+
+var wasmPluginsDirPath string
+
+type WasmCapabilities struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+	Net   []string `json:"net,omitempty"`
+}
+
+type WasmManifest struct {
+	Description  string           `json:"description"`
+	Parameters   json.RawMessage  `json:"parameters,omitempty"`
+	Capabilities WasmCapabilities `json:"capabilities"`
+}
+
+type WasmPlugin struct {
+	Name string
+	Dir  string
+	WasmManifest
+
+	clientOnce sync.Once
+	client     *http.Client
+}
+
+func readWasmManifest(dir string) (WasmManifest, error) {
+	var m WasmManifest
+	data, err := os.ReadFile(filepath.Join(dir, wasmPluginManifest))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", wasmPluginManifest, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, wasmPluginBinary)); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func discoverWasmPlugins() []*WasmPlugin {
+	entries, err := os.ReadDir(wasmPluginsDirPath)
+	if err != nil {
+		return nil
+	}
+	var out []*WasmPlugin
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(wasmPluginsDirPath, e.Name())
+		m, err := readWasmManifest(dir)
+		if err != nil {
+			log.Printf("wasm plugin %s: %v", e.Name(), err)
+			continue
+		}
+		out = append(out, &WasmPlugin{Name: e.Name(), Dir: dir, WasmManifest: m})
+	}
+	return out
+}
+
+func (p *WasmPlugin) runTool(args json.RawMessage) (string, error) {
+	in, err := json.Marshal(map[string]any{"arguments": args})
+	if err != nil {
+		return "", err
+	}
+	out, err := p.run(in)
+	if err != nil {
+		return "", fmt.Errorf("wasm plugin %s: %w", p.Name, err)
+	}
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", fmt.Errorf("wasm plugin %s: bad response: %w", p.Name, err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("wasm plugin %s: %s", p.Name, resp.Error)
+	}
+	return resp.Content, nil
+}
+
+// run instantiates the module in a fresh runtime with only the declared
+// mounts and returns what it wrote to stdout.
+func (p *WasmPlugin) run(stdin []byte) ([]byte, error) {
+	code, err := os.ReadFile(filepath.Join(p.Dir, wasmPluginBinary))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginCallTimeout)
+	defer cancel()
+
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryPages))
+	defer rt.Close(ctx)
+
+	wasi_snapshot_preview1.MustInstantiate(ctx, rt)
+	_, err = rt.NewHostModuleBuilder("go_chat").
+		NewFunctionBuilder().WithFunc(p.httpRequest).Export("http_request").
+		Instantiate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fsCfg := wazero.NewFSConfig()
+	for _, dir := range p.Capabilities.Read {
+		fsCfg = fsCfg.WithReadOnlyDirMount(dir, dir)
+	}
+	for _, dir := range p.Capabilities.Write {
+		fsCfg = fsCfg.WithDirMount(dir, dir)
+	}
+
+	var stdout bytes.Buffer
+	cfg := wazero.NewModuleConfig().
+		WithName(p.Name).
+		WithArgs(p.Name).
+		WithStdin(bytes.NewReader(stdin)).
+		WithStdout(&stdout).
+		WithStderr(os.Stderr).
+		WithFSConfig(fsCfg).
+		WithSysWalltime().
+		WithSysNanotime()
+
+	mod, err := rt.InstantiateWithConfig(ctx, code, cfg)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	var exit *sys.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+type wasmHTTPRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body"`
+}
+
+type wasmHTTPResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+	Error  string `json:"error,omitempty"`
+}
+
+// httpRequest is the go_chat.http_request host import. Only hosts listed in
+// the manifest's "net" capability are reachable.
+func (p *WasmPlugin) httpRequest(ctx context.Context, m api.Module, ptr, size uint32) uint64 {
+	var resp wasmHTTPResponse
+	if raw, ok := m.Memory().Read(ptr, size); !ok {
+		resp.Error = "request out of bounds"
+	} else {
+		resp = p.doHTTP(ctx, raw)
+	}
+
+	out, _ := json.Marshal(resp)
+	alloc := m.ExportedFunction("alloc")
+	if alloc == nil {
+		return 0
+	}
+	res, err := alloc.Call(ctx, uint64(len(out)))
+	if err != nil || len(res) == 0 {
+		return 0
+	}
+	dst := uint32(res[0])
+	if !m.Memory().Write(dst, out) {
+		return 0
+	}
+	return uint64(dst)<<32 | uint64(len(out))
+}
+
+func (p *WasmPlugin) doHTTP(ctx context.Context, raw []byte) wasmHTTPResponse {
+	var req wasmHTTPRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return wasmHTTPResponse{Error: err.Error()}
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return wasmHTTPResponse{Error: err.Error()}
+	}
+	if err := p.allowHost(u); err != nil {
+		return wasmHTTPResponse{Error: err.Error()}
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), strings.NewReader(req.Body))
+	if err != nil {
+		return wasmHTTPResponse{Error: err.Error()}
+	}
+	hresp, err := p.httpClient().Do(hreq)
+	if err != nil {
+		return wasmHTTPResponse{Error: err.Error()}
+	}
+	defer hresp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(hresp.Body, 1<<20))
+	if err != nil {
+		return wasmHTTPResponse{Status: hresp.StatusCode, Error: err.Error()}
+	}
+	return wasmHTTPResponse{Status: hresp.StatusCode, Body: string(body)}
+}
+
+func (p *WasmPlugin) allowHost(u *url.URL) error {
+	if !slices.Contains(p.Capabilities.Net, u.Hostname()) {
+		return errors.New("host " + u.Hostname() + " not allowed by manifest")
+	}
+	return nil
+}
+
+// httpClient is the shared client with every redirect checked against the
+// manifest too, so an allowed host can't bounce the plugin elsewhere.
+func (p *WasmPlugin) httpClient() *http.Client {
+	p.clientOnce.Do(func() {
+		p.client = &http.Client{
+			Timeout:   httpClient.Timeout,
+			Transport: httpClient.Transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= wasmMaxRedirects {
+					return fmt.Errorf("stopped after %d redirects", len(via))
+				}
+				return p.allowHost(req.URL)
+			},
+		}
+	})
+	return p.client
+}
+
+// installWasmPlugin copies a plugin directory into ~/.go-chat-plugins after
+// showing the capabilities it asks for.
+func installWasmPlugin(src string) error {
+	m, err := readWasmManifest(src)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(filepath.Clean(src))
+
+	fmt.Printf("%s: %s\n", name, m.Description)
+	fmt.Printf("  read:  %s\n", strings.Join(m.Capabilities.Read, ", "))
+	fmt.Printf("  write: %s\n", strings.Join(m.Capabilities.Write, ", "))
+	fmt.Printf("  net:   %s\n", strings.Join(m.Capabilities.Net, ", "))
+	if !confirm("Install with these capabilities?") {
+		return errors.New("aborted")
+	}
+
+	dst := filepath.Join(wasmPluginsDirPath, name)
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for _, f := range []string{wasmPluginManifest, wasmPluginBinary} {
+		data, err := os.ReadFile(filepath.Join(src, f))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, f), data, 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Println("installed", dst)
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Weather comes from Open-Meteo, which needs no API key. With a location
+// in the config the model gets a get_weather tool, so "do I need an
+// umbrella?" is answered from the forecast, and the first check-in of
+// each day mentions the day's weather when it matters:
+//
+//	"weather": {"location": "Leeds, GB", "units": "metric"}
+//
+// latitude and longitude may be given instead of (or to pin down) the
+// location; "briefing": false leaves the forecast out of check-ins.
+type WeatherConfig struct {
+	Location  string   `json:"location,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Units     string   `json:"units,omitempty"`    // metric (default) or imperial
+	Briefing  *bool    `json:"briefing,omitempty"` // forecast in the day's first check-in (default on)
+	APIURL    string   `json:"api_url,omitempty"`  // default https://api.open-meteo.com; for a self-hosted instance
+}
+
+const (
+	defaultWeatherDays  = 2
+	defaultOpenMeteoURL = "https://api.open-meteo.com"
+	openMeteoGeocodeURL = "https://geocoding-api.open-meteo.com/v1/search"
+)
+
+// weatherCodes describes the WMO weather codes Open-Meteo reports.
+var weatherCodes = map[int]string{
+	0: "clear", 1: "mainly clear", 2: "partly cloudy", 3: "overcast", 45: "fog", 48: "freezing fog",
+	51: "light drizzle", 53: "drizzle", 55: "heavy drizzle", 56: "freezing drizzle", 57: "heavy freezing drizzle",
+	61: "light rain", 63: "rain", 65: "heavy rain", 66: "freezing rain", 67: "heavy freezing rain",
+	71: "light snow", 73: "snow", 75: "heavy snow", 77: "snow grains",
+	80: "light showers", 81: "showers", 82: "violent showers", 85: "snow showers", 86: "heavy snow showers",
+	95: "thunderstorms", 96: "thunderstorms with hail", 99: "thunderstorms with heavy hail",
+}
+
+func (w WeatherConfig) configured() bool {
+	return w.Location != "" || (w.Latitude != nil && w.Longitude != nil)
+}
+
+var weatherOnce sync.Once
+
+func registerWeather() {
+	weatherOnce.Do(func() {
+		if !getConfig().Weather.configured() {
+			return
+		}
+		registerTool(&Tool{
+			Name: "get_weather",
+			Description: "Current weather and the daily forecast from Open-Meteo, for the user's location unless another " +
+				"place is given. Use it for any question about the weather, such as whether to take an umbrella.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"location":{"type":"string","description":"Place name, optionally with country, e.g. \"Lyon, France\"; default the user's location"},` +
+				`"days":{"type":"integer","minimum":1,"maximum":7,"description":"Days of forecast, starting today (default 2)"}}}`),
+			Run: func(args json.RawMessage) (string, error) {
+				var a struct {
+					Location string `json:"location"`
+					Days     int    `json:"days"`
+				}
+				if err := json.Unmarshal(args, &a); err != nil {
+					return "", err
+				}
+				return forecast(getConfig().Weather, a.Location, a.Days)
+			},
+		})
+	})
+}
+
+// weatherBriefing is today's forecast to add to the first check-in of the
+// day, or "" if there is none to add.
+func weatherBriefing(cfg WeatherConfig) string {
+	if !cfg.configured() || (cfg.Briefing != nil && !*cfg.Briefing) {
+		return ""
+	}
+	f, err := forecast(cfg, "", 1)
+	if err != nil {
+		log.Printf("weather: %v", err)
+		return ""
+	}
+	return "\n\n" + prompt("weather-briefing") + "\n" + f
+}
+
+type place struct {
+	name     string
+	lat, lon float64
+}
+
+// forecast describes the current weather and days days of forecast for
+// location, or the configured location if it is empty.
+func forecast(cfg WeatherConfig, location string, days int) (string, error) {
+	if days <= 0 {
+		days = defaultWeatherDays
+	}
+	days = min(days, 7)
+
+	var p place
+	switch {
+	case location != "":
+		var err error
+		if p, err = geocode(location); err != nil {
+			return "", err
+		}
+	case cfg.Latitude != nil && cfg.Longitude != nil:
+		p = place{cmp.Or(cfg.Location, "your location"), *cfg.Latitude, *cfg.Longitude}
+	case cfg.Location != "":
+		var err error
+		if p, err = geocode(cfg.Location); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("no location given and none configured")
+	}
+
+	q := url.Values{
+		"latitude":      {fmt.Sprint(p.lat)},
+		"longitude":     {fmt.Sprint(p.lon)},
+		"current":       {"temperature_2m,apparent_temperature,weather_code,wind_speed_10m"},
+		"daily":         {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum,precipitation_probability_max,wind_speed_10m_max"},
+		"timezone":      {"auto"},
+		"forecast_days": {fmt.Sprint(days)},
+	}
+	if cfg.Units == "imperial" {
+		q.Set("temperature_unit", "fahrenheit")
+		q.Set("wind_speed_unit", "mph")
+		q.Set("precipitation_unit", "inch")
+	}
+	var res struct {
+		Current struct {
+			Temperature float64 `json:"temperature_2m"`
+			FeelsLike   float64 `json:"apparent_temperature"`
+			Code        int     `json:"weather_code"`
+			Wind        float64 `json:"wind_speed_10m"`
+		} `json:"current"`
+		CurrentUnits map[string]string `json:"current_units"`
+		Daily        struct {
+			Time          []string   `json:"time"`
+			Code          []int      `json:"weather_code"`
+			Max           []float64  `json:"temperature_2m_max"`
+			Min           []float64  `json:"temperature_2m_min"`
+			Precipitation []float64  `json:"precipitation_sum"`
+			Chance        []*float64 `json:"precipitation_probability_max"`
+			Wind          []float64  `json:"wind_speed_10m_max"`
+		} `json:"daily"`
+		DailyUnits map[string]string `json:"daily_units"`
+	}
+	api := strings.TrimSuffix(cmp.Or(cfg.APIURL, defaultOpenMeteoURL), "/")
+	if err := getJSON(api+"/v1/forecast?"+q.Encode(), &res); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	c, cu := res.Current, res.CurrentUnits
+	fmt.Fprintf(&b, "%s (%.2f, %.2f)\n", p.name, p.lat, p.lon)
+	fmt.Fprintf(&b, "Now: %g%s (feels like %g%s), %s, wind %g %s\n",
+		c.Temperature, cu["temperature_2m"], c.FeelsLike, cu["apparent_temperature"],
+		weatherCode(c.Code), c.Wind, cu["wind_speed_10m"])
+	d, du := res.Daily, res.DailyUnits
+	for i, day := range d.Time {
+		if i >= len(d.Code) || i >= len(d.Max) || i >= len(d.Min) || i >= len(d.Precipitation) || i >= len(d.Wind) {
+			break
+		}
+		fmt.Fprintf(&b, "%s: %s, %g to %g%s, ", day, weatherCode(d.Code[i]), d.Min[i], d.Max[i], du["temperature_2m_max"])
+		if i < len(d.Chance) && d.Chance[i] != nil {
+			fmt.Fprintf(&b, "%g%% chance of precipitation, ", *d.Chance[i])
+		}
+		fmt.Fprintf(&b, "%g %s expected, wind up to %g %s\n", d.Precipitation[i], du["precipitation_sum"], d.Wind[i], du["wind_speed_10m_max"])
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+func weatherCode(code int) string {
+	if s, ok := weatherCodes[code]; ok {
+		return s
+	}
+	return fmt.Sprintf("weather code %d", code)
+}
+
+var (
+	geocoded   = map[string]place{}
+	geocodedMu sync.Mutex
+)
+
+// geocode finds a place by name. "Paris, US" or "Paris, Texas" picks the
+// first match whose country, country code or region matches the part
+// after the comma.
+func geocode(location string) (place, error) {
+	geocodedMu.Lock()
+	p, ok := geocoded[location]
+	geocodedMu.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	name, qualifier, _ := strings.Cut(location, ",")
+	name, qualifier = strings.TrimSpace(name), strings.TrimSpace(qualifier)
+	var res struct {
+		Results []struct {
+			Name        string  `json:"name"`
+			Latitude    float64 `json:"latitude"`
+			Longitude   float64 `json:"longitude"`
+			Country     string  `json:"country"`
+			CountryCode string  `json:"country_code"`
+			Admin1      string  `json:"admin1"`
+		} `json:"results"`
+	}
+	q := url.Values{"name": {name}, "count": {"10"}, "format": {"json"}}
+	if err := getJSON(openMeteoGeocodeURL+"?"+q.Encode(), &res); err != nil {
+		return place{}, err
+	}
+	for _, r := range res.Results {
+		if qualifier != "" && !strings.EqualFold(qualifier, r.Country) &&
+			!strings.EqualFold(qualifier, r.CountryCode) && !strings.EqualFold(qualifier, r.Admin1) {
+			continue
+		}
+		if r.Admin1 == r.Name {
+			r.Admin1 = ""
+		}
+		p = place{strings.Join(nonEmpty(r.Name, r.Admin1, r.Country), ", "), r.Latitude, r.Longitude}
+		geocodedMu.Lock()
+		geocoded[location] = p
+		geocodedMu.Unlock()
+		return p, nil
+	}
+	return place{}, fmt.Errorf("no place called %q found", location)
+}
+
+func nonEmpty(ss ...string) []string {
+	var out []string
+	for _, s := range ss {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// The web UI is a single embedded page (assets/web/index.html, overridable
+// like any other asset) talking to /ws. Each client message is
+//
+//	{"prompt": "..."}
+//
+// and the answer streams back as {"text": "..."} frames followed by
+// {"done": true}, or {"error": "..."}.
+type wsRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+type wsFrame struct {
+	Text  string `json:"text,omitempty"`
+	Done  bool   `json:"done,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// The page itself is public; /ws takes the token as ?token= because
+// browsers can't set headers on WebSocket requests, and only accepts
+// connections from pages served by this host.
+func registerWeb(mux *http.ServeMux, auth *authenticator) {
+	mux.HandleFunc("GET /{$}", handleWebIndex)
+	ws := websocket.Server{Handler: handleWebSocket, Handshake: checkSameOrigin}
+	mux.Handle("GET /ws", auth.requireWebSocket(scopeChat, ws))
+}
+
+// checkSameOrigin refuses WebSockets opened by other sites' pages.
+func checkSameOrigin(cfg *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(cfg, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("websocket: origin %q is not %s", r.Header.Get("Origin"), r.Host)
+	}
+	cfg.Origin = origin
+	return nil
+}
+
+func handleWebIndex(w http.ResponseWriter, r *http.Request) {
+	const name = "web/index.html"
+	data, err := os.ReadFile(filepath.Join(assetsDirPath, name))
+	if err != nil {
+		data, _ = embeddedAssets.ReadFile("assets/" + name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(data)
+}
+
+// wsClients tracks open WebSocket connections and whether each is in the
+// middle of an answer, so shutdown can close them between turns.
+var wsClients = struct {
+	sync.Mutex
+	conns   map[*websocket.Conn]bool
+	closing bool
+}{conns: map[*websocket.Conn]bool{}}
+
+func setWebSocketBusy(ws *websocket.Conn, busy bool) (closing bool) {
+	wsClients.Lock()
+	defer wsClients.Unlock()
+	wsClients.conns[ws] = busy
+	return wsClients.closing
+}
+
+func closeWebSockets() {
+	wsClients.Lock()
+	defer wsClients.Unlock()
+	wsClients.closing = true
+	for ws, busy := range wsClients.conns {
+		if !busy {
+			ws.Close()
+		}
+	}
+}
+
+func handleWebSocket(ws *websocket.Conn) {
+	defer func() {
+		wsClients.Lock()
+		delete(wsClients.conns, ws)
+		wsClients.Unlock()
+		ws.Close()
+	}()
+	if setWebSocketBusy(ws, false) {
+		return
+	}
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+		if req.Prompt == "" {
+			continue
+		}
+
+		if setWebSocketBusy(ws, true) {
+			return
+		}
+		done, err := startTurn(ws.Request().Context(), req.Prompt)
+		if err != nil {
+			if websocket.JSON.Send(ws, wsFrame{Error: err.Error()}) != nil || setWebSocketBusy(ws, false) {
+				return
+			}
+			continue
+		}
+		_, err = respond(req.Prompt, chatOptions{
+			MemoryScope: identityFrom(ws.Request().Context()).MemoryScope,
+			OnToken: func(text string) {
+				websocket.JSON.Send(ws, wsFrame{Text: text})
+			},
+		})
+		done()
+
+		frame := wsFrame{Done: true}
+		if err != nil {
+			log.Printf("web: %v", err)
+			frame = wsFrame{Error: err.Error()}
+		}
+		if err := websocket.JSON.Send(ws, frame); err != nil {
+			return
+		}
+		if setWebSocketBusy(ws, false) {
+			return
+		}
+	}
+}
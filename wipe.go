@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// `go-chat wipe` deletes stored data for good: -logs, -memories, one
+// namespace's history with -sessions name, or everything with -all. Files
+// are overwritten with random bytes before they are removed, and files
+// that only lose some entries are rewritten with the old copy overwritten
+// the same way. Derived caches (cached system prompts, the ANN graph) go
+// too, and day summaries: all of them, or with -sessions alone only the
+// namespace's. Responses that OpenAI stores for logged turns (see
+// responses.go) are deleted through its API first, unless -local is
+// given; if that fails nothing is removed. It prints what it removed.
+//
+// Overwriting is best effort: SSDs, copy-on-write filesystems and backups
+// may keep old blocks. Configuration, personas, plugins and server tokens
+// are not touched.
+
+// wipeReport lists what a wipe removed.
+type wipeReport struct {
+	lines []string
+	bytes int64
+}
+
+func (r *wipeReport) add(format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+// shredFile overwrites a file with random bytes, syncs it and removes it.
+func shredFile(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	st, err := f.Stat()
+	if err == nil {
+		_, err = io.CopyN(f, rand.Reader, st.Size())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), os.Remove(path)
+}
+
+// shred removes a file or directory tree, shredding every file in it.
+// Missing paths are not an error.
+func (r *wipeReport) shred(path string) error {
+	var n int64
+	files := 0
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		size, err := shredFile(p)
+		n += size
+		files++
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if files > 0 {
+		r.bytes += n
+		r.add("removed %s (%d files, %d bytes)", tildePath(path), files, n)
+	}
+	return nil
+}
+
+// rewrite replaces a file's content through write and shreds the old copy,
+// kept reachable through a hard link until the new one is in place.
+func (r *wipeReport) rewrite(path string, write func() error) error {
+	old := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".wipe")
+	linked := os.Link(path, old) == nil
+	if err := write(); err != nil {
+		if linked {
+			os.Remove(old)
+		}
+		return err
+	}
+	if linked {
+		n, err := shredFile(old)
+		r.bytes += n
+		return err
+	}
+	return nil
+}
+
+func tildePath(p string) string {
+	if rel, err := filepath.Rel(homeDir, p); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.Join("~", rel)
+	}
+	return p
+}
+
+func homeFile(name string) string { return filepath.Join(homeDir, name) }
+
+// tiktokenCacheDir is where tokenizer() caches downloaded BPE files.
+func tiktokenCacheDir() string {
+	if dir := os.Getenv("TIKTOKEN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "go-chat", "tiktoken")
+	}
+	return ""
+}
+
+// wipeCaches removes what is derived from logs and memories; day
+// summaries too if summaries is set.
+func (r *wipeReport) wipeCaches(summaries bool) error {
+	paths := []string{promptCacheFile(), homeFile(annFilePath)}
+	if summaries {
+		paths = append(paths, summaryFilePath)
+	}
+	for _, p := range paths {
+		if err := r.shred(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *wipeReport) wipeMemories() error {
+	defer lockFile(vectorStorePath)()
+	defer lockFile(walFile())()
+	for _, p := range []string{vectorStorePath, annFilePath, feedbackFilePath, memoryWALPath} {
+		if err := r.shred(homeFile(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeNamespace removes one namespace's log entries, memories, day
+// summaries and bookmarks.
+func (r *wipeReport) wipeNamespace(ns string) error {
+	ns = storedNamespace(ns)
+
+	entries := 0
+	for _, day := range logDays() {
+		p := filepath.Join(logDirPath, day+".json")
+		unlock := lockFile(p)
+		logs, err := readDayLog(day)
+		if err != nil {
+			unlock()
+			return err
+		}
+		kept := slices.DeleteFunc(slices.Clone(logs), func(l ChatLog) bool { return l.Namespace == ns })
+		if n := len(logs) - len(kept); n > 0 {
+			entries += n
+			data, _ := json.MarshalIndent(kept, "", "  ")
+			err = r.rewrite(p, func() error { return writeFileAtomic(p, data, 0o644) })
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if entries > 0 {
+		r.add("removed %d log entries", entries)
+	}
+
+	unlock := lockFile(vectorStorePath)
+	store := loadVectorStore()
+	kept := slices.DeleteFunc(slices.Clone(store), func(m VectorMemory) bool { return m.Namespace == ns })
+	var err error
+	if n := len(store) - len(kept); n > 0 {
+		err = r.rewrite(homeFile(vectorStorePath), func() error { return saveVectorStore(kept) })
+		r.add("removed %d memories", n)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	unlock = lockFile(walFile())
+	pending := map[string]bool{}
+	for _, rec := range readWAL() {
+		if rec.Namespace == ns {
+			pending[rec.ID] = true
+		}
+	}
+	if len(pending) > 0 {
+		err = r.rewrite(walFile(), func() error { return dropWAL(pending) })
+		r.add("removed %d unsaved memories", len(pending))
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	unlock = lockFile(summaryFilePath)
+	sums := loadDaySummaries()
+	before := len(sums)
+	for k := range sums {
+		if parts := strings.SplitN(k, "/", 3); len(parts) == 3 && parts[2] == ns {
+			delete(sums, k)
+		}
+	}
+	if n := before - len(sums); n > 0 {
+		data, _ := json.MarshalIndent(sums, "", "  ")
+		err = r.rewrite(summaryFilePath, func() error { return writeFileAtomic(summaryFilePath, data, 0o644) })
+		r.add("removed %d day summaries", n)
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	unlock = lockFile(bookmarksFile())
+	bms := loadBookmarks()
+	keptBms := slices.DeleteFunc(slices.Clone(bms), func(b Bookmark) bool { return b.Namespace == ns })
+	if n := len(bms) - len(keptBms); n > 0 {
+		err = r.rewrite(bookmarksFile(), func() error { return saveBookmarks(keptBms) })
+		r.add("removed %d bookmarks", n)
+	}
+	unlock()
+	return err
+}
+
+// loggedResponseIDs returns the ids of provider-stored responses named in
+// the logs, for namespace ns only unless it is allNamespaces.
+func loggedResponseIDs(ns string) []string {
+	var ids []string
+	for _, day := range logDays() {
+		logs, _ := readDayLog(day)
+		for _, l := range logs {
+			if l.ResponseID != "" && (ns == allNamespaces || l.Namespace == storedNamespace(ns)) {
+				ids = append(ids, l.ResponseID)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+// deleteResponses deletes responses stored by OpenAI, stopping at the
+// first that fails.
+func (r *wipeReport) deleteResponses(ids []string) error {
+	for i, id := range ids {
+		if err := deleteResponse(id); err != nil {
+			if i > 0 {
+				r.add("deleted %d of %d response(s) stored by OpenAI", i, len(ids))
+			}
+			return fmt.Errorf("deleting response %s stored by OpenAI: %w\nnothing else was removed; run it again, or with -local to leave them with OpenAI", id, err)
+		}
+	}
+	r.add("deleted %d response(s) stored by OpenAI", len(ids))
+	return nil
+}
+
+func runWipe(args []string) {
+	fset := flag.NewFlagSet("wipe", flag.ExitOnError)
+	all := fset.Bool("all", false, "Everything go-chat has stored about you")
+	memories := fset.Bool("memories", false, "All memories")
+	logs := fset.Bool("logs", false, "All chat logs")
+	session := fset.String("sessions", "", "Only this namespace's logs, memories, day summaries and bookmarks")
+	local := fset.Bool("local", false, "Leave the responses OpenAI stores for logged turns with it")
+	yes := fset.Bool("y", false, "Don't ask for confirmation")
+	fset.Parse(args)
+	if !*all && !*memories && !*logs && *session == "" {
+		log.Fatal("usage: go-chat wipe [-y] [-local] -all | -memories | -logs | -sessions <namespace>")
+	}
+	if *session != "" {
+		if err := validNamespace(*session); err != nil {
+			log.Fatalf("wipe: %v", err)
+		}
+	}
+
+	var what []string
+	switch {
+	case *all:
+		what = []string{"all chat logs, memories, summaries, bookmarks, snippets, journal entries, tracking, failed requests, spend and audit records, the document index and caches"}
+	default:
+		if *logs {
+			what = append(what, "all chat logs")
+		}
+		if *memories {
+			what = append(what, "all memories")
+		}
+		if *session != "" {
+			what = append(what, fmt.Sprintf("namespace %q's log entries, memories, day summaries and bookmarks", *session))
+		}
+		if *logs || *memories {
+			what = append(what, "summaries and caches")
+		} else {
+			what = append(what, "cached prompts")
+		}
+	}
+	var responseIDs []string
+	switch {
+	case *all || *logs:
+		responseIDs = loggedResponseIDs(allNamespaces)
+	case *session != "":
+		responseIDs = loggedResponseIDs(*session)
+	}
+	if len(responseIDs) > 0 && !*local {
+		what = append(what, fmt.Sprintf("the %d response(s) OpenAI stores for those logs (through its API)", len(responseIDs)))
+	}
+	fmt.Printf("This permanently deletes %s.\n", strings.Join(what, ", "))
+	if len(responseIDs) > 0 && *local {
+		fmt.Printf("The %d response(s) OpenAI stores for those logs stay there (-local).\n", len(responseIDs))
+	}
+	if !*yes && !confirm("Wipe?") {
+		return
+	}
+
+	r := &wipeReport{}
+	err := func() error {
+		if len(responseIDs) > 0 && !*local {
+			if err := r.deleteResponses(responseIDs); err != nil {
+				return err
+			}
+		}
+		if *session != "" && !*all {
+			if err := r.wipeNamespace(*session); err != nil {
+				return err
+			}
+		}
+		if *logs || *all {
+			if err := r.shred(logDirPath); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(logDirPath, 0o755); err != nil {
+				return err
+			}
+		}
+		if *memories || *all {
+			if err := r.wipeMemories(); err != nil {
+				return err
+			}
+		}
+		if *all {
+			paths := []string{
+				bookmarksFile(), snippetsFile(), trackingFile(), failuresFile(), spendFilePath, auditFilePath, indexFilePath, lastFailFilePath, batchesFilePath, daemonFilePath, deferredFilePath,
+				tiktokenCacheDir(),
+			}
+			for _, day := range journalDays() {
+				paths = append(paths, filepath.Join(journalDir(), day+".md"))
+			}
+			for _, p := range paths {
+				if p == "" {
+					continue
+				}
+				if err := r.shred(p); err != nil {
+					return err
+				}
+			}
+		}
+		return r.wipeCaches(*all || *logs || *memories)
+	}()
+
+	for _, l := range r.lines {
+		fmt.Println(l)
+	}
+	if err != nil {
+		log.Fatalf("wipe: %v", err)
+	}
+	fmt.Printf("done: %d bytes overwritten and removed\n", r.bytes)
+	if len(responseIDs) > 0 && *local {
+		fmt.Printf("%d response(s) are still stored by OpenAI until its retention period ends\n", len(responseIDs))
+	}
+}